@@ -3,17 +3,60 @@ package main
 import (
 	"net/http"
 
+	"github.com/trancong12102/jina-http-proxy/apitoken"
+	"github.com/trancong12102/jina-http-proxy/client"
 	"github.com/trancong12102/jina-http-proxy/key"
+	"github.com/trancong12102/jina-http-proxy/proxy"
 )
 
 func createApiRouter(
 	keyHandler *key.KeyHandler,
+	clientHandler *client.ClientHandler,
+	apiTokenHandler *apitoken.APITokenHandler,
+	proxyStatsHandler *proxy.StatsHandler,
+	metricsHandler http.Handler,
+	authenticator apitoken.Authenticator,
 ) http.Handler {
 	router := http.NewServeMux()
+	roles := apitoken.RouteRoles{}
+
+	// handle registers a route and the role required to call it
+	handle := func(pattern string, role apitoken.Role, handler http.HandlerFunc) {
+		router.HandleFunc(pattern, handler)
+		roles[pattern] = role
+	}
 
 	// Key
-	router.HandleFunc("GET /keys/stats", keyHandler.GetKeyStats)
-	router.HandleFunc("POST /keys", keyHandler.InsertKey)
+	handle("GET /keys/stats", apitoken.RoleReadOnly, keyHandler.GetKeyStats)
+	handle("POST /keys", apitoken.RoleKeyOperator, keyHandler.InsertKey)
+	handle("POST /keys/import", apitoken.RoleKeyOperator, keyHandler.ImportKeys)
+	handle("GET /keys/export", apitoken.RoleReadOnly, keyHandler.ExportKeys)
+	handle("GET /keys/export/raw", apitoken.RoleAdmin, keyHandler.ExportRawKeys)
+	handle("GET /keys", apitoken.RoleReadOnly, keyHandler.ListKeys)
+	handle("GET /keys/{id}", apitoken.RoleReadOnly, keyHandler.GetKey)
+	handle("PATCH /keys/{id}", apitoken.RoleKeyOperator, keyHandler.UpdateKey)
+	handle("DELETE /keys/{id}", apitoken.RoleKeyOperator, keyHandler.DeleteKey)
+	handle("POST /keys/{id}/validate", apitoken.RoleKeyOperator, keyHandler.ValidateKey)
+
+	// Client
+	handle("POST /clients", apitoken.RoleAdmin, clientHandler.CreateClient)
+	handle("GET /clients", apitoken.RoleReadOnly, clientHandler.ListClients)
+	handle("GET /clients/{id}", apitoken.RoleReadOnly, clientHandler.GetClient)
+	handle("PATCH /clients/{id}", apitoken.RoleAdmin, clientHandler.UpdateClient)
+	handle("DELETE /clients/{id}", apitoken.RoleAdmin, clientHandler.DeleteClient)
+	handle("POST /clients/{id}/token", apitoken.RoleAdmin, clientHandler.RotateClientToken)
+	handle("GET /clients/{id}/budget", apitoken.RoleReadOnly, clientHandler.GetClientBudget)
+
+	// API token
+	handle("POST /api-tokens", apitoken.RoleAdmin, apiTokenHandler.CreateAPIToken)
+	handle("GET /api-tokens", apitoken.RoleAdmin, apiTokenHandler.ListAPITokens)
+	handle("DELETE /api-tokens/{id}", apitoken.RoleAdmin, apiTokenHandler.DeleteAPIToken)
+
+	// Proxy
+	handle("GET /proxy/stats", apitoken.RoleReadOnly, proxyStatsHandler.GetStats)
+
+	// Metrics
+	handle("GET /metrics", apitoken.RoleReadOnly, metricsHandler.ServeHTTP)
 
-	return router
+	return apitoken.RequireRole(authenticator, roles, router)
 }
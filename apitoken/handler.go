@@ -0,0 +1,125 @@
+package apitoken
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/trancong12102/jina-http-proxy/internal/httpapi"
+)
+
+type CreateAPITokenRequest struct {
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+}
+
+// Convert CreateAPITokenRequest to CreateAPITokenParams
+func (r CreateAPITokenRequest) ToParams() CreateAPITokenParams {
+	return CreateAPITokenParams{Name: r.Name, Role: r.Role}
+}
+
+type APITokenResponse struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewAPITokenResponse(token APIToken) APITokenResponse {
+	return APITokenResponse{
+		ID:        token.ID,
+		Name:      token.Name,
+		Role:      token.Role,
+		CreatedAt: token.CreatedAt,
+	}
+}
+
+// APITokenSecretResponse is an API token with its secret, only sent when the token is created
+type APITokenSecretResponse struct {
+	APIToken APITokenResponse `json:"api_token"`
+	Secret   string           `json:"secret"`
+}
+
+type ListAPITokensResponse struct {
+	APITokens []APITokenResponse `json:"api_tokens"`
+}
+
+type APITokenBiz interface {
+	CreateAPIToken(ctx context.Context, params CreateAPITokenParams) (*APITokenWithSecret, error)
+	ListAPITokens(ctx context.Context) ([]APIToken, error)
+	DeleteAPIToken(ctx context.Context, id int64) error
+}
+
+type APITokenHandler struct {
+	service APITokenBiz
+}
+
+func (h *APITokenHandler) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPITokenRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := h.service.CreateAPIToken(r.Context(), req.ToParams())
+	if err != nil {
+		writeAPITokenError(w, err)
+		return
+	}
+
+	httpapi.WriteJSON(w, http.StatusCreated, APITokenSecretResponse{APIToken: NewAPITokenResponse(created.APIToken), Secret: created.Secret})
+}
+
+func (h *APITokenHandler) ListAPITokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := h.service.ListAPITokens(r.Context())
+	if err != nil {
+		writeAPITokenError(w, err)
+		return
+	}
+
+	response := ListAPITokensResponse{APITokens: make([]APITokenResponse, 0, len(tokens))}
+	for _, token := range tokens {
+		response.APITokens = append(response.APITokens, NewAPITokenResponse(token))
+	}
+	httpapi.WriteJSON(w, http.StatusOK, response)
+}
+
+// DeleteAPIToken revokes an API token, its secret stops working at once
+func (h *APITokenHandler) DeleteAPIToken(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid api token id: %s", r.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.DeleteAPIToken(r.Context(), id)
+	if err != nil {
+		writeAPITokenError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeAPITokenError writes the error with the status matching its kind
+func writeAPITokenError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrAPITokenNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidAPIToken):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrDuplicateAPIToken):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func NewAPITokenHandler(service APITokenBiz) *APITokenHandler {
+	return &APITokenHandler{service: service}
+}
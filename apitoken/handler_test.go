@@ -0,0 +1,162 @@
+package apitoken
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockAPITokenService is a mock implementation of APITokenBiz
+type MockAPITokenService struct {
+	mock.Mock
+}
+
+func (m *MockAPITokenService) CreateAPIToken(ctx context.Context, params CreateAPITokenParams) (*APITokenWithSecret, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*APITokenWithSecret), args.Error(1)
+}
+
+func (m *MockAPITokenService) ListAPITokens(ctx context.Context) ([]APIToken, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]APIToken), args.Error(1)
+}
+
+func (m *MockAPITokenService) DeleteAPIToken(ctx context.Context, id int64) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// newAPITokenRouter routes the API token endpoints to the handler, so path values are set
+func newAPITokenRouter(handler *APITokenHandler) http.Handler {
+	router := http.NewServeMux()
+	router.HandleFunc("POST /api-tokens", handler.CreateAPIToken)
+	router.HandleFunc("GET /api-tokens", handler.ListAPITokens)
+	router.HandleFunc("DELETE /api-tokens/{id}", handler.DeleteAPIToken)
+	return router
+}
+
+func TestAPITokenHandler(t *testing.T) {
+	secret := "jhpa_secret"
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		setupMock      func(*MockAPITokenService)
+		expectedStatus int
+		expectedSecret bool
+	}{
+		{
+			name:   "Create API token",
+			method: "POST",
+			path:   "/api-tokens",
+			body:   `{"name":"ci","role":"key-operator"}`,
+			setupMock: func(m *MockAPITokenService) {
+				m.On("CreateAPIToken", mock.Anything, CreateAPITokenParams{Name: "ci", Role: RoleKeyOperator}).
+					Return(&APITokenWithSecret{APIToken: APIToken{ID: 1, Name: "ci", Role: RoleKeyOperator}, Secret: secret}, nil)
+			},
+			expectedStatus: http.StatusCreated,
+			expectedSecret: true,
+		},
+		{
+			name:   "Create API token with unknown role",
+			method: "POST",
+			path:   "/api-tokens",
+			body:   `{"name":"ci","role":"root"}`,
+			setupMock: func(m *MockAPITokenService) {
+				m.On("CreateAPIToken", mock.Anything, CreateAPITokenParams{Name: "ci", Role: Role("root")}).Return(nil, ErrInvalidAPIToken)
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Create duplicate API token",
+			method: "POST",
+			path:   "/api-tokens",
+			body:   `{"name":"ci","role":"admin"}`,
+			setupMock: func(m *MockAPITokenService) {
+				m.On("CreateAPIToken", mock.Anything, CreateAPITokenParams{Name: "ci", Role: RoleAdmin}).Return(nil, ErrDuplicateAPIToken)
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Invalid create body",
+			method:         "POST",
+			path:           "/api-tokens",
+			body:           "invalid-json",
+			setupMock:      func(m *MockAPITokenService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "List API tokens",
+			method: "GET",
+			path:   "/api-tokens",
+			setupMock: func(m *MockAPITokenService) {
+				m.On("ListAPITokens", mock.Anything).Return([]APIToken{{ID: 1, Name: "ci", Role: RoleAdmin}}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Delete API token",
+			method: "DELETE",
+			path:   "/api-tokens/1",
+			setupMock: func(m *MockAPITokenService) {
+				m.On("DeleteAPIToken", mock.Anything, int64(1)).Return(nil)
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:   "Delete missing API token",
+			method: "DELETE",
+			path:   "/api-tokens/2",
+			setupMock: func(m *MockAPITokenService) {
+				m.On("DeleteAPIToken", mock.Anything, int64(2)).Return(ErrAPITokenNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Invalid id",
+			method:         "DELETE",
+			path:           "/api-tokens/abc",
+			setupMock:      func(m *MockAPITokenService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockService := new(MockAPITokenService)
+			tc.setupMock(mockService)
+			router := newAPITokenRouter(NewAPITokenHandler(mockService))
+
+			req, err := http.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body))
+			assert.NoError(t, err)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedSecret {
+				var response APITokenSecretResponse
+				err = json.NewDecoder(rr.Body).Decode(&response)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(1), response.APIToken.ID)
+				assert.Equal(t, secret, response.Secret)
+			} else {
+				assert.NotContains(t, rr.Body.String(), secret)
+			}
+			mockService.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package apitoken
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/trancong12102/jina-http-proxy/internal/httpapi"
+)
+
+// Authenticator returns the API token with a secret
+type Authenticator interface {
+	AuthenticateAPIToken(ctx context.Context, secret string) (*APIToken, error)
+}
+
+// RouteRoles is the role required by each route pattern of a mux
+type RouteRoles map[string]Role
+
+// Error codes of the responses sent by RequireRole
+const (
+	ErrorCodeUnauthorized  = "unauthorized"
+	ErrorCodeForbidden     = "forbidden"
+	ErrorCodeInternalError = "internal_error"
+)
+
+// RequireRole authenticates the callers of the mux with their bearer API token, and only lets them through
+// when their role allows the role of the route they call. Requests that match no route with a role are
+// left to the mux, which answers 404 or 405.
+func RequireRole(authenticator Authenticator, roles RouteRoles, mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		required, ok := roles[pattern]
+		if !ok {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || secret == "" {
+			writeUnauthorized(w, "An API token is required")
+			return
+		}
+
+		token, err := authenticator.AuthenticateAPIToken(r.Context(), secret)
+		if errors.Is(err, ErrInvalidCredentials) {
+			writeUnauthorized(w, "The API token is invalid")
+			return
+		}
+		if err != nil {
+			slog.Warn("authenticate api token", slog.Any("error", err))
+			httpapi.WriteError(w, http.StatusInternalServerError, ErrorCodeInternalError, "The API token could not be checked")
+			return
+		}
+
+		if !token.Role.Allows(required) {
+			slog.Warn("api token not allowed",
+				slog.String("api_token", token.Name),
+				slog.String("role", string(token.Role)),
+				slog.String("route", pattern),
+			)
+			httpapi.WriteError(w, http.StatusForbidden, ErrorCodeForbidden, "The "+string(required)+" role is required")
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="jina-http-proxy"`)
+	httpapi.WriteError(w, http.StatusUnauthorized, ErrorCodeUnauthorized, message)
+}
@@ -0,0 +1,89 @@
+package apitoken
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trancong12102/jina-http-proxy/internal/httpapi"
+)
+
+// fakeAuthenticator authenticates the API tokens of a secret map
+type fakeAuthenticator map[string]APIToken
+
+func (a fakeAuthenticator) AuthenticateAPIToken(ctx context.Context, secret string) (*APIToken, error) {
+	if secret == "failing" {
+		return nil, assert.AnError
+	}
+	token, ok := a[secret]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return &token, nil
+}
+
+func TestRequireRole(t *testing.T) {
+	mux := http.NewServeMux()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	mux.HandleFunc("GET /keys", ok)
+	mux.HandleFunc("POST /keys", ok)
+	mux.HandleFunc("GET /keys/export/raw", ok)
+	roles := RouteRoles{
+		"GET /keys":            RoleReadOnly,
+		"POST /keys":           RoleKeyOperator,
+		"GET /keys/export/raw": RoleAdmin,
+	}
+	handler := RequireRole(fakeAuthenticator{
+		"reader":   {Name: "reader", Role: RoleReadOnly},
+		"operator": {Name: "operator", Role: RoleKeyOperator},
+		"admin":    {Name: "admin", Role: RoleAdmin},
+	}, roles, mux)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		authorization  string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{name: "Missing token", method: "GET", path: "/keys", expectedStatus: http.StatusUnauthorized, expectedCode: ErrorCodeUnauthorized},
+		{name: "Not a bearer token", method: "GET", path: "/keys", authorization: "Basic cmVhZGVy", expectedStatus: http.StatusUnauthorized, expectedCode: ErrorCodeUnauthorized},
+		{name: "Invalid token", method: "GET", path: "/keys", authorization: "Bearer unknown", expectedStatus: http.StatusUnauthorized, expectedCode: ErrorCodeUnauthorized},
+		{name: "Authentication failure", method: "GET", path: "/keys", authorization: "Bearer failing", expectedStatus: http.StatusInternalServerError, expectedCode: ErrorCodeInternalError},
+		{name: "Read only reads", method: "GET", path: "/keys", authorization: "Bearer reader", expectedStatus: http.StatusOK},
+		{name: "Read only cannot insert", method: "POST", path: "/keys", authorization: "Bearer reader", expectedStatus: http.StatusForbidden, expectedCode: ErrorCodeForbidden},
+		{name: "Key operator inserts", method: "POST", path: "/keys", authorization: "Bearer operator", expectedStatus: http.StatusOK},
+		{name: "Key operator cannot export raw keys", method: "GET", path: "/keys/export/raw", authorization: "Bearer operator", expectedStatus: http.StatusForbidden, expectedCode: ErrorCodeForbidden},
+		{name: "Admin exports raw keys", method: "GET", path: "/keys/export/raw", authorization: "Bearer admin", expectedStatus: http.StatusOK},
+		{name: "Unknown route", method: "GET", path: "/unknown", expectedStatus: http.StatusNotFound},
+		{name: "Unknown method", method: "PUT", path: "/keys", expectedStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedCode != "" {
+				var body httpapi.ErrorResponse
+				require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+				assert.Equal(t, tc.expectedCode, body.Error.Code)
+				assert.NotEmpty(t, body.Error.Message)
+				assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+			}
+			if tc.expectedStatus == http.StatusUnauthorized {
+				assert.Equal(t, `Bearer realm="jina-http-proxy"`, rr.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
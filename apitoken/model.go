@@ -0,0 +1,101 @@
+package apitoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Role tells what an API token is allowed to do, each role can do what the previous roles can
+type Role string
+
+const (
+	// RoleReadOnly reads keys, clients and statistics
+	RoleReadOnly Role = "read-only"
+	// RoleKeyOperator also adds, updates, validates and deletes keys
+	RoleKeyOperator Role = "key-operator"
+	// RoleAdmin also exports raw keys and manages clients and API tokens
+	RoleAdmin Role = "admin"
+)
+
+// roleRanks orders the roles, a role is allowed what lower ranked roles are
+var roleRanks = map[Role]int{
+	RoleReadOnly:    1,
+	RoleKeyOperator: 2,
+	RoleAdmin:       3,
+}
+
+// IsValid reports whether r is a known role
+func (r Role) IsValid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Allows reports whether r is allowed what the required role is
+func (r Role) Allows(required Role) bool {
+	return r.IsValid() && roleRanks[r] >= roleRanks[required]
+}
+
+// APIToken authenticates a caller of the management API
+type APIToken struct {
+	ID        int64
+	Name      string
+	Role      Role
+	CreatedAt time.Time
+}
+
+type CreateAPITokenParams struct {
+	Name string
+	Role Role
+}
+
+// APITokenWithSecret is an API token with its raw secret, only known when the token is created
+type APITokenWithSecret struct {
+	APIToken APIToken
+	Secret   string
+}
+
+// MaxNameLength is the longest API token name accepted
+const MaxNameLength = 64
+
+// ValidateName checks that a name is not empty and not too long
+func ValidateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: name is empty", ErrInvalidAPIToken)
+	}
+	if len(name) > MaxNameLength {
+		return fmt.Errorf("%w: name is longer than %d characters", ErrInvalidAPIToken, MaxNameLength)
+	}
+	return nil
+}
+
+// SecretPrefix starts every API token secret, to recognize them
+const SecretPrefix = "jhpa_"
+
+// GenerateSecret returns a new random API token secret
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return SecretPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// HashSecret returns the hash of a secret, only hashes are stored
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	ErrAPITokenNotFound   = errors.New("api token not found")
+	ErrDuplicateAPIToken  = errors.New("api token already exists")
+	ErrInvalidAPIToken    = errors.New("invalid api token")
+	ErrInvalidCredentials = errors.New("invalid api token credentials")
+)
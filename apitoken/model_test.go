@@ -0,0 +1,51 @@
+package apitoken
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRole_Allows(t *testing.T) {
+	tests := []struct {
+		role     Role
+		required Role
+		expected bool
+	}{
+		{role: RoleReadOnly, required: RoleReadOnly, expected: true},
+		{role: RoleReadOnly, required: RoleKeyOperator, expected: false},
+		{role: RoleReadOnly, required: RoleAdmin, expected: false},
+		{role: RoleKeyOperator, required: RoleReadOnly, expected: true},
+		{role: RoleKeyOperator, required: RoleKeyOperator, expected: true},
+		{role: RoleKeyOperator, required: RoleAdmin, expected: false},
+		{role: RoleAdmin, required: RoleReadOnly, expected: true},
+		{role: RoleAdmin, required: RoleAdmin, expected: true},
+		{role: Role("root"), required: RoleReadOnly, expected: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(string(tc.role)+" "+string(tc.required), func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.role.Allows(tc.required))
+		})
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	assert.NoError(t, ValidateName("ci deploy"))
+	assert.ErrorIs(t, ValidateName(""), ErrInvalidAPIToken)
+	assert.ErrorIs(t, ValidateName(strings.Repeat("a", MaxNameLength+1)), ErrInvalidAPIToken)
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, SecretPrefix))
+
+	other, err := GenerateSecret()
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, other)
+	assert.Len(t, HashSecret(secret), 64)
+	assert.NotEqual(t, HashSecret(secret), HashSecret(other))
+}
@@ -0,0 +1,140 @@
+package apitoken
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+type APITokenDBRepository struct {
+	db *sql.DB
+}
+
+// Check if APITokenDBRepository implements APITokenRepository
+var _ APITokenRepository = &APITokenDBRepository{}
+
+// CreateAPIToken inserts an API token with the hash of its secret, or returns ErrDuplicateAPIToken if the name is taken
+func (r *APITokenDBRepository) CreateAPIToken(ctx context.Context, params CreateAPITokenParams, secretHash string) (*APIToken, error) {
+	token, err := r.getAPIToken(ctx, `
+		INSERT INTO api_tokens (name, token_hash, role) VALUES ($1, $2, $3) ON CONFLICT (name) DO NOTHING
+		RETURNING id, name, role, created_at`, params.Name, secretHash, params.Role)
+	if errors.Is(err, ErrAPITokenNotFound) {
+		return nil, ErrDuplicateAPIToken
+	}
+	return token, err
+}
+
+// bootstrapLockID identifies the advisory lock held while bootstrapping, so concurrent instances create a single token
+const bootstrapLockID int64 = 0x6a687061
+
+// BootstrapAPIToken inserts an API token unless a token with its role exists, it returns nil in that case.
+// It returns ErrDuplicateAPIToken if the name is taken by a token of another role.
+func (r *APITokenDBRepository) BootstrapAPIToken(ctx context.Context, params CreateAPITokenParams, secretHash string) (*APIToken, error) {
+	// Create a transaction
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if tx != nil {
+			_ = tx.Rollback() // Intentionally ignore error from rollback as it's called from defer
+		}
+	}()
+
+	// Wait for the other instances bootstrapping, the lock is released with the transaction
+	_, err = tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", bootstrapLockID)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int64
+	err = tx.QueryRowContext(ctx, "SELECT count(*) FROM api_tokens WHERE role = $1", params.Role).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, nil
+	}
+
+	var token APIToken
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO api_tokens (name, token_hash, role) VALUES ($1, $2, $3) ON CONFLICT (name) DO NOTHING
+		RETURNING id, name, role, created_at`, params.Name, secretHash, params.Role).
+		Scan(&token.ID, &token.Name, &token.Role, &token.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDuplicateAPIToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// GetAPITokenBySecretHash returns the API token with the given secret hash, or ErrAPITokenNotFound if it does not exist
+func (r *APITokenDBRepository) GetAPITokenBySecretHash(ctx context.Context, secretHash string) (*APIToken, error) {
+	return r.getAPIToken(ctx, "SELECT id, name, role, created_at FROM api_tokens WHERE token_hash = $1", secretHash)
+}
+
+// ListAPITokens returns every API token ordered by id
+func (r *APITokenDBRepository) ListAPITokens(ctx context.Context) ([]APIToken, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, name, role, created_at FROM api_tokens ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		var token APIToken
+		err = rows.Scan(&token.ID, &token.Name, &token.Role, &token.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// DeleteAPIToken deletes the API token with the given id
+func (r *APITokenDBRepository) DeleteAPIToken(ctx context.Context, id int64) error {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM api_tokens WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrAPITokenNotFound
+	}
+
+	return nil
+}
+
+// getAPIToken returns the single API token of the query, or ErrAPITokenNotFound if there is none
+func (r *APITokenDBRepository) getAPIToken(ctx context.Context, query string, args ...any) (*APIToken, error) {
+	var token APIToken
+	err := r.db.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.Name, &token.Role, &token.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAPITokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func NewAPITokenDBRepository(db *sql.DB) APITokenRepository {
+	return &APITokenDBRepository{db: db}
+}
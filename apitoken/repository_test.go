@@ -0,0 +1,103 @@
+package apitoken
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trancong12102/jina-http-proxy/internal/testdb"
+)
+
+func TestAPITokenDBRepository_CreateAPIToken(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewAPITokenDBRepository(db)
+	ctx := context.Background()
+
+	created, err := repo.CreateAPIToken(ctx, CreateAPITokenParams{Name: "ci", Role: RoleKeyOperator}, HashSecret("secret-a"))
+	require.NoError(t, err)
+	assert.Equal(t, "ci", created.Name)
+	assert.Equal(t, RoleKeyOperator, created.Role)
+
+	_, err = repo.CreateAPIToken(ctx, CreateAPITokenParams{Name: "ci", Role: RoleAdmin}, HashSecret("secret-b"))
+	assert.ErrorIs(t, err, ErrDuplicateAPIToken)
+
+	token, err := repo.GetAPITokenBySecretHash(ctx, HashSecret("secret-a"))
+	require.NoError(t, err)
+	assert.Equal(t, created, token)
+
+	_, err = repo.GetAPITokenBySecretHash(ctx, HashSecret("secret-b"))
+	assert.ErrorIs(t, err, ErrAPITokenNotFound)
+}
+
+func TestAPITokenService_Bootstrap_Concurrent(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewAPITokenDBRepository(db)
+	service := NewAPITokenService(repo)
+	ctx := context.Background()
+
+	// A token of another role already has the bootstrap name
+	_, err := repo.CreateAPIToken(ctx, CreateAPITokenParams{Name: BootstrapTokenName, Role: RoleReadOnly}, HashSecret("secret-a"))
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	created := make([]*APITokenWithSecret, 2)
+	errs := make([]error, 2)
+	for i := range created {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			created[i], errs[i] = service.Bootstrap(ctx, "")
+		}()
+	}
+	wg.Wait()
+
+	require.NoError(t, errs[0])
+	require.NoError(t, errs[1])
+	assert.True(t, (created[0] == nil) != (created[1] == nil), "exactly one instance creates the admin token")
+
+	tokens, err := repo.ListAPITokens(ctx)
+	require.NoError(t, err)
+	var admins int
+	for _, token := range tokens {
+		if token.Role == RoleAdmin {
+			admins++
+		}
+	}
+	assert.Equal(t, 1, admins)
+
+	again, err := service.Bootstrap(ctx, "")
+	require.NoError(t, err)
+	assert.Nil(t, again)
+}
+
+func TestAPITokenDBRepository_ManageAPITokens(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewAPITokenDBRepository(db)
+	ctx := context.Background()
+
+	admin, err := repo.CreateAPIToken(ctx, CreateAPITokenParams{Name: "admin", Role: RoleAdmin}, HashSecret("secret-a"))
+	require.NoError(t, err)
+	reader, err := repo.CreateAPIToken(ctx, CreateAPITokenParams{Name: "reader", Role: RoleReadOnly}, HashSecret("secret-b"))
+	require.NoError(t, err)
+
+	tokens, err := repo.ListAPITokens(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []APIToken{*admin, *reader}, tokens)
+
+	err = repo.DeleteAPIToken(ctx, admin.ID)
+	assert.NoError(t, err)
+
+	_, err = repo.GetAPITokenBySecretHash(ctx, HashSecret("secret-a"))
+	assert.ErrorIs(t, err, ErrAPITokenNotFound)
+
+	err = repo.DeleteAPIToken(ctx, admin.ID)
+	assert.ErrorIs(t, err, ErrAPITokenNotFound)
+}
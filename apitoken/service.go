@@ -0,0 +1,96 @@
+package apitoken
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+type APITokenRepository interface {
+	CreateAPIToken(ctx context.Context, params CreateAPITokenParams, secretHash string) (*APIToken, error)
+	GetAPITokenBySecretHash(ctx context.Context, secretHash string) (*APIToken, error)
+	ListAPITokens(ctx context.Context) ([]APIToken, error)
+	BootstrapAPIToken(ctx context.Context, params CreateAPITokenParams, secretHash string) (*APIToken, error)
+	DeleteAPIToken(ctx context.Context, id int64) error
+}
+
+type APITokenService struct {
+	repo APITokenRepository
+}
+
+// BootstrapTokenName is the name of the admin token created at startup
+const BootstrapTokenName = "bootstrap-admin"
+
+// CreateAPIToken creates an API token with a new secret, the secret is only returned here
+func (s *APITokenService) CreateAPIToken(ctx context.Context, params CreateAPITokenParams) (*APITokenWithSecret, error) {
+	err := ValidateName(params.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !params.Role.IsValid() {
+		return nil, fmt.Errorf("%w: unknown role %q", ErrInvalidAPIToken, params.Role)
+	}
+
+	secret, err := GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.repo.CreateAPIToken(ctx, params, HashSecret(secret))
+	if err != nil {
+		return nil, err
+	}
+
+	return &APITokenWithSecret{APIToken: *token, Secret: secret}, nil
+}
+
+// Bootstrap creates the first admin token when there is no admin token yet.
+// The token uses the given secret, or a new secret when it is empty.
+// It returns the created token, or nil when an admin token already exists.
+// Instances bootstrapping at the same time create a single token.
+func (s *APITokenService) Bootstrap(ctx context.Context, secret string) (*APITokenWithSecret, error) {
+	var err error
+	if secret == "" {
+		secret, err = GenerateSecret()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	secretHash := HashSecret(secret)
+	token, err := s.repo.BootstrapAPIToken(ctx, CreateAPITokenParams{Name: BootstrapTokenName, Role: RoleAdmin}, secretHash)
+	if errors.Is(err, ErrDuplicateAPIToken) {
+		// A token of another role took the name, the admin token gets a name of its own
+		token, err = s.repo.BootstrapAPIToken(ctx, CreateAPITokenParams{Name: BootstrapTokenName + "-" + secretHash[:8], Role: RoleAdmin}, secretHash)
+	}
+	if err != nil || token == nil {
+		return nil, err
+	}
+
+	return &APITokenWithSecret{APIToken: *token, Secret: secret}, nil
+}
+
+// AuthenticateAPIToken returns the API token with the secret, or ErrInvalidCredentials
+func (s *APITokenService) AuthenticateAPIToken(ctx context.Context, secret string) (*APIToken, error) {
+	token, err := s.repo.GetAPITokenBySecretHash(ctx, HashSecret(secret))
+	if errors.Is(err, ErrAPITokenNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (s *APITokenService) ListAPITokens(ctx context.Context) ([]APIToken, error) {
+	return s.repo.ListAPITokens(ctx)
+}
+
+func (s *APITokenService) DeleteAPIToken(ctx context.Context, id int64) error {
+	return s.repo.DeleteAPIToken(ctx, id)
+}
+
+func NewAPITokenService(repo APITokenRepository) *APITokenService {
+	return &APITokenService{repo: repo}
+}
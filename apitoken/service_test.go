@@ -0,0 +1,163 @@
+package apitoken
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockAPITokenRepository is a mock implementation of APITokenRepository
+type MockAPITokenRepository struct {
+	mock.Mock
+}
+
+func (m *MockAPITokenRepository) CreateAPIToken(ctx context.Context, params CreateAPITokenParams, secretHash string) (*APIToken, error) {
+	args := m.Called(ctx, params, secretHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*APIToken), args.Error(1)
+}
+
+func (m *MockAPITokenRepository) GetAPITokenBySecretHash(ctx context.Context, secretHash string) (*APIToken, error) {
+	args := m.Called(ctx, secretHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*APIToken), args.Error(1)
+}
+
+func (m *MockAPITokenRepository) ListAPITokens(ctx context.Context) ([]APIToken, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]APIToken), args.Error(1)
+}
+
+func (m *MockAPITokenRepository) BootstrapAPIToken(ctx context.Context, params CreateAPITokenParams, secretHash string) (*APIToken, error) {
+	args := m.Called(ctx, params, secretHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*APIToken), args.Error(1)
+}
+
+func (m *MockAPITokenRepository) DeleteAPIToken(ctx context.Context, id int64) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func TestAPITokenService_CreateAPIToken(t *testing.T) {
+	mockRepo := new(MockAPITokenRepository)
+	service := NewAPITokenService(mockRepo)
+	ctx := context.Background()
+	params := CreateAPITokenParams{Name: "ci", Role: RoleKeyOperator}
+
+	var storedHash string
+	mockRepo.On("CreateAPIToken", ctx, params, mock.AnythingOfType("string")).
+		Run(func(args mock.Arguments) { storedHash = args.String(2) }).
+		Return(&APIToken{ID: 1, Name: "ci", Role: RoleKeyOperator}, nil)
+
+	created, err := service.CreateAPIToken(ctx, params)
+	require.NoError(t, err)
+	assert.Equal(t, APIToken{ID: 1, Name: "ci", Role: RoleKeyOperator}, created.APIToken)
+	assert.Equal(t, HashSecret(created.Secret), storedHash)
+
+	_, err = service.CreateAPIToken(ctx, CreateAPITokenParams{Name: "ci", Role: Role("root")})
+	assert.ErrorIs(t, err, ErrInvalidAPIToken)
+
+	_, err = service.CreateAPIToken(ctx, CreateAPITokenParams{Role: RoleAdmin})
+	assert.ErrorIs(t, err, ErrInvalidAPIToken)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAPITokenService_Bootstrap(t *testing.T) {
+	bootstrapParams := CreateAPITokenParams{Name: BootstrapTokenName, Role: RoleAdmin}
+
+	tests := []struct {
+		name           string
+		secret         string
+		setupMock      func(*MockAPITokenRepository)
+		expectedCreate bool
+	}{
+		{
+			name:   "Admin token exists",
+			secret: "admin-secret",
+			setupMock: func(m *MockAPITokenRepository) {
+				m.On("BootstrapAPIToken", mock.Anything, bootstrapParams, HashSecret("admin-secret")).Return(nil, nil)
+			},
+		},
+		{
+			name:   "Admin token created with the given secret",
+			secret: "admin-secret",
+			setupMock: func(m *MockAPITokenRepository) {
+				m.On("BootstrapAPIToken", mock.Anything, bootstrapParams, HashSecret("admin-secret")).Return(&APIToken{ID: 1, Name: BootstrapTokenName, Role: RoleAdmin}, nil)
+			},
+			expectedCreate: true,
+		},
+		{
+			name: "Admin token created with a new secret",
+			setupMock: func(m *MockAPITokenRepository) {
+				m.On("BootstrapAPIToken", mock.Anything, bootstrapParams, mock.AnythingOfType("string")).Return(&APIToken{ID: 1, Name: BootstrapTokenName, Role: RoleAdmin}, nil)
+			},
+			expectedCreate: true,
+		},
+		{
+			name:   "Admin token renamed when the name is taken",
+			secret: "admin-secret",
+			setupMock: func(m *MockAPITokenRepository) {
+				renamed := CreateAPITokenParams{Name: BootstrapTokenName + "-" + HashSecret("admin-secret")[:8], Role: RoleAdmin}
+				m.On("BootstrapAPIToken", mock.Anything, bootstrapParams, HashSecret("admin-secret")).Return(nil, ErrDuplicateAPIToken)
+				m.On("BootstrapAPIToken", mock.Anything, renamed, HashSecret("admin-secret")).Return(&APIToken{ID: 2, Name: renamed.Name, Role: RoleAdmin}, nil)
+			},
+			expectedCreate: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockAPITokenRepository)
+			tc.setupMock(mockRepo)
+			service := NewAPITokenService(mockRepo)
+
+			created, err := service.Bootstrap(context.Background(), tc.secret)
+			require.NoError(t, err)
+			if !tc.expectedCreate {
+				assert.Nil(t, created)
+			} else {
+				require.NotNil(t, created)
+				assert.Equal(t, RoleAdmin, created.APIToken.Role)
+				assert.NotEmpty(t, created.Secret)
+				if tc.secret != "" {
+					assert.Equal(t, tc.secret, created.Secret)
+				}
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAPITokenService_AuthenticateAPIToken(t *testing.T) {
+	mockRepo := new(MockAPITokenRepository)
+	service := NewAPITokenService(mockRepo)
+	ctx := context.Background()
+
+	mockRepo.On("GetAPITokenBySecretHash", ctx, HashSecret("valid")).Return(&APIToken{ID: 1, Role: RoleAdmin}, nil)
+	mockRepo.On("GetAPITokenBySecretHash", ctx, HashSecret("unknown")).Return(nil, ErrAPITokenNotFound)
+	mockRepo.On("GetAPITokenBySecretHash", ctx, HashSecret("failing")).Return(nil, assert.AnError)
+
+	token, err := service.AuthenticateAPIToken(ctx, "valid")
+	assert.NoError(t, err)
+	assert.Equal(t, &APIToken{ID: 1, Role: RoleAdmin}, token)
+
+	_, err = service.AuthenticateAPIToken(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	_, err = service.AuthenticateAPIToken(ctx, "failing")
+	assert.ErrorIs(t, err, assert.AnError)
+	mockRepo.AssertExpectations(t)
+}
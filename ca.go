@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/trancong12102/jina-http-proxy/proxy"
+)
+
+// GenerateCACommand is the command writing a new proxy CA instead of running the server
+const GenerateCACommand = "generate-ca"
+
+const (
+	DefaultCACommonName = "Jina HTTP Proxy CA"
+	DefaultCAValidity   = 10 * 365 * 24 * time.Hour
+)
+
+// runGenerateCA writes a new CA certificate and private key to be loaded by the proxy.
+// Existing files are never overwritten.
+func runGenerateCA(args []string) error {
+	flags := flag.NewFlagSet(GenerateCACommand, flag.ContinueOnError)
+	certFile := flags.String("cert", "ca.crt", "path of the PEM encoded CA certificate")
+	keyFile := flags.String("key", "ca.key", "path of the PEM encoded CA private key")
+	commonName := flags.String("cn", DefaultCACommonName, "common name of the CA")
+	validity := flags.Duration("validity", DefaultCAValidity, "validity of the CA")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	certPEM, keyPEM, err := proxy.GenerateCA(*commonName, time.Now(), *validity)
+	if err != nil {
+		return fmt.Errorf("generate CA: %w", err)
+	}
+
+	err = writeNewFile(*keyFile, keyPEM, 0o600)
+	if err != nil {
+		return fmt.Errorf("write CA key: %w", err)
+	}
+
+	err = writeNewFile(*certFile, certPEM, 0o644)
+	if err != nil {
+		return fmt.Errorf("write CA certificate: %w", err)
+	}
+
+	slog.Info("CA generated", slog.String("cert", *certFile), slog.String("key", *keyFile))
+	return nil
+}
+
+// writeNewFile writes data to a file that must not exist yet
+func writeNewFile(name string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
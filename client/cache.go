@@ -0,0 +1,144 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// CacheTTL is how long authenticated clients and their usage are kept in memory
+const CacheTTL = 5 * time.Second
+
+// ClientCache keeps the authenticated clients and their usage in memory for a short time,
+// so the proxy does not query the database on every request.
+// Entries of a client are dropped when it changes on this instance, other instances see the change once they expire.
+type ClientCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	tokens  map[string]cacheEntry[Client]
+	clients map[int64]cacheEntry[Client]
+	usages  map[int64]cacheEntry[ClientUsage]
+}
+
+// cacheEntry is a cached value and when it expires. The usage of a client is only valid in its UTC day.
+type cacheEntry[T any] struct {
+	value     T
+	expiresAt time.Time
+	day       time.Time
+}
+
+// valid reports whether the entry can still be used at now
+func (e cacheEntry[T]) valid(now time.Time) bool {
+	return now.Before(e.expiresAt) && e.day.Equal(startOfDay(now))
+}
+
+// Authenticated returns the client owning the token hash, if cached
+func (c *ClientCache) Authenticated(tokenHash string, now time.Time) (*Client, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.tokens[tokenHash]
+	if !ok || !entry.valid(now) {
+		return nil, false
+	}
+	client := entry.value
+	return &client, true
+}
+
+// SetAuthenticated caches the client owning the token hash.
+// Expired tokens are dropped, so tokens rotated on other instances do not pile up.
+func (c *ClientCache) SetAuthenticated(tokenHash string, client Client, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for hash, entry := range c.tokens {
+		if !entry.valid(now) {
+			delete(c.tokens, hash)
+		}
+	}
+	c.tokens[tokenHash] = newCacheEntry(client, c.ttl, now)
+	c.clients[client.ID] = newCacheEntry(client, c.ttl, now)
+}
+
+// Client returns the client, if cached
+func (c *ClientCache) Client(id int64, now time.Time) (*Client, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.clients[id]
+	if !ok || !entry.valid(now) {
+		return nil, false
+	}
+	client := entry.value
+	return &client, true
+}
+
+// SetClient caches the client
+func (c *ClientCache) SetClient(client Client, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.clients[client.ID] = newCacheEntry(client, c.ttl, now)
+}
+
+// Usage returns the usage of the client in the day of now, if cached
+func (c *ClientCache) Usage(id int64, now time.Time) (*ClientUsage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.usages[id]
+	if !ok || !entry.valid(now) {
+		return nil, false
+	}
+	usage := entry.value
+	return &usage, true
+}
+
+// SetUsage caches the usage of the client in the day of now
+func (c *ClientCache) SetUsage(id int64, usage ClientUsage, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.usages[id] = newCacheEntry(usage, c.ttl, now)
+}
+
+// AddUsage adds tokens debited in the day of now to the cached usage of the client, if any
+func (c *ClientCache) AddUsage(id int64, tokens int64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.usages[id]
+	if !ok || !entry.valid(now) {
+		return
+	}
+	entry.value.DailyTokens += tokens
+	entry.value.MonthlyTokens += tokens
+	c.usages[id] = entry
+}
+
+// Forget drops every entry of the client
+func (c *ClientCache) Forget(id int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for tokenHash, entry := range c.tokens {
+		if entry.value.ID == id {
+			delete(c.tokens, tokenHash)
+		}
+	}
+	delete(c.clients, id)
+	delete(c.usages, id)
+}
+
+// newCacheEntry returns an entry of the value expiring after ttl
+func newCacheEntry[T any](value T, ttl time.Duration, now time.Time) cacheEntry[T] {
+	return cacheEntry[T]{value: value, expiresAt: now.Add(ttl), day: startOfDay(now)}
+}
+
+func NewClientCache(ttl time.Duration) *ClientCache {
+	return &ClientCache{
+		ttl:     ttl,
+		tokens:  make(map[string]cacheEntry[Client]),
+		clients: make(map[int64]cacheEntry[Client]),
+		usages:  make(map[int64]cacheEntry[ClientUsage]),
+	}
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientCache_Expiry(t *testing.T) {
+	cache := NewClientCache(5 * time.Second)
+	now := time.Date(2026, 10, 16, 23, 59, 50, 0, time.UTC)
+
+	cache.SetAuthenticated("hash-a", Client{ID: 1, Name: "team-a"}, now)
+	cache.SetUsage(1, ClientUsage{DailyTokens: 100, MonthlyTokens: 1000}, now)
+
+	client, ok := cache.Authenticated("hash-a", now.Add(4*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, &Client{ID: 1, Name: "team-a"}, client)
+	client, ok = cache.Client(1, now.Add(4*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, "team-a", client.Name)
+
+	// Debited tokens are added to the cached usage
+	cache.AddUsage(1, 50, now.Add(time.Second))
+	usage, ok := cache.Usage(1, now.Add(4*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, &ClientUsage{DailyTokens: 150, MonthlyTokens: 1050}, usage)
+
+	// Entries expire after the TTL
+	_, ok = cache.Authenticated("hash-a", now.Add(5*time.Second))
+	assert.False(t, ok)
+	_, ok = cache.Client(1, now.Add(5*time.Second))
+	assert.False(t, ok)
+
+	// The usage of the previous day is not used after midnight, even before it expires
+	cache.SetUsage(1, ClientUsage{DailyTokens: 100, MonthlyTokens: 1000}, now.Add(8*time.Second))
+	_, ok = cache.Usage(1, now.Add(10*time.Second))
+	assert.False(t, ok)
+}
+
+func TestClientCache_Forget(t *testing.T) {
+	cache := NewClientCache(time.Minute)
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	cache.SetAuthenticated("hash-a", Client{ID: 1, Name: "team-a"}, now)
+	cache.SetAuthenticated("hash-b", Client{ID: 2, Name: "team-b"}, now)
+	cache.SetUsage(1, ClientUsage{DailyTokens: 100}, now)
+
+	cache.Forget(1)
+	_, ok := cache.Authenticated("hash-a", now)
+	assert.False(t, ok)
+	_, ok = cache.Client(1, now)
+	assert.False(t, ok)
+	_, ok = cache.Usage(1, now)
+	assert.False(t, ok)
+
+	// Other clients are kept
+	_, ok = cache.Authenticated("hash-b", now)
+	assert.True(t, ok)
+}
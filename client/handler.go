@@ -0,0 +1,268 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/trancong12102/jina-http-proxy/internal/httpapi"
+)
+
+type CreateClientRequest struct {
+	Name string `json:"name"`
+}
+
+// Convert CreateClientRequest to CreateClientParams
+func (r CreateClientRequest) ToParams() CreateClientParams {
+	return CreateClientParams{Name: r.Name}
+}
+
+// UpdateClientRequest updates the fields that are set
+type UpdateClientRequest struct {
+	Disabled           *bool  `json:"disabled"`
+	DailyTokenBudget   *int64 `json:"daily_token_budget"`
+	MonthlyTokenBudget *int64 `json:"monthly_token_budget"`
+	RequestsPerMinute  *int64 `json:"requests_per_minute"`
+}
+
+// Convert UpdateClientRequest to UpdateClientParams
+func (r UpdateClientRequest) ToParams(id int64) UpdateClientParams {
+	return UpdateClientParams{
+		ID:                 id,
+		Disabled:           r.Disabled,
+		DailyTokenBudget:   r.DailyTokenBudget,
+		MonthlyTokenBudget: r.MonthlyTokenBudget,
+		RequestsPerMinute:  r.RequestsPerMinute,
+	}
+}
+
+type ClientResponse struct {
+	ID                 int64     `json:"id"`
+	Name               string    `json:"name"`
+	Disabled           bool      `json:"disabled"`
+	DailyTokenBudget   int64     `json:"daily_token_budget"`
+	MonthlyTokenBudget int64     `json:"monthly_token_budget"`
+	RequestsPerMinute  int64     `json:"requests_per_minute"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+func NewClientResponse(client Client) ClientResponse {
+	return ClientResponse{
+		ID:                 client.ID,
+		Name:               client.Name,
+		Disabled:           client.Disabled,
+		DailyTokenBudget:   client.Budget.DailyTokens,
+		MonthlyTokenBudget: client.Budget.MonthlyTokens,
+		RequestsPerMinute:  client.Budget.RequestsPerMinute,
+		CreatedAt:          client.CreatedAt,
+	}
+}
+
+// BudgetPeriodResponse is the usage of a budget, limit and remaining are 0 when it is not limited
+type BudgetPeriodResponse struct {
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used"`
+	Remaining int64     `json:"remaining"`
+	Exceeded  bool      `json:"exceeded"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
+func NewBudgetPeriodResponse(status BudgetPeriodStatus) BudgetPeriodResponse {
+	return BudgetPeriodResponse{
+		Limit:     status.Limit,
+		Used:      status.Used,
+		Remaining: status.Remaining(),
+		Exceeded:  status.Exceeded(),
+		ResetsAt:  status.ResetsAt,
+	}
+}
+
+type ClientBudgetResponse struct {
+	ClientID          int64                `json:"client_id"`
+	DailyTokens       BudgetPeriodResponse `json:"daily_tokens"`
+	MonthlyTokens     BudgetPeriodResponse `json:"monthly_tokens"`
+	RequestsPerMinute BudgetPeriodResponse `json:"requests_per_minute"`
+}
+
+func NewClientBudgetResponse(status ClientBudgetStatus) ClientBudgetResponse {
+	return ClientBudgetResponse{
+		ClientID:          status.ClientID,
+		DailyTokens:       NewBudgetPeriodResponse(status.DailyTokens),
+		MonthlyTokens:     NewBudgetPeriodResponse(status.MonthlyTokens),
+		RequestsPerMinute: NewBudgetPeriodResponse(status.RequestsPerMinute),
+	}
+}
+
+// ClientTokenResponse is a client with its token, only sent when the token is generated
+type ClientTokenResponse struct {
+	Client ClientResponse `json:"client"`
+	Token  string         `json:"token"`
+}
+
+type ListClientsResponse struct {
+	Clients []ClientResponse `json:"clients"`
+}
+
+type ClientBiz interface {
+	CreateClient(ctx context.Context, params CreateClientParams) (*ClientWithToken, error)
+	RotateClientToken(ctx context.Context, id int64) (*ClientWithToken, error)
+	GetClient(ctx context.Context, id int64) (*Client, error)
+	ListClients(ctx context.Context) ([]Client, error)
+	UpdateClient(ctx context.Context, params UpdateClientParams) (*Client, error)
+	DeleteClient(ctx context.Context, id int64) error
+	GetClientBudgetStatus(ctx context.Context, id int64) (*ClientBudgetStatus, error)
+}
+
+type ClientHandler struct {
+	service ClientBiz
+}
+
+func (h *ClientHandler) CreateClient(w http.ResponseWriter, r *http.Request) {
+	var req CreateClientRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := h.service.CreateClient(r.Context(), req.ToParams())
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+
+	httpapi.WriteJSON(w, http.StatusCreated, ClientTokenResponse{Client: NewClientResponse(created.Client), Token: created.Token})
+}
+
+// RotateClientToken replaces the token of a client, the previous token stops working at once
+func (h *ClientHandler) RotateClientToken(w http.ResponseWriter, r *http.Request) {
+	id, err := parseClientID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rotated, err := h.service.RotateClientToken(r.Context(), id)
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+
+	httpapi.WriteJSON(w, http.StatusOK, ClientTokenResponse{Client: NewClientResponse(rotated.Client), Token: rotated.Token})
+}
+
+func (h *ClientHandler) ListClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := h.service.ListClients(r.Context())
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+
+	response := ListClientsResponse{Clients: make([]ClientResponse, 0, len(clients))}
+	for _, client := range clients {
+		response.Clients = append(response.Clients, NewClientResponse(client))
+	}
+	httpapi.WriteJSON(w, http.StatusOK, response)
+}
+
+func (h *ClientHandler) GetClient(w http.ResponseWriter, r *http.Request) {
+	id, err := parseClientID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.service.GetClient(r.Context(), id)
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+
+	httpapi.WriteJSON(w, http.StatusOK, NewClientResponse(*client))
+}
+
+func (h *ClientHandler) UpdateClient(w http.ResponseWriter, r *http.Request) {
+	id, err := parseClientID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req UpdateClientRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.service.UpdateClient(r.Context(), req.ToParams(id))
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+
+	httpapi.WriteJSON(w, http.StatusOK, NewClientResponse(*client))
+}
+
+func (h *ClientHandler) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	id, err := parseClientID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.DeleteClient(r.Context(), id)
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetClientBudget returns the usage of the budgets of a client
+func (h *ClientHandler) GetClientBudget(w http.ResponseWriter, r *http.Request) {
+	id, err := parseClientID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status, err := h.service.GetClientBudgetStatus(r.Context(), id)
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+
+	httpapi.WriteJSON(w, http.StatusOK, NewClientBudgetResponse(*status))
+}
+
+func parseClientID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid client id: %s", r.PathValue("id"))
+	}
+	return id, nil
+}
+
+// writeClientError writes the error with the status matching its kind
+func writeClientError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrClientNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidClientName), errors.Is(err, ErrInvalidBudget):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrDuplicateClient):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func NewClientHandler(service ClientBiz) *ClientHandler {
+	return &ClientHandler{service: service}
+}
@@ -0,0 +1,302 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockClientService is a mock implementation of ClientBiz
+type MockClientService struct {
+	mock.Mock
+}
+
+func (m *MockClientService) CreateClient(ctx context.Context, params CreateClientParams) (*ClientWithToken, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*ClientWithToken), args.Error(1)
+}
+
+func (m *MockClientService) RotateClientToken(ctx context.Context, id int64) (*ClientWithToken, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*ClientWithToken), args.Error(1)
+}
+
+func (m *MockClientService) GetClient(ctx context.Context, id int64) (*Client, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Client), args.Error(1)
+}
+
+func (m *MockClientService) ListClients(ctx context.Context) ([]Client, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Client), args.Error(1)
+}
+
+func (m *MockClientService) UpdateClient(ctx context.Context, params UpdateClientParams) (*Client, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Client), args.Error(1)
+}
+
+func (m *MockClientService) DeleteClient(ctx context.Context, id int64) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockClientService) GetClientBudgetStatus(ctx context.Context, id int64) (*ClientBudgetStatus, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*ClientBudgetStatus), args.Error(1)
+}
+
+// newClientRouter routes the client endpoints to the handler, so path values are set
+func newClientRouter(handler *ClientHandler) http.Handler {
+	router := http.NewServeMux()
+	router.HandleFunc("POST /clients", handler.CreateClient)
+	router.HandleFunc("GET /clients", handler.ListClients)
+	router.HandleFunc("GET /clients/{id}", handler.GetClient)
+	router.HandleFunc("PATCH /clients/{id}", handler.UpdateClient)
+	router.HandleFunc("DELETE /clients/{id}", handler.DeleteClient)
+	router.HandleFunc("POST /clients/{id}/token", handler.RotateClientToken)
+	router.HandleFunc("GET /clients/{id}/budget", handler.GetClientBudget)
+	return router
+}
+
+func TestClientHandler_GetClientBudget(t *testing.T) {
+	resetsAt := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	mockService := new(MockClientService)
+	mockService.On("GetClientBudgetStatus", mock.Anything, int64(1)).Return(&ClientBudgetStatus{
+		ClientID:          1,
+		DailyTokens:       BudgetPeriodStatus{Period: BudgetPeriodDay, Limit: 1000, Used: 1200, ResetsAt: resetsAt},
+		MonthlyTokens:     BudgetPeriodStatus{Period: BudgetPeriodMonth, Used: 5000},
+		RequestsPerMinute: BudgetPeriodStatus{Period: BudgetPeriodMinute, Limit: 60, Used: 12},
+	}, nil)
+	router := newClientRouter(NewClientHandler(mockService))
+
+	req := httptest.NewRequest("GET", "/clients/1/budget", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response ClientBudgetResponse
+	err := json.NewDecoder(rr.Body).Decode(&response)
+	assert.NoError(t, err)
+	assert.Equal(t, BudgetPeriodResponse{Limit: 1000, Used: 1200, Remaining: 0, Exceeded: true, ResetsAt: resetsAt}, response.DailyTokens)
+	assert.Equal(t, BudgetPeriodResponse{Used: 5000}, response.MonthlyTokens)
+	assert.Equal(t, BudgetPeriodResponse{Limit: 60, Used: 12, Remaining: 48}, response.RequestsPerMinute)
+	mockService.AssertExpectations(t)
+}
+
+func TestClientHandler(t *testing.T) {
+	token := "jhp_secret-token"
+	disabled := true
+	budget := int64(100000)
+	negative := int64(-1)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		setupMock      func(*MockClientService)
+		expectedStatus int
+		expectedToken  bool
+	}{
+		{
+			name:   "Create client",
+			method: "POST",
+			path:   "/clients",
+			body:   `{"name":"team-a"}`,
+			setupMock: func(m *MockClientService) {
+				m.On("CreateClient", mock.Anything, CreateClientParams{Name: "team-a"}).Return(&ClientWithToken{Client: Client{ID: 1, Name: "team-a"}, Token: token}, nil)
+			},
+			expectedStatus: http.StatusCreated,
+			expectedToken:  true,
+		},
+		{
+			name:   "Create client with invalid name",
+			method: "POST",
+			path:   "/clients",
+			body:   `{"name":"team:a"}`,
+			setupMock: func(m *MockClientService) {
+				m.On("CreateClient", mock.Anything, CreateClientParams{Name: "team:a"}).Return(nil, ErrInvalidClientName)
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Create duplicate client",
+			method: "POST",
+			path:   "/clients",
+			body:   `{"name":"team-a"}`,
+			setupMock: func(m *MockClientService) {
+				m.On("CreateClient", mock.Anything, CreateClientParams{Name: "team-a"}).Return(nil, ErrDuplicateClient)
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Invalid create body",
+			method:         "POST",
+			path:           "/clients",
+			body:           "invalid-json",
+			setupMock:      func(m *MockClientService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "List clients",
+			method: "GET",
+			path:   "/clients",
+			setupMock: func(m *MockClientService) {
+				m.On("ListClients", mock.Anything).Return([]Client{{ID: 1, Name: "team-a"}}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Get client",
+			method: "GET",
+			path:   "/clients/1",
+			setupMock: func(m *MockClientService) {
+				m.On("GetClient", mock.Anything, int64(1)).Return(&Client{ID: 1, Name: "team-a"}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Get missing client",
+			method: "GET",
+			path:   "/clients/2",
+			setupMock: func(m *MockClientService) {
+				m.On("GetClient", mock.Anything, int64(2)).Return(nil, ErrClientNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Invalid id",
+			method:         "GET",
+			path:           "/clients/abc",
+			setupMock:      func(m *MockClientService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Disable client",
+			method: "PATCH",
+			path:   "/clients/1",
+			body:   `{"disabled":true}`,
+			setupMock: func(m *MockClientService) {
+				m.On("UpdateClient", mock.Anything, UpdateClientParams{ID: 1, Disabled: &disabled}).Return(&Client{ID: 1, Name: "team-a", Disabled: true}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Set daily token budget",
+			method: "PATCH",
+			path:   "/clients/1",
+			body:   `{"daily_token_budget":100000}`,
+			setupMock: func(m *MockClientService) {
+				m.On("UpdateClient", mock.Anything, UpdateClientParams{ID: 1, DailyTokenBudget: &budget}).Return(&Client{ID: 1, Name: "team-a", Budget: ClientBudget{DailyTokens: budget}}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Set negative budget",
+			method: "PATCH",
+			path:   "/clients/1",
+			body:   `{"requests_per_minute":-1}`,
+			setupMock: func(m *MockClientService) {
+				m.On("UpdateClient", mock.Anything, UpdateClientParams{ID: 1, RequestsPerMinute: &negative}).Return(nil, ErrInvalidBudget)
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Get client budget",
+			method: "GET",
+			path:   "/clients/1/budget",
+			setupMock: func(m *MockClientService) {
+				m.On("GetClientBudgetStatus", mock.Anything, int64(1)).Return(&ClientBudgetStatus{ClientID: 1}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Get budget of missing client",
+			method: "GET",
+			path:   "/clients/2/budget",
+			setupMock: func(m *MockClientService) {
+				m.On("GetClientBudgetStatus", mock.Anything, int64(2)).Return(nil, ErrClientNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:   "Rotate token",
+			method: "POST",
+			path:   "/clients/1/token",
+			setupMock: func(m *MockClientService) {
+				m.On("RotateClientToken", mock.Anything, int64(1)).Return(&ClientWithToken{Client: Client{ID: 1, Name: "team-a"}, Token: token}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedToken:  true,
+		},
+		{
+			name:   "Delete client",
+			method: "DELETE",
+			path:   "/clients/1",
+			setupMock: func(m *MockClientService) {
+				m.On("DeleteClient", mock.Anything, int64(1)).Return(nil)
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:   "Delete missing client",
+			method: "DELETE",
+			path:   "/clients/1",
+			setupMock: func(m *MockClientService) {
+				m.On("DeleteClient", mock.Anything, int64(1)).Return(ErrClientNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockService := new(MockClientService)
+			tc.setupMock(mockService)
+			router := newClientRouter(NewClientHandler(mockService))
+
+			req, err := http.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body))
+			assert.NoError(t, err)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedToken {
+				var response ClientTokenResponse
+				err = json.NewDecoder(bytes.NewReader(rr.Body.Bytes())).Decode(&response)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(1), response.Client.ID)
+				assert.Equal(t, token, response.Token)
+			} else {
+				assert.NotContains(t, rr.Body.String(), token)
+			}
+			mockService.AssertExpectations(t)
+		})
+	}
+}
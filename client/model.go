@@ -0,0 +1,171 @@
+package client
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Client is a consumer of the proxy, authenticated by its token
+type Client struct {
+	ID        int64
+	Name      string
+	Disabled  bool
+	Budget    ClientBudget
+	CreatedAt time.Time
+}
+
+// ClientBudget is what a client may spend, 0 means no limit
+type ClientBudget struct {
+	DailyTokens       int64
+	MonthlyTokens     int64
+	RequestsPerMinute int64
+}
+
+type CreateClientParams struct {
+	Name string
+}
+
+// ClientWithToken is a client with its raw token, only known when the token is generated
+type ClientWithToken struct {
+	Client Client
+	Token  string
+}
+
+// UpdateClientParams updates the fields that are not nil
+type UpdateClientParams struct {
+	ID                 int64
+	Disabled           *bool
+	DailyTokenBudget   *int64
+	MonthlyTokenBudget *int64
+	RequestsPerMinute  *int64
+}
+
+// BudgetPeriod is the period over which a budget is spent
+type BudgetPeriod string
+
+const (
+	BudgetPeriodMinute BudgetPeriod = "minute"
+	BudgetPeriodDay    BudgetPeriod = "day"
+	BudgetPeriodMonth  BudgetPeriod = "month"
+)
+
+// ClientUsage is the tokens used by a client in the current day and month
+type ClientUsage struct {
+	DailyTokens   int64
+	MonthlyTokens int64
+}
+
+// BudgetPeriodStatus is the usage of a budget in its current period
+type BudgetPeriodStatus struct {
+	Period   BudgetPeriod
+	Limit    int64
+	Used     int64
+	ResetsAt time.Time
+}
+
+// Exceeded reports whether the budget is limited and spent
+func (s BudgetPeriodStatus) Exceeded() bool {
+	return s.Limit > 0 && s.Used >= s.Limit
+}
+
+// Remaining returns what is left of a limited budget, 0 when it is not limited
+func (s BudgetPeriodStatus) Remaining() int64 {
+	if s.Limit == 0 {
+		return 0
+	}
+	return max(s.Limit-s.Used, 0)
+}
+
+// ClientBudgetStatus is the usage of every budget of a client
+type ClientBudgetStatus struct {
+	ClientID          int64
+	DailyTokens       BudgetPeriodStatus
+	MonthlyTokens     BudgetPeriodStatus
+	RequestsPerMinute BudgetPeriodStatus
+}
+
+// startOfDay returns the start of the UTC day of t
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// startOfMonth returns the start of the UTC month of t
+func startOfMonth(t time.Time) time.Time {
+	year, month, _ := t.UTC().Date()
+	return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// NewClientBudgetStatus returns the status of the token budgets of a client from its usage,
+// days and months are UTC. The requests per minute status is left to the rate limiter.
+func NewClientBudgetStatus(client Client, usage ClientUsage, now time.Time) ClientBudgetStatus {
+	return ClientBudgetStatus{
+		ClientID: client.ID,
+		DailyTokens: BudgetPeriodStatus{
+			Period:   BudgetPeriodDay,
+			Limit:    client.Budget.DailyTokens,
+			Used:     usage.DailyTokens,
+			ResetsAt: startOfDay(now).AddDate(0, 0, 1),
+		},
+		MonthlyTokens: BudgetPeriodStatus{
+			Period:   BudgetPeriodMonth,
+			Limit:    client.Budget.MonthlyTokens,
+			Used:     usage.MonthlyTokens,
+			ResetsAt: startOfMonth(now).AddDate(0, 1, 0),
+		},
+	}
+}
+
+// MaxClientNameLength is the longest client name accepted
+const MaxClientNameLength = 64
+
+// ValidateClientName checks that a name is not empty and only has letters, digits, '.', '_' and '-',
+// so it can be used as the user name of Basic authentication
+func ValidateClientName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: name is empty", ErrInvalidClientName)
+	}
+	if len(name) > MaxClientNameLength {
+		return fmt.Errorf("%w: name is longer than %d characters", ErrInvalidClientName, MaxClientNameLength)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return fmt.Errorf("%w: name must only contain letters, digits, '.', '_' and '-'", ErrInvalidClientName)
+		}
+	}
+	return nil
+}
+
+// TokenPrefix starts every client token, to recognize them
+const TokenPrefix = "jhp_"
+
+// GenerateToken returns a new random client token
+func GenerateToken() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return TokenPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// HashToken returns the hash of a token, only hashes are stored
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	ErrClientNotFound     = errors.New("client not found")
+	ErrClientDisabled     = errors.New("client disabled")
+	ErrDuplicateClient    = errors.New("client already exists")
+	ErrInvalidClientName  = errors.New("invalid client name")
+	ErrInvalidCredentials = errors.New("invalid client credentials")
+	ErrInvalidBudget      = errors.New("invalid budget: limits must not be negative")
+)
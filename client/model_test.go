@@ -0,0 +1,75 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateClientName(t *testing.T) {
+	tests := []struct {
+		name        string
+		clientName  string
+		expectedErr bool
+	}{
+		{name: "Valid", clientName: "team-a.search_1"},
+		{name: "Longest", clientName: strings.Repeat("a", MaxClientNameLength)},
+		{name: "Empty", clientName: "", expectedErr: true},
+		{name: "Too long", clientName: strings.Repeat("a", MaxClientNameLength+1), expectedErr: true},
+		{name: "Colon", clientName: "team:a", expectedErr: true},
+		{name: "Space", clientName: "team a", expectedErr: true},
+		{name: "Non ASCII", clientName: "équipe", expectedErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateClientName(tc.clientName)
+			if tc.expectedErr {
+				assert.ErrorIs(t, err, ErrInvalidClientName)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGenerateToken(t *testing.T) {
+	token, err := GenerateToken()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, TokenPrefix))
+
+	other, err := GenerateToken()
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+}
+
+func TestHashToken(t *testing.T) {
+	hash := HashToken("jhp_token")
+	assert.Len(t, hash, 64)
+	assert.Equal(t, hash, HashToken("jhp_token"))
+	assert.NotEqual(t, hash, HashToken("jhp_other"))
+	assert.NotContains(t, hash, "jhp_token")
+}
+
+func TestBudgetPeriodStatus(t *testing.T) {
+	tests := []struct {
+		name              string
+		status            BudgetPeriodStatus
+		expectedExceeded  bool
+		expectedRemaining int64
+	}{
+		{name: "No limit", status: BudgetPeriodStatus{Used: 100}},
+		{name: "Within limit", status: BudgetPeriodStatus{Limit: 100, Used: 40}, expectedRemaining: 60},
+		{name: "Limit reached", status: BudgetPeriodStatus{Limit: 100, Used: 100}, expectedExceeded: true},
+		{name: "Limit overshot", status: BudgetPeriodStatus{Limit: 100, Used: 130}, expectedExceeded: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedExceeded, tc.status.Exceeded())
+			assert.Equal(t, tc.expectedRemaining, tc.status.Remaining())
+		})
+	}
+}
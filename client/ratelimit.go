@@ -0,0 +1,74 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter counts the requests of each client over fixed one minute windows.
+// Counts are kept in memory, so each instance enforces the limit on its own.
+type RateLimiter struct {
+	mu      sync.Mutex
+	windows map[int64]rateWindow
+}
+
+// rateWindow is the number of requests of a client since the start of the window
+type rateWindow struct {
+	start time.Time
+	count int64
+}
+
+// Allow counts a request of the client unless the limit is reached, and returns the status of the window.
+// Requests are always allowed when the limit is 0.
+func (l *RateLimiter) Allow(id int64, limit int64, now time.Time) (BudgetPeriodStatus, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	window := l.window(id, now)
+	allowed := limit == 0 || window.count < limit
+	if allowed {
+		window.count++
+		l.windows[id] = window
+	}
+
+	return rateStatus(window, limit), allowed
+}
+
+// Status returns the status of the window of the client without counting a request
+func (l *RateLimiter) Status(id int64, limit int64, now time.Time) BudgetPeriodStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return rateStatus(l.window(id, now), limit)
+}
+
+// Forget drops the window of a client
+func (l *RateLimiter) Forget(id int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.windows, id)
+}
+
+// window returns the current window of the client, a new one when the previous window is over
+func (l *RateLimiter) window(id int64, now time.Time) rateWindow {
+	start := now.Truncate(time.Minute)
+	window, ok := l.windows[id]
+	if !ok || window.start.Before(start) {
+		return rateWindow{start: start}
+	}
+	return window
+}
+
+func rateStatus(window rateWindow, limit int64) BudgetPeriodStatus {
+	return BudgetPeriodStatus{
+		Period:   BudgetPeriodMinute,
+		Limit:    limit,
+		Used:     window.count,
+		ResetsAt: window.start.Add(time.Minute),
+	}
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{windows: make(map[int64]rateWindow)}
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	limiter := NewRateLimiter()
+	start := time.Date(2026, 10, 16, 12, 30, 0, 0, time.UTC)
+
+	// Requests are counted up to the limit
+	for i := 0; i < 3; i++ {
+		status, allowed := limiter.Allow(1, 3, start.Add(time.Duration(i)*time.Second))
+		assert.True(t, allowed)
+		assert.Equal(t, int64(i+1), status.Used)
+	}
+
+	status, allowed := limiter.Allow(1, 3, start.Add(59*time.Second))
+	assert.False(t, allowed)
+	assert.Equal(t, BudgetPeriodStatus{Period: BudgetPeriodMinute, Limit: 3, Used: 3, ResetsAt: start.Add(time.Minute)}, status)
+
+	// Other clients have their own window
+	_, allowed = limiter.Allow(2, 3, start.Add(59*time.Second))
+	assert.True(t, allowed)
+
+	// The window resets every minute
+	status, allowed = limiter.Allow(1, 3, start.Add(time.Minute))
+	assert.True(t, allowed)
+	assert.Equal(t, int64(1), status.Used)
+
+	// Requests are not limited without limit
+	for i := 0; i < 10; i++ {
+		_, allowed = limiter.Allow(3, 0, start)
+		assert.True(t, allowed)
+	}
+}
+
+func TestRateLimiter_Status(t *testing.T) {
+	limiter := NewRateLimiter()
+	now := time.Date(2026, 10, 16, 12, 30, 20, 0, time.UTC)
+
+	limiter.Allow(1, 5, now)
+	assert.Equal(t, int64(1), limiter.Status(1, 5, now).Used)
+	assert.Equal(t, int64(1), limiter.Status(1, 5, now).Used)
+	assert.Equal(t, int64(0), limiter.Status(1, 5, now.Add(time.Minute)).Used)
+
+	limiter.Forget(1)
+	assert.Equal(t, int64(0), limiter.Status(1, 5, now).Used)
+}
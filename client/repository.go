@@ -0,0 +1,159 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// clientColumns are the columns scanned by scanClient
+const clientColumns = "id, name, disabled, daily_token_budget, monthly_token_budget, requests_per_minute, created_at"
+
+type ClientDBRepository struct {
+	db *sql.DB
+}
+
+// Check if ClientDBRepository implements ClientRepository
+var _ ClientRepository = &ClientDBRepository{}
+
+// CreateClient inserts a client with the hash of its token, or returns ErrDuplicateClient if the name is taken
+func (r *ClientDBRepository) CreateClient(ctx context.Context, name string, tokenHash string) (*Client, error) {
+	clients, err := r.listClients(ctx, `
+		WITH inserted AS (
+			INSERT INTO clients (name, token_hash) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING
+			RETURNING `+clientColumns+`
+		)
+		SELECT `+clientColumns+` FROM inserted`, name, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(clients) == 0 {
+		return nil, ErrDuplicateClient
+	}
+
+	return &clients[0], nil
+}
+
+// GetClientByTokenHash returns the client with the given token hash, or ErrClientNotFound if it does not exist
+func (r *ClientDBRepository) GetClientByTokenHash(ctx context.Context, tokenHash string) (*Client, error) {
+	return r.getClient(ctx, "SELECT "+clientColumns+" FROM clients WHERE token_hash = $1", tokenHash)
+}
+
+// GetClient returns the client with the given id, or ErrClientNotFound if it does not exist
+func (r *ClientDBRepository) GetClient(ctx context.Context, id int64) (*Client, error) {
+	return r.getClient(ctx, "SELECT "+clientColumns+" FROM clients WHERE id = $1", id)
+}
+
+// ListClients returns every client ordered by id
+func (r *ClientDBRepository) ListClients(ctx context.Context) ([]Client, error) {
+	return r.listClients(ctx, "SELECT "+clientColumns+" FROM clients ORDER BY id")
+}
+
+// UpdateClient updates the given fields of a client and returns the updated client
+func (r *ClientDBRepository) UpdateClient(ctx context.Context, params UpdateClientParams) (*Client, error) {
+	return r.getClient(ctx, `
+		UPDATE clients SET
+			disabled = COALESCE($2, disabled),
+			daily_token_budget = COALESCE($3, daily_token_budget),
+			monthly_token_budget = COALESCE($4, monthly_token_budget),
+			requests_per_minute = COALESCE($5, requests_per_minute)
+		WHERE id = $1
+		RETURNING `+clientColumns, params.ID, params.Disabled, params.DailyTokenBudget, params.MonthlyTokenBudget, params.RequestsPerMinute)
+}
+
+// UpdateClientToken replaces the token hash of a client, the previous token stops working
+func (r *ClientDBRepository) UpdateClientToken(ctx context.Context, id int64, tokenHash string) (*Client, error) {
+	return r.getClient(ctx, `
+		UPDATE clients SET token_hash = $2 WHERE id = $1
+		RETURNING `+clientColumns, id, tokenHash)
+}
+
+// DeleteClient deletes the client with the given id
+func (r *ClientDBRepository) DeleteClient(ctx context.Context, id int64) error {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM clients WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrClientNotFound
+	}
+
+	return nil
+}
+
+// GetClientUsage returns the tokens used by a client in the UTC day and month of now
+func (r *ClientDBRepository) GetClientUsage(ctx context.Context, id int64, now time.Time) (*ClientUsage, error) {
+	var usage ClientUsage
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(tokens) FILTER (WHERE day = $2), 0), COALESCE(SUM(tokens), 0)
+		FROM client_usage WHERE client_id = $1 AND day >= $3 AND day <= $2`,
+		id, startOfDay(now), startOfMonth(now)).Scan(&usage.DailyTokens, &usage.MonthlyTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	return &usage, nil
+}
+
+// AddClientUsage adds tokens to the usage of a client in the UTC day of now
+func (r *ClientDBRepository) AddClientUsage(ctx context.Context, id int64, tokens int64, now time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO client_usage (client_id, day, tokens) VALUES ($1, $2, $3)
+		ON CONFLICT (client_id, day) DO UPDATE SET tokens = client_usage.tokens + EXCLUDED.tokens`,
+		id, startOfDay(now), tokens)
+	return err
+}
+
+// getClient returns the single client of the query, or ErrClientNotFound if there is none
+func (r *ClientDBRepository) getClient(ctx context.Context, query string, args ...any) (*Client, error) {
+	client, err := scanClient(r.db.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrClientNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// listClients returns the clients of the query
+func (r *ClientDBRepository) listClients(ctx context.Context, query string, args ...any) ([]Client, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []Client
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, *client)
+	}
+
+	return clients, rows.Err()
+}
+
+// scanClient scans the clientColumns of a row
+func scanClient(row interface{ Scan(dest ...any) error }) (*Client, error) {
+	var client Client
+	err := row.Scan(&client.ID, &client.Name, &client.Disabled,
+		&client.Budget.DailyTokens, &client.Budget.MonthlyTokens, &client.Budget.RequestsPerMinute, &client.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func NewClientDBRepository(db *sql.DB) ClientRepository {
+	return &ClientDBRepository{db: db}
+}
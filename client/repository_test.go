@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trancong12102/jina-http-proxy/internal/testdb"
+)
+
+func TestClientDBRepository_CreateClient(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewClientDBRepository(db)
+	ctx := context.Background()
+
+	created, err := repo.CreateClient(ctx, "team-a", HashToken("token-a"))
+	require.NoError(t, err)
+	assert.Equal(t, "team-a", created.Name)
+	assert.False(t, created.Disabled)
+
+	_, err = repo.CreateClient(ctx, "team-a", HashToken("token-b"))
+	assert.ErrorIs(t, err, ErrDuplicateClient)
+
+	client, err := repo.GetClientByTokenHash(ctx, HashToken("token-a"))
+	require.NoError(t, err)
+	assert.Equal(t, created, client)
+
+	_, err = repo.GetClientByTokenHash(ctx, HashToken("token-b"))
+	assert.ErrorIs(t, err, ErrClientNotFound)
+}
+
+func TestClientDBRepository_UpdateClientToken(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewClientDBRepository(db)
+	ctx := context.Background()
+
+	created, err := repo.CreateClient(ctx, "team-a", HashToken("token-a"))
+	require.NoError(t, err)
+
+	_, err = repo.UpdateClientToken(ctx, created.ID, HashToken("token-b"))
+	require.NoError(t, err)
+
+	_, err = repo.GetClientByTokenHash(ctx, HashToken("token-a"))
+	assert.ErrorIs(t, err, ErrClientNotFound)
+
+	client, err := repo.GetClientByTokenHash(ctx, HashToken("token-b"))
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, client.ID)
+
+	_, err = repo.UpdateClientToken(ctx, created.ID+1, HashToken("token-c"))
+	assert.ErrorIs(t, err, ErrClientNotFound)
+}
+
+func TestClientDBRepository_ManageClients(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewClientDBRepository(db)
+	ctx := context.Background()
+
+	first, err := repo.CreateClient(ctx, "team-a", HashToken("token-a"))
+	require.NoError(t, err)
+	second, err := repo.CreateClient(ctx, "team-b", HashToken("token-b"))
+	require.NoError(t, err)
+
+	clients, err := repo.ListClients(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []Client{*first, *second}, clients)
+
+	disabled := true
+	updated, err := repo.UpdateClient(ctx, UpdateClientParams{ID: first.ID, Disabled: &disabled})
+	require.NoError(t, err)
+	assert.True(t, updated.Disabled)
+
+	unchanged, err := repo.UpdateClient(ctx, UpdateClientParams{ID: first.ID})
+	require.NoError(t, err)
+	assert.True(t, unchanged.Disabled)
+
+	err = repo.DeleteClient(ctx, first.ID)
+	assert.NoError(t, err)
+
+	_, err = repo.GetClient(ctx, first.ID)
+	assert.ErrorIs(t, err, ErrClientNotFound)
+
+	err = repo.DeleteClient(ctx, first.ID)
+	assert.ErrorIs(t, err, ErrClientNotFound)
+}
+
+func TestClientDBRepository_UpdateClient_Budget(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewClientDBRepository(db)
+	ctx := context.Background()
+
+	created, err := repo.CreateClient(ctx, "team-a", HashToken("token-a"))
+	require.NoError(t, err)
+	assert.Equal(t, ClientBudget{}, created.Budget)
+
+	daily, perMinute := int64(1000), int64(60)
+	updated, err := repo.UpdateClient(ctx, UpdateClientParams{ID: created.ID, DailyTokenBudget: &daily, RequestsPerMinute: &perMinute})
+	require.NoError(t, err)
+	assert.Equal(t, ClientBudget{DailyTokens: 1000, RequestsPerMinute: 60}, updated.Budget)
+
+	client, err := repo.GetClient(ctx, created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, updated.Budget, client.Budget)
+}
+
+func TestClientDBRepository_ClientUsage(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewClientDBRepository(db)
+	ctx := context.Background()
+
+	created, err := repo.CreateClient(ctx, "team-a", HashToken("token-a"))
+	require.NoError(t, err)
+
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	usage, err := repo.GetClientUsage(ctx, created.ID, now)
+	require.NoError(t, err)
+	assert.Equal(t, &ClientUsage{}, usage)
+
+	require.NoError(t, repo.AddClientUsage(ctx, created.ID, 100, now))
+	require.NoError(t, repo.AddClientUsage(ctx, created.ID, 50, now.Add(time.Hour)))
+	require.NoError(t, repo.AddClientUsage(ctx, created.ID, 300, now.AddDate(0, 0, -1)))
+	require.NoError(t, repo.AddClientUsage(ctx, created.ID, 1000, now.AddDate(0, -1, 0)))
+
+	usage, err = repo.GetClientUsage(ctx, created.ID, now)
+	require.NoError(t, err)
+	assert.Equal(t, &ClientUsage{DailyTokens: 150, MonthlyTokens: 450}, usage)
+
+	// Usage is deleted with the client
+	require.NoError(t, repo.DeleteClient(ctx, created.ID))
+	usage, err = repo.GetClientUsage(ctx, created.ID, now)
+	require.NoError(t, err)
+	assert.Equal(t, &ClientUsage{}, usage)
+}
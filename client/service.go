@@ -0,0 +1,203 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type ClientRepository interface {
+	CreateClient(ctx context.Context, name string, tokenHash string) (*Client, error)
+	GetClientByTokenHash(ctx context.Context, tokenHash string) (*Client, error)
+	GetClient(ctx context.Context, id int64) (*Client, error)
+	ListClients(ctx context.Context) ([]Client, error)
+	UpdateClient(ctx context.Context, params UpdateClientParams) (*Client, error)
+	UpdateClientToken(ctx context.Context, id int64, tokenHash string) (*Client, error)
+	DeleteClient(ctx context.Context, id int64) error
+	GetClientUsage(ctx context.Context, id int64, now time.Time) (*ClientUsage, error)
+	AddClientUsage(ctx context.Context, id int64, tokens int64, now time.Time) error
+}
+
+type ClientService struct {
+	repo    ClientRepository
+	limiter *RateLimiter
+	cache   *ClientCache
+	now     func() time.Time
+}
+
+// CreateClient creates a client with a new token, the token is only returned here
+func (s *ClientService) CreateClient(ctx context.Context, params CreateClientParams) (*ClientWithToken, error) {
+	err := ValidateClientName(params.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := s.repo.CreateClient(ctx, params.Name, HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClientWithToken{Client: *client, Token: token}, nil
+}
+
+// RotateClientToken replaces the token of a client with a new one, the token is only returned here
+func (s *ClientService) RotateClientToken(ctx context.Context, id int64) (*ClientWithToken, error) {
+	token, err := GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := s.repo.UpdateClientToken(ctx, id, HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+
+	// The previous token is revoked at once on this instance
+	s.cache.Forget(id)
+	return &ClientWithToken{Client: *client, Token: token}, nil
+}
+
+// AuthenticateClient returns the enabled client owning the token, or ErrInvalidCredentials.
+// Authenticated clients are cached, invalid tokens are always looked up.
+func (s *ClientService) AuthenticateClient(ctx context.Context, token string) (*Client, error) {
+	now := s.now()
+	tokenHash := HashToken(token)
+	client, ok := s.cache.Authenticated(tokenHash, now)
+	if ok {
+		return client, nil
+	}
+
+	client, err := s.repo.GetClientByTokenHash(ctx, tokenHash)
+	if errors.Is(err, ErrClientNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if client.Disabled {
+		return nil, ErrInvalidCredentials
+	}
+
+	s.cache.SetAuthenticated(tokenHash, *client, now)
+	return client, nil
+}
+
+func (s *ClientService) GetClient(ctx context.Context, id int64) (*Client, error) {
+	return s.repo.GetClient(ctx, id)
+}
+
+func (s *ClientService) ListClients(ctx context.Context) ([]Client, error) {
+	return s.repo.ListClients(ctx)
+}
+
+func (s *ClientService) UpdateClient(ctx context.Context, params UpdateClientParams) (*Client, error) {
+	for _, limit := range []*int64{params.DailyTokenBudget, params.MonthlyTokenBudget, params.RequestsPerMinute} {
+		if limit != nil && *limit < 0 {
+			return nil, ErrInvalidBudget
+		}
+	}
+
+	client, err := s.repo.UpdateClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	// A disabled client or a new budget applies at once on this instance
+	s.cache.Forget(params.ID)
+	return client, nil
+}
+
+func (s *ClientService) DeleteClient(ctx context.Context, id int64) error {
+	err := s.repo.DeleteClient(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	s.limiter.Forget(id)
+	s.cache.Forget(id)
+	return nil
+}
+
+// CheckClientBudget counts a request of the client and returns the first budget it has spent,
+// or nil when the request can be sent. A request spending more than the tokens left is still sent,
+// the following requests are refused until the budget resets.
+// ErrClientNotFound or ErrClientDisabled is returned when the client can no longer send requests.
+// The client and its usage are cached, the usage debited by other instances is seen once the cache expires.
+func (s *ClientService) CheckClientBudget(ctx context.Context, id int64) (*BudgetPeriodStatus, error) {
+	now := s.now()
+	client, ok := s.cache.Client(id, now)
+	if !ok {
+		var err error
+		client, err = s.repo.GetClient(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		s.cache.SetClient(*client, now)
+	}
+	if client.Disabled {
+		return nil, ErrClientDisabled
+	}
+
+	usage, ok := s.cache.Usage(id, now)
+	if !ok {
+		var err error
+		usage, err = s.repo.GetClientUsage(ctx, id, now)
+		if err != nil {
+			return nil, err
+		}
+		s.cache.SetUsage(id, *usage, now)
+	}
+
+	status := NewClientBudgetStatus(*client, *usage, now)
+	for _, period := range []BudgetPeriodStatus{status.DailyTokens, status.MonthlyTokens} {
+		if period.Exceeded() {
+			return &period, nil
+		}
+	}
+
+	minute, allowed := s.limiter.Allow(id, client.Budget.RequestsPerMinute, now)
+	if !allowed {
+		return &minute, nil
+	}
+
+	return nil, nil
+}
+
+// DebitClientUsage adds the tokens used by a request of the client to its usage, and to its cached usage
+func (s *ClientService) DebitClientUsage(ctx context.Context, id int64, tokens int64) error {
+	now := s.now()
+	err := s.repo.AddClientUsage(ctx, id, tokens, now)
+	if err != nil {
+		return err
+	}
+
+	s.cache.AddUsage(id, tokens, now)
+	return nil
+}
+
+// GetClientBudgetStatus returns the usage of every budget of the client
+func (s *ClientService) GetClientBudgetStatus(ctx context.Context, id int64) (*ClientBudgetStatus, error) {
+	now := s.now()
+	client, err := s.repo.GetClient(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := s.repo.GetClientUsage(ctx, id, now)
+	if err != nil {
+		return nil, err
+	}
+
+	status := NewClientBudgetStatus(*client, *usage, now)
+	status.RequestsPerMinute = s.limiter.Status(id, client.Budget.RequestsPerMinute, now)
+	return &status, nil
+}
+
+func NewClientService(repo ClientRepository) *ClientService {
+	return &ClientService{repo: repo, limiter: NewRateLimiter(), cache: NewClientCache(CacheTTL), now: time.Now}
+}
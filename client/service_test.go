@@ -0,0 +1,392 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockClientRepository is a mock implementation of ClientRepository
+type MockClientRepository struct {
+	mock.Mock
+}
+
+func (m *MockClientRepository) CreateClient(ctx context.Context, name string, tokenHash string) (*Client, error) {
+	args := m.Called(ctx, name, tokenHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Client), args.Error(1)
+}
+
+func (m *MockClientRepository) GetClientByTokenHash(ctx context.Context, tokenHash string) (*Client, error) {
+	args := m.Called(ctx, tokenHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Client), args.Error(1)
+}
+
+func (m *MockClientRepository) GetClient(ctx context.Context, id int64) (*Client, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Client), args.Error(1)
+}
+
+func (m *MockClientRepository) ListClients(ctx context.Context) ([]Client, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Client), args.Error(1)
+}
+
+func (m *MockClientRepository) UpdateClient(ctx context.Context, params UpdateClientParams) (*Client, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Client), args.Error(1)
+}
+
+func (m *MockClientRepository) UpdateClientToken(ctx context.Context, id int64, tokenHash string) (*Client, error) {
+	args := m.Called(ctx, id, tokenHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Client), args.Error(1)
+}
+
+func (m *MockClientRepository) DeleteClient(ctx context.Context, id int64) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockClientRepository) GetClientUsage(ctx context.Context, id int64, now time.Time) (*ClientUsage, error) {
+	args := m.Called(ctx, id, now)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*ClientUsage), args.Error(1)
+}
+
+func (m *MockClientRepository) AddClientUsage(ctx context.Context, id int64, tokens int64, now time.Time) error {
+	args := m.Called(ctx, id, tokens, now)
+	return args.Error(0)
+}
+
+func TestClientService_CreateClient(t *testing.T) {
+	mockRepo := new(MockClientRepository)
+	service := NewClientService(mockRepo)
+	ctx := context.Background()
+
+	var storedHash string
+	mockRepo.On("CreateClient", ctx, "team-a", mock.AnythingOfType("string")).
+		Run(func(args mock.Arguments) { storedHash = args.String(2) }).
+		Return(&Client{ID: 1, Name: "team-a"}, nil)
+
+	created, err := service.CreateClient(ctx, CreateClientParams{Name: "team-a"})
+	require.NoError(t, err)
+	assert.Equal(t, Client{ID: 1, Name: "team-a"}, created.Client)
+	assert.Equal(t, HashToken(created.Token), storedHash)
+
+	_, err = service.CreateClient(ctx, CreateClientParams{Name: "team:a"})
+	assert.ErrorIs(t, err, ErrInvalidClientName)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestClientService_RotateClientToken(t *testing.T) {
+	mockRepo := new(MockClientRepository)
+	service := NewClientService(mockRepo)
+	ctx := context.Background()
+
+	var storedHash string
+	mockRepo.On("UpdateClientToken", ctx, int64(1), mock.AnythingOfType("string")).
+		Run(func(args mock.Arguments) { storedHash = args.String(2) }).
+		Return(&Client{ID: 1, Name: "team-a"}, nil).Once()
+	mockRepo.On("UpdateClientToken", ctx, int64(2), mock.AnythingOfType("string")).Return(nil, ErrClientNotFound).Once()
+
+	rotated, err := service.RotateClientToken(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, HashToken(rotated.Token), storedHash)
+
+	_, err = service.RotateClientToken(ctx, 2)
+	assert.ErrorIs(t, err, ErrClientNotFound)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestClientService_AuthenticateClient(t *testing.T) {
+	tests := []struct {
+		name           string
+		client         *Client
+		repoErr        error
+		expectedClient *Client
+		expectedErr    error
+	}{
+		{
+			name:           "Valid token",
+			client:         &Client{ID: 1, Name: "team-a"},
+			expectedClient: &Client{ID: 1, Name: "team-a"},
+		},
+		{
+			name:        "Unknown token",
+			repoErr:     ErrClientNotFound,
+			expectedErr: ErrInvalidCredentials,
+		},
+		{
+			name:        "Disabled client",
+			client:      &Client{ID: 1, Name: "team-a", Disabled: true},
+			expectedErr: ErrInvalidCredentials,
+		},
+		{
+			name:        "Repository error",
+			repoErr:     assert.AnError,
+			expectedErr: assert.AnError,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockClientRepository)
+			service := NewClientService(mockRepo)
+			ctx := context.Background()
+
+			if tc.client != nil {
+				mockRepo.On("GetClientByTokenHash", ctx, HashToken("jhp_token")).Return(tc.client, nil)
+			} else {
+				mockRepo.On("GetClientByTokenHash", ctx, HashToken("jhp_token")).Return(nil, tc.repoErr)
+			}
+
+			client, err := service.AuthenticateClient(ctx, "jhp_token")
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectedClient, client)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestClientService_Cache(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 30, 15, 0, time.UTC)
+	mockRepo := new(MockClientRepository)
+	service := NewClientService(mockRepo)
+	service.now = func() time.Time { return now }
+	ctx := context.Background()
+	budget := ClientBudget{DailyTokens: 1000}
+
+	mockRepo.On("GetClientByTokenHash", ctx, HashToken("jhp_token")).Return(&Client{ID: 1, Name: "team-a", Budget: budget}, nil).Twice()
+	mockRepo.On("GetClientUsage", ctx, int64(1), now).Return(&ClientUsage{DailyTokens: 900}, nil).Twice()
+	mockRepo.On("AddClientUsage", ctx, int64(1), int64(100), now).Return(nil).Once()
+
+	// The authenticated client and its usage are read once, the debited tokens are added to the cached usage
+	for i := 0; i < 3; i++ {
+		_, err := service.AuthenticateClient(ctx, "jhp_token")
+		require.NoError(t, err)
+		exceeded, err := service.CheckClientBudget(ctx, 1)
+		require.NoError(t, err)
+		assert.Nil(t, exceeded)
+	}
+	require.NoError(t, service.DebitClientUsage(ctx, 1, 100))
+	exceeded, err := service.CheckClientBudget(ctx, 1)
+	require.NoError(t, err)
+	require.NotNil(t, exceeded)
+	assert.Equal(t, int64(1000), exceeded.Used)
+
+	// Updating the client drops its cached entries
+	enabled := false
+	mockRepo.On("UpdateClient", ctx, UpdateClientParams{ID: 1, Disabled: &enabled}).Return(&Client{ID: 1, Name: "team-a"}, nil).Once()
+	_, err = service.UpdateClient(ctx, UpdateClientParams{ID: 1, Disabled: &enabled})
+	require.NoError(t, err)
+	_, err = service.AuthenticateClient(ctx, "jhp_token")
+	require.NoError(t, err)
+	_, err = service.CheckClientBudget(ctx, 1)
+	require.NoError(t, err)
+
+	// Rotating the token revokes the cached token
+	mockRepo.On("UpdateClientToken", ctx, int64(1), mock.AnythingOfType("string")).Return(&Client{ID: 1, Name: "team-a"}, nil).Once()
+	mockRepo.On("GetClientByTokenHash", ctx, HashToken("jhp_token")).Return(nil, ErrClientNotFound).Once()
+	_, err = service.RotateClientToken(ctx, 1)
+	require.NoError(t, err)
+	_, err = service.AuthenticateClient(ctx, "jhp_token")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestClientService_ManageClients(t *testing.T) {
+	mockRepo := new(MockClientRepository)
+	service := NewClientService(mockRepo)
+	ctx := context.Background()
+	client := &Client{ID: 1, Name: "team-a"}
+	disabled := true
+
+	mockRepo.On("ListClients", ctx).Return([]Client{*client}, nil)
+	clients, err := service.ListClients(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []Client{*client}, clients)
+
+	mockRepo.On("GetClient", ctx, int64(1)).Return(client, nil)
+	got, err := service.GetClient(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, client, got)
+
+	updateParams := UpdateClientParams{ID: 1, Disabled: &disabled}
+	mockRepo.On("UpdateClient", ctx, updateParams).Return(client, nil)
+	_, err = service.UpdateClient(ctx, updateParams)
+	assert.NoError(t, err)
+
+	mockRepo.On("DeleteClient", ctx, int64(1)).Return(ErrClientNotFound)
+	err = service.DeleteClient(ctx, 1)
+	assert.ErrorIs(t, err, ErrClientNotFound)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestClientService_UpdateClient_InvalidBudget(t *testing.T) {
+	mockRepo := new(MockClientRepository)
+	service := NewClientService(mockRepo)
+	negative := int64(-1)
+
+	_, err := service.UpdateClient(context.Background(), UpdateClientParams{ID: 1, MonthlyTokenBudget: &negative})
+	assert.ErrorIs(t, err, ErrInvalidBudget)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestClientService_CheckClientBudget(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 30, 15, 0, time.UTC)
+
+	tests := []struct {
+		name             string
+		budget           ClientBudget
+		usage            ClientUsage
+		requests         int
+		expectedExceeded *BudgetPeriodStatus
+	}{
+		{
+			name:     "No limit",
+			usage:    ClientUsage{DailyTokens: 1000000, MonthlyTokens: 1000000},
+			requests: 100,
+		},
+		{
+			name:     "Within budgets",
+			budget:   ClientBudget{DailyTokens: 1000, MonthlyTokens: 10000, RequestsPerMinute: 2},
+			usage:    ClientUsage{DailyTokens: 999, MonthlyTokens: 5000},
+			requests: 2,
+		},
+		{
+			name:     "Daily budget spent",
+			budget:   ClientBudget{DailyTokens: 1000, MonthlyTokens: 10000},
+			usage:    ClientUsage{DailyTokens: 1000, MonthlyTokens: 5000},
+			requests: 1,
+			expectedExceeded: &BudgetPeriodStatus{
+				Period: BudgetPeriodDay, Limit: 1000, Used: 1000,
+				ResetsAt: time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "Monthly budget spent",
+			budget:   ClientBudget{DailyTokens: 1000, MonthlyTokens: 10000},
+			usage:    ClientUsage{DailyTokens: 10, MonthlyTokens: 10500},
+			requests: 1,
+			expectedExceeded: &BudgetPeriodStatus{
+				Period: BudgetPeriodMonth, Limit: 10000, Used: 10500,
+				ResetsAt: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "Rate limited",
+			budget:   ClientBudget{RequestsPerMinute: 2},
+			requests: 3,
+			expectedExceeded: &BudgetPeriodStatus{
+				Period: BudgetPeriodMinute, Limit: 2, Used: 2,
+				ResetsAt: time.Date(2026, 10, 16, 12, 31, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockClientRepository)
+			service := NewClientService(mockRepo)
+			service.now = func() time.Time { return now }
+			ctx := context.Background()
+
+			mockRepo.On("GetClient", ctx, int64(1)).Return(&Client{ID: 1, Name: "team-a", Budget: tc.budget}, nil)
+			mockRepo.On("GetClientUsage", ctx, int64(1), now).Return(&tc.usage, nil)
+
+			var exceeded *BudgetPeriodStatus
+			var err error
+			for i := 0; i < tc.requests; i++ {
+				exceeded, err = service.CheckClientBudget(ctx, 1)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectedExceeded, exceeded)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestClientService_CheckClientBudget_Errors(t *testing.T) {
+	mockRepo := new(MockClientRepository)
+	service := NewClientService(mockRepo)
+	ctx := context.Background()
+
+	mockRepo.On("GetClient", ctx, int64(1)).Return(nil, ErrClientNotFound).Once()
+	_, err := service.CheckClientBudget(ctx, 1)
+	assert.ErrorIs(t, err, ErrClientNotFound)
+
+	mockRepo.On("GetClient", ctx, int64(1)).Return(&Client{ID: 1}, nil).Once()
+	mockRepo.On("GetClientUsage", ctx, int64(1), mock.Anything).Return(nil, assert.AnError).Once()
+	_, err = service.CheckClientBudget(ctx, 1)
+	assert.ErrorIs(t, err, assert.AnError)
+
+	// A disabled client is refused
+	mockRepo.On("GetClient", ctx, int64(2)).Return(&Client{ID: 2, Disabled: true}, nil).Once()
+	_, err = service.CheckClientBudget(ctx, 2)
+	assert.ErrorIs(t, err, ErrClientDisabled)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestClientService_GetClientBudgetStatus(t *testing.T) {
+	now := time.Date(2026, 12, 31, 23, 59, 30, 0, time.UTC)
+	mockRepo := new(MockClientRepository)
+	service := NewClientService(mockRepo)
+	service.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	budget := ClientBudget{DailyTokens: 1000, MonthlyTokens: 20000, RequestsPerMinute: 10}
+	mockRepo.On("GetClient", ctx, int64(1)).Return(&Client{ID: 1, Budget: budget}, nil)
+	mockRepo.On("GetClientUsage", ctx, int64(1), now).Return(&ClientUsage{DailyTokens: 300, MonthlyTokens: 9000}, nil)
+	mockRepo.On("AddClientUsage", ctx, int64(1), int64(150), now).Return(nil)
+
+	_, err := service.CheckClientBudget(ctx, 1)
+	require.NoError(t, err)
+	err = service.DebitClientUsage(ctx, 1, 150)
+	require.NoError(t, err)
+
+	status, err := service.GetClientBudgetStatus(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, &ClientBudgetStatus{
+		ClientID: 1,
+		DailyTokens: BudgetPeriodStatus{
+			Period: BudgetPeriodDay, Limit: 1000, Used: 300,
+			ResetsAt: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		MonthlyTokens: BudgetPeriodStatus{
+			Period: BudgetPeriodMonth, Limit: 20000, Used: 9000,
+			ResetsAt: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		RequestsPerMinute: BudgetPeriodStatus{
+			Period: BudgetPeriodMinute, Limit: 10, Used: 1,
+			ResetsAt: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}, status)
+	mockRepo.AssertExpectations(t)
+}
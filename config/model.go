@@ -1,10 +1,117 @@
 package config
 
-import "errors"
+import (
+	"errors"
+	"log/slog"
+	"net/url"
+	"time"
+)
 
 type Config struct {
-	DatabaseURL  string
+	// DatabaseURL is the PostgreSQL connection string
+	DatabaseURL string
+	// MigrationDir is the directory of the database migrations
 	MigrationDir string
+	// DatabaseMaxOpenConns is the largest number of open database connections, 0 means no limit
+	DatabaseMaxOpenConns int
+	// DatabaseMaxIdleConns is the largest number of idle database connections
+	DatabaseMaxIdleConns int
+	// DatabaseConnMaxLifetime is how long a database connection is reused, 0 means forever
+	DatabaseConnMaxLifetime time.Duration
+	// DatabaseConnMaxIdleTime is how long a database connection stays idle, 0 means forever
+	DatabaseConnMaxIdleTime time.Duration
+
+	// ProxyListenAddr is the address of the HTTP proxy
+	ProxyListenAddr string
+	// ApiListenAddr is the address of the management API
+	ApiListenAddr string
+	// ReverseProxyListenAddr is the address of the reverse proxy
+	ReverseProxyListenAddr string
+	// ReadHeaderTimeout bounds the reading of request headers by every server
+	ReadHeaderTimeout time.Duration
+	// ShutdownTimeout is how long servers wait for requests in flight when stopping
+	ShutdownTimeout time.Duration
+
+	// LogLevel is the lowest level of the logged records
+	LogLevel slog.Level
+	// LogFormat is "text" or "json"
+	LogFormat string
+
+	// TracingExporter is "none" to not export spans or "otlp" to export them with OTLP over HTTP
+	TracingExporter string
+	// TracingOTLPEndpoint is the URL spans are exported to, the OTEL_EXPORTER_OTLP_* variables are used when nil
+	TracingOTLPEndpoint *url.URL
+	// TracingSampleRatio is the ratio of the traces started by the proxy that are sampled,
+	// traces started by the client follow the client sampling decision
+	TracingSampleRatio float64
+
+	// AdminToken is the secret of the admin API token created at startup when there is no admin token,
+	// a random secret is generated when empty
+	AdminToken string
+
+	// KeySelectionStrategy is the strategy used to pick the best key
+	KeySelectionStrategy string
+	// KeyValidationURL is the upstream endpoint called to validate a key, {key} is replaced by the key
+	KeyValidationURL string
+	// KeyValidationTimeout bounds a key validation request
+	KeyValidationTimeout time.Duration
+	// KeyValidateOnInsert rejects keys refused by upstream on insert
+	KeyValidateOnInsert bool
+	// BalanceRefreshEnabled periodically replaces key balances with the balances reported by upstream
+	BalanceRefreshEnabled bool
+	// BalanceRefreshInterval is the delay between two balance refreshes
+	BalanceRefreshInterval time.Duration
+	// BalanceRefreshJitter is the longest random delay added to the interval
+	BalanceRefreshJitter time.Duration
+	// BalanceRefreshConcurrency is the number of keys refreshed at the same time
+	BalanceRefreshConcurrency int
+	// KeyPoolEnabled selects and accounts keys in memory instead of in the database
+	KeyPoolEnabled bool
+	// KeyPoolFlushInterval is how often the usage accounted in memory is written to the database
+	KeyPoolFlushInterval time.Duration
+	// KeyPoolReloadInterval is how often the keys in memory are reloaded from the database
+	KeyPoolReloadInterval time.Duration
+
+	// ProxyCACert is the PEM encoded certificate of the CA signing the certificates of intercepted hosts
+	ProxyCACert []byte
+	// ProxyCAKey is the PEM encoded private key of the CA
+	ProxyCAKey []byte
+	// ProxyCACertFile is the path of the CA certificate, read when ProxyCACert is not set
+	ProxyCACertFile string
+	// ProxyCAKeyFile is the path of the CA private key, read when ProxyCAKey is not set
+	ProxyCAKeyFile string
+	// ProxyCertCacheSize is the number of certificates of intercepted hosts kept in memory
+	ProxyCertCacheSize int
+	// ProxyAuthEnabled requires clients to authenticate with the Proxy-Authorization header
+	ProxyAuthEnabled bool
+	// ProxyClientKeyPolicy is "override", "keep" or "reject" for requests carrying their own Authorization header
+	ProxyClientKeyPolicy string
+	// ProxyClientKeyPolicies overrides the client key policy of some clients, written as "client=policy"
+	ProxyClientKeyPolicies []string
+	// ProxyAllowedTargets lists the hosts, optionally with a path prefix, that receive keys
+	ProxyAllowedTargets []string
+	// ProxyUnmatchedPolicy is "forward" or "refuse" for requests to other targets
+	ProxyUnmatchedPolicy string
+	// ProxyMaxRetries is the number of times a request is retried with another key
+	ProxyMaxRetries int
+	// ProxyMaxBufferedBodyBytes is the largest request body buffered to be replayed on retry,
+	// and the largest response body buffered to deduct its usage before it is sent
+	ProxyMaxBufferedBodyBytes int64
+	// ProxyDefaultCooldown is how long a rate limited key is skipped when upstream does not tell
+	ProxyDefaultCooldown time.Duration
+	// ProxyMaxCooldown caps the cooldown requested by upstream
+	ProxyMaxCooldown time.Duration
+	// ProxyKeyWaitTimeout is how long a request waits for a key when none is available
+	ProxyKeyWaitTimeout time.Duration
+
+	// ReverseProxyEnabled serves the reverse proxy listener forwarding requests to ReverseProxyUpstreamURL
+	ReverseProxyEnabled bool
+	// ReverseProxyUpstreamURL is the base URL requests to the reverse proxy are forwarded to
+	ReverseProxyUpstreamURL *url.URL
 }
 
-var ErrMissingEnv = errors.New("missing environment variable")
+var (
+	ErrMissingSetting = errors.New("missing setting")
+	ErrInvalidSetting = errors.New("invalid setting")
+	ErrUnknownSetting = errors.New("unknown setting")
+)
@@ -1,23 +1,366 @@
 package config
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DefaultDatabaseMaxIdleConns      = 2
+	DefaultProxyListenAddr           = ":5555"
+	DefaultApiListenAddr             = ":5556"
+	DefaultReverseProxyListenAddr    = ":5557"
+	DefaultReadHeaderTimeout         = 5 * time.Second
+	DefaultShutdownTimeout           = 10 * time.Second
+	DefaultLogLevel                  = slog.LevelInfo
+	DefaultLogFormat                 = "text"
+	DefaultTracingExporter           = "none"
+	DefaultTracingSampleRatio        = 1.0
+	DefaultKeySelectionStrategy      = "newest-first"
+	DefaultKeyValidationURL          = "https://embeddings-dashboard-api.jina.ai/api/v1/api_key/fe/user?api_key={key}"
+	DefaultKeyValidationTimeout      = 10 * time.Second
+	DefaultBalanceRefreshInterval    = time.Hour
+	DefaultBalanceRefreshJitter      = 5 * time.Minute
+	DefaultBalanceRefreshConcurrency = 4
+	DefaultKeyPoolFlushInterval      = 5 * time.Second
+	DefaultKeyPoolReloadInterval     = time.Minute
+	DefaultProxyCertCacheSize        = 1000
+	DefaultProxyClientKeyPolicy      = "override"
+	DefaultProxyAllowedTargets       = "*.jina.ai"
+	DefaultProxyUnmatchedPolicy      = "forward"
+	DefaultProxyMaxRetries           = 3
+	DefaultProxyMaxBufferedBodyBytes = 10 << 20
+	DefaultProxyDefaultCooldown      = time.Minute
+	DefaultProxyMaxCooldown          = time.Hour
+	DefaultReverseProxyUpstreamURL   = "https://api.jina.ai"
 )
 
-func LoadConfig() (*Config, error) {
-	databaseURL := os.Getenv("GOOSE_DBSTRING")
-	if databaseURL == "" {
-		return nil, fmt.Errorf("%w: DATABASE_URL", ErrMissingEnv)
+// ConfigFileEnv is the environment variable naming the config file when the -config flag is not given
+const ConfigFileEnv = "CONFIG_FILE"
+
+// Rules checks the settings whose accepted values are defined by the packages using them.
+// A nil check accepts every value.
+type Rules struct {
+	// KeySelectionStrategies are the accepted key selection strategies
+	KeySelectionStrategies []string
+	// ClientKeyPolicies checks the default client key policy with the policies of some clients
+	ClientKeyPolicies func(defaultPolicy string, clientPolicies []string) error
+	// AllowedTargets checks the targets that receive keys
+	AllowedTargets func(targets []string) error
+}
+
+// LoadConfig loads the config from, in decreasing precedence: the command-line flags, the environment
+// variables, the YAML config file named by -config or CONFIG_FILE, and the defaults.
+// Empty environment variables are ignored.
+// Every invalid setting is reported in the returned error. flag.ErrHelp is returned when help is requested.
+func LoadConfig(args []string, rules Rules) (*Config, error) {
+	config := defaultConfig()
+	settings := settings(config)
+
+	flagValues, configFile, err := parseFlags(settings, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileValues map[string]string
+	if configFile != "" {
+		fileValues, err = readConfigFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	keys := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		keys[s.key] = s
+	}
+	for _, fileKey := range sortedKeys(fileValues) {
+		if _, ok := keys[fileKey]; !ok {
+			errs = append(errs, fmt.Errorf("%w: %s in %s", ErrUnknownSetting, fileKey, configFile))
+		}
+	}
+
+	for _, s := range settings {
+		value, ok := flagValues[s.flagName()]
+		if !ok {
+			// Empty variables, like the ones left empty in .env.example, are not set
+			value = os.Getenv(s.env)
+			ok = value != ""
+		}
+		if !ok {
+			value, ok = fileValues[s.key]
+		}
+		if !ok {
+			continue
+		}
+
+		err = s.set(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: %s %w", ErrInvalidSetting, s, err))
+		}
 	}
 
-	migrationDir := os.Getenv("GOOSE_MIGRATION_DIR")
-	if migrationDir == "" {
-		return nil, fmt.Errorf("%w: GOOSE_MIGRATION_DIR", ErrMissingEnv)
+	errs = append(errs, config.validate(keys, rules)...)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
 	}
 
+	return config, nil
+}
+
+// defaultConfig returns the config with the default value of every setting
+func defaultConfig() *Config {
+	reverseProxyUpstreamURL, _ := url.Parse(DefaultReverseProxyUpstreamURL) // The default URL is valid
+
 	return &Config{
-		DatabaseURL:  databaseURL,
-		MigrationDir: migrationDir,
-	}, nil
+		DatabaseMaxIdleConns:      DefaultDatabaseMaxIdleConns,
+		ProxyListenAddr:           DefaultProxyListenAddr,
+		ApiListenAddr:             DefaultApiListenAddr,
+		ReverseProxyListenAddr:    DefaultReverseProxyListenAddr,
+		ReadHeaderTimeout:         DefaultReadHeaderTimeout,
+		ShutdownTimeout:           DefaultShutdownTimeout,
+		LogLevel:                  DefaultLogLevel,
+		LogFormat:                 DefaultLogFormat,
+		TracingExporter:           DefaultTracingExporter,
+		TracingSampleRatio:        DefaultTracingSampleRatio,
+		KeySelectionStrategy:      DefaultKeySelectionStrategy,
+		KeyValidationURL:          DefaultKeyValidationURL,
+		KeyValidationTimeout:      DefaultKeyValidationTimeout,
+		BalanceRefreshInterval:    DefaultBalanceRefreshInterval,
+		BalanceRefreshJitter:      DefaultBalanceRefreshJitter,
+		BalanceRefreshConcurrency: DefaultBalanceRefreshConcurrency,
+		KeyPoolFlushInterval:      DefaultKeyPoolFlushInterval,
+		KeyPoolReloadInterval:     DefaultKeyPoolReloadInterval,
+		ProxyCertCacheSize:        DefaultProxyCertCacheSize,
+		ProxyClientKeyPolicy:      DefaultProxyClientKeyPolicy,
+		ProxyAllowedTargets:       []string{DefaultProxyAllowedTargets},
+		ProxyUnmatchedPolicy:      DefaultProxyUnmatchedPolicy,
+		ProxyMaxRetries:           DefaultProxyMaxRetries,
+		ProxyMaxBufferedBodyBytes: DefaultProxyMaxBufferedBodyBytes,
+		ProxyDefaultCooldown:      DefaultProxyDefaultCooldown,
+		ProxyMaxCooldown:          DefaultProxyMaxCooldown,
+		ReverseProxyUpstreamURL:   reverseProxyUpstreamURL,
+	}
+}
+
+// validate checks the settings that depend on each other or on the file system,
+// and returns every error found
+func (c *Config) validate(keys map[string]setting, rules Rules) []error {
+	var errs []error
+	invalid := func(key string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: %s %s", ErrInvalidSetting, keys[key], fmt.Sprintf(format, args...)))
+	}
+
+	if c.DatabaseURL == "" {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrMissingSetting, keys["database.url"]))
+	}
+	if c.MigrationDir == "" {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrMissingSetting, keys["database.migration_dir"]))
+	}
+
+	for _, addr := range []struct {
+		key   string
+		value string
+	}{
+		{"server.proxy_listen_addr", c.ProxyListenAddr},
+		{"server.api_listen_addr", c.ApiListenAddr},
+		{"server.reverse_proxy_listen_addr", c.ReverseProxyListenAddr},
+	} {
+		if _, _, err := net.SplitHostPort(addr.value); err != nil {
+			invalid(addr.key, "must be a host:port address")
+		}
+	}
+	if c.ReadHeaderTimeout == 0 {
+		invalid("server.read_header_timeout", "must be positive")
+	}
+
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		invalid("log.format", "must be text or json")
+	}
+
+	if c.TracingExporter != "none" && c.TracingExporter != "otlp" {
+		invalid("tracing.exporter", "must be none or otlp")
+	}
+
+	if rules.KeySelectionStrategies != nil && !slices.Contains(rules.KeySelectionStrategies, c.KeySelectionStrategy) {
+		invalid("key.selection_strategy", "must be one of %s", strings.Join(rules.KeySelectionStrategies, ", "))
+	}
+	if c.KeyPoolFlushInterval == 0 {
+		invalid("key.pool.flush_interval", "must be positive")
+	}
+	if c.KeyPoolReloadInterval == 0 {
+		invalid("key.pool.reload_interval", "must be positive")
+	}
+
+	if c.BalanceRefreshInterval == 0 {
+		invalid("balance_refresh.interval", "must be positive")
+	}
+	if c.BalanceRefreshConcurrency == 0 {
+		invalid("balance_refresh.concurrency", "must be positive")
+	}
+
+	if c.ProxyClientKeyPolicy != "override" && c.ProxyClientKeyPolicy != "keep" && c.ProxyClientKeyPolicy != "reject" {
+		invalid("proxy.client_key_policy", "must be override, keep or reject")
+	} else if rules.ClientKeyPolicies != nil {
+		if err := rules.ClientKeyPolicies(c.ProxyClientKeyPolicy, c.ProxyClientKeyPolicies); err != nil {
+			invalid("proxy.client_key_policies", "must be client=policy pairs with override, keep or reject policies: %v", err)
+		}
+	}
+	if rules.AllowedTargets != nil {
+		if err := rules.AllowedTargets(c.ProxyAllowedTargets); err != nil {
+			invalid("proxy.allowed_targets", "must be hosts, *.domain wildcards or host/path prefixes: %v", err)
+		}
+	}
+	if c.ProxyUnmatchedPolicy != "forward" && c.ProxyUnmatchedPolicy != "refuse" {
+		invalid("proxy.unmatched_policy", "must be forward or refuse")
+	}
+	if c.ProxyMaxBufferedBodyBytes == 0 {
+		invalid("proxy.max_buffered_body_bytes", "must be positive")
+	}
+	if c.ProxyMaxCooldown < c.ProxyDefaultCooldown {
+		invalid("proxy.max_cooldown", "must not be shorter than proxy.default_cooldown")
+	}
+
+	// Keys are injected into the requests to the upstream, they must not travel in cleartext
+	if c.ReverseProxyUpstreamURL.Scheme != "https" {
+		invalid("reverse_proxy.upstream_url", "must be an https URL")
+	}
+
+	if err := readPEM(&c.ProxyCACert, c.ProxyCACertFile, keys["proxy.ca_cert"], keys["proxy.ca_cert_file"]); err != nil {
+		errs = append(errs, err)
+	}
+	if err := readPEM(&c.ProxyCAKey, c.ProxyCAKeyFile, keys["proxy.ca_key"], keys["proxy.ca_key_file"]); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// readPEM reads the PEM content from the file when it is not set directly. One of them is required.
+func readPEM(content *[]byte, path string, contentSetting setting, fileSetting setting) error {
+	if len(*content) > 0 {
+		return nil
+	}
+	if path == "" {
+		return fmt.Errorf("%w: %s or %s", ErrMissingSetting, fileSetting, contentSetting)
+	}
+
+	read, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%w: %s %w", ErrInvalidSetting, fileSetting, err)
+	}
+
+	*content = read
+	return nil
+}
+
+// flagValue keeps the raw value of a setting flag, parsed like the values of the other sources.
+// Boolean settings are boolean flags, so they can be given without a value.
+type flagValue struct {
+	value  string
+	isBool bool
+}
+
+func (v *flagValue) String() string {
+	return v.value
+}
+
+func (v *flagValue) Set(value string) error {
+	v.value = value
+	return nil
+}
+
+// IsBoolFlag lets the flag package accept the flag without a value, set to "true"
+func (v *flagValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
+// parseFlags returns the value of the flags given in args by flag name, and the config file
+func parseFlags(settings []setting, args []string) (map[string]string, string, error) {
+	flags := flag.NewFlagSet("jina-http-proxy", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv(ConfigFileEnv), "YAML config file (env "+ConfigFileEnv+")")
+	for _, s := range settings {
+		_, isBool := s.target.(*bool)
+		flags.Var(&flagValue{isBool: isBool}, s.flagName(), fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, "", err
+	}
+	if flags.NArg() > 0 {
+		return nil, "", fmt.Errorf("%w: unexpected argument %q", ErrUnknownSetting, flags.Arg(0))
+	}
+
+	values := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name != "config" {
+			values[f.Name] = f.Value.String()
+		}
+	})
+
+	return values, *configFile, nil
+}
+
+// readConfigFile returns the values of the YAML config file by dotted key.
+// Lists are joined with commas, like in environment variables.
+func readConfigFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	var document map[string]any
+	err = yaml.Unmarshal(content, &document)
+	if err != nil {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	flattenConfig("", document, values)
+	return values, nil
+}
+
+// flattenConfig adds the scalar values of the node to values, by dotted key
+func flattenConfig(prefix string, node any, values map[string]string) {
+	switch node := node.(type) {
+	case nil:
+	case map[string]any:
+		for name, child := range node {
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			flattenConfig(name, child, values)
+		}
+	case []any:
+		items := make([]string, 0, len(node))
+		for _, item := range node {
+			items = append(items, fmt.Sprint(item))
+		}
+		values[prefix] = strings.Join(items, ",")
+	default:
+		values[prefix] = fmt.Sprint(node)
+	}
+}
+
+// sortedKeys returns the keys of the map in order, for stable error messages
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
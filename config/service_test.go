@@ -0,0 +1,260 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clearEnv unsets every setting in the environment of the test
+func clearEnv(t *testing.T) {
+	envs := []string{ConfigFileEnv}
+	for _, s := range settings(&Config{}) {
+		envs = append(envs, s.env)
+	}
+	for _, env := range envs {
+		t.Setenv(env, "") // Restores the variable after the test
+		require.NoError(t, os.Unsetenv(env))
+	}
+}
+
+// testRules rejects the "*" target and the client key policies that are not override, keep or reject
+var testRules = Rules{
+	KeySelectionStrategies: []string{"newest-first", "round-robin"},
+	ClientKeyPolicies: func(defaultPolicy string, clientPolicies []string) error {
+		for _, clientPolicy := range clientPolicies {
+			_, policy, _ := strings.Cut(clientPolicy, "=")
+			if policy != "override" && policy != "keep" && policy != "reject" {
+				return fmt.Errorf("unknown policy %q", policy)
+			}
+		}
+		return nil
+	},
+	AllowedTargets: func(targets []string) error {
+		if slices.Contains(targets, "*") {
+			return errors.New("target matching every host")
+		}
+		return nil
+	},
+}
+
+// requiredEnv sets the settings without default
+func requiredEnv(t *testing.T) {
+	t.Setenv("GOOSE_DBSTRING", "postgres://localhost/jina")
+	t.Setenv("GOOSE_MIGRATION_DIR", "migrations")
+	t.Setenv("PROXY_CA_CERT", "cert")
+	t.Setenv("PROXY_CA_KEY", "key")
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	clearEnv(t)
+	requiredEnv(t)
+
+	config, err := LoadConfig(nil, testRules)
+	require.NoError(t, err)
+
+	assert.Equal(t, DefaultProxyListenAddr, config.ProxyListenAddr)
+	assert.Equal(t, DefaultApiListenAddr, config.ApiListenAddr)
+	assert.Equal(t, DefaultReadHeaderTimeout, config.ReadHeaderTimeout)
+	assert.Equal(t, DefaultLogLevel, config.LogLevel)
+	assert.Equal(t, DefaultLogFormat, config.LogFormat)
+	assert.Equal(t, DefaultKeySelectionStrategy, config.KeySelectionStrategy)
+	assert.Equal(t, []string{DefaultProxyAllowedTargets}, config.ProxyAllowedTargets)
+	assert.Equal(t, DefaultReverseProxyUpstreamURL, config.ReverseProxyUpstreamURL.String())
+	assert.Equal(t, []byte("cert"), config.ProxyCACert)
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	clearEnv(t)
+	requiredEnv(t)
+	path := writeFile(t, "config.yaml", `
+server:
+  proxy_listen_addr: ":6001"
+  api_listen_addr: ":6002"
+  reverse_proxy_listen_addr: ":6003"
+log:
+  level: debug
+proxy:
+  allowed_targets:
+    - api.jina.ai
+    - r.jina.ai/v1
+`)
+	t.Setenv("API_LISTEN_ADDR", ":7002")
+	t.Setenv("REVERSE_PROXY_LISTEN_ADDR", ":7003")
+	t.Setenv("LOG_LEVEL", "")
+
+	config, err := LoadConfig([]string{"-config", path, "-server-reverse-proxy-listen-addr", ":8003"}, testRules)
+	require.NoError(t, err)
+
+	assert.Equal(t, ":6001", config.ProxyListenAddr, "The file overrides the default")
+	assert.Equal(t, ":7002", config.ApiListenAddr, "The environment overrides the file")
+	assert.Equal(t, ":8003", config.ReverseProxyListenAddr, "The flag overrides the environment")
+	assert.Equal(t, slog.LevelDebug, config.LogLevel, "An empty variable does not override the file")
+	assert.Equal(t, []string{"api.jina.ai", "r.jina.ai/v1"}, config.ProxyAllowedTargets)
+}
+
+func TestLoadConfig_ConfigFileEnv(t *testing.T) {
+	clearEnv(t)
+	requiredEnv(t)
+	t.Setenv(ConfigFileEnv, writeFile(t, "config.yaml", "server:\n  shutdown_timeout: 30s\n"))
+
+	config, err := LoadConfig(nil, testRules)
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, config.ShutdownTimeout)
+}
+
+func TestLoadConfig_Tracing(t *testing.T) {
+	clearEnv(t)
+	requiredEnv(t)
+
+	config, err := LoadConfig(nil, testRules)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultTracingExporter, config.TracingExporter)
+	assert.Nil(t, config.TracingOTLPEndpoint)
+	assert.Equal(t, DefaultTracingSampleRatio, config.TracingSampleRatio)
+
+	path := writeFile(t, "config.yaml", `
+tracing:
+  exporter: otlp
+  otlp_endpoint: http://collector:4318
+  sample_ratio: 0.25
+`)
+	config, err = LoadConfig([]string{"-config", path}, testRules)
+	require.NoError(t, err)
+	assert.Equal(t, "otlp", config.TracingExporter)
+	assert.Equal(t, "http://collector:4318", config.TracingOTLPEndpoint.String())
+	assert.Equal(t, 0.25, config.TracingSampleRatio)
+}
+
+func TestLoadConfig_ReverseProxyUpstreamURL(t *testing.T) {
+	clearEnv(t)
+	requiredEnv(t)
+
+	t.Setenv("REVERSE_PROXY_UPSTREAM_URL", "https://api.jina.ai/v1")
+	config, err := LoadConfig(nil, testRules)
+	require.NoError(t, err)
+	assert.Equal(t, "https://api.jina.ai/v1", config.ReverseProxyUpstreamURL.String())
+
+	// Keys are never sent in cleartext
+	t.Setenv("REVERSE_PROXY_UPSTREAM_URL", "http://api.jina.ai")
+	_, err = LoadConfig(nil, testRules)
+	assert.ErrorIs(t, err, ErrInvalidSetting)
+	assert.ErrorContains(t, err, "reverse_proxy.upstream_url")
+}
+
+func TestLoadConfig_PEMFiles(t *testing.T) {
+	clearEnv(t)
+	requiredEnv(t)
+	t.Setenv("PROXY_CA_CERT", "")
+	t.Setenv("PROXY_CA_CERT_FILE", writeFile(t, "ca.pem", "cert from file"))
+
+	config, err := LoadConfig(nil, testRules)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("cert from file"), config.ProxyCACert)
+
+	t.Setenv("PROXY_CA_CERT_FILE", filepath.Join(t.TempDir(), "missing.pem"))
+	_, err = LoadConfig(nil, testRules)
+	assert.ErrorIs(t, err, ErrInvalidSetting)
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, "config.yaml", `
+server:
+  proxy_listen_addr: "5555"
+  unknown: true
+log:
+  format: xml
+tracing:
+  exporter: jaeger
+  sample_ratio: 2
+proxy:
+  allowed_targets: ["*"]
+  client_key_policies: [team-a=forward]
+  max_buffered_body_bytes: 0
+  default_cooldown: 2h
+  max_cooldown: 1h
+`)
+	t.Setenv("KEY_POOL_ENABLED", "maybe")
+
+	config, err := LoadConfig([]string{"-config", path, "-proxy-max-retries", "-1"}, testRules)
+	assert.Nil(t, config)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrMissingSetting)
+	assert.ErrorIs(t, err, ErrInvalidSetting)
+	assert.ErrorIs(t, err, ErrUnknownSetting)
+
+	// Every error is reported at once
+	for _, expected := range []string{
+		"server.unknown",
+		"database.url",
+		"database.migration_dir",
+		"proxy.ca_cert",
+		"proxy.ca_key",
+		"server.proxy_listen_addr",
+		"log.format",
+		"tracing.exporter",
+		"tracing.sample_ratio",
+		"proxy.allowed_targets",
+		"proxy.client_key_policies",
+		"proxy.max_buffered_body_bytes",
+		"proxy.max_cooldown",
+		"KEY_POOL_ENABLED",
+		"-proxy-max-retries",
+	} {
+		assert.ErrorContains(t, err, expected)
+	}
+}
+
+func TestLoadConfig_Flags(t *testing.T) {
+	clearEnv(t)
+	requiredEnv(t)
+
+	_, err := LoadConfig([]string{"-help"}, testRules)
+	assert.True(t, errors.Is(err, flag.ErrHelp))
+
+	_, err = LoadConfig([]string{"-unknown-flag", "value"}, testRules)
+	assert.Error(t, err)
+
+	_, err = LoadConfig([]string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}, testRules)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Boolean flags can be given without a value
+	config, err := LoadConfig([]string{"-proxy-auth-enabled", "-key-pool-enabled=false", "-log-level", "debug"}, testRules)
+	require.NoError(t, err)
+	assert.True(t, config.ProxyAuthEnabled)
+	assert.False(t, config.KeyPoolEnabled)
+	assert.Equal(t, slog.LevelDebug, config.LogLevel)
+
+	_, err = LoadConfig([]string{"-proxy-auth-enabled=maybe"}, testRules)
+	assert.ErrorContains(t, err, "must be a boolean")
+}
+
+func TestChangedSettings(t *testing.T) {
+	clearEnv(t)
+	requiredEnv(t)
+
+	previous, err := LoadConfig(nil, testRules)
+	require.NoError(t, err)
+	next, err := LoadConfig([]string{"-log-level", "debug", "-proxy-allowed-targets", "api.jina.ai", "-server-api-listen-addr", ":6000"}, testRules)
+	require.NoError(t, err)
+
+	assert.Empty(t, ChangedSettings(previous, previous))
+	assert.Equal(t, []string{"server.api_listen_addr", "log.level", "proxy.allowed_targets"}, ChangedSettings(previous, next))
+}
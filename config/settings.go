@@ -0,0 +1,215 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is a configuration value that can be set from the config file, the environment or a flag
+type setting struct {
+	// key is the dotted path of the setting in the config file, its flag is the key with dashes
+	key string
+	// env is the environment variable of the setting
+	env string
+	// usage describes the setting in the flag help
+	usage string
+	// target points to the config field of the setting
+	target any
+	// set parses the value into the config
+	set func(value string) error
+}
+
+// flagName returns the name of the command-line flag of the setting
+func (s setting) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.key)
+}
+
+// String names the setting in every source, for error messages
+func (s setting) String() string {
+	return fmt.Sprintf("%s (%s, -%s)", s.key, s.env, s.flagName())
+}
+
+// settings returns every setting bound to the fields of the config
+func settings(c *Config) []setting {
+	return []setting{
+		stringSetting("database.url", "GOOSE_DBSTRING", "PostgreSQL connection string", &c.DatabaseURL),
+		stringSetting("database.migration_dir", "GOOSE_MIGRATION_DIR", "directory of the database migrations", &c.MigrationDir),
+		intSetting("database.max_open_conns", "DATABASE_MAX_OPEN_CONNS", "largest number of open database connections, 0 means no limit", &c.DatabaseMaxOpenConns),
+		intSetting("database.max_idle_conns", "DATABASE_MAX_IDLE_CONNS", "largest number of idle database connections", &c.DatabaseMaxIdleConns),
+		durationSetting("database.conn_max_lifetime", "DATABASE_CONN_MAX_LIFETIME", "how long a database connection is reused, 0 means forever", &c.DatabaseConnMaxLifetime),
+		durationSetting("database.conn_max_idle_time", "DATABASE_CONN_MAX_IDLE_TIME", "how long a database connection stays idle, 0 means forever", &c.DatabaseConnMaxIdleTime),
+
+		stringSetting("server.proxy_listen_addr", "PROXY_LISTEN_ADDR", "address of the HTTP proxy", &c.ProxyListenAddr),
+		stringSetting("server.api_listen_addr", "API_LISTEN_ADDR", "address of the management API", &c.ApiListenAddr),
+		stringSetting("server.reverse_proxy_listen_addr", "REVERSE_PROXY_LISTEN_ADDR", "address of the reverse proxy", &c.ReverseProxyListenAddr),
+		durationSetting("server.read_header_timeout", "READ_HEADER_TIMEOUT", "timeout to read request headers", &c.ReadHeaderTimeout),
+		durationSetting("server.shutdown_timeout", "SHUTDOWN_TIMEOUT", "how long servers wait for requests in flight when stopping", &c.ShutdownTimeout),
+
+		logLevelSetting("log.level", "LOG_LEVEL", "lowest level logged: debug, info, warn or error", &c.LogLevel),
+		stringSetting("log.format", "LOG_FORMAT", "log format: text or json", &c.LogFormat),
+
+		stringSetting("tracing.exporter", "TRACING_EXPORTER", "span exporter: none or otlp", &c.TracingExporter),
+		urlSetting("tracing.otlp_endpoint", "TRACING_OTLP_ENDPOINT", "URL spans are exported to with OTLP over HTTP", &c.TracingOTLPEndpoint),
+		ratioSetting("tracing.sample_ratio", "TRACING_SAMPLE_RATIO", "ratio of the traces started by the proxy that are sampled", &c.TracingSampleRatio),
+
+		stringSetting("admin_token", "ADMIN_TOKEN", "secret of the admin API token created at startup when there is no admin token", &c.AdminToken),
+
+		stringSetting("key.selection_strategy", "KEY_SELECTION_STRATEGY", "key selection strategy", &c.KeySelectionStrategy),
+		stringSetting("key.validation_url", "KEY_VALIDATION_URL", "upstream endpoint called to validate a key, {key} is replaced by the key", &c.KeyValidationURL),
+		durationSetting("key.validation_timeout", "KEY_VALIDATION_TIMEOUT", "timeout of a key validation request", &c.KeyValidationTimeout),
+		boolSetting("key.validate_on_insert", "KEY_VALIDATE_ON_INSERT", "validate keys against upstream before inserting them", &c.KeyValidateOnInsert),
+		boolSetting("key.pool.enabled", "KEY_POOL_ENABLED", "keep keys in memory and flush usage in batches", &c.KeyPoolEnabled),
+		durationSetting("key.pool.flush_interval", "KEY_POOL_FLUSH_INTERVAL", "interval between usage flushes of the key pool", &c.KeyPoolFlushInterval),
+		durationSetting("key.pool.reload_interval", "KEY_POOL_RELOAD_INTERVAL", "interval between key reloads of the key pool", &c.KeyPoolReloadInterval),
+
+		boolSetting("balance_refresh.enabled", "BALANCE_REFRESH_ENABLED", "periodically replace key balances with the balances reported by upstream", &c.BalanceRefreshEnabled),
+		durationSetting("balance_refresh.interval", "BALANCE_REFRESH_INTERVAL", "interval between balance refreshes", &c.BalanceRefreshInterval),
+		durationSetting("balance_refresh.jitter", "BALANCE_REFRESH_JITTER", "longest random delay added to the refresh interval", &c.BalanceRefreshJitter),
+		intSetting("balance_refresh.concurrency", "BALANCE_REFRESH_CONCURRENCY", "number of keys refreshed at the same time", &c.BalanceRefreshConcurrency),
+
+		bytesSetting("proxy.ca_cert", "PROXY_CA_CERT", "PEM encoded proxy CA certificate", &c.ProxyCACert),
+		bytesSetting("proxy.ca_key", "PROXY_CA_KEY", "PEM encoded proxy CA private key", &c.ProxyCAKey),
+		stringSetting("proxy.ca_cert_file", "PROXY_CA_CERT_FILE", "path of the PEM encoded proxy CA certificate", &c.ProxyCACertFile),
+		stringSetting("proxy.ca_key_file", "PROXY_CA_KEY_FILE", "path of the PEM encoded proxy CA private key", &c.ProxyCAKeyFile),
+		intSetting("proxy.cert_cache_size", "PROXY_CERT_CACHE_SIZE", "number of certificates of intercepted hosts kept in memory, 0 disables the cache", &c.ProxyCertCacheSize),
+		boolSetting("proxy.auth_enabled", "PROXY_AUTH_ENABLED", "require clients to authenticate with the Proxy-Authorization header", &c.ProxyAuthEnabled),
+		stringSetting("proxy.client_key_policy", "PROXY_CLIENT_KEY_POLICY", "policy for requests carrying their own Authorization header: override, keep or reject", &c.ProxyClientKeyPolicy),
+		listSetting("proxy.client_key_policies", "PROXY_CLIENT_KEY_POLICIES", "comma separated client=policy overriding the client key policy of some clients", &c.ProxyClientKeyPolicies),
+		listSetting("proxy.allowed_targets", "PROXY_ALLOWED_TARGETS", "comma separated targets that receive keys", &c.ProxyAllowedTargets),
+		stringSetting("proxy.unmatched_policy", "PROXY_UNMATCHED_POLICY", "policy for requests to other targets: forward or refuse", &c.ProxyUnmatchedPolicy),
+		intSetting("proxy.max_retries", "PROXY_MAX_RETRIES", "number of retries with another key", &c.ProxyMaxRetries),
+		int64Setting("proxy.max_buffered_body_bytes", "PROXY_MAX_BUFFERED_BODY_BYTES", "largest request body buffered for retries and response body buffered to deduct its usage before it is sent", &c.ProxyMaxBufferedBodyBytes),
+		durationSetting("proxy.default_cooldown", "PROXY_DEFAULT_COOLDOWN", "cooldown of a rate limited key when upstream does not tell when to retry", &c.ProxyDefaultCooldown),
+		durationSetting("proxy.max_cooldown", "PROXY_MAX_COOLDOWN", "longest cooldown of a rate limited key", &c.ProxyMaxCooldown),
+		durationSetting("proxy.key_wait_timeout", "PROXY_KEY_WAIT_TIMEOUT", "how long a request waits for a key when none is available", &c.ProxyKeyWaitTimeout),
+
+		boolSetting("reverse_proxy.enabled", "REVERSE_PROXY_ENABLED", "serve the reverse proxy", &c.ReverseProxyEnabled),
+		urlSetting("reverse_proxy.upstream_url", "REVERSE_PROXY_UPSTREAM_URL", "HTTPS base URL requests to the reverse proxy are forwarded to", &c.ReverseProxyUpstreamURL),
+	}
+}
+
+// ChangedSettings returns the keys of the settings that differ between the configs
+func ChangedSettings(previous *Config, next *Config) []string {
+	previousSettings := settings(previous)
+	nextSettings := settings(next)
+
+	var changed []string
+	for i, s := range previousSettings {
+		if !reflect.DeepEqual(s.target, nextSettings[i].target) {
+			changed = append(changed, s.key)
+		}
+	}
+	return changed
+}
+
+func stringSetting(key, env, usage string, target *string) setting {
+	return setting{key: key, env: env, usage: usage, target: target, set: func(value string) error {
+		*target = value
+		return nil
+	}}
+}
+
+func bytesSetting(key, env, usage string, target *[]byte) setting {
+	return setting{key: key, env: env, usage: usage, target: target, set: func(value string) error {
+		*target = []byte(value)
+		return nil
+	}}
+}
+
+// listSetting splits comma separated values, ignoring empty values
+func listSetting(key, env, usage string, target *[]string) setting {
+	return setting{key: key, env: env, usage: usage, target: target, set: func(value string) error {
+		var values []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		*target = values
+		return nil
+	}}
+}
+
+func boolSetting(key, env, usage string, target *bool) setting {
+	return setting{key: key, env: env, usage: usage, target: target, set: func(value string) error {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be a boolean")
+		}
+		*target = parsed
+		return nil
+	}}
+}
+
+func intSetting(key, env, usage string, target *int) setting {
+	return setting{key: key, env: env, usage: usage, target: target, set: func(value string) error {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return fmt.Errorf("must be a non-negative integer")
+		}
+		*target = parsed
+		return nil
+	}}
+}
+
+func int64Setting(key, env, usage string, target *int64) setting {
+	return setting{key: key, env: env, usage: usage, target: target, set: func(value string) error {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			return fmt.Errorf("must be a non-negative integer")
+		}
+		*target = parsed
+		return nil
+	}}
+}
+
+// ratioSetting only accepts numbers between 0 and 1
+func ratioSetting(key, env, usage string, target *float64) setting {
+	return setting{key: key, env: env, usage: usage, target: target, set: func(value string) error {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			return fmt.Errorf("must be a number between 0 and 1")
+		}
+		*target = parsed
+		return nil
+	}}
+}
+
+func durationSetting(key, env, usage string, target *time.Duration) setting {
+	return setting{key: key, env: env, usage: usage, target: target, set: func(value string) error {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			return fmt.Errorf("must be a non-negative duration")
+		}
+		*target = parsed
+		return nil
+	}}
+}
+
+func logLevelSetting(key, env, usage string, target *slog.Level) setting {
+	return setting{key: key, env: env, usage: usage, target: target, set: func(value string) error {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			return fmt.Errorf("must be debug, info, warn or error")
+		}
+		*target = level
+		return nil
+	}}
+}
+
+// urlSetting only accepts absolute http or https URLs
+func urlSetting(key, env, usage string, target **url.URL) setting {
+	return setting{key: key, env: env, usage: usage, target: target, set: func(value string) error {
+		parsed, err := url.Parse(value)
+		if err != nil || parsed.Scheme != "https" && parsed.Scheme != "http" || parsed.Host == "" {
+			return fmt.Errorf("must be an absolute http or https URL")
+		}
+		*target = parsed
+		return nil
+	}}
+}
@@ -0,0 +1,29 @@
+// Package httpapi holds the helpers shared by the HTTP handlers
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ErrorResponse is the JSON body of the errors sent by the HTTP handlers
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// WriteJSON writes the value as a JSON response
+func WriteJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value) // Intentionally ignore error as the status is already written
+}
+
+// WriteError writes an error response with the code and message
+func WriteError(w http.ResponseWriter, status int, code string, message string) {
+	WriteJSON(w, status, ErrorResponse{Error: ErrorDetail{Code: code, Message: message}})
+}
@@ -0,0 +1,94 @@
+// Package testdb provides the PostgreSQL database of the repository tests
+package testdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // Import pgx driver
+	"github.com/pressly/goose/v3"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// SetupPostgres starts a PostgreSQL container with the migrations applied, and returns the database
+// and the function terminating the container
+func SetupPostgres(t *testing.T) (*sql.DB, func()) {
+	t.Helper()
+
+	db, _, cleanup := SetupPostgresWithURL(t)
+	return db, cleanup
+}
+
+// SetupPostgresWithURL also returns the connection string, to open dedicated connections
+func SetupPostgresWithURL(t *testing.T) (*sql.DB, string, func()) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	// Create a PostgreSQL container using the Run function
+	postgresContainer, err := postgres.Run(ctx,
+		"postgres:17",
+		postgres.WithDatabase("testdb"),
+		postgres.WithUsername("postgres"),
+		postgres.WithPassword("postgres"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").
+				WithOccurrence(2).WithStartupTimeout(5*time.Second)),
+	)
+	require.NoError(t, err)
+
+	// Get the connection details
+	host, err := postgresContainer.Host(ctx)
+	require.NoError(t, err)
+
+	port, err := postgresContainer.MappedPort(ctx, "5432")
+	require.NoError(t, err)
+
+	// Construct connection string manually
+	connStr := fmt.Sprintf("host=%s port=%s user=postgres password=postgres dbname=testdb sslmode=disable", host, port.Port())
+
+	// Connect to the database
+	db, err := sql.Open("pgx", connStr)
+	require.NoError(t, err)
+
+	// Test connection with retry
+	var pingErr error
+	for i := 0; i < 5; i++ {
+		pingErr = db.Ping()
+		if pingErr == nil {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	require.NoError(t, pingErr, "Failed to connect to database after retries")
+
+	// Run migrations
+	_, currentFile, _, _ := runtime.Caller(0)
+	migrationsDir := filepath.Join(filepath.Dir(currentFile), "..", "..", "migrations")
+	err = goose.SetDialect("postgres")
+	require.NoError(t, err)
+
+	err = goose.Up(db, migrationsDir)
+	require.NoError(t, err)
+
+	// Return cleanup function
+	cleanup := func() {
+		if err := db.Close(); err != nil {
+			log.Printf("Failed to close database connection: %v", err)
+		}
+		if err := postgresContainer.Terminate(ctx); err != nil {
+			log.Printf("Failed to terminate container: %v", err)
+		}
+	}
+
+	return db, connStr, cleanup
+}
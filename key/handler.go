@@ -3,7 +3,14 @@ package key
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/trancong12102/jina-http-proxy/internal/httpapi"
 )
 
 type InsertKeyRequest struct {
@@ -12,12 +19,91 @@ type InsertKeyRequest struct {
 
 // Convert InsertKeyRequest to InsertKeyParams
 func (r InsertKeyRequest) ToParams() InsertKeyParams {
-	return InsertKeyParams(r)
+	return InsertKeyParams{Key: r.Key}
+}
+
+// UpdateKeyRequest updates the fields that are set
+type UpdateKeyRequest struct {
+	Balance *int64     `json:"balance"`
+	Status  *KeyStatus `json:"status"`
+}
+
+// Convert UpdateKeyRequest to UpdateKeyParams
+func (r UpdateKeyRequest) ToParams(id int64) UpdateKeyParams {
+	return UpdateKeyParams{ID: id, Balance: r.Balance, Status: r.Status}
+}
+
+// KeyResponse identifies a key by its id and masked form, never by the raw key
+type KeyResponse struct {
+	ID            int64      `json:"id"`
+	MaskedKey     string     `json:"masked_key"`
+	Balance       int64      `json:"balance"`
+	Status        KeyStatus  `json:"status"`
+	UsedAt        *time.Time `json:"used_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	CooldownUntil *time.Time `json:"cooldown_until"`
+	Label         string     `json:"label"`
+}
+
+func NewKeyResponse(key Key) KeyResponse {
+	return KeyResponse{
+		ID:            key.ID,
+		MaskedKey:     MaskKey(key.Key),
+		Balance:       key.Balance,
+		Status:        key.Status,
+		UsedAt:        key.UsedAt,
+		CreatedAt:     key.CreatedAt,
+		CooldownUntil: key.CooldownUntil,
+		Label:         key.Label,
+	}
+}
+
+type ListKeysResponse struct {
+	Keys   []KeyResponse `json:"keys"`
+	Total  int           `json:"total"`
+	Limit  int           `json:"limit"`
+	Offset int           `json:"offset"`
+}
+
+const (
+	DefaultListKeysLimit = 50
+	MaxListKeysLimit     = 500
+	MaxImportBodyBytes   = 10 << 20
+)
+
+// ImportKeyLineResponse is the report of one imported line
+type ImportKeyLineResponse struct {
+	Line      int             `json:"line"`
+	MaskedKey string          `json:"masked_key"`
+	Status    ImportKeyStatus `json:"status"`
+	ID        int64           `json:"id,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+type ImportKeysResponse struct {
+	Inserted   int                     `json:"inserted"`
+	Duplicates int                     `json:"duplicates"`
+	Invalid    int                     `json:"invalid"`
+	Lines      []ImportKeyLineResponse `json:"lines"`
 }
 
 type KeyBiz interface {
 	GetKeyStats(ctx context.Context) (*KeyStats, error)
 	InsertKey(ctx context.Context, params InsertKeyParams) error
+	ImportKeys(ctx context.Context, params []ImportKeyParams) ([]ImportKeyResult, error)
+	ExportKeys(ctx context.Context) ([]Key, error)
+	ListKeys(ctx context.Context, params ListKeysParams) (*KeyList, error)
+	GetKey(ctx context.Context, id int64) (*Key, error)
+	UpdateKey(ctx context.Context, params UpdateKeyParams) (*Key, error)
+	DeleteKey(ctx context.Context, id int64) error
+	ValidateKey(ctx context.Context, id int64) (*ValidatedKey, error)
+}
+
+// ValidateKeyResponse is the outcome of a validation and the key after applying it
+type ValidateKeyResponse struct {
+	Result          KeyValidationResult `json:"result"`
+	ReportedBalance *int64              `json:"reported_balance"`
+	Key             KeyResponse         `json:"key"`
 }
 
 type KeyHandler struct {
@@ -45,13 +131,271 @@ func (h *KeyHandler) InsertKey(w http.ResponseWriter, r *http.Request) {
 	}
 	err = h.service.InsertKey(r.Context(), req.ToParams())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeKeyError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
 }
 
+// ListKeys lists the keys with the status, sort, limit and offset query parameters
+func (h *KeyHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit, err := parseQueryInt(query.Get("limit"), DefaultListKeysLimit)
+	if err != nil || limit < 1 || limit > MaxListKeysLimit {
+		http.Error(w, fmt.Sprintf("limit must be between 1 and %d", MaxListKeysLimit), http.StatusBadRequest)
+		return
+	}
+	offset, err := parseQueryInt(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	list, err := h.service.ListKeys(r.Context(), ListKeysParams{
+		Status: KeyStatus(query.Get("status")),
+		Sort:   query.Get("sort"),
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	response := ListKeysResponse{
+		Keys:   make([]KeyResponse, 0, len(list.Keys)),
+		Total:  list.Total,
+		Limit:  limit,
+		Offset: offset,
+	}
+	for _, key := range list.Keys {
+		response.Keys = append(response.Keys, NewKeyResponse(key))
+	}
+	httpapi.WriteJSON(w, http.StatusOK, response)
+}
+
+func (h *KeyHandler) GetKey(w http.ResponseWriter, r *http.Request) {
+	id, err := parseKeyID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key, err := h.service.GetKey(r.Context(), id)
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	httpapi.WriteJSON(w, http.StatusOK, NewKeyResponse(*key))
+}
+
+func (h *KeyHandler) UpdateKey(w http.ResponseWriter, r *http.Request) {
+	id, err := parseKeyID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req UpdateKeyRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key, err := h.service.UpdateKey(r.Context(), req.ToParams(id))
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	httpapi.WriteJSON(w, http.StatusOK, NewKeyResponse(*key))
+}
+
+func (h *KeyHandler) DeleteKey(w http.ResponseWriter, r *http.Request) {
+	id, err := parseKeyID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.DeleteKey(r.Context(), id)
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ValidateKey validates a stored key against upstream and updates its balance and status
+func (h *KeyHandler) ValidateKey(w http.ResponseWriter, r *http.Request) {
+	id, err := parseKeyID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	validated, err := h.service.ValidateKey(r.Context(), id)
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	httpapi.WriteJSON(w, http.StatusOK, ValidateKeyResponse{
+		Result:          validated.Validation.Result,
+		ReportedBalance: validated.Validation.Balance,
+		Key:             NewKeyResponse(validated.Key),
+	})
+}
+
+// ImportKeys inserts a batch of keys in JSON, text or CSV format and reports the outcome of each line.
+// The format is read from the format query parameter, or from the Content-Type header.
+func (h *KeyHandler) ImportKeys(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = keyFormatFromContentType(r.Header.Get("Content-Type"))
+	}
+
+	lines, err := ParseKeyImport(format, http.MaxBytesReader(w, r.Body, MaxImportBodyBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Import the valid lines only
+	params := make([]ImportKeyParams, 0, len(lines))
+	for _, line := range lines {
+		if line.Err == nil {
+			params = append(params, line.Params)
+		}
+	}
+
+	var results []ImportKeyResult
+	if len(params) > 0 {
+		results, err = h.service.ImportKeys(r.Context(), params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := ImportKeysResponse{Lines: make([]ImportKeyLineResponse, 0, len(lines))}
+	for _, line := range lines {
+		lineResponse := ImportKeyLineResponse{Line: line.Line, MaskedKey: MaskKey(line.Params.Key)}
+		if line.Err != nil {
+			lineResponse.Status = ImportKeyStatusInvalid
+			lineResponse.Error = line.Err.Error()
+		} else {
+			result := results[0]
+			results = results[1:]
+			lineResponse.Status = result.Status
+			lineResponse.ID = result.ID
+			if result.Err != nil {
+				lineResponse.Error = result.Err.Error()
+			}
+		}
+
+		switch lineResponse.Status {
+		case ImportKeyStatusInserted:
+			response.Inserted++
+		case ImportKeyStatusDuplicate:
+			response.Duplicates++
+		case ImportKeyStatusInvalid:
+			response.Invalid++
+		}
+		response.Lines = append(response.Lines, lineResponse)
+	}
+
+	httpapi.WriteJSON(w, http.StatusOK, response)
+}
+
+// ExportKeys exports every key with masked values
+func (h *KeyHandler) ExportKeys(w http.ResponseWriter, r *http.Request) {
+	h.exportKeys(w, r, false)
+}
+
+// ExportRawKeys exports every key with raw values, it must only be routed behind admin permission
+func (h *KeyHandler) ExportRawKeys(w http.ResponseWriter, r *http.Request) {
+	h.exportKeys(w, r, true)
+}
+
+// exportKeys writes every key in the format of the format query parameter, JSON by default
+func (h *KeyHandler) exportKeys(w http.ResponseWriter, r *http.Request, raw bool) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = KeyFormatJSON
+	}
+	contentType, ok := keyFormatContentTypes[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("%s: %s", ErrUnknownKeyFormat, format), http.StatusBadRequest)
+		return
+	}
+
+	keys, err := h.service.ExportKeys(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	_ = WriteKeyExport(w, format, keys, raw) // Intentionally ignore error as the status is already written
+}
+
+// keyFormatContentTypes maps the key formats to their content type
+var keyFormatContentTypes = map[string]string{
+	KeyFormatJSON: "application/json",
+	KeyFormatText: "text/plain",
+	KeyFormatCSV:  "text/csv",
+}
+
+// keyFormatFromContentType returns the key format of the content type, JSON by default
+func keyFormatFromContentType(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for format, formatContentType := range keyFormatContentTypes {
+		if mediaType == formatContentType {
+			return format
+		}
+	}
+	return KeyFormatJSON
+}
+
+// parseKeyID parses the id path value
+func parseKeyID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid key id: %s", r.PathValue("id"))
+	}
+	return id, nil
+}
+
+// parseQueryInt parses an integer query parameter, or returns the fallback if it is empty
+func parseQueryInt(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// writeKeyError writes the error with the status matching its kind
+func writeKeyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidKey), errors.Is(err, ErrInvalidKeyStatus), errors.Is(err, ErrInvalidKeySort), errors.Is(err, ErrInvalidBalance):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrInvalidStatusTransition):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrKeyRejected):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 func NewKeyHandler(service KeyBiz) *KeyHandler {
 	return &KeyHandler{service: service}
 }
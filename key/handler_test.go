@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -22,8 +23,8 @@ func (m *MockKeyService) InsertKey(ctx context.Context, params InsertKeyParams)
 	return args.Error(0)
 }
 
-func (m *MockKeyService) UseBestKey(ctx context.Context) (*string, error) {
-	args := m.Called(ctx)
+func (m *MockKeyService) UseBestKey(ctx context.Context, exclude ...string) (*string, error) {
+	args := m.Called(ctx, exclude)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
@@ -39,6 +40,70 @@ func (m *MockKeyService) GetKeyStats(ctx context.Context) (*KeyStats, error) {
 	return args.Get(0).(*KeyStats), args.Error(1)
 }
 
+func (m *MockKeyService) ImportKeys(ctx context.Context, params []ImportKeyParams) ([]ImportKeyResult, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]ImportKeyResult), args.Error(1)
+}
+
+func (m *MockKeyService) ExportKeys(ctx context.Context) ([]Key, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Key), args.Error(1)
+}
+
+func (m *MockKeyService) ListKeys(ctx context.Context, params ListKeysParams) (*KeyList, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*KeyList), args.Error(1)
+}
+
+func (m *MockKeyService) GetKey(ctx context.Context, id int64) (*Key, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Key), args.Error(1)
+}
+
+func (m *MockKeyService) UpdateKey(ctx context.Context, params UpdateKeyParams) (*Key, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Key), args.Error(1)
+}
+
+func (m *MockKeyService) DeleteKey(ctx context.Context, id int64) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockKeyService) ValidateKey(ctx context.Context, id int64) (*ValidatedKey, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*ValidatedKey), args.Error(1)
+}
+
+// newKeyRouter routes the key endpoints to the handler, so path values are set
+func newKeyRouter(handler *KeyHandler) http.Handler {
+	router := http.NewServeMux()
+	router.HandleFunc("GET /keys", handler.ListKeys)
+	router.HandleFunc("GET /keys/{id}", handler.GetKey)
+	router.HandleFunc("PATCH /keys/{id}", handler.UpdateKey)
+	router.HandleFunc("DELETE /keys/{id}", handler.DeleteKey)
+	router.HandleFunc("POST /keys/{id}/validate", handler.ValidateKey)
+	return router
+}
+
 func TestKeyHandler_InsertKey(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -62,6 +127,22 @@ func TestKeyHandler_InsertKey(t *testing.T) {
 			},
 			expectedStatus: http.StatusInternalServerError,
 		},
+		{
+			name:        "Key rejected by upstream",
+			requestBody: InsertKeyRequest{Key: "test-key"},
+			setupMock: func(m *MockKeyService) {
+				m.On("InsertKey", mock.Anything, InsertKeyParams{Key: "test-key"}).Return(ErrKeyRejected)
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "Invalid key",
+			requestBody: InsertKeyRequest{Key: "test key"},
+			setupMock: func(m *MockKeyService) {
+				m.On("InsertKey", mock.Anything, InsertKeyParams{Key: "test key"}).Return(ErrInvalidKey)
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
 		{
 			name:           "Invalid request body",
 			requestBody:    "invalid-json", // Will cause JSON decode error
@@ -160,3 +241,310 @@ func TestKeyHandler_GetKeyStats(t *testing.T) {
 		})
 	}
 }
+
+func TestKeyHandler_ListKeys(t *testing.T) {
+	createdAt := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	secret := "jina_0123456789abcdefghij"
+
+	tests := []struct {
+		name           string
+		query          string
+		setupMock      func(*MockKeyService)
+		expectedStatus int
+		expectedBody   *ListKeysResponse
+	}{
+		{
+			name:  "Default page",
+			query: "",
+			setupMock: func(m *MockKeyService) {
+				m.On("ListKeys", mock.Anything, ListKeysParams{Limit: DefaultListKeysLimit}).
+					Return(&KeyList{Keys: []Key{{ID: 1, Key: secret, Balance: 100, Status: KeyStatusActive, CreatedAt: createdAt}}, Total: 1}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: &ListKeysResponse{
+				Keys:  []KeyResponse{{ID: 1, MaskedKey: "jina_012...ghij", Balance: 100, Status: KeyStatusActive, CreatedAt: createdAt}},
+				Total: 1,
+				Limit: DefaultListKeysLimit,
+			},
+		},
+		{
+			name:  "Filter, sort and paginate",
+			query: "?status=disabled&sort=-balance&limit=10&offset=20",
+			setupMock: func(m *MockKeyService) {
+				m.On("ListKeys", mock.Anything, ListKeysParams{Status: KeyStatusDisabled, Sort: "-balance", Limit: 10, Offset: 20}).
+					Return(&KeyList{Total: 3}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   &ListKeysResponse{Keys: []KeyResponse{}, Total: 3, Limit: 10, Offset: 20},
+		},
+		{
+			name:           "Invalid limit",
+			query:          "?limit=1000",
+			setupMock:      func(m *MockKeyService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid offset",
+			query:          "?offset=-1",
+			setupMock:      func(m *MockKeyService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:  "Invalid sort",
+			query: "?sort=key",
+			setupMock: func(m *MockKeyService) {
+				m.On("ListKeys", mock.Anything, ListKeysParams{Sort: "key", Limit: DefaultListKeysLimit}).Return(nil, ErrInvalidKeySort)
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockService := new(MockKeyService)
+			tc.setupMock(mockService)
+			router := newKeyRouter(NewKeyHandler(mockService))
+
+			req, err := http.NewRequest("GET", "/keys"+tc.query, nil)
+			assert.NoError(t, err)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedBody != nil {
+				var response ListKeysResponse
+				err = json.NewDecoder(rr.Body).Decode(&response)
+				assert.NoError(t, err)
+				assert.Equal(t, *tc.expectedBody, response)
+			}
+			assert.NotContains(t, rr.Body.String(), secret)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestKeyHandler_KeyByID(t *testing.T) {
+	secret := "jina_0123456789abcdefghij"
+	disabled := KeyStatusDisabled
+	balance := int64(500)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		setupMock      func(*MockKeyService)
+		expectedStatus int
+	}{
+		{
+			name:   "Get key",
+			method: "GET",
+			path:   "/keys/1",
+			setupMock: func(m *MockKeyService) {
+				m.On("GetKey", mock.Anything, int64(1)).Return(&Key{ID: 1, Key: secret, Status: KeyStatusActive}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Get missing key",
+			method: "GET",
+			path:   "/keys/2",
+			setupMock: func(m *MockKeyService) {
+				m.On("GetKey", mock.Anything, int64(2)).Return(nil, ErrKeyNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Invalid id",
+			method:         "GET",
+			path:           "/keys/abc",
+			setupMock:      func(m *MockKeyService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Disable key",
+			method: "PATCH",
+			path:   "/keys/1",
+			body:   `{"status":"disabled"}`,
+			setupMock: func(m *MockKeyService) {
+				m.On("UpdateKey", mock.Anything, UpdateKeyParams{ID: 1, Status: &disabled}).Return(&Key{ID: 1, Key: secret, Status: KeyStatusDisabled}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Update balance",
+			method: "PATCH",
+			path:   "/keys/1",
+			body:   `{"balance":500}`,
+			setupMock: func(m *MockKeyService) {
+				m.On("UpdateKey", mock.Anything, UpdateKeyParams{ID: 1, Balance: &balance}).Return(&Key{ID: 1, Key: secret, Balance: 500}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Invalid transition",
+			method: "PATCH",
+			path:   "/keys/1",
+			body:   `{"status":"disabled"}`,
+			setupMock: func(m *MockKeyService) {
+				m.On("UpdateKey", mock.Anything, UpdateKeyParams{ID: 1, Status: &disabled}).Return(nil, ErrInvalidStatusTransition)
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Invalid update body",
+			method:         "PATCH",
+			path:           "/keys/1",
+			body:           "invalid-json",
+			setupMock:      func(m *MockKeyService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Delete key",
+			method: "DELETE",
+			path:   "/keys/1",
+			setupMock: func(m *MockKeyService) {
+				m.On("DeleteKey", mock.Anything, int64(1)).Return(nil)
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:   "Delete missing key",
+			method: "DELETE",
+			path:   "/keys/1",
+			setupMock: func(m *MockKeyService) {
+				m.On("DeleteKey", mock.Anything, int64(1)).Return(ErrKeyNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockService := new(MockKeyService)
+			tc.setupMock(mockService)
+			router := newKeyRouter(NewKeyHandler(mockService))
+
+			req, err := http.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body))
+			assert.NoError(t, err)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var response KeyResponse
+				err = json.NewDecoder(bytes.NewReader(rr.Body.Bytes())).Decode(&response)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(1), response.ID)
+				assert.Equal(t, "jina_012...ghij", response.MaskedKey)
+			}
+			assert.NotContains(t, rr.Body.String(), secret)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestKeyHandler_ImportKeys(t *testing.T) {
+	mockService := new(MockKeyService)
+	mockService.On("ImportKeys", mock.Anything, []ImportKeyParams{
+		{Key: "jina_0123456789abcdefghij", Balance: DefaultKeyBalance},
+		{Key: "key-2", Balance: DefaultKeyBalance},
+		{Key: "key-3", Balance: DefaultKeyBalance},
+	}).Return([]ImportKeyResult{
+		{ID: 1, Key: "jina_0123456789abcdefghij", Status: ImportKeyStatusInserted},
+		{Key: "key-2", Status: ImportKeyStatusDuplicate},
+		{Key: "key-3", Status: ImportKeyStatusInvalid, Err: ErrKeyRejected},
+	}, nil)
+	handler := NewKeyHandler(mockService)
+
+	req, err := http.NewRequest("POST", "/keys/import", bytes.NewBufferString("jina_0123456789abcdefghij\nbad key\nkey-2\nkey-3\n"))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	rr := httptest.NewRecorder()
+	handler.ImportKeys(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response ImportKeysResponse
+	err = json.NewDecoder(rr.Body).Decode(&response)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, response.Inserted)
+	assert.Equal(t, 1, response.Duplicates)
+	assert.Equal(t, 2, response.Invalid)
+	assert.Equal(t, []ImportKeyLineResponse{
+		{Line: 1, MaskedKey: "jina_012...ghij", Status: ImportKeyStatusInserted, ID: 1},
+		{Line: 2, MaskedKey: "*******", Status: ImportKeyStatusInvalid, Error: "invalid key: key contains whitespace or control characters"},
+		{Line: 3, MaskedKey: "*****", Status: ImportKeyStatusDuplicate},
+		{Line: 4, MaskedKey: "*****", Status: ImportKeyStatusInvalid, Error: "key rejected by upstream"},
+	}, response.Lines)
+	mockService.AssertExpectations(t)
+
+	// Malformed body is rejected
+	req, err = http.NewRequest("POST", "/keys/import?format=json", bytes.NewBufferString("not-json"))
+	assert.NoError(t, err)
+	rr = httptest.NewRecorder()
+	handler.ImportKeys(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestKeyHandler_ExportKeys(t *testing.T) {
+	secret := "jina_0123456789abcdefghij"
+	mockService := new(MockKeyService)
+	mockService.On("ExportKeys", mock.Anything).Return([]Key{{ID: 1, Key: secret, Balance: 100, Status: KeyStatusActive}}, nil)
+	handler := NewKeyHandler(mockService)
+
+	// Masked export
+	req, err := http.NewRequest("GET", "/keys/export?format=csv", nil)
+	assert.NoError(t, err)
+	rr := httptest.NewRecorder()
+	handler.ExportKeys(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/csv", rr.Header().Get("Content-Type"))
+	assert.NotContains(t, rr.Body.String(), secret)
+	assert.Contains(t, rr.Body.String(), "jina_012...ghij")
+
+	// Raw export
+	req, err = http.NewRequest("GET", "/keys/export/raw?format=text", nil)
+	assert.NoError(t, err)
+	rr = httptest.NewRecorder()
+	handler.ExportRawKeys(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, secret+"\n", rr.Body.String())
+
+	// Unknown format
+	req, err = http.NewRequest("GET", "/keys/export?format=xml", nil)
+	assert.NoError(t, err)
+	rr = httptest.NewRecorder()
+	handler.ExportKeys(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestKeyHandler_ValidateKey(t *testing.T) {
+	balance := int64(500)
+	mockService := new(MockKeyService)
+	mockService.On("ValidateKey", mock.Anything, int64(1)).Return(&ValidatedKey{
+		Key:        Key{ID: 1, Key: "jina_0123456789abcdefghij", Balance: 500, Status: KeyStatusActive},
+		Validation: KeyValidation{Result: KeyValidationResultValid, Balance: &balance},
+	}, nil)
+	mockService.On("ValidateKey", mock.Anything, int64(2)).Return(nil, ErrKeyNotFound)
+	router := newKeyRouter(NewKeyHandler(mockService))
+
+	req, err := http.NewRequest("POST", "/keys/1/validate", nil)
+	assert.NoError(t, err)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response ValidateKeyResponse
+	err = json.NewDecoder(rr.Body).Decode(&response)
+	assert.NoError(t, err)
+	assert.Equal(t, KeyValidationResultValid, response.Result)
+	assert.Equal(t, &balance, response.ReportedBalance)
+	assert.Equal(t, "jina_012...ghij", response.Key.MaskedKey)
+
+	req, err = http.NewRequest("POST", "/keys/2/validate", nil)
+	assert.NoError(t, err)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	mockService.AssertExpectations(t)
+}
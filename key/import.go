@@ -0,0 +1,201 @@
+package key
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Formats of the bulk import and export
+const (
+	KeyFormatJSON = "json"
+	KeyFormatText = "text"
+	KeyFormatCSV  = "csv"
+)
+
+var ErrUnknownKeyFormat = errors.New("unknown key format")
+
+// ImportKeyLine is one entry of a bulk import.
+// Line is the line number, or the array position for JSON, starting at 1.
+// Err is set when the entry is invalid and must not be imported.
+type ImportKeyLine struct {
+	Line   int
+	Params ImportKeyParams
+	Err    error
+}
+
+// importKeyEntry is an entry of a JSON import, either a key string or an object
+type importKeyEntry struct {
+	Key     string `json:"key"`
+	Balance *int64 `json:"balance"`
+	Label   string `json:"label"`
+}
+
+// ParseKeyImport parses a bulk import in the given format
+func ParseKeyImport(format string, body io.Reader) ([]ImportKeyLine, error) {
+	switch format {
+	case KeyFormatJSON:
+		return parseJSONKeyImport(body)
+	case KeyFormatText:
+		return parseTextKeyImport(body)
+	case KeyFormatCSV:
+		return parseCSVKeyImport(body)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKeyFormat, format)
+	}
+}
+
+// parseJSONKeyImport parses an array of key strings or {"key", "balance", "label"} objects
+func parseJSONKeyImport(body io.Reader) ([]ImportKeyLine, error) {
+	var entries []json.RawMessage
+	err := json.NewDecoder(body).Decode(&entries)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]ImportKeyLine, 0, len(entries))
+	for i, raw := range entries {
+		var entry importKeyEntry
+		if err := json.Unmarshal(raw, &entry.Key); err != nil {
+			if err := json.Unmarshal(raw, &entry); err != nil {
+				lines = append(lines, ImportKeyLine{Line: i + 1, Err: fmt.Errorf("%w: %v", ErrInvalidKey, err)})
+				continue
+			}
+		}
+
+		balance := int64(DefaultKeyBalance)
+		if entry.Balance != nil {
+			balance = *entry.Balance
+		}
+		lines = append(lines, newImportKeyLine(i+1, entry.Key, balance, entry.Label))
+	}
+
+	return lines, nil
+}
+
+// parseTextKeyImport parses one key per line, skipping empty lines and # comments
+func parseTextKeyImport(body io.Reader) ([]ImportKeyLine, error) {
+	var lines []ImportKeyLine
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for number := 1; scanner.Scan(); number++ {
+		key := strings.TrimSpace(scanner.Text())
+		if key == "" || strings.HasPrefix(key, "#") {
+			continue
+		}
+		lines = append(lines, newImportKeyLine(number, key, DefaultKeyBalance, ""))
+	}
+
+	return lines, scanner.Err()
+}
+
+// parseCSVKeyImport parses key, balance and label columns.
+// A header row with a "key" column sets the column order, otherwise the columns are positional.
+func parseCSVKeyImport(body io.Reader) ([]ImportKeyLine, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	columns := map[string]int{"key": 0, "balance": 1, "label": 2}
+	var lines []ImportKeyLine
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		number, _ := reader.FieldPos(0)
+
+		// Read the column order from the header
+		if first && slices.ContainsFunc(record, func(name string) bool { return strings.EqualFold(strings.TrimSpace(name), "key") }) {
+			columns = map[string]int{}
+			for i, name := range record {
+				columns[strings.ToLower(strings.TrimSpace(name))] = i
+			}
+			continue
+		}
+
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		balance := int64(DefaultKeyBalance)
+		if value := field("balance"); value != "" {
+			balance, err = strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				lines = append(lines, ImportKeyLine{Line: number, Params: ImportKeyParams{Key: field("key")}, Err: fmt.Errorf("%w: %s", ErrInvalidBalance, value)})
+				continue
+			}
+		}
+		lines = append(lines, newImportKeyLine(number, field("key"), balance, field("label")))
+	}
+
+	return lines, nil
+}
+
+// newImportKeyLine validates the key and balance of an entry
+func newImportKeyLine(number int, key string, balance int64, label string) ImportKeyLine {
+	line := ImportKeyLine{Line: number, Params: ImportKeyParams{Key: key, Balance: balance, Label: label}}
+	if err := ValidateKey(key); err != nil {
+		line.Err = err
+	} else if balance < 0 {
+		line.Err = fmt.Errorf("%w: must not be negative", ErrInvalidBalance)
+	}
+	return line
+}
+
+// ExportKeyEntry is a key in a bulk export, Key is masked unless the export is raw
+type ExportKeyEntry struct {
+	ID      int64     `json:"id"`
+	Key     string    `json:"key"`
+	Balance int64     `json:"balance"`
+	Label   string    `json:"label"`
+	Status  KeyStatus `json:"status"`
+}
+
+// WriteKeyExport writes the keys in the given format, masking them unless raw is set.
+// A raw export can be imported back.
+func WriteKeyExport(w io.Writer, format string, keys []Key, raw bool) error {
+	entries := make([]ExportKeyEntry, 0, len(keys))
+	for _, key := range keys {
+		value := key.Key
+		if !raw {
+			value = MaskKey(key.Key)
+		}
+		entries = append(entries, ExportKeyEntry{ID: key.ID, Key: value, Balance: key.Balance, Label: key.Label, Status: key.Status})
+	}
+
+	switch format {
+	case KeyFormatJSON:
+		return json.NewEncoder(w).Encode(entries)
+	case KeyFormatText:
+		for _, entry := range entries {
+			if _, err := fmt.Fprintln(w, entry.Key); err != nil {
+				return err
+			}
+		}
+		return nil
+	case KeyFormatCSV:
+		writer := csv.NewWriter(w)
+		_ = writer.Write([]string{"key", "balance", "label", "status", "id"}) // Errors are reported by Flush
+		for _, entry := range entries {
+			_ = writer.Write([]string{entry.Key, strconv.FormatInt(entry.Balance, 10), entry.Label, string(entry.Status), strconv.FormatInt(entry.ID, 10)})
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownKeyFormat, format)
+	}
+}
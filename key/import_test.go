@@ -0,0 +1,164 @@
+package key
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeyImport(t *testing.T) {
+	const validKey = "jina_0123456789abcdefghij"
+
+	tests := []struct {
+		name          string
+		format        string
+		body          string
+		expectedLines []ImportKeyLine
+		expectedErrs  []error
+		expectedErr   error
+	}{
+		{
+			name:   "JSON strings and objects",
+			format: KeyFormatJSON,
+			body:   `["` + validKey + `", {"key": "key-2", "balance": 500, "label": "team-a"}, {"key": ""}, 42]`,
+			expectedLines: []ImportKeyLine{
+				{Line: 1, Params: ImportKeyParams{Key: validKey, Balance: DefaultKeyBalance}},
+				{Line: 2, Params: ImportKeyParams{Key: "key-2", Balance: 500, Label: "team-a"}},
+				{Line: 3, Params: ImportKeyParams{Balance: DefaultKeyBalance}},
+				{Line: 4},
+			},
+			expectedErrs: []error{nil, nil, ErrInvalidKey, ErrInvalidKey},
+		},
+		{
+			name:   "Text skips empty lines and comments",
+			format: KeyFormatText,
+			body:   "# keys\n" + validKey + "\n\n  key-2  \nkey 3\n",
+			expectedLines: []ImportKeyLine{
+				{Line: 2, Params: ImportKeyParams{Key: validKey, Balance: DefaultKeyBalance}},
+				{Line: 4, Params: ImportKeyParams{Key: "key-2", Balance: DefaultKeyBalance}},
+				{Line: 5, Params: ImportKeyParams{Key: "key 3", Balance: DefaultKeyBalance}},
+			},
+			expectedErrs: []error{nil, nil, ErrInvalidKey},
+		},
+		{
+			name:   "CSV with header",
+			format: KeyFormatCSV,
+			body:   "label,key,balance\nteam-a,key-1,100\nteam-b,key-2,\nteam-c,key-3,abc\n,key-4,-1\n",
+			expectedLines: []ImportKeyLine{
+				{Line: 2, Params: ImportKeyParams{Key: "key-1", Balance: 100, Label: "team-a"}},
+				{Line: 3, Params: ImportKeyParams{Key: "key-2", Balance: DefaultKeyBalance, Label: "team-b"}},
+				{Line: 4, Params: ImportKeyParams{Key: "key-3"}},
+				{Line: 5, Params: ImportKeyParams{Key: "key-4", Balance: -1}},
+			},
+			expectedErrs: []error{nil, nil, ErrInvalidBalance, ErrInvalidBalance},
+		},
+		{
+			name:   "CSV without header",
+			format: KeyFormatCSV,
+			body:   "key-1\nkey-2,200,team-a\n",
+			expectedLines: []ImportKeyLine{
+				{Line: 1, Params: ImportKeyParams{Key: "key-1", Balance: DefaultKeyBalance}},
+				{Line: 2, Params: ImportKeyParams{Key: "key-2", Balance: 200, Label: "team-a"}},
+			},
+			expectedErrs: []error{nil, nil},
+		},
+		{
+			name:   "CSV with balances beyond 32 bits",
+			format: KeyFormatCSV,
+			body:   "key-1,5000000000\nkey-2,9223372036854775808\n",
+			expectedLines: []ImportKeyLine{
+				{Line: 1, Params: ImportKeyParams{Key: "key-1", Balance: 5000000000}},
+				{Line: 2, Params: ImportKeyParams{Key: "key-2"}},
+			},
+			expectedErrs: []error{nil, ErrInvalidBalance},
+		},
+		{
+			name:        "Invalid JSON",
+			format:      KeyFormatJSON,
+			body:        `{"key": "key-1"}`,
+			expectedErr: assert.AnError,
+		},
+		{
+			name:        "Unknown format",
+			format:      "xml",
+			expectedErr: ErrUnknownKeyFormat,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			lines, err := ParseKeyImport(tc.format, strings.NewReader(tc.body))
+			if tc.expectedErr != nil {
+				assert.Error(t, err)
+				if tc.expectedErr != assert.AnError {
+					assert.ErrorIs(t, err, tc.expectedErr)
+				}
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, lines, len(tc.expectedLines))
+
+			for i, line := range lines {
+				assert.Equal(t, tc.expectedLines[i].Line, line.Line)
+				assert.Equal(t, tc.expectedLines[i].Params, line.Params)
+				if tc.expectedErrs[i] == nil {
+					assert.NoError(t, line.Err)
+				} else {
+					assert.ErrorIs(t, line.Err, tc.expectedErrs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWriteKeyExport(t *testing.T) {
+	keys := []Key{
+		{ID: 1, Key: "jina_0123456789abcdefghij", Balance: 100, Status: KeyStatusActive, Label: "team-a"},
+	}
+
+	tests := []struct {
+		name     string
+		format   string
+		raw      bool
+		expected string
+	}{
+		{
+			name:     "Masked JSON",
+			format:   KeyFormatJSON,
+			expected: `[{"id":1,"key":"jina_012...ghij","balance":100,"label":"team-a","status":"active"}]` + "\n",
+		},
+		{
+			name:     "Raw text",
+			format:   KeyFormatText,
+			raw:      true,
+			expected: "jina_0123456789abcdefghij\n",
+		},
+		{
+			name:     "Raw CSV",
+			format:   KeyFormatCSV,
+			raw:      true,
+			expected: "key,balance,label,status,id\njina_0123456789abcdefghij,100,team-a,active,1\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := WriteKeyExport(&buf, tc.format, keys, tc.raw)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, buf.String())
+		})
+	}
+
+	// Raw CSV export can be imported back
+	var buf bytes.Buffer
+	require.NoError(t, WriteKeyExport(&buf, KeyFormatCSV, keys, true))
+	lines, err := ParseKeyImport(KeyFormatCSV, &buf)
+	require.NoError(t, err)
+	require.Len(t, lines, 1)
+	assert.NoError(t, lines[0].Err)
+	assert.Equal(t, ImportKeyParams{Key: keys[0].Key, Balance: 100, Label: "team-a"}, lines[0].Params)
+}
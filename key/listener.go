@@ -0,0 +1,95 @@
+package key
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// KeyChangesChannel is the Postgres channel on which key changes are published.
+// The payload of a notification is the id of the changed key, never the key itself,
+// so roles allowed to listen do not get the secrets.
+const KeyChangesChannel = "key_changes"
+
+// KeyChangeHandler is notified of key changes made by any process
+type KeyChangeHandler interface {
+	// RefreshKeyByID reloads a changed key
+	RefreshKeyByID(ctx context.Context, id int64) error
+	// Load reloads every key, used when notifications may have been missed
+	Load(ctx context.Context) error
+}
+
+// KeyListener subscribes to the key changes channel and forwards the changes to the handler.
+// Notifications sent while the listener is disconnected are lost,
+// so every key is reloaded after each reconnection.
+type KeyListener struct {
+	databaseURL       string
+	handler           KeyChangeHandler
+	reconnectInterval time.Duration
+}
+
+// Run listens for key changes until the context is done, reconnecting on failure
+func (l *KeyListener) Run(ctx context.Context) error {
+	for {
+		err := l.listen(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		slog.Error("listen key changes", slog.Any("error", err))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(l.reconnectInterval):
+		}
+	}
+}
+
+// listen subscribes on a dedicated connection and handles notifications until an error occurs
+func (l *KeyListener) listen(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, l.databaseURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	_, err = conn.Exec(ctx, "LISTEN "+KeyChangesChannel)
+	if err != nil {
+		return err
+	}
+
+	// Resync the keys changed while not listening
+	err = l.handler.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		id, err := strconv.ParseInt(notification.Payload, 10, 64)
+		if err != nil {
+			slog.Error("parse changed key id", slog.String("payload", notification.Payload), slog.Any("error", err))
+			continue
+		}
+
+		err = l.handler.RefreshKeyByID(ctx, id)
+		if err != nil {
+			slog.Error("refresh changed key", slog.Any("error", err))
+		}
+	}
+}
+
+func NewKeyListener(databaseURL string, handler KeyChangeHandler, reconnectInterval time.Duration) *KeyListener {
+	return &KeyListener{
+		databaseURL:       databaseURL,
+		handler:           handler,
+		reconnectInterval: reconnectInterval,
+	}
+}
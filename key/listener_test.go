@@ -0,0 +1,125 @@
+package key
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trancong12102/jina-http-proxy/internal/testdb"
+)
+
+// recordingKeyChangeHandler records the key changes it receives
+type recordingKeyChangeHandler struct {
+	mu        sync.Mutex
+	refreshed []int64
+	loads     int
+}
+
+func (h *recordingKeyChangeHandler) RefreshKeyByID(ctx context.Context, id int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.refreshed = append(h.refreshed, id)
+	return nil
+}
+
+func (h *recordingKeyChangeHandler) Load(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.loads++
+	return nil
+}
+
+func (h *recordingKeyChangeHandler) snapshot() ([]int64, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]int64(nil), h.refreshed...), h.loads
+}
+
+func TestKeyDBRepository_NotifyKeyChanges(t *testing.T) {
+	db, connStr, cleanup := testdb.SetupPostgresWithURL(t)
+	defer cleanup()
+
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
+	ctx := context.Background()
+
+	conn, err := pgx.Connect(ctx, connStr)
+	require.NoError(t, err)
+	defer conn.Close(ctx)
+	_, err = conn.Exec(ctx, "LISTEN "+KeyChangesChannel)
+	require.NoError(t, err)
+
+	// The payload is the id of the key, never the key itself
+	waitNotification := func() string {
+		waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		notification, err := conn.WaitForNotification(waitCtx)
+		require.NoError(t, err)
+		return notification.Payload
+	}
+
+	// Insert is notified, duplicate insert is not
+	require.NoError(t, repo.InsertKey(ctx, InsertKeyParams{Key: "key-1"}))
+	require.NoError(t, repo.InsertKey(ctx, InsertKeyParams{Key: "key-1"}))
+	require.NoError(t, repo.InsertKey(ctx, InsertKeyParams{Key: "key-2"}))
+	key1, err := repo.GetKey(ctx, "key-1")
+	require.NoError(t, err)
+	key2, err := repo.GetKey(ctx, "key-2")
+	require.NoError(t, err)
+	assert.Equal(t, strconv.FormatInt(key1.ID, 10), waitNotification())
+	assert.Equal(t, strconv.FormatInt(key2.ID, 10), waitNotification())
+
+	// Status change is notified
+	require.NoError(t, repo.UpdateKeyStatus(ctx, UpdateKeyStatusParams{Key: "key-1", Status: KeyStatusDisabled}))
+	assert.Equal(t, strconv.FormatInt(key1.ID, 10), waitNotification())
+
+	// Deduction is only notified when it exhausts the key
+	require.NoError(t, repo.DeductKeyBalance(ctx, DeductKeyBalanceParams{Key: "key-2", Amount: 10}))
+	require.NoError(t, repo.DeductKeyBalance(ctx, DeductKeyBalanceParams{Key: "key-2", Amount: 1000000}))
+	assert.Equal(t, strconv.FormatInt(key2.ID, 10), waitNotification())
+
+	// Usage of a key already exhausted is not notified again, so the next notification is the deletion
+	require.NoError(t, repo.DeductKeyBalance(ctx, DeductKeyBalanceParams{Key: "key-2", Amount: 10}))
+	require.NoError(t, repo.ApplyKeyUsage(ctx, []KeyUsage{{Key: "key-2", Amount: 10}}))
+
+	// Deletion is notified
+	require.NoError(t, repo.DeleteKey(ctx, key1.ID))
+	assert.Equal(t, strconv.FormatInt(key1.ID, 10), waitNotification())
+}
+
+func TestKeyListener_Run(t *testing.T) {
+	db, connStr, cleanup := testdb.SetupPostgresWithURL(t)
+	defer cleanup()
+
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
+	handler := &recordingKeyChangeHandler{}
+	listener := NewKeyListener(connStr, handler, 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- listener.Run(ctx)
+	}()
+
+	// Keys are resynced once listening
+	require.Eventually(t, func() bool {
+		_, loads := handler.snapshot()
+		return loads == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, repo.InsertKey(context.Background(), InsertKeyParams{Key: "key-1"}))
+	key, err := repo.GetKey(context.Background(), "key-1")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		refreshed, _ := handler.snapshot()
+		return assert.ObjectsAreEqual([]int64{key.ID}, refreshed)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Listener stops with the context
+	cancel()
+	assert.NoError(t, <-done)
+}
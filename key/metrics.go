@@ -0,0 +1,124 @@
+package key
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StatsCollectTimeout bounds the reading of the key stats on every scrape
+const StatsCollectTimeout = 5 * time.Second
+
+// Metrics counts the key selections and the key status changes, a repository without metrics counts nothing
+type Metrics struct {
+	selections    *prometheus.CounterVec
+	statusChanges *prometheus.CounterVec
+}
+
+// observeSelection counts a key picked by the selection strategy
+func (m *Metrics) observeSelection(id int64) {
+	if m == nil {
+		return
+	}
+	m.selections.WithLabelValues(strconv.FormatInt(id, 10)).Inc()
+}
+
+// observeStatusChange counts a key moved to a new status
+func (m *Metrics) observeStatusChange(status KeyStatus) {
+	if m == nil {
+		return
+	}
+	m.statusChanges.WithLabelValues(string(status)).Inc()
+}
+
+// InstrumentSelector returns a selector counting the keys picked by the selector
+func (m *Metrics) InstrumentSelector(selector Selector) Selector {
+	return &instrumentedSelector{selector: selector, metrics: m}
+}
+
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		selections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "jina",
+			Subsystem: "key",
+			Name:      "selections_total",
+			Help:      "Keys picked by the selection strategy, by key ID.",
+		}, []string{"key_id"}),
+		statusChanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "jina",
+			Subsystem: "key",
+			Name:      "status_changes_total",
+			Help:      "Keys moved to a new status, by new status.",
+		}, []string{"status"}),
+	}
+	registerer.MustRegister(m.selections, m.statusChanges)
+	return m
+}
+
+// instrumentedSelector counts the keys picked by its selector
+type instrumentedSelector struct {
+	selector Selector
+	metrics  *Metrics
+}
+
+func (s *instrumentedSelector) Select(candidates []Key) *Key {
+	selected := s.selector.Select(candidates)
+	if selected != nil {
+		s.metrics.observeSelection(selected.ID)
+	}
+	return selected
+}
+
+// KeyStatusStatsGetter is implemented by KeyService and KeyPool
+type KeyStatusStatsGetter interface {
+	GetKeyStatusStats(ctx context.Context) ([]KeyStatusStats, error)
+}
+
+// statsCollector exposes the number of keys and their balance by status, read on every scrape
+type statsCollector struct {
+	stats   KeyStatusStatsGetter
+	keys    *prometheus.Desc
+	balance *prometheus.Desc
+}
+
+// Check if statsCollector implements prometheus.Collector
+var _ prometheus.Collector = &statsCollector{}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.keys
+	ch <- c.balance
+}
+
+// Collect reports every status, with zero for the statuses without key.
+// Nothing is reported when the stats cannot be read.
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), StatsCollectTimeout)
+	defer cancel()
+
+	stats, err := c.stats.GetKeyStatusStats(ctx)
+	if err != nil {
+		slog.Warn("collect key stats", slog.Any("error", err))
+		return
+	}
+
+	byStatus := make(map[KeyStatus]KeyStatusStats, len(stats))
+	for _, status := range stats {
+		byStatus[status.Status] = status
+	}
+	for status := range keyStatusTransitions {
+		ch <- prometheus.MustNewConstMetric(c.keys, prometheus.GaugeValue, float64(byStatus[status].Count), string(status))
+		ch <- prometheus.MustNewConstMetric(c.balance, prometheus.GaugeValue, float64(byStatus[status].Balance), string(status))
+	}
+}
+
+// NewStatsCollector returns the collector of the key count and balance gauges
+func NewStatsCollector(stats KeyStatusStatsGetter) prometheus.Collector {
+	return &statsCollector{
+		stats:   stats,
+		keys:    prometheus.NewDesc("jina_key_pool_size", "Number of keys by status.", []string{"status"}, nil),
+		balance: prometheus.NewDesc("jina_key_balance", "Remaining balance of the keys by status.", []string{"status"}, nil),
+	}
+}
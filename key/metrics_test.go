@@ -0,0 +1,62 @@
+package key
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_InstrumentSelector(t *testing.T) {
+	now := time.Now()
+	metrics := NewMetrics(prometheus.NewRegistry())
+	selector := metrics.InstrumentSelector(lessSelector(newestFirst))
+
+	candidates := []Key{
+		{ID: 1, Key: "old-key", Balance: 1000, CreatedAt: now.Add(-time.Hour)},
+		{ID: 2, Key: "new-key", Balance: 1000, CreatedAt: now},
+	}
+	assert.Equal(t, "new-key", selector.Select(candidates).Key)
+	assert.Equal(t, "new-key", selector.Select(candidates).Key)
+	assert.Nil(t, selector.Select(nil))
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.selections.WithLabelValues("2")))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.selections), "Only selected keys are counted")
+}
+
+func TestStatsCollector(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	mockRepo.On("GetKeyStatusStats", mock.Anything).Return([]KeyStatusStats{
+		{Status: KeyStatusActive, Count: 2, Balance: 3000},
+		{Status: KeyStatusExhausted, Count: 1, Balance: 0},
+	}, nil).Once()
+	collector := NewStatsCollector(NewKeyService(mockRepo, nil, false))
+
+	expected := `
+# HELP jina_key_balance Remaining balance of the keys by status.
+# TYPE jina_key_balance gauge
+jina_key_balance{status="active"} 3000
+jina_key_balance{status="cooling_down"} 0
+jina_key_balance{status="disabled"} 0
+jina_key_balance{status="exhausted"} 0
+jina_key_balance{status="invalid"} 0
+# HELP jina_key_pool_size Number of keys by status.
+# TYPE jina_key_pool_size gauge
+jina_key_pool_size{status="active"} 2
+jina_key_pool_size{status="cooling_down"} 0
+jina_key_pool_size{status="disabled"} 0
+jina_key_pool_size{status="exhausted"} 1
+jina_key_pool_size{status="invalid"} 0
+`
+	require.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+
+	// Nothing is reported when the stats cannot be read
+	mockRepo.On("GetKeyStatusStats", mock.Anything).Return(nil, assert.AnError)
+	assert.Equal(t, 0, testutil.CollectAndCount(collector))
+	mockRepo.AssertExpectations(t)
+}
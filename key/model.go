@@ -1,14 +1,208 @@
 package key
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DefaultKeyBalance is the balance of a new key when it is not given
+const DefaultKeyBalance = 1000000
+
+type KeyStatus string
+
+const (
+	KeyStatusActive      KeyStatus = "active"
+	KeyStatusExhausted   KeyStatus = "exhausted"
+	KeyStatusInvalid     KeyStatus = "invalid"
+	KeyStatusCoolingDown KeyStatus = "cooling_down"
+	KeyStatusDisabled    KeyStatus = "disabled"
+)
+
+// keyStatusTransitions lists the statuses a key can move to from each status
+var keyStatusTransitions = map[KeyStatus][]KeyStatus{
+	KeyStatusActive:      {KeyStatusExhausted, KeyStatusInvalid, KeyStatusCoolingDown, KeyStatusDisabled},
+	KeyStatusCoolingDown: {KeyStatusActive, KeyStatusExhausted, KeyStatusInvalid, KeyStatusDisabled},
+	KeyStatusExhausted:   {KeyStatusActive, KeyStatusInvalid, KeyStatusDisabled},
+	KeyStatusInvalid:     {KeyStatusActive, KeyStatusDisabled},
+	KeyStatusDisabled:    {KeyStatusActive},
+}
+
+// IsValid reports whether s is a known key status
+func (s KeyStatus) IsValid() bool {
+	_, ok := keyStatusTransitions[s]
+	return ok
+}
+
+// CanTransitionTo reports whether a key in status s can be moved to status next.
+// Staying in the same status is always allowed.
+func (s KeyStatus) CanTransitionTo(next KeyStatus) bool {
+	if s == next {
+		return s.IsValid()
+	}
+	for _, status := range keyStatusTransitions[s] {
+		if status == next {
+			return true
+		}
+	}
+	return false
+}
+
 type Key struct {
-	Key string
+	ID            int64
+	Key           string
+	Balance       int64
+	Status        KeyStatus
+	UsedAt        *time.Time
+	CreatedAt     time.Time
+	CooldownUntil *time.Time
+	Label         string
 }
 
 type InsertKeyParams struct {
 	Key string
+	// Balance is the initial balance, DefaultKeyBalance when nil
+	Balance *int64
+}
+
+type ImportKeyParams struct {
+	Key     string
+	Balance int64
+	Label   string
+}
+
+type ImportKeyStatus string
+
+const (
+	ImportKeyStatusInserted  ImportKeyStatus = "inserted"
+	ImportKeyStatusDuplicate ImportKeyStatus = "duplicate"
+	ImportKeyStatusInvalid   ImportKeyStatus = "invalid"
+)
+
+// ImportKeyResult is the outcome of importing one key
+type ImportKeyResult struct {
+	ID     int64
+	Key    string
+	Status ImportKeyStatus
+	// Err is the reason an invalid key was not imported
+	Err error
+}
+
+type DeductKeyBalanceParams struct {
+	Key    string
+	Amount int64
+}
+
+type UpdateKeyStatusParams struct {
+	Key    string
+	Status KeyStatus
+	// CooldownUntil is required when Status is KeyStatusCoolingDown
+	CooldownUntil *time.Time
+}
+
+// Sort fields of ListKeysParams, prefix with "-" to sort in descending order
+const (
+	KeySortID        = "id"
+	KeySortCreatedAt = "created_at"
+	KeySortUsedAt    = "used_at"
+	KeySortBalance   = "balance"
+	KeySortStatus    = "status"
+)
+
+type ListKeysParams struct {
+	// Status filters the keys by status, every key is listed when empty
+	Status KeyStatus
+	// Sort is one of the KeySort fields, sorted by id when empty
+	Sort   string
+	Limit  int
+	Offset int
+}
+
+// KeyList is a page of keys with the total number of matching keys
+type KeyList struct {
+	Keys  []Key
+	Total int
+}
+
+// UpdateKeyParams updates the fields that are not nil
+type UpdateKeyParams struct {
+	ID      int64
+	Balance *int64
+	Status  *KeyStatus
+}
+
+// BalanceDrift is the difference between the local balance of a key and the balance reported by upstream.
+// Drift is positive when upstream reports more balance than stored locally.
+type BalanceDrift struct {
+	Key             string
+	LocalBalance    int64
+	UpstreamBalance int64
+	Drift           int64
+	// Status is the new status of the key when the reported balance changed it, nil otherwise
+	Status *KeyStatus
+}
+
+// ValidatedKey is a key after applying the outcome of its validation
+type ValidatedKey struct {
+	Key        Key
+	Validation KeyValidation
+}
+
+// KeyUsage is the usage of a key accumulated in memory
+type KeyUsage struct {
+	Key    string
+	Amount int64
+	UsedAt *time.Time
+	// EndCooldown ends the cooldown of the key when it has expired, the key was selected after its cooldown
+	EndCooldown bool
 }
 
 type KeyStats struct {
 	Count   int
 	Balance int64
 }
+
+// KeyStatusStats is the number of keys in a status and their balance
+type KeyStatusStats struct {
+	Status  KeyStatus
+	Count   int
+	Balance int64
+}
+
+// MaxKeyLength is the longest key accepted
+const MaxKeyLength = 256
+
+// ValidateKey checks that a key is not empty and has no whitespace or control characters
+func ValidateKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("%w: key is empty", ErrInvalidKey)
+	}
+	if len(key) > MaxKeyLength {
+		return fmt.Errorf("%w: key is longer than %d characters", ErrInvalidKey, MaxKeyLength)
+	}
+	if strings.IndexFunc(key, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return fmt.Errorf("%w: key contains whitespace or control characters", ErrInvalidKey)
+	}
+	return nil
+}
+
+// MaskKey hides the secret part of a key, keeping its start and end to recognize it
+func MaskKey(key string) string {
+	if len(key) <= 12 {
+		return strings.Repeat("*", len(key))
+	}
+	return key[:8] + "..." + key[len(key)-4:]
+}
+
+var (
+	ErrKeyNotFound             = errors.New("key not found")
+	ErrInvalidKeyStatus        = errors.New("invalid key status")
+	ErrInvalidStatusTransition = errors.New("invalid key status transition")
+	ErrUnknownStrategy         = errors.New("unknown key selection strategy")
+	ErrInvalidKeySort          = errors.New("invalid key sort")
+	ErrInvalidBalance          = errors.New("invalid key balance")
+	ErrInvalidKey              = errors.New("invalid key")
+	ErrKeyRejected             = errors.New("key rejected by upstream")
+)
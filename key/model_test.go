@@ -0,0 +1,51 @@
+package key
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyStatus_CanTransitionTo(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     KeyStatus
+		to       KeyStatus
+		expected bool
+	}{
+		{name: "Active to invalid", from: KeyStatusActive, to: KeyStatusInvalid, expected: true},
+		{name: "Active to exhausted", from: KeyStatusActive, to: KeyStatusExhausted, expected: true},
+		{name: "Active to cooling down", from: KeyStatusActive, to: KeyStatusCoolingDown, expected: true},
+		{name: "Cooling down to active", from: KeyStatusCoolingDown, to: KeyStatusActive, expected: true},
+		{name: "Exhausted to active", from: KeyStatusExhausted, to: KeyStatusActive, expected: true},
+		{name: "Exhausted to cooling down", from: KeyStatusExhausted, to: KeyStatusCoolingDown, expected: false},
+		{name: "Invalid to exhausted", from: KeyStatusInvalid, to: KeyStatusExhausted, expected: false},
+		{name: "Disabled to invalid", from: KeyStatusDisabled, to: KeyStatusInvalid, expected: false},
+		{name: "Disabled to active", from: KeyStatusDisabled, to: KeyStatusActive, expected: true},
+		{name: "Same status", from: KeyStatusDisabled, to: KeyStatusDisabled, expected: true},
+		{name: "Unknown status", from: KeyStatus("unknown"), to: KeyStatus("unknown"), expected: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.from.CanTransitionTo(tc.to))
+		})
+	}
+}
+
+func TestMaskKey(t *testing.T) {
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{key: "jina_0123456789abcdefghij", expected: "jina_012...ghij"},
+		{key: "short-key", expected: "*********"},
+		{key: "", expected: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.key, func(t *testing.T) {
+			assert.Equal(t, tc.expected, MaskKey(tc.key))
+		})
+	}
+}
@@ -0,0 +1,565 @@
+package key
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyPool is a key service that selects keys and accounts usage in memory.
+//
+// Keys are loaded from the repository and kept in memory, so selecting a key
+// and deducting usage never wait for the database. Usage is accumulated and
+// flushed to the repository in batches every flush interval, and the keys are
+// reloaded every reload interval to see changes made outside of this process.
+//
+// Consistency guarantees:
+//   - Status changes reported by upstream (invalid, exhausted, cooling down)
+//     are written to the repository before they are applied in memory, so
+//     they survive a crash.
+//   - Usage not flushed yet is lost if the process crashes, so the stored
+//     balance can be higher than the real one by at most one flush interval
+//     of usage, and used_at and the end of expired cooldowns can lag behind
+//     by the same interval. A key exhausted by deducted usage is exhausted in
+//     memory first and only stored with the next flush, so this exhaustion is
+//     lost with the unflushed usage.
+//   - A failed flush keeps the usage in memory and retries on the next flush.
+//   - Other processes see the usage of this process after it is flushed, and
+//     this process sees changes of other processes after the next reload, or
+//     as soon as they are notified when a KeyListener is running.
+type KeyPool struct {
+	repo             KeyRepository
+	selector         Selector
+	validator        KeyValidator
+	validateOnInsert bool
+	flushInterval    time.Duration
+	reloadInterval   time.Duration
+
+	// syncMu serializes flushes and loads, so a load never misses or doubles in-flight usage
+	syncMu sync.Mutex
+
+	mu      sync.Mutex
+	keys    map[string]*Key
+	pending map[string]*KeyUsage
+}
+
+// Load replaces the keys in memory with the keys from the repository.
+// Usage not flushed yet is applied on top of the loaded keys.
+func (p *KeyPool) Load(ctx context.Context) error {
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+
+	keys, err := p.repo.GetAllKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.keys = make(map[string]*Key, len(keys))
+	for i := range keys {
+		p.setKeyLocked(keys[i])
+	}
+
+	return nil
+}
+
+// Run flushes usage and reloads keys periodically until the context is done.
+// Remaining usage is flushed before returning.
+func (p *KeyPool) Run(ctx context.Context) error {
+	flushTicker := time.NewTicker(p.flushInterval)
+	defer flushTicker.Stop()
+
+	reloadTicker := time.NewTicker(p.reloadInterval)
+	defer reloadTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := p.Flush(context.WithoutCancel(ctx)); err != nil {
+				slog.Error("flush key usage on shutdown", slog.Any("error", err))
+			}
+			return nil
+		case <-flushTicker.C:
+			if err := p.Flush(ctx); err != nil {
+				slog.Error("flush key usage", slog.Any("error", err))
+			}
+		case <-reloadTicker.C:
+			if err := p.Load(ctx); err != nil {
+				slog.Error("reload keys", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// Flush writes the usage accumulated in memory to the repository
+func (p *KeyPool) Flush(ctx context.Context) error {
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+
+	p.mu.Lock()
+	pending := p.pending
+	p.pending = make(map[string]*KeyUsage)
+	p.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// Rows are updated in key order, so concurrent flushes of other instances lock the same keys
+	// in the same order and never deadlock
+	usages := make([]KeyUsage, 0, len(pending))
+	for _, usage := range pending {
+		usages = append(usages, *usage)
+	}
+	slices.SortFunc(usages, func(a, b KeyUsage) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	err := p.repo.ApplyKeyUsage(ctx, usages)
+	if err != nil {
+		// Keep the usage to retry on the next flush
+		p.mu.Lock()
+		for _, usage := range usages {
+			p.addPendingLocked(usage)
+		}
+		p.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// InsertKey inserts the key into the repository and the pool.
+// The key is validated against upstream first when validation on insert is enabled,
+// a malformed key returns ErrInvalidKey.
+func (p *KeyPool) InsertKey(ctx context.Context, params InsertKeyParams) error {
+	err := ValidateKey(params.Key)
+	if err != nil {
+		return err
+	}
+
+	if p.validateOnInsert {
+		params, err = validatedInsertParams(ctx, p.validator, params)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = p.repo.InsertKey(ctx, params)
+	if err != nil {
+		return err
+	}
+
+	return p.RefreshKey(ctx, params.Key)
+}
+
+// ValidateKey validates a stored key against upstream and updates its balance and status.
+// The pending usage is flushed first, so the balance read from upstream is not deducted again.
+func (p *KeyPool) ValidateKey(ctx context.Context, id int64) (*ValidatedKey, error) {
+	err := p.Flush(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := p.repo.GetKeyByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return validateStoredKey(ctx, p.validator, key, p.UpdateKey)
+}
+
+// ImportKeys imports the keys into the repository, validating them against upstream first when
+// validation on insert is enabled, then reloads the pool once if any key was inserted.
+// The import is committed before the reload, so a failed reload is only logged and
+// the keys are loaded by the key listener or the next periodic reload.
+func (p *KeyPool) ImportKeys(ctx context.Context, params []ImportKeyParams) ([]ImportKeyResult, error) {
+	var results []ImportKeyResult
+	var err error
+	if p.validateOnInsert {
+		results, err = importValidatedKeys(ctx, p.validator, p.repo, params)
+	} else {
+		results, err = p.repo.ImportKeys(ctx, params)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		if result.Status == ImportKeyStatusInserted {
+			if err := p.Load(ctx); err != nil {
+				slog.Error("reload keys after import", slog.Any("error", err))
+			}
+			break
+		}
+	}
+
+	return results, nil
+}
+
+// ExportKeys returns every key from the repository with the usage not flushed yet applied
+func (p *KeyPool) ExportKeys(ctx context.Context) ([]Key, error) {
+	keys, err := p.repo.GetAllKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := range keys {
+		p.applyPendingLocked(&keys[i])
+	}
+
+	return keys, nil
+}
+
+// UseBestKey selects the best selectable key in memory, skipping the excluded keys
+func (p *KeyPool) UseBestKey(ctx context.Context, exclude ...string) (*string, error) {
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	candidates := make([]Key, 0, len(p.keys))
+	for _, key := range p.keys {
+		if isSelectable(key, now) && !slices.Contains(exclude, key.Key) {
+			candidates = append(candidates, *key)
+		}
+	}
+
+	selected := p.selector.Select(candidates)
+	if selected == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	// Update used_at and end the expired cooldown, the repository is updated on the next flush
+	key := p.keys[selected.Key]
+	endCooldown := key.Status == KeyStatusCoolingDown
+	key.UsedAt = &now
+	key.Status = KeyStatusActive
+	key.CooldownUntil = nil
+	p.addPendingLocked(KeyUsage{Key: key.Key, UsedAt: &now, EndCooldown: endCooldown})
+
+	value := key.Key
+	return &value, nil
+}
+
+// DeductKeyBalance deducts the used tokens from the key balance in memory.
+// A key that reaches zero is exhausted, the repository is updated on the next flush.
+// The usage of a key not loaded yet, for example inserted by another instance, is only flushed,
+// and applied to the key once it is loaded.
+func (p *KeyPool) DeductKeyBalance(ctx context.Context, key string, amount int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if poolKey, ok := p.keys[key]; ok {
+		applyDeduction(poolKey, amount)
+	}
+	p.addPendingLocked(KeyUsage{Key: key, Amount: amount})
+
+	return nil
+}
+
+// InvalidateKey marks a key rejected by upstream as invalid
+func (p *KeyPool) InvalidateKey(ctx context.Context, key string) error {
+	return p.updateKeyStatus(ctx, UpdateKeyStatusParams{Key: key, Status: KeyStatusInvalid})
+}
+
+// ExhaustKey marks a key without remaining balance as exhausted
+func (p *KeyPool) ExhaustKey(ctx context.Context, key string) error {
+	return p.updateKeyStatus(ctx, UpdateKeyStatusParams{Key: key, Status: KeyStatusExhausted})
+}
+
+// CooldownKey stops using a rate limited key until the given time
+func (p *KeyPool) CooldownKey(ctx context.Context, key string, until time.Time) error {
+	return p.updateKeyStatus(ctx, UpdateKeyStatusParams{Key: key, Status: KeyStatusCoolingDown, CooldownUntil: &until})
+}
+
+// ListKeys lists the keys from the repository with the usage not flushed yet applied
+func (p *KeyPool) ListKeys(ctx context.Context, params ListKeysParams) (*KeyList, error) {
+	list, err := p.repo.ListKeys(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := range list.Keys {
+		p.applyPendingLocked(&list.Keys[i])
+	}
+
+	return list, nil
+}
+
+// GetKey returns the key from the repository with the usage not flushed yet applied
+func (p *KeyPool) GetKey(ctx context.Context, id int64) (*Key, error) {
+	key, err := p.repo.GetKeyByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.applyPendingLocked(key)
+	return key, nil
+}
+
+// UpdateKey updates the key in the repository, then refreshes it in memory.
+// Usage not flushed yet is deducted from the updated balance.
+func (p *KeyPool) UpdateKey(ctx context.Context, params UpdateKeyParams) (*Key, error) {
+	key, err := p.repo.UpdateKey(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.RefreshKey(ctx, key.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.applyPendingLocked(key)
+	return key, nil
+}
+
+// DeleteKey deletes the key from the repository, then removes it from memory
+func (p *KeyPool) DeleteKey(ctx context.Context, id int64) error {
+	key, err := p.repo.GetKeyByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = p.repo.DeleteKey(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return p.RefreshKey(ctx, key.Key)
+}
+
+// ReconcileKeyBalance replaces the balance of a key with the balance reported by upstream.
+// Usage is flushed first, so usage already billed by upstream is not deducted again.
+func (p *KeyPool) ReconcileKeyBalance(ctx context.Context, key string, balance int64) (*BalanceDrift, error) {
+	err := p.Flush(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	drift, err := p.repo.ReconcileKeyBalance(ctx, key, balance)
+	if err != nil {
+		return nil, err
+	}
+
+	return drift, p.RefreshKey(ctx, key)
+}
+
+// GetKeyStats returns the stats of the keys in memory
+func (p *KeyPool) GetKeyStats(ctx context.Context) (*KeyStats, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var stats KeyStats
+	for _, key := range p.keys {
+		stats.Count++
+		stats.Balance += key.Balance
+	}
+
+	return &stats, nil
+}
+
+// GetKeyStatusStats returns the stats of the keys in memory by status, statuses without key are omitted
+func (p *KeyPool) GetKeyStatusStats(ctx context.Context) ([]KeyStatusStats, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	byStatus := make(map[KeyStatus]*KeyStatusStats)
+	for _, key := range p.keys {
+		status, ok := byStatus[key.Status]
+		if !ok {
+			status = &KeyStatusStats{Status: key.Status}
+			byStatus[key.Status] = status
+		}
+		status.Count++
+		status.Balance += key.Balance
+	}
+
+	stats := make([]KeyStatusStats, 0, len(byStatus))
+	for _, status := range byStatus {
+		stats = append(stats, *status)
+	}
+	slices.SortFunc(stats, func(a, b KeyStatusStats) int {
+		return strings.Compare(string(a.Status), string(b.Status))
+	})
+
+	return stats, nil
+}
+
+// updateKeyStatus writes the status to the repository, then refreshes the key in memory
+func (p *KeyPool) updateKeyStatus(ctx context.Context, params UpdateKeyStatusParams) error {
+	err := p.repo.UpdateKeyStatus(ctx, params)
+	if err != nil {
+		return err
+	}
+
+	return p.RefreshKey(ctx, params.Key)
+}
+
+// RefreshKey reloads a single key from the repository.
+// A key that no longer exists is removed from the pool.
+func (p *KeyPool) RefreshKey(ctx context.Context, key string) error {
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+
+	loaded, err := p.repo.GetKey(ctx, key)
+	if errors.Is(err, ErrKeyNotFound) {
+		p.mu.Lock()
+		delete(p.keys, key)
+		p.mu.Unlock()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.setKeyLocked(*loaded)
+	return nil
+}
+
+// RefreshKeyByID reloads a single key from the repository by its id.
+// A key that no longer exists is removed from the pool.
+func (p *KeyPool) RefreshKeyByID(ctx context.Context, id int64) error {
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+
+	loaded, err := p.repo.GetKeyByID(ctx, id)
+	if errors.Is(err, ErrKeyNotFound) {
+		p.mu.Lock()
+		for key, poolKey := range p.keys {
+			if poolKey.ID == id {
+				delete(p.keys, key)
+			}
+		}
+		p.mu.Unlock()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.setKeyLocked(*loaded)
+	return nil
+}
+
+// setKeyLocked stores a key loaded from the repository with the pending usage applied.
+// Must be called with the lock held.
+func (p *KeyPool) setKeyLocked(key Key) {
+	p.applyPendingLocked(&key)
+	p.keys[key.Key] = &key
+}
+
+// applyPendingLocked applies the usage not flushed yet to a key loaded from the repository.
+// Must be called with the lock held.
+func (p *KeyPool) applyPendingLocked(key *Key) {
+	if usage, ok := p.pending[key.Key]; ok {
+		if usage.EndCooldown && key.Status == KeyStatusCoolingDown && isSelectable(key, time.Now()) {
+			key.Status = KeyStatusActive
+			key.CooldownUntil = nil
+		}
+		applyDeduction(key, usage.Amount)
+		if usage.UsedAt != nil && (key.UsedAt == nil || usage.UsedAt.After(*key.UsedAt)) {
+			key.UsedAt = usage.UsedAt
+		}
+	}
+}
+
+// addPendingLocked accumulates usage to be flushed.
+// Must be called with the lock held.
+func (p *KeyPool) addPendingLocked(usage KeyUsage) {
+	pending, ok := p.pending[usage.Key]
+	if !ok {
+		pending = &KeyUsage{Key: usage.Key}
+		p.pending[usage.Key] = pending
+	}
+
+	pending.Amount += usage.Amount
+	pending.EndCooldown = pending.EndCooldown || usage.EndCooldown
+	if usage.UsedAt != nil && (pending.UsedAt == nil || usage.UsedAt.After(*pending.UsedAt)) {
+		pending.UsedAt = usage.UsedAt
+	}
+}
+
+// applyDeduction deducts the amount from the key the same way as the repository does
+func applyDeduction(key *Key, amount int64) {
+	if amount <= 0 {
+		return
+	}
+	if key.Balance-amount <= 0 && (key.Status == KeyStatusActive || key.Status == KeyStatusCoolingDown) {
+		key.Status = KeyStatusExhausted
+	}
+	key.Balance = max(key.Balance-amount, 0)
+}
+
+// NextKeyAvailableAt returns when the first cooling down key in memory can be used again,
+// nil when no key is cooling down
+func (p *KeyPool) NextKeyAvailableAt(ctx context.Context) (*time.Time, error) {
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var next *time.Time
+	for _, key := range p.keys {
+		if key.Status != KeyStatusCoolingDown || key.CooldownUntil == nil || !key.CooldownUntil.After(now) {
+			continue
+		}
+		if next == nil || key.CooldownUntil.Before(*next) {
+			until := *key.CooldownUntil
+			next = &until
+		}
+	}
+
+	return next, nil
+}
+
+// isSelectable reports whether the key is active or its cooldown has expired
+func isSelectable(key *Key, now time.Time) bool {
+	switch key.Status {
+	case KeyStatusActive:
+		return true
+	case KeyStatusCoolingDown:
+		return key.CooldownUntil != nil && !key.CooldownUntil.After(now)
+	default:
+		return false
+	}
+}
+
+func NewKeyPool(repo KeyRepository, selector Selector, validator KeyValidator, validateOnInsert bool, flushInterval time.Duration, reloadInterval time.Duration) *KeyPool {
+	return &KeyPool{
+		repo:             repo,
+		selector:         selector,
+		validator:        validator,
+		validateOnInsert: validateOnInsert,
+		flushInterval:    flushInterval,
+		reloadInterval:   reloadInterval,
+		keys:             make(map[string]*Key),
+		pending:          make(map[string]*KeyUsage),
+	}
+}
@@ -0,0 +1,502 @@
+package key
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestKeyPool returns a pool loaded with the given keys
+func newTestKeyPool(t *testing.T, mockRepo *MockKeyRepository, keys []Key) *KeyPool {
+	t.Helper()
+
+	mockRepo.On("GetAllKeys", mock.Anything).Return(keys, nil).Once()
+	pool := NewKeyPool(mockRepo, lessSelector(newestFirst), nil, false, time.Second, time.Minute)
+	require.NoError(t, pool.Load(context.Background()))
+	return pool
+}
+
+func TestKeyPool_UseBestKey(t *testing.T) {
+	now := time.Now()
+	expired := now.Add(-time.Second)
+	later := now.Add(time.Hour)
+
+	tests := []struct {
+		name        string
+		keys        []Key
+		exclude     []string
+		expectedKey string
+		expectedErr error
+	}{
+		{
+			name: "Select newest active key",
+			keys: []Key{
+				{Key: "old-key", Balance: 1000, Status: KeyStatusActive, CreatedAt: now.Add(-time.Hour)},
+				{Key: "new-key", Balance: 1000, Status: KeyStatusActive, CreatedAt: now},
+			},
+			expectedKey: "new-key",
+		},
+		{
+			name: "Skip inactive keys",
+			keys: []Key{
+				{Key: "active-key", Balance: 1000, Status: KeyStatusActive, CreatedAt: now.Add(-time.Hour)},
+				{Key: "invalid-key", Balance: 1000, Status: KeyStatusInvalid, CreatedAt: now},
+				{Key: "cooling-key", Balance: 1000, Status: KeyStatusCoolingDown, CreatedAt: now, CooldownUntil: &later},
+			},
+			expectedKey: "active-key",
+		},
+		{
+			name: "Expired cooldown is selectable",
+			keys: []Key{
+				{Key: "active-key", Balance: 1000, Status: KeyStatusActive, CreatedAt: now.Add(-time.Hour)},
+				{Key: "cooled-key", Balance: 1000, Status: KeyStatusCoolingDown, CreatedAt: now, CooldownUntil: &expired},
+			},
+			expectedKey: "cooled-key",
+		},
+		{
+			name: "Skip excluded keys",
+			keys: []Key{
+				{Key: "old-key", Balance: 1000, Status: KeyStatusActive, CreatedAt: now.Add(-time.Hour)},
+				{Key: "new-key", Balance: 1000, Status: KeyStatusActive, CreatedAt: now},
+			},
+			exclude:     []string{"new-key"},
+			expectedKey: "old-key",
+		},
+		{
+			name: "No selectable key",
+			keys: []Key{
+				{Key: "disabled-key", Balance: 1000, Status: KeyStatusDisabled, CreatedAt: now},
+			},
+			expectedErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockKeyRepository)
+			pool := newTestKeyPool(t, mockRepo, tc.keys)
+
+			key, err := pool.UseBestKey(context.Background(), tc.exclude...)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedKey, *key)
+
+			// Selected key is active and used
+			selected := pool.keys[tc.expectedKey]
+			assert.Equal(t, KeyStatusActive, selected.Status)
+			assert.NotNil(t, selected.UsedAt)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestKeyPool_DeductKeyBalance(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	pool := newTestKeyPool(t, mockRepo, []Key{
+		{Key: "test-key", Balance: 100, Status: KeyStatusActive},
+	})
+	ctx := context.Background()
+
+	// Deduction is applied in memory
+	err := pool.DeductKeyBalance(ctx, "test-key", 40)
+	assert.NoError(t, err)
+	stats, err := pool.GetKeyStats(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &KeyStats{Count: 1, Balance: 60}, stats)
+
+	// Key reaching zero is exhausted and no longer selectable
+	err = pool.DeductKeyBalance(ctx, "test-key", 100)
+	assert.NoError(t, err)
+	assert.Equal(t, KeyStatusExhausted, pool.keys["test-key"].Status)
+	assert.Equal(t, int64(0), pool.keys["test-key"].Balance)
+	_, err = pool.UseBestKey(ctx)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// Key not loaded yet, its usage is still flushed
+	err = pool.DeductKeyBalance(ctx, "missing-key", 1)
+	assert.NoError(t, err)
+	assert.NotContains(t, pool.keys, "missing-key")
+
+	// Usage is flushed as a single batch
+	mockRepo.On("ApplyKeyUsage", ctx, []KeyUsage{{Key: "missing-key", Amount: 1}, {Key: "test-key", Amount: 140}}).Return(nil).Once()
+	assert.NoError(t, pool.Flush(ctx))
+
+	// Nothing left to flush
+	assert.NoError(t, pool.Flush(ctx))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyPool_Flush_Failure(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	pool := newTestKeyPool(t, mockRepo, []Key{
+		{Key: "test-key", Balance: 100, Status: KeyStatusActive},
+	})
+	ctx := context.Background()
+
+	require.NoError(t, pool.DeductKeyBalance(ctx, "test-key", 10))
+
+	// Failed flush keeps the usage
+	mockRepo.On("ApplyKeyUsage", ctx, []KeyUsage{{Key: "test-key", Amount: 10}}).Return(assert.AnError).Once()
+	assert.ErrorIs(t, pool.Flush(ctx), assert.AnError)
+
+	// Usage after the failure is merged into the next flush
+	require.NoError(t, pool.DeductKeyBalance(ctx, "test-key", 5))
+	mockRepo.On("ApplyKeyUsage", ctx, []KeyUsage{{Key: "test-key", Amount: 15}}).Return(nil).Once()
+	assert.NoError(t, pool.Flush(ctx))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyPool_Flush_SortsUsageByKey(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	pool := newTestKeyPool(t, mockRepo, []Key{
+		{Key: "key-a", Balance: 100, Status: KeyStatusActive},
+		{Key: "key-b", Balance: 100, Status: KeyStatusActive},
+		{Key: "key-c", Balance: 100, Status: KeyStatusActive},
+	})
+	ctx := context.Background()
+
+	require.NoError(t, pool.DeductKeyBalance(ctx, "key-c", 3))
+	require.NoError(t, pool.DeductKeyBalance(ctx, "key-a", 1))
+	require.NoError(t, pool.DeductKeyBalance(ctx, "key-b", 2))
+
+	// Keys are always locked in the same order
+	mockRepo.On("ApplyKeyUsage", ctx, []KeyUsage{
+		{Key: "key-a", Amount: 1},
+		{Key: "key-b", Amount: 2},
+		{Key: "key-c", Amount: 3},
+	}).Return(nil).Once()
+	require.NoError(t, pool.Flush(ctx))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyPool_Flush_EndsExpiredCooldown(t *testing.T) {
+	expired := time.Now().Add(-time.Second)
+	mockRepo := new(MockKeyRepository)
+	pool := newTestKeyPool(t, mockRepo, []Key{
+		{Key: "test-key", Balance: 100, Status: KeyStatusCoolingDown, CooldownUntil: &expired},
+	})
+	ctx := context.Background()
+
+	key, err := pool.UseBestKey(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "test-key", *key)
+
+	// A reload before the flush keeps the cooldown ended
+	mockRepo.On("GetAllKeys", ctx).Return([]Key{
+		{Key: "test-key", Balance: 100, Status: KeyStatusCoolingDown, CooldownUntil: &expired},
+	}, nil).Once()
+	require.NoError(t, pool.Load(ctx))
+	assert.Equal(t, KeyStatusActive, pool.keys["test-key"].Status)
+	assert.Nil(t, pool.keys["test-key"].CooldownUntil)
+
+	// The flush ends the cooldown in the repository
+	mockRepo.On("ApplyKeyUsage", ctx, mock.MatchedBy(func(usages []KeyUsage) bool {
+		return len(usages) == 1 && usages[0].Key == "test-key" && usages[0].EndCooldown
+	})).Return(nil).Once()
+	require.NoError(t, pool.Flush(ctx))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyPool_Load_KeepsPendingUsage(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	pool := newTestKeyPool(t, mockRepo, []Key{
+		{Key: "test-key", Balance: 100, Status: KeyStatusActive},
+	})
+	ctx := context.Background()
+
+	require.NoError(t, pool.DeductKeyBalance(ctx, "test-key", 30))
+
+	// Reload sees a balance without the unflushed usage, and a new key
+	mockRepo.On("GetAllKeys", ctx).Return([]Key{
+		{Key: "test-key", Balance: 100, Status: KeyStatusActive},
+		{Key: "other-key", Balance: 50, Status: KeyStatusActive},
+	}, nil).Once()
+	require.NoError(t, pool.Load(ctx))
+
+	assert.Equal(t, int64(70), pool.keys["test-key"].Balance)
+	assert.Equal(t, int64(50), pool.keys["other-key"].Balance)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyPool_UpdateKeyStatus(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	pool := newTestKeyPool(t, mockRepo, []Key{
+		{Key: "test-key", Balance: 100, Status: KeyStatusActive},
+	})
+	ctx := context.Background()
+	until := time.Now().Add(time.Minute)
+
+	// Status is written to the repository before being applied in memory
+	mockRepo.On("UpdateKeyStatus", ctx, UpdateKeyStatusParams{Key: "test-key", Status: KeyStatusCoolingDown, CooldownUntil: &until}).Return(nil).Once()
+	mockRepo.On("GetKey", ctx, "test-key").Return(&Key{Key: "test-key", Balance: 100, Status: KeyStatusCoolingDown, CooldownUntil: &until}, nil).Once()
+	require.NoError(t, pool.CooldownKey(ctx, "test-key", until))
+	assert.Equal(t, KeyStatusCoolingDown, pool.keys["test-key"].Status)
+
+	// Repository failure leaves the key unchanged
+	mockRepo.On("UpdateKeyStatus", ctx, UpdateKeyStatusParams{Key: "test-key", Status: KeyStatusInvalid}).Return(assert.AnError).Once()
+	assert.ErrorIs(t, pool.InvalidateKey(ctx, "test-key"), assert.AnError)
+	assert.Equal(t, KeyStatusCoolingDown, pool.keys["test-key"].Status)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyPool_NextKeyAvailableAt(t *testing.T) {
+	now := time.Now()
+	expired := now.Add(-time.Second)
+	soon := now.Add(time.Minute)
+	later := now.Add(time.Hour)
+
+	mockRepo := new(MockKeyRepository)
+	pool := newTestKeyPool(t, mockRepo, []Key{
+		{Key: "active-key", Balance: 100, Status: KeyStatusActive},
+		{Key: "cooled-key", Balance: 100, Status: KeyStatusCoolingDown, CooldownUntil: &expired},
+		{Key: "soon-key", Balance: 100, Status: KeyStatusCoolingDown, CooldownUntil: &soon},
+		{Key: "later-key", Balance: 100, Status: KeyStatusCoolingDown, CooldownUntil: &later},
+	})
+
+	next, err := pool.NextKeyAvailableAt(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	assert.True(t, soon.Equal(*next))
+
+	// No key is cooling down
+	pool = newTestKeyPool(t, mockRepo, []Key{
+		{Key: "active-key", Balance: 100, Status: KeyStatusActive},
+	})
+
+	next, err = pool.NextKeyAvailableAt(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, next)
+}
+
+func TestKeyPool_InsertKey(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	pool := newTestKeyPool(t, mockRepo, nil)
+	ctx := context.Background()
+
+	mockRepo.On("InsertKey", ctx, InsertKeyParams{Key: "new-key"}).Return(nil).Once()
+	mockRepo.On("GetKey", ctx, "new-key").Return(&Key{Key: "new-key", Balance: 1000, Status: KeyStatusActive}, nil).Once()
+	require.NoError(t, pool.InsertKey(ctx, InsertKeyParams{Key: "new-key"}))
+
+	key, err := pool.UseBestKey(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "new-key", *key)
+
+	// Malformed keys are not stored
+	err = pool.InsertKey(ctx, InsertKeyParams{Key: " "})
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyPool_ImportKeys(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	pool := newTestKeyPool(t, mockRepo, nil)
+	ctx := context.Background()
+	params := []ImportKeyParams{{Key: "new-key", Balance: 1000}, {Key: "old-key", Balance: 1000}}
+	results := []ImportKeyResult{
+		{ID: 1, Key: "new-key", Status: ImportKeyStatusInserted},
+		{Key: "old-key", Status: ImportKeyStatusDuplicate},
+	}
+
+	// Inserted keys are loaded
+	mockRepo.On("ImportKeys", ctx, params).Return(results, nil).Once()
+	mockRepo.On("GetAllKeys", ctx).Return([]Key{{ID: 1, Key: "new-key", Balance: 1000, Status: KeyStatusActive}}, nil).Once()
+	imported, err := pool.ImportKeys(ctx, params)
+	require.NoError(t, err)
+	assert.Equal(t, results, imported)
+	key, err := pool.UseBestKey(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "new-key", *key)
+
+	// The import is committed, a failed reload does not fail it
+	mockRepo.On("ImportKeys", ctx, params).Return(results, nil).Once()
+	mockRepo.On("GetAllKeys", ctx).Return(nil, assert.AnError).Once()
+	imported, err = pool.ImportKeys(ctx, params)
+	assert.NoError(t, err)
+	assert.Equal(t, results, imported)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyPool_Concurrency(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	pool := newTestKeyPool(t, mockRepo, []Key{
+		{Key: "key-a", Balance: 1_000_000, Status: KeyStatusActive},
+		{Key: "key-b", Balance: 1_000_000, Status: KeyStatusActive},
+	})
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	flushed := map[string]int64{}
+	mockRepo.On("ApplyKeyUsage", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, usage := range args.Get(1).([]KeyUsage) {
+			flushed[usage.Key] += usage.Amount
+		}
+	}).Return(nil)
+
+	const workers = 20
+	const requestsPerWorker = 100
+
+	used := map[string]int64{}
+	var usedMu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < requestsPerWorker; j++ {
+				key, err := pool.UseBestKey(ctx)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.NoError(t, pool.DeductKeyBalance(ctx, *key, 3))
+
+				usedMu.Lock()
+				used[*key] += 3
+				usedMu.Unlock()
+
+				// Flush concurrently with usage
+				if j%25 == 0 {
+					assert.NoError(t, pool.Flush(ctx))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, pool.Flush(ctx))
+
+	// Every deduction is flushed exactly once
+	assert.Equal(t, used, flushed)
+
+	stats, err := pool.GetKeyStats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2_000_000-workers*requestsPerWorker*3), stats.Balance)
+}
+
+func TestKeyPool_RefreshKey(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	pool := newTestKeyPool(t, mockRepo, []Key{
+		{Key: "key-1", Balance: 100, Status: KeyStatusActive},
+		{Key: "key-2", Balance: 100, Status: KeyStatusActive},
+	})
+	ctx := context.Background()
+
+	// Changed key is reloaded
+	mockRepo.On("GetKey", ctx, "key-1").Return(&Key{Key: "key-1", Balance: 100, Status: KeyStatusDisabled}, nil).Once()
+	require.NoError(t, pool.RefreshKey(ctx, "key-1"))
+	assert.Equal(t, KeyStatusDisabled, pool.keys["key-1"].Status)
+
+	// Deleted key is removed
+	mockRepo.On("GetKey", ctx, "key-2").Return(nil, ErrKeyNotFound).Once()
+	require.NoError(t, pool.RefreshKey(ctx, "key-2"))
+	assert.NotContains(t, pool.keys, "key-2")
+
+	_, err := pool.UseBestKey(ctx)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyPool_RefreshKeyByID(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	pool := newTestKeyPool(t, mockRepo, []Key{
+		{ID: 1, Key: "key-1", Balance: 100, Status: KeyStatusActive},
+		{ID: 2, Key: "key-2", Balance: 100, Status: KeyStatusActive},
+	})
+	ctx := context.Background()
+
+	// Changed key is reloaded
+	mockRepo.On("GetKeyByID", ctx, int64(1)).Return(&Key{ID: 1, Key: "key-1", Balance: 100, Status: KeyStatusDisabled}, nil).Once()
+	require.NoError(t, pool.RefreshKeyByID(ctx, 1))
+	assert.Equal(t, KeyStatusDisabled, pool.keys["key-1"].Status)
+
+	// Deleted key is removed
+	mockRepo.On("GetKeyByID", ctx, int64(2)).Return(nil, ErrKeyNotFound).Once()
+	require.NoError(t, pool.RefreshKeyByID(ctx, 2))
+	assert.NotContains(t, pool.keys, "key-2")
+
+	_, err := pool.UseBestKey(ctx)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyPool_ManageKeys(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	pool := newTestKeyPool(t, mockRepo, []Key{
+		{ID: 1, Key: "key-1", Balance: 100, Status: KeyStatusActive},
+	})
+	ctx := context.Background()
+
+	require.NoError(t, pool.DeductKeyBalance(ctx, "key-1", 30))
+
+	// Listed keys include the usage not flushed yet
+	mockRepo.On("ListKeys", ctx, ListKeysParams{Limit: 10}).Return(&KeyList{Keys: []Key{{ID: 1, Key: "key-1", Balance: 100, Status: KeyStatusActive}}, Total: 1}, nil).Once()
+	list, err := pool.ListKeys(ctx, ListKeysParams{Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, int64(70), list.Keys[0].Balance)
+
+	// Disabled key is no longer selectable
+	disabled := KeyStatusDisabled
+	mockRepo.On("UpdateKey", ctx, UpdateKeyParams{ID: 1, Status: &disabled}).Return(&Key{ID: 1, Key: "key-1", Balance: 100, Status: KeyStatusDisabled}, nil).Once()
+	mockRepo.On("GetKey", ctx, "key-1").Return(&Key{ID: 1, Key: "key-1", Balance: 100, Status: KeyStatusDisabled}, nil).Once()
+	key, err := pool.UpdateKey(ctx, UpdateKeyParams{ID: 1, Status: &disabled})
+	require.NoError(t, err)
+	assert.Equal(t, int64(70), key.Balance)
+	_, err = pool.UseBestKey(ctx)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// Deleted key is removed
+	mockRepo.On("GetKeyByID", ctx, int64(1)).Return(&Key{ID: 1, Key: "key-1"}, nil).Once()
+	mockRepo.On("DeleteKey", ctx, int64(1)).Return(nil).Once()
+	mockRepo.On("GetKey", ctx, "key-1").Return(nil, ErrKeyNotFound).Once()
+	require.NoError(t, pool.DeleteKey(ctx, 1))
+	assert.Empty(t, pool.keys)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyPool_ReconcileKeyBalance(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	pool := newTestKeyPool(t, mockRepo, []Key{
+		{Key: "test-key", Balance: 100, Status: KeyStatusActive},
+	})
+	ctx := context.Background()
+
+	require.NoError(t, pool.DeductKeyBalance(ctx, "test-key", 30))
+
+	// Usage is flushed before the balance is replaced, so it is not deducted twice
+	flush := mockRepo.On("ApplyKeyUsage", ctx, []KeyUsage{{Key: "test-key", Amount: 30}}).Return(nil).Once()
+	mockRepo.On("ReconcileKeyBalance", ctx, "test-key", int64(50)).
+		Return(&BalanceDrift{Key: "test-key", LocalBalance: 70, UpstreamBalance: 50, Drift: -20}, nil).Once().
+		NotBefore(flush)
+	mockRepo.On("GetKey", ctx, "test-key").Return(&Key{Key: "test-key", Balance: 50, Status: KeyStatusActive}, nil).Once()
+
+	drift, err := pool.ReconcileKeyBalance(ctx, "test-key", 50)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-20), drift.Drift)
+	assert.Equal(t, int64(50), pool.keys["test-key"].Balance)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyPool_GetKeyStatusStats(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	pool := newTestKeyPool(t, mockRepo, []Key{
+		{Key: "key-1", Balance: 100, Status: KeyStatusActive},
+		{Key: "key-2", Balance: 200, Status: KeyStatusActive},
+		{Key: "key-3", Balance: 0, Status: KeyStatusExhausted},
+	})
+
+	stats, err := pool.GetKeyStatusStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []KeyStatusStats{
+		{Status: KeyStatusActive, Count: 2, Balance: 300},
+		{Status: KeyStatusExhausted, Count: 1, Balance: 0},
+	}, stats)
+}
@@ -0,0 +1,128 @@
+package key
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// BalanceStore lists keys, reconciles their balance and invalidates them, implemented by KeyService and KeyPool
+type BalanceStore interface {
+	ExportKeys(ctx context.Context) ([]Key, error)
+	ReconcileKeyBalance(ctx context.Context, key string, balance int64) (*BalanceDrift, error)
+	InvalidateKey(ctx context.Context, key string) error
+}
+
+// BalanceRefresher periodically replaces the balance of every usable or exhausted key with the balance reported by upstream
+type BalanceRefresher struct {
+	store       BalanceStore
+	validator   KeyValidator
+	interval    time.Duration
+	jitter      time.Duration
+	concurrency int
+	int64N      func(n int64) int64
+}
+
+// Run refreshes the balances every interval, plus a random jitter, until the context is done
+func (r *BalanceRefresher) Run(ctx context.Context) error {
+	for {
+		timer := time.NewTimer(r.nextDelay())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		err := r.Refresh(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("refresh key balances", slog.Any("error", err))
+		}
+	}
+}
+
+// Refresh reconciles the balance of every active, cooling down or exhausted key, querying upstream for at most
+// concurrency keys at a time. Invalid and disabled keys are skipped. A key that fails to refresh is logged and
+// does not stop the others.
+func (r *BalanceRefresher) Refresh(ctx context.Context) error {
+	keys, err := r.store.ExportKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	errGroup, ctx := errgroup.WithContext(ctx)
+	errGroup.SetLimit(r.concurrency)
+	for _, key := range keys {
+		if key.Status == KeyStatusInvalid || key.Status == KeyStatusDisabled {
+			continue
+		}
+
+		errGroup.Go(func() error {
+			err := r.refreshKey(ctx, key.Key)
+			if err != nil && ctx.Err() == nil {
+				slog.Error("refresh key balance", slog.String("key", MaskKey(key.Key)), slog.Any("error", err))
+			}
+			return nil
+		})
+	}
+
+	return errGroup.Wait()
+}
+
+// refreshKey reconciles the balance of a key when upstream reports it, and logs the drift and the status change.
+// A key rejected by upstream is invalidated, the same way as after a validation.
+func (r *BalanceRefresher) refreshKey(ctx context.Context, key string) error {
+	validation, err := r.validator.Validate(ctx, key)
+	if err != nil {
+		return err
+	}
+	if validation.Result == KeyValidationResultInvalid {
+		slog.Warn("key rejected by upstream", slog.String("key", MaskKey(key)))
+		return r.store.InvalidateKey(ctx, key)
+	}
+	if validation.Result != KeyValidationResultValid || validation.Balance == nil {
+		slog.Warn("key balance not reported", slog.String("key", MaskKey(key)), slog.String("result", string(validation.Result)))
+		return nil
+	}
+
+	drift, err := r.store.ReconcileKeyBalance(ctx, key, *validation.Balance)
+	if err != nil {
+		return err
+	}
+
+	if drift.Drift != 0 {
+		slog.Warn("key balance drift",
+			slog.String("key", MaskKey(key)),
+			slog.Int64("local_balance", drift.LocalBalance),
+			slog.Int64("upstream_balance", drift.UpstreamBalance),
+			slog.Int64("drift", drift.Drift),
+		)
+	}
+	if drift.Status != nil {
+		slog.Info("key status changed by balance refresh", slog.String("key", MaskKey(key)), slog.String("status", string(*drift.Status)))
+	}
+
+	return nil
+}
+
+// nextDelay returns the interval plus a random jitter
+func (r *BalanceRefresher) nextDelay() time.Duration {
+	if r.jitter <= 0 {
+		return r.interval
+	}
+	return r.interval + time.Duration(r.int64N(int64(r.jitter)))
+}
+
+func NewBalanceRefresher(store BalanceStore, validator KeyValidator, interval time.Duration, jitter time.Duration, concurrency int) *BalanceRefresher {
+	return &BalanceRefresher{
+		store:       store,
+		validator:   validator,
+		interval:    interval,
+		jitter:      jitter,
+		concurrency: concurrency,
+		int64N:      rand.Int64N,
+	}
+}
@@ -0,0 +1,149 @@
+package key
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// countingKeyValidator reports the same validation for every key and tracks concurrent calls
+type countingKeyValidator struct {
+	validation    KeyValidation
+	active        atomic.Int32
+	maxActive     atomic.Int32
+	mu            sync.Mutex
+	validatedKeys []string
+}
+
+func (v *countingKeyValidator) Validate(ctx context.Context, key string) (*KeyValidation, error) {
+	active := v.active.Add(1)
+	defer v.active.Add(-1)
+	for {
+		current := v.maxActive.Load()
+		if active <= current || v.maxActive.CompareAndSwap(current, active) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	v.mu.Lock()
+	v.validatedKeys = append(v.validatedKeys, key)
+	v.mu.Unlock()
+
+	validation := v.validation
+	return &validation, nil
+}
+
+func TestBalanceRefresher_Refresh(t *testing.T) {
+	server := newFakeJinaServer(t)
+	validator := NewUpstreamKeyValidator(server.URL+"?api_key={key}", http.DefaultClient)
+	ctx := context.Background()
+
+	mockRepo := new(MockKeyRepository)
+	mockRepo.On("GetAllKeys", mock.Anything).Return([]Key{
+		{Key: "valid-key", Balance: 10000, Status: KeyStatusActive},
+		{Key: "broken-key", Balance: 10000, Status: KeyStatusActive},
+		{Key: "revoked-key", Balance: 10000, Status: KeyStatusActive},
+		{Key: "disabled-key", Balance: 10000, Status: KeyStatusDisabled},
+		{Key: "invalid-key", Balance: 10000, Status: KeyStatusInvalid},
+	}, nil)
+	mockRepo.On("ReconcileKeyBalance", mock.Anything, "valid-key", int64(12345)).
+		Return(&BalanceDrift{Key: "valid-key", LocalBalance: 10000, UpstreamBalance: 12345, Drift: 2345}, nil)
+	mockRepo.On("UpdateKeyStatus", mock.Anything, UpdateKeyStatusParams{Key: "revoked-key", Status: KeyStatusInvalid}).Return(nil).Once()
+
+	refresher := NewBalanceRefresher(NewKeyService(mockRepo, validator, false), validator, time.Hour, 0, 2)
+	err := refresher.Refresh(ctx)
+	assert.NoError(t, err)
+
+	// Only the key with a reported balance is reconciled, the rejected key is invalidated,
+	// and invalid and disabled keys are not queried
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNumberOfCalls(t, "ReconcileKeyBalance", 1)
+}
+
+func TestBalanceRefresher_Refresh_ReactivatesExhaustedKey(t *testing.T) {
+	balance := int64(100)
+	validator := &countingKeyValidator{validation: KeyValidation{Result: KeyValidationResultValid, Balance: &balance}}
+
+	active := KeyStatusActive
+	mockRepo := new(MockKeyRepository)
+	mockRepo.On("GetAllKeys", mock.Anything).Return([]Key{{Key: "key-1", Status: KeyStatusExhausted}}, nil)
+	mockRepo.On("ReconcileKeyBalance", mock.Anything, "key-1", int64(100)).
+		Return(&BalanceDrift{Key: "key-1", UpstreamBalance: 100, Drift: 100, Status: &active}, nil).Once()
+
+	refresher := NewBalanceRefresher(NewKeyService(mockRepo, validator, false), validator, time.Hour, 0, 1)
+	require.NoError(t, refresher.Refresh(context.Background()))
+
+	assert.Equal(t, []string{"key-1"}, validator.validatedKeys)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestBalanceRefresher_Refresh_Concurrency(t *testing.T) {
+	balance := int64(100)
+	validator := &countingKeyValidator{validation: KeyValidation{Result: KeyValidationResultValid, Balance: &balance}}
+
+	var keys []Key
+	for _, name := range []string{"key-1", "key-2", "key-3", "key-4", "key-5", "key-6"} {
+		keys = append(keys, Key{Key: name, Balance: 100, Status: KeyStatusActive})
+	}
+	mockRepo := new(MockKeyRepository)
+	mockRepo.On("GetAllKeys", mock.Anything).Return(keys, nil)
+	mockRepo.On("ReconcileKeyBalance", mock.Anything, mock.Anything, int64(100)).Return(&BalanceDrift{}, nil)
+
+	refresher := NewBalanceRefresher(NewKeyService(mockRepo, validator, false), validator, time.Hour, 0, 2)
+	require.NoError(t, refresher.Refresh(context.Background()))
+
+	assert.Len(t, validator.validatedKeys, 6)
+	assert.Equal(t, int32(2), validator.maxActive.Load())
+}
+
+func TestBalanceRefresher_Run(t *testing.T) {
+	balance := int64(100)
+	validator := &countingKeyValidator{validation: KeyValidation{Result: KeyValidationResultValid, Balance: &balance}}
+
+	mockRepo := new(MockKeyRepository)
+	mockRepo.On("GetAllKeys", mock.Anything).Return([]Key{{Key: "key-1", Status: KeyStatusActive}}, nil)
+	mockRepo.On("ReconcileKeyBalance", mock.Anything, "key-1", int64(100)).Return(&BalanceDrift{}, nil)
+
+	refresher := NewBalanceRefresher(NewKeyService(mockRepo, validator, false), validator, 10*time.Millisecond, 10*time.Millisecond, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- refresher.Run(ctx)
+	}()
+
+	// Refreshes repeatedly, then stops cleanly on shutdown
+	require.Eventually(t, func() bool {
+		validator.mu.Lock()
+		defer validator.mu.Unlock()
+		return len(validator.validatedKeys) >= 2
+	}, time.Second, 5*time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("refresher did not stop")
+	}
+}
+
+func TestBalanceRefresher_NextDelay(t *testing.T) {
+	refresher := NewBalanceRefresher(nil, nil, time.Minute, 10*time.Second, 1)
+	refresher.int64N = func(n int64) int64 {
+		assert.Equal(t, int64(10*time.Second), n)
+		return int64(3 * time.Second)
+	}
+	assert.Equal(t, time.Minute+3*time.Second, refresher.nextDelay())
+
+	refresher = NewBalanceRefresher(nil, nil, time.Minute, 0, 1)
+	assert.Equal(t, time.Minute, refresher.nextDelay())
+}
@@ -3,26 +3,108 @@ package key
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type KeyDBRepository struct {
-	db *sql.DB
+	db       *sql.DB
+	strategy StrategyGetter
+	metrics  *Metrics
+	// cursor is the last key selected with the round robin strategy
+	cursor roundRobinCursor
 }
 
 // Check if KeyDBRepository implements KeyRepository
 var _ KeyRepository = &KeyDBRepository{}
 
 // InsertKey inserts a new key into the database
-// Skip if the key already exists
+// Skip if the key already exists, otherwise notify the key change
 func (r *KeyDBRepository) InsertKey(ctx context.Context, params InsertKeyParams) error {
-	_, err := r.db.ExecContext(ctx, "INSERT INTO keys (key, balance) VALUES ($1, $2) ON CONFLICT DO NOTHING", params.Key, 1000000)
+	balance := int64(DefaultKeyBalance)
+	if params.Balance != nil {
+		balance = *params.Balance
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		WITH inserted AS (
+			INSERT INTO keys (key, balance) VALUES ($1, $2) ON CONFLICT DO NOTHING RETURNING id
+		)
+		SELECT pg_notify($3, id::text) FROM inserted`, params.Key, balance, KeyChangesChannel)
 	return err
 }
 
-// UseBestKey returns the best key from the database.
-// Best key is the key with latest created_at, then most old used_at, then most balance.
-// Use SELECT FOR UPDATE SKIP LOCKED to lock the key, update used_at and return the key.
-func (r *KeyDBRepository) UseBestKey(ctx context.Context) (*string, error) {
+// ImportKeys inserts the keys in a single transaction and reports for each key whether it was inserted or already existed.
+// Inserted keys are notified as changed.
+func (r *KeyDBRepository) ImportKeys(ctx context.Context, params []ImportKeyParams) ([]ImportKeyResult, error) {
+	// Create a transaction
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if tx != nil {
+			_ = tx.Rollback() // Intentionally ignore error from rollback as it's called from defer
+		}
+	}()
+
+	results := make([]ImportKeyResult, 0, len(params))
+	for _, param := range params {
+		result := ImportKeyResult{Key: param.Key, Status: ImportKeyStatusInserted}
+		err = tx.QueryRowContext(ctx, "INSERT INTO keys (key, balance, label) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING RETURNING id",
+			param.Key, param.Balance, param.Label).Scan(&result.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			result.Status = ImportKeyStatusDuplicate
+		} else if err != nil {
+			return nil, err
+		} else {
+			err = notifyKeyChanged(ctx, tx, result.ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		results = append(results, result)
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// selectableKeyCondition matches active keys and cooling down keys whose cooldown has expired
+const selectableKeyCondition = "(status = 'active' OR (status = 'cooling_down' AND cooldown_until <= now()))"
+
+// UseBestKey returns the best selectable key from the database, skipping the excluded keys.
+// Best key is the first key in the SQL order of the current strategy, round robin starts after
+// the last key selected by this repository.
+// Use SELECT FOR UPDATE SKIP LOCKED to lock the key, so concurrent callers get different keys,
+// then update used_at, which also ends an expired cooldown.
+func (r *KeyDBRepository) UseBestKey(ctx context.Context, exclude ...string) (*string, error) {
+	// A nil slice is sent as NULL, which would exclude every key
+	if exclude == nil {
+		exclude = []string{}
+	}
+
+	strategy := r.strategy.Strategy()
+	order, ok := strategyOrders[strategy]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStrategy, strategy)
+	}
+
+	// Keys up to the last selected key go after the others, false sorts first
+	args := []any{exclude}
+	if last := r.cursor.Last(); strategy == StrategyRoundRobin && last != nil {
+		order = `(created_at, key COLLATE "C") <= ($2, $3), ` + order
+		args = append(args, last.CreatedAt, last.Key)
+	}
+
 	// Create a transaction
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -35,14 +117,397 @@ func (r *KeyDBRepository) UseBestKey(ctx context.Context) (*string, error) {
 	}()
 
 	// Select the best key and lock it
-	var key string
-	err = tx.QueryRowContext(ctx, "SELECT key FROM keys ORDER BY created_at DESC, used_at ASC, balance DESC LIMIT 1 FOR UPDATE SKIP LOCKED").Scan(&key)
+	var key Key
+	err = tx.QueryRowContext(ctx, "SELECT id, key, status, created_at FROM keys WHERE "+selectableKeyCondition+" AND key <> ALL($1) ORDER BY "+order+" LIMIT 1 FOR UPDATE SKIP LOCKED", args...).
+		Scan(&key.ID, &key.Key, &key.Status, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Update used_at and end the expired cooldown
+	_, err = tx.ExecContext(ctx, "UPDATE keys SET used_at = now(), status = 'active', cooldown_until = NULL WHERE id = $1", key.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	if strategy == StrategyRoundRobin {
+		r.cursor.Move(key)
+	}
+	r.metrics.observeSelection(key.ID)
+	if key.Status == KeyStatusCoolingDown {
+		r.metrics.observeStatusChange(KeyStatusActive)
+	}
+	return &key.Key, nil
+}
+
+// listKeys returns the keys matching the given query suffix
+func (r *KeyDBRepository) listKeys(ctx context.Context, suffix string, args ...any) ([]Key, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, key, balance, status, used_at, created_at, cooldown_until, label FROM keys "+suffix, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []Key
+	for rows.Next() {
+		var key Key
+		err = rows.Scan(&key.ID, &key.Key, &key.Balance, &key.Status, &key.UsedAt, &key.CreatedAt, &key.CooldownUntil, &key.Label)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// DeductKeyBalance subtracts the used tokens from the key balance.
+// The subtraction is done in a single statement so concurrent deductions are never lost.
+// Balance never goes below zero, a key that reaches zero is marked as exhausted and the key change is notified.
+// The key is locked to read its previous status, so a key already exhausted is not notified nor counted again.
+func (r *KeyDBRepository) DeductKeyBalance(ctx context.Context, params DeductKeyBalanceParams) error {
+	var exhausted int
+	err := r.db.QueryRowContext(ctx, `
+		WITH previous AS (
+			SELECT id, status FROM keys WHERE key = $1 FOR UPDATE
+		), updated AS (
+			UPDATE keys SET
+				balance = GREATEST(keys.balance - $2, 0),
+				status = CASE WHEN keys.balance - $2 <= 0 AND keys.status IN ('active', 'cooling_down') THEN 'exhausted' ELSE keys.status END
+			FROM previous
+			WHERE keys.id = previous.id
+			RETURNING keys.id, keys.status, previous.status AS previous_status
+		), exhausted AS (
+			SELECT pg_notify($3, id::text) FROM updated WHERE status = 'exhausted' AND previous_status <> 'exhausted'
+		)
+		SELECT count(*) FROM exhausted`, params.Key, params.Amount, KeyChangesChannel).Scan(&exhausted)
+	if err != nil {
+		return err
+	}
+
+	if exhausted > 0 {
+		r.metrics.observeStatusChange(KeyStatusExhausted)
+	}
+	return nil
+}
+
+// UpdateKeyStatus moves the key to a new status.
+// Lock the key row so the transition is checked against the current status.
+// A cooling down key keeps the latest of its current and new cooldown end.
+func (r *KeyDBRepository) UpdateKeyStatus(ctx context.Context, params UpdateKeyStatusParams) error {
+	if !params.Status.IsValid() {
+		return fmt.Errorf("%w: %s", ErrInvalidKeyStatus, params.Status)
+	}
+	if params.Status == KeyStatusCoolingDown && params.CooldownUntil == nil {
+		return fmt.Errorf("%w: cooldown end is required", ErrInvalidKeyStatus)
+	}
+
+	// Create a transaction
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tx != nil {
+			_ = tx.Rollback() // Intentionally ignore error from rollback as it's called from defer
+		}
+	}()
+
+	// Select the current status and lock the key
+	var id int64
+	var status KeyStatus
+	err = tx.QueryRowContext(ctx, "SELECT id, status FROM keys WHERE key = $1 FOR UPDATE", params.Key).Scan(&id, &status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if !status.CanTransitionTo(params.Status) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidStatusTransition, status, params.Status)
+	}
+
+	// Update status
+	if params.Status == KeyStatusCoolingDown {
+		_, err = tx.ExecContext(ctx, "UPDATE keys SET status = $2, cooldown_until = GREATEST(cooldown_until, $3) WHERE id = $1", id, params.Status, *params.CooldownUntil)
+	} else {
+		_, err = tx.ExecContext(ctx, "UPDATE keys SET status = $2, cooldown_until = NULL WHERE id = $1", id, params.Status)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Notify the key change, delivered on commit
+	err = notifyKeyChanged(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	if status != params.Status {
+		r.metrics.observeStatusChange(params.Status)
+	}
+	return nil
+}
+
+// GetAllKeys returns every key in the database
+func (r *KeyDBRepository) GetAllKeys(ctx context.Context) ([]Key, error) {
+	return r.listKeys(ctx, "ORDER BY created_at, key")
+}
+
+// GetKey returns the key, or ErrKeyNotFound if it does not exist
+func (r *KeyDBRepository) GetKey(ctx context.Context, key string) (*Key, error) {
+	keys, err := r.listKeys(ctx, "WHERE key = $1", key)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, ErrKeyNotFound
+	}
+
+	return &keys[0], nil
+}
+
+// GetExistingKeys returns the given keys that are already stored
+func (r *KeyDBRepository) GetExistingKeys(ctx context.Context, keys []string) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT key FROM keys WHERE key = ANY($1)", keys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make([]string, 0, len(keys))
+	for rows.Next() {
+		var key string
+		err = rows.Scan(&key)
+		if err != nil {
+			return nil, err
+		}
+		existing = append(existing, key)
+	}
+
+	return existing, rows.Err()
+}
+
+// keySortColumns maps the sort fields to their column
+var keySortColumns = map[string]string{
+	KeySortID:        "id",
+	KeySortCreatedAt: "created_at",
+	KeySortUsedAt:    "used_at",
+	KeySortBalance:   "balance",
+	KeySortStatus:    "status",
+}
+
+// ListKeys returns a page of keys filtered by status and sorted by the given field
+func (r *KeyDBRepository) ListKeys(ctx context.Context, params ListKeysParams) (*KeyList, error) {
+	if params.Status != "" && !params.Status.IsValid() {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidKeyStatus, params.Status)
+	}
+
+	// Build the order from the whitelisted columns, ties are broken by id
+	sort, direction := params.Sort, "ASC"
+	if strings.HasPrefix(sort, "-") {
+		sort, direction = sort[1:], "DESC"
+	}
+	if sort == "" {
+		sort = KeySortID
+	}
+	column, ok := keySortColumns[sort]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidKeySort, params.Sort)
+	}
+	order := fmt.Sprintf("ORDER BY %s %s NULLS LAST, id %s", column, direction, direction)
+
+	const filter = "WHERE ($1 = '' OR status = $1)"
+
+	var list KeyList
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM keys "+filter, params.Status).Scan(&list.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	list.Keys, err = r.listKeys(ctx, filter+" "+order+" LIMIT $2 OFFSET $3", params.Status, params.Limit, params.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+// GetKeyByID returns the key with the given id, or ErrKeyNotFound if it does not exist
+func (r *KeyDBRepository) GetKeyByID(ctx context.Context, id int64) (*Key, error) {
+	keys, err := r.listKeys(ctx, "WHERE id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, ErrKeyNotFound
+	}
+
+	return &keys[0], nil
+}
+
+// UpdateKey updates the balance and status of a key and returns the updated key.
+// The status change is checked against the current status, a key cannot be put in cooldown this way.
+func (r *KeyDBRepository) UpdateKey(ctx context.Context, params UpdateKeyParams) (*Key, error) {
+	if params.Balance != nil && *params.Balance < 0 {
+		return nil, fmt.Errorf("%w: must not be negative", ErrInvalidBalance)
+	}
+	if params.Status != nil && (!params.Status.IsValid() || *params.Status == KeyStatusCoolingDown) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidKeyStatus, *params.Status)
+	}
+
+	// Create a transaction
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if tx != nil {
+			_ = tx.Rollback() // Intentionally ignore error from rollback as it's called from defer
+		}
+	}()
+
+	// Select the current status and lock the key
+	var status KeyStatus
+	err = tx.QueryRowContext(ctx, "SELECT status FROM keys WHERE id = $1 FOR UPDATE", params.ID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Status != nil && !status.CanTransitionTo(*params.Status) {
+		return nil, fmt.Errorf("%w: %s to %s", ErrInvalidStatusTransition, status, *params.Status)
+	}
+
+	// Update the given fields, a new status ends the cooldown
+	_, err = tx.ExecContext(ctx, `
+		UPDATE keys SET
+			balance = COALESCE($2, balance),
+			status = COALESCE($3::varchar, status),
+			cooldown_until = CASE WHEN $3::varchar IS NULL THEN cooldown_until ELSE NULL END
+		WHERE id = $1`, params.ID, params.Balance, params.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	// Notify the key change, delivered on commit
+	err = notifyKeyChanged(ctx, tx, params.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Status != nil && *params.Status != status {
+		r.metrics.observeStatusChange(*params.Status)
+	}
+	return r.GetKeyByID(ctx, params.ID)
+}
+
+// DeleteKey deletes the key with the given id
+func (r *KeyDBRepository) DeleteKey(ctx context.Context, id int64) error {
+	// Create a transaction
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tx != nil {
+			_ = tx.Rollback() // Intentionally ignore error from rollback as it's called from defer
+		}
+	}()
+
+	err = tx.QueryRowContext(ctx, "DELETE FROM keys WHERE id = $1 RETURNING id", id).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// Notify the key change, delivered on commit
+	err = notifyKeyChanged(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	return tx.Commit()
+}
+
+// ReconcileKeyBalance replaces the balance of a key with the balance reported by upstream.
+// A non-zero drift is recorded, and the status changes the same way as after a validation reporting the balance:
+// a key without balance left is exhausted, and an exhausted or invalid key with balance is active again.
+func (r *KeyDBRepository) ReconcileKeyBalance(ctx context.Context, key string, balance int64) (*BalanceDrift, error) {
+	// Create a transaction
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if tx != nil {
+			_ = tx.Rollback() // Intentionally ignore error from rollback as it's called from defer
+		}
+	}()
+
+	// Select the current balance and status and lock the key
+	var id int64
+	var status KeyStatus
+	drift := BalanceDrift{Key: key, UpstreamBalance: balance}
+	err = tx.QueryRowContext(ctx, "SELECT id, balance, status FROM keys WHERE key = $1 FOR UPDATE", key).Scan(&id, &drift.LocalBalance, &status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	drift.Drift = drift.UpstreamBalance - drift.LocalBalance
+	drift.Status = validatedStatus(status, &KeyValidation{Result: KeyValidationResultValid, Balance: &balance})
+	if drift.Drift == 0 && drift.Status == nil {
+		return &drift, nil
+	}
+
+	// A new status ends the cooldown, the same way as UpdateKey
+	_, err = tx.ExecContext(ctx, `
+		UPDATE keys SET
+			balance = $2,
+			status = COALESCE($3::varchar, status),
+			cooldown_until = CASE WHEN $3::varchar IS NULL THEN cooldown_until ELSE NULL END
+		WHERE id = $1`, id, balance, drift.Status)
 	if err != nil {
 		return nil, err
 	}
 
-	// Update used_at
-	_, err = tx.ExecContext(ctx, "UPDATE keys SET used_at = now() WHERE key = $1", key)
+	if drift.Drift != 0 {
+		_, err = tx.ExecContext(ctx, "INSERT INTO key_balance_drifts (key_id, local_balance, upstream_balance, drift) VALUES ($1, $2, $3, $4)",
+			id, drift.LocalBalance, drift.UpstreamBalance, drift.Drift)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Notify the key change, delivered on commit
+	err = notifyKeyChanged(ctx, tx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -53,7 +518,75 @@ func (r *KeyDBRepository) UseBestKey(ctx context.Context) (*string, error) {
 		return nil, err
 	}
 
-	return &key, nil
+	if drift.Status != nil {
+		r.metrics.observeStatusChange(*drift.Status)
+	}
+	return &drift, nil
+}
+
+// ApplyKeyUsage applies a batch of usage accumulated in memory in a single transaction.
+// Usages are applied in the given order, callers sort them by key so concurrent batches lock the keys in the same order.
+// Balance is deducted the same way as DeductKeyBalance, used_at only moves forward,
+// and an expired cooldown ends when the usage asks for it, the same way as UseBestKey.
+// Keys exhausted by the usage are notified as changed, keys already exhausted are not notified nor counted again.
+func (r *KeyDBRepository) ApplyKeyUsage(ctx context.Context, usages []KeyUsage) error {
+	// Create a transaction
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tx != nil {
+			_ = tx.Rollback() // Intentionally ignore error from rollback as it's called from defer
+		}
+	}()
+
+	var exhausted, cooldownsEnded int
+	for _, usage := range usages {
+		var usageExhausted, usageCooldownsEnded int
+		err = tx.QueryRowContext(ctx, `
+			WITH previous AS (
+				SELECT id, status FROM keys WHERE key = $1 FOR UPDATE
+			), updated AS (
+				UPDATE keys SET
+					balance = GREATEST(keys.balance - $2, 0),
+					status = CASE
+						WHEN $2 > 0 AND keys.balance - $2 <= 0 AND keys.status IN ('active', 'cooling_down') THEN 'exhausted'
+						WHEN $5 AND keys.status = 'cooling_down' AND keys.cooldown_until <= now() THEN 'active'
+						ELSE keys.status
+					END,
+					cooldown_until = CASE WHEN $5 AND keys.status = 'cooling_down' AND keys.cooldown_until <= now() THEN NULL ELSE keys.cooldown_until END,
+					used_at = GREATEST(keys.used_at, $3)
+				FROM previous
+				WHERE keys.id = previous.id
+				RETURNING keys.id, keys.status, previous.status AS previous_status
+			), exhausted AS (
+				SELECT pg_notify($4, id::text) FROM updated WHERE status = 'exhausted' AND previous_status <> 'exhausted'
+			)
+			SELECT
+				(SELECT count(*) FROM exhausted),
+				(SELECT count(*) FROM updated WHERE status = 'active' AND previous_status = 'cooling_down')`,
+			usage.Key, usage.Amount, usage.UsedAt, KeyChangesChannel, usage.EndCooldown).Scan(&usageExhausted, &usageCooldownsEnded)
+		if err != nil {
+			return err
+		}
+		exhausted += usageExhausted
+		cooldownsEnded += usageCooldownsEnded
+	}
+
+	// Commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	for range exhausted {
+		r.metrics.observeStatusChange(KeyStatusExhausted)
+	}
+	for range cooldownsEnded {
+		r.metrics.observeStatusChange(KeyStatusActive)
+	}
+	return nil
 }
 
 // GetKeyStats returns the stats of the keys
@@ -67,6 +600,62 @@ func (r *KeyDBRepository) GetKeyStats(ctx context.Context) (*KeyStats, error) {
 	return &stats, nil
 }
 
-func NewKeyDBRepository(db *sql.DB) KeyRepository {
-	return &KeyDBRepository{db: db}
+// GetKeyStatusStats returns the stats of the keys by status, statuses without key are omitted
+func (r *KeyDBRepository) GetKeyStatusStats(ctx context.Context) ([]KeyStatusStats, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT status, COUNT(*), COALESCE(SUM(balance), 0) FROM keys GROUP BY status ORDER BY status")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []KeyStatusStats
+	for rows.Next() {
+		var status KeyStatusStats
+		err = rows.Scan(&status.Status, &status.Count, &status.Balance)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, status)
+	}
+
+	return stats, rows.Err()
+}
+
+// NextCooldownEnd returns the earliest end of the cooldowns not expired yet, nil when no key is cooling down
+func (r *KeyDBRepository) NextCooldownEnd(ctx context.Context) (*time.Time, error) {
+	var cooldownEnd sql.NullTime
+	err := r.db.QueryRowContext(ctx, "SELECT MIN(cooldown_until) FROM keys WHERE status = 'cooling_down' AND cooldown_until > now()").Scan(&cooldownEnd)
+	if err != nil {
+		return nil, err
+	}
+	if !cooldownEnd.Valid {
+		return nil, nil
+	}
+
+	return &cooldownEnd.Time, nil
+}
+
+// notifyKeyChanged publishes the id of the key on the key changes channel
+func notifyKeyChanged(ctx context.Context, tx *sql.Tx, id int64) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", KeyChangesChannel, strconv.FormatInt(id, 10))
+	return err
+}
+
+// KeyDBRepositoryOption configures an optional behavior of the repository
+type KeyDBRepositoryOption func(*KeyDBRepository)
+
+// WithMetrics counts the keys selected and the status changes made by the repository
+func WithMetrics(metrics *Metrics) KeyDBRepositoryOption {
+	return func(r *KeyDBRepository) {
+		r.metrics = metrics
+	}
+}
+
+// NewKeyDBRepository creates the repository selecting keys with the current strategy
+func NewKeyDBRepository(db *sql.DB, strategy StrategyGetter, options ...KeyDBRepositoryOption) KeyRepository {
+	r := &KeyDBRepository{db: db, strategy: strategy}
+	for _, option := range options {
+		option(r)
+	}
+	return r
 }
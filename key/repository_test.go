@@ -3,91 +3,30 @@ package key
 import (
 	"context"
 	"database/sql"
-	"fmt"
-	"log"
-	"path/filepath"
-	"runtime"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
-	_ "github.com/jackc/pgx/v5/stdlib" // Import pgx driver
-	"github.com/pressly/goose/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"github.com/testcontainers/testcontainers-go"
-	"github.com/testcontainers/testcontainers-go/modules/postgres"
-	"github.com/testcontainers/testcontainers-go/wait"
+	"github.com/trancong12102/jina-http-proxy/internal/testdb"
 )
 
-func setupPostgres(t *testing.T) (*sql.DB, func()) {
-	t.Helper()
+// fixedStrategy is a strategy that never changes
+type fixedStrategy string
 
-	ctx := context.Background()
-
-	// Create a PostgreSQL container using the Run function
-	postgresContainer, err := postgres.Run(ctx,
-		"postgres:17",
-		postgres.WithDatabase("testdb"),
-		postgres.WithUsername("postgres"),
-		postgres.WithPassword("postgres"),
-		testcontainers.WithWaitStrategy(
-			wait.ForLog("database system is ready to accept connections").
-				WithOccurrence(2).WithStartupTimeout(5*time.Second)),
-	)
-	require.NoError(t, err)
-
-	// Get the connection details
-	host, err := postgresContainer.Host(ctx)
-	require.NoError(t, err)
-
-	port, err := postgresContainer.MappedPort(ctx, "5432")
-	require.NoError(t, err)
-
-	// Construct connection string manually
-	connStr := fmt.Sprintf("host=%s port=%s user=postgres password=postgres dbname=testdb sslmode=disable", host, port.Port())
-
-	// Connect to the database
-	db, err := sql.Open("pgx", connStr)
-	require.NoError(t, err)
-
-	// Test connection with retry
-	var pingErr error
-	for i := 0; i < 5; i++ {
-		pingErr = db.Ping()
-		if pingErr == nil {
-			break
-		}
-		time.Sleep(time.Second)
-	}
-	require.NoError(t, pingErr, "Failed to connect to database after retries")
-
-	// Run migrations
-	_, currentFile, _, _ := runtime.Caller(0)
-	migrationsDir := filepath.Join(filepath.Dir(filepath.Dir(currentFile)), "migrations")
-	err = goose.SetDialect("postgres")
-	require.NoError(t, err)
-
-	err = goose.Up(db, migrationsDir)
-	require.NoError(t, err)
-
-	// Return cleanup function
-	cleanup := func() {
-		if err := db.Close(); err != nil {
-			log.Printf("Failed to close database connection: %v", err)
-		}
-		if err := postgresContainer.Terminate(ctx); err != nil {
-			log.Printf("Failed to terminate container: %v", err)
-		}
-	}
-
-	return db, cleanup
+func (s fixedStrategy) Strategy() string {
+	return string(s)
 }
 
 func TestKeyDBRepository_InsertKey(t *testing.T) {
-	db, cleanup := setupPostgres(t)
+	db, cleanup := testdb.SetupPostgres(t)
 	defer cleanup()
 
-	repo := NewKeyDBRepository(db)
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
 	ctx := context.Background()
 
 	testCases := []struct {
@@ -139,10 +78,10 @@ func TestKeyDBRepository_InsertKey(t *testing.T) {
 }
 
 func TestKeyDBRepository_UseBestKey(t *testing.T) {
-	db, cleanup := setupPostgres(t)
+	db, cleanup := testdb.SetupPostgres(t)
 	defer cleanup()
 
-	repo := NewKeyDBRepository(db)
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
 	ctx := context.Background()
 
 	// Base time for consistent test cases
@@ -268,10 +207,10 @@ func TestKeyDBRepository_UseBestKey(t *testing.T) {
 }
 
 func TestKeyDBRepository_GetKeyStats(t *testing.T) {
-	db, cleanup := setupPostgres(t)
+	db, cleanup := testdb.SetupPostgres(t)
 	defer cleanup()
 
-	repo := NewKeyDBRepository(db)
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
 	ctx := context.Background()
 
 	// Create a specialized wrapper for empty tables
@@ -377,3 +316,875 @@ func TestKeyDBRepository_GetKeyStats(t *testing.T) {
 		})
 	}
 }
+
+func TestKeyDBRepository_GetKeyStatusStats(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
+	ctx := context.Background()
+
+	stats, err := repo.GetKeyStatusStats(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, stats)
+
+	_, err = db.ExecContext(ctx, "INSERT INTO keys (key, balance, status) VALUES ('key-1', 1000, 'active'), ('key-2', 2000, 'active'), ('key-3', 0, 'exhausted')")
+	require.NoError(t, err)
+
+	stats, err = repo.GetKeyStatusStats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []KeyStatusStats{
+		{Status: KeyStatusActive, Count: 2, Balance: 3000},
+		{Status: KeyStatusExhausted, Count: 1, Balance: 0},
+	}, stats)
+}
+
+func TestKeyDBRepository_DeductKeyBalance(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
+	ctx := context.Background()
+
+	testCases := []struct {
+		name            string
+		initialBalance  int
+		amount          int64
+		expectedBalance int
+	}{
+		{
+			name:            "Deduct used tokens",
+			initialBalance:  1000,
+			amount:          250,
+			expectedBalance: 750,
+		},
+		{
+			name:            "Deduct more than balance",
+			initialBalance:  100,
+			amount:          250,
+			expectedBalance: 0, // Balance never goes below zero
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := db.ExecContext(ctx, "DELETE FROM keys")
+			require.NoError(t, err)
+			_, err = db.ExecContext(ctx, "INSERT INTO keys (key, balance) VALUES ($1, $2)", "test-key", tc.initialBalance)
+			require.NoError(t, err)
+
+			err = repo.DeductKeyBalance(ctx, DeductKeyBalanceParams{Key: "test-key", Amount: tc.amount})
+			assert.NoError(t, err)
+
+			var balance int
+			err = db.QueryRowContext(ctx, "SELECT balance FROM keys WHERE key = $1", "test-key").Scan(&balance)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedBalance, balance)
+		})
+	}
+
+	t.Run("Concurrent deductions", func(t *testing.T) {
+		_, err := db.ExecContext(ctx, "DELETE FROM keys")
+		require.NoError(t, err)
+		_, err = db.ExecContext(ctx, "INSERT INTO keys (key, balance) VALUES ($1, $2)", "test-key", 1000)
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, repo.DeductKeyBalance(ctx, DeductKeyBalanceParams{Key: "test-key", Amount: 10}))
+			}()
+		}
+		wg.Wait()
+
+		var balance int
+		err = db.QueryRowContext(ctx, "SELECT balance FROM keys WHERE key = $1", "test-key").Scan(&balance)
+		assert.NoError(t, err)
+		assert.Equal(t, 900, balance)
+	})
+}
+
+func TestKeyDBRepository_UpdateKeyStatus(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
+	ctx := context.Background()
+
+	testCases := []struct {
+		name           string
+		initialStatus  KeyStatus
+		params         UpdateKeyStatusParams
+		expectedErr    error
+		expectedStatus KeyStatus
+	}{
+		{
+			name:           "Active to invalid",
+			initialStatus:  KeyStatusActive,
+			params:         UpdateKeyStatusParams{Key: "test-key", Status: KeyStatusInvalid},
+			expectedStatus: KeyStatusInvalid,
+		},
+		{
+			name:           "Disabled to exhausted is rejected",
+			initialStatus:  KeyStatusDisabled,
+			params:         UpdateKeyStatusParams{Key: "test-key", Status: KeyStatusExhausted},
+			expectedErr:    ErrInvalidStatusTransition,
+			expectedStatus: KeyStatusDisabled,
+		},
+		{
+			name:           "Unknown status",
+			initialStatus:  KeyStatusActive,
+			params:         UpdateKeyStatusParams{Key: "test-key", Status: KeyStatus("unknown")},
+			expectedErr:    ErrInvalidKeyStatus,
+			expectedStatus: KeyStatusActive,
+		},
+		{
+			name:           "Missing key",
+			initialStatus:  KeyStatusActive,
+			params:         UpdateKeyStatusParams{Key: "missing-key", Status: KeyStatusDisabled},
+			expectedErr:    ErrKeyNotFound,
+			expectedStatus: KeyStatusActive,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := db.ExecContext(ctx, "DELETE FROM keys")
+			require.NoError(t, err)
+			_, err = db.ExecContext(ctx, "INSERT INTO keys (key, balance, status) VALUES ($1, $2, $3)", "test-key", 1000, tc.initialStatus)
+			require.NoError(t, err)
+
+			err = repo.UpdateKeyStatus(ctx, tc.params)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			var status KeyStatus
+			err = db.QueryRowContext(ctx, "SELECT status FROM keys WHERE key = $1", "test-key").Scan(&status)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, status)
+		})
+	}
+}
+
+func TestKeyDBRepository_UseBestKey_OnlyActive(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
+	ctx := context.Background()
+	now := time.Now()
+
+	// Newer keys would be preferred but are not active
+	for i, status := range []KeyStatus{KeyStatusExhausted, KeyStatusInvalid, KeyStatusCoolingDown, KeyStatusDisabled} {
+		_, err := db.ExecContext(ctx, "INSERT INTO keys (key, balance, created_at, status) VALUES ($1, $2, $3, $4)",
+			string(status)+"-key", 1000, now.Add(time.Duration(i)*time.Minute), status)
+		require.NoError(t, err)
+	}
+	_, err := db.ExecContext(ctx, "INSERT INTO keys (key, balance, created_at) VALUES ($1, $2, $3)",
+		"active-key", 1000, now.Add(-time.Hour))
+	require.NoError(t, err)
+
+	key, err := repo.UseBestKey(ctx)
+	assert.NoError(t, err)
+	assert.NotNil(t, key)
+	assert.Equal(t, "active-key", *key)
+
+	// No active key left
+	_, err = db.ExecContext(ctx, "UPDATE keys SET status = 'disabled' WHERE key = 'active-key'")
+	require.NoError(t, err)
+	_, err = repo.UseBestKey(ctx)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestKeyDBRepository_DeductKeyBalance_Exhausts(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
+	ctx := context.Background()
+
+	_, err := db.ExecContext(ctx, "INSERT INTO keys (key, balance) VALUES ($1, $2)", "test-key", 100)
+	require.NoError(t, err)
+
+	err = repo.DeductKeyBalance(ctx, DeductKeyBalanceParams{Key: "test-key", Amount: 100})
+	assert.NoError(t, err)
+
+	var status KeyStatus
+	err = db.QueryRowContext(ctx, "SELECT status FROM keys WHERE key = $1", "test-key").Scan(&status)
+	assert.NoError(t, err)
+	assert.Equal(t, KeyStatusExhausted, status)
+}
+
+func TestKeyService_StatusChangeMetrics(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	metrics := NewMetrics(prometheus.NewRegistry())
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst), WithMetrics(metrics))
+	service := NewKeyService(repo, nil, false)
+	ctx := context.Background()
+	statusChanges := func(status KeyStatus) float64 {
+		return testutil.ToFloat64(metrics.statusChanges.WithLabelValues(string(status)))
+	}
+
+	_, err := db.ExecContext(ctx, "INSERT INTO keys (key, balance) VALUES ('key-1', 100), ('key-2', 100)")
+	require.NoError(t, err)
+
+	// Only the deduction reaching zero exhausts the key
+	for range 3 {
+		require.NoError(t, service.DeductKeyBalance(ctx, "key-1", 60))
+	}
+	assert.Equal(t, 1.0, statusChanges(KeyStatusExhausted))
+
+	// Writing the current status again is not a transition
+	require.NoError(t, service.ExhaustKey(ctx, "key-1"))
+	assert.Equal(t, 1.0, statusChanges(KeyStatusExhausted))
+
+	// An expired cooldown ends when the key is used
+	require.NoError(t, service.CooldownKey(ctx, "key-2", time.Now().Add(-time.Second)))
+	require.NoError(t, service.CooldownKey(ctx, "key-2", time.Now().Add(-time.Second)))
+	assert.Equal(t, 1.0, statusChanges(KeyStatusCoolingDown))
+
+	for range 2 {
+		key, err := service.UseBestKey(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "key-2", *key)
+	}
+	assert.Equal(t, 1.0, statusChanges(KeyStatusActive))
+
+	// The pool exhausts the key in memory, the transition is counted once the usage is flushed
+	pool := NewKeyPool(repo, lessSelector(newestFirst), nil, false, time.Second, time.Minute)
+	require.NoError(t, pool.Load(ctx))
+	require.NoError(t, pool.DeductKeyBalance(ctx, "key-2", 100))
+	assert.Equal(t, 1.0, statusChanges(KeyStatusExhausted))
+
+	require.NoError(t, pool.Flush(ctx))
+	assert.Equal(t, 2.0, statusChanges(KeyStatusExhausted))
+}
+
+func TestKeyDBRepository_UseBestKey_Exclude(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
+	ctx := context.Background()
+	now := time.Now()
+
+	_, err := db.ExecContext(ctx, "INSERT INTO keys (key, balance, created_at) VALUES ($1, $2, $3), ($4, $5, $6)",
+		"new-key", 1000, now, "old-key", 1000, now.Add(-time.Hour))
+	require.NoError(t, err)
+
+	key, err := repo.UseBestKey(ctx, "new-key")
+	assert.NoError(t, err)
+	assert.NotNil(t, key)
+	assert.Equal(t, "old-key", *key)
+
+	_, err = repo.UseBestKey(ctx, "new-key", "old-key")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestKeyDBRepository_UseBestKey_SkipLocked(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	metrics := NewMetrics(prometheus.NewRegistry())
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst), WithMetrics(metrics))
+	ctx := context.Background()
+	now := time.Now()
+
+	_, err := db.ExecContext(ctx, "INSERT INTO keys (key, balance, created_at) VALUES ($1, $2, $3), ($4, $5, $6)",
+		"new-key", 1000, now, "old-key", 1000, now.Add(-time.Hour))
+	require.NoError(t, err)
+
+	// Another caller holds the best key
+	tx, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	defer func() { _ = tx.Rollback() }()
+	var lockedID int64
+	err = tx.QueryRowContext(ctx, "SELECT id FROM keys WHERE key = 'new-key' FOR UPDATE").Scan(&lockedID)
+	require.NoError(t, err)
+
+	key, err := repo.UseBestKey(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "old-key", *key, "The locked key is skipped")
+
+	_, err = repo.UseBestKey(ctx, "old-key")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	require.NoError(t, tx.Rollback())
+	key, err = repo.UseBestKey(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "new-key", *key)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.selections.WithLabelValues(strconv.FormatInt(lockedID, 10))))
+}
+
+func TestKeyDBRepository_Cooldown(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
+	ctx := context.Background()
+	now := time.Now()
+
+	_, err := db.ExecContext(ctx, "INSERT INTO keys (key, balance, created_at) VALUES ($1, $2, $3), ($4, $5, $6)",
+		"new-key", 1000, now, "old-key", 1000, now.Add(-time.Hour))
+	require.NoError(t, err)
+
+	// Cooling down key is skipped
+	until := now.Add(time.Hour)
+	err = repo.UpdateKeyStatus(ctx, UpdateKeyStatusParams{Key: "new-key", Status: KeyStatusCoolingDown, CooldownUntil: &until})
+	require.NoError(t, err)
+
+	key, err := repo.UseBestKey(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "old-key", *key)
+
+	// A shorter cooldown does not shorten the current one
+	shorter := now.Add(time.Minute)
+	err = repo.UpdateKeyStatus(ctx, UpdateKeyStatusParams{Key: "new-key", Status: KeyStatusCoolingDown, CooldownUntil: &shorter})
+	require.NoError(t, err)
+
+	var cooldownUntil time.Time
+	err = db.QueryRowContext(ctx, "SELECT cooldown_until FROM keys WHERE key = $1", "new-key").Scan(&cooldownUntil)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, until, cooldownUntil, time.Millisecond)
+
+	// Cooldown is required
+	err = repo.UpdateKeyStatus(ctx, UpdateKeyStatusParams{Key: "new-key", Status: KeyStatusCoolingDown})
+	assert.ErrorIs(t, err, ErrInvalidKeyStatus)
+
+	// Expired cooldown makes the key active again
+	_, err = db.ExecContext(ctx, "UPDATE keys SET cooldown_until = $2 WHERE key = $1", "new-key", now.Add(-time.Second))
+	require.NoError(t, err)
+
+	key, err = repo.UseBestKey(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "new-key", *key)
+
+	var status KeyStatus
+	var nullableCooldownUntil sql.NullTime
+	err = db.QueryRowContext(ctx, "SELECT status, cooldown_until FROM keys WHERE key = $1", "new-key").Scan(&status, &nullableCooldownUntil)
+	assert.NoError(t, err)
+	assert.Equal(t, KeyStatusActive, status)
+	assert.False(t, nullableCooldownUntil.Valid)
+}
+
+func TestKeyDBRepository_NextCooldownEnd(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
+	ctx := context.Background()
+	now := time.Now()
+
+	_, err := db.ExecContext(ctx, "INSERT INTO keys (key, balance) VALUES ($1, $2), ($3, $4), ($5, $6)",
+		"active-key", 1000, "soon-key", 1000, "later-key", 1000)
+	require.NoError(t, err)
+
+	// No key is cooling down
+	next, err := repo.NextCooldownEnd(ctx)
+	assert.NoError(t, err)
+	assert.Nil(t, next)
+
+	soon := now.Add(time.Minute)
+	later := now.Add(time.Hour)
+	err = repo.UpdateKeyStatus(ctx, UpdateKeyStatusParams{Key: "soon-key", Status: KeyStatusCoolingDown, CooldownUntil: &soon})
+	require.NoError(t, err)
+	err = repo.UpdateKeyStatus(ctx, UpdateKeyStatusParams{Key: "later-key", Status: KeyStatusCoolingDown, CooldownUntil: &later})
+	require.NoError(t, err)
+
+	next, err = repo.NextCooldownEnd(ctx)
+	assert.NoError(t, err)
+	require.NotNil(t, next)
+	assert.WithinDuration(t, soon, *next, time.Millisecond)
+
+	// Expired cooldowns are ignored
+	_, err = db.ExecContext(ctx, "UPDATE keys SET cooldown_until = $2 WHERE key = $1", "soon-key", now.Add(-time.Second))
+	require.NoError(t, err)
+
+	next, err = repo.NextCooldownEnd(ctx)
+	assert.NoError(t, err)
+	require.NotNil(t, next)
+	assert.WithinDuration(t, later, *next, time.Millisecond)
+}
+
+func TestKeyDBRepository_UseBestKey_Strategies(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	now := time.Now()
+	hourAgo := now.Add(-1 * time.Hour)
+	twoHoursAgo := now.Add(-2 * time.Hour)
+
+	type seedKey struct {
+		key       string
+		balance   int
+		createdAt time.Time
+		usedAt    *time.Time
+	}
+
+	testCases := []struct {
+		name         string
+		strategy     string
+		keys         []seedKey
+		expectedKeys []string // Keys expected from consecutive calls
+	}{
+		{
+			name:     "Newest first",
+			strategy: StrategyNewestFirst,
+			keys: []seedKey{
+				{key: "old-key", balance: 1000, createdAt: twoHoursAgo},
+				{key: "new-key", balance: 1000, createdAt: hourAgo},
+			},
+			expectedKeys: []string{"new-key", "new-key"},
+		},
+		{
+			name:     "Round robin",
+			strategy: StrategyRoundRobin,
+			keys: []seedKey{
+				{key: "key-b", balance: 1000, createdAt: hourAgo},
+				{key: "key-a", balance: 1000, createdAt: twoHoursAgo, usedAt: &now},
+				{key: "key-c", balance: 1000, createdAt: now},
+			},
+			expectedKeys: []string{"key-a", "key-b", "key-c", "key-a"},
+		},
+		{
+			name:     "Least recently used",
+			strategy: StrategyLeastRecentlyUsed,
+			keys: []seedKey{
+				{key: "recently-used", balance: 1000, createdAt: twoHoursAgo, usedAt: &now},
+				{key: "long-ago-used", balance: 1000, createdAt: twoHoursAgo, usedAt: &hourAgo},
+				{key: "never-used", balance: 1000, createdAt: now},
+			},
+			expectedKeys: []string{"never-used", "long-ago-used", "recently-used", "never-used"},
+		},
+		{
+			name:     "Highest balance",
+			strategy: StrategyHighestBalance,
+			keys: []seedKey{
+				{key: "low-balance", balance: 1000, createdAt: now},
+				{key: "high-balance", balance: 5000, createdAt: twoHoursAgo, usedAt: &now},
+			},
+			expectedKeys: []string{"high-balance", "high-balance"},
+		},
+		{
+			name:     "Weighted random",
+			strategy: StrategyWeightedRandom,
+			keys: []seedKey{
+				{key: "key-a", balance: 1, createdAt: now},
+				{key: "key-b", balance: 1000000000, createdAt: now},
+			},
+			expectedKeys: []string{"key-b", "key-b"},
+		},
+		{
+			name:     "Drain oldest first",
+			strategy: StrategyDrainOldestFirst,
+			keys: []seedKey{
+				{key: "new-key", balance: 5000, createdAt: now},
+				{key: "old-key", balance: 10, createdAt: twoHoursAgo, usedAt: &now},
+			},
+			expectedKeys: []string{"old-key", "old-key"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := db.ExecContext(ctx, "DELETE FROM keys")
+			require.NoError(t, err)
+			for _, k := range tc.keys {
+				_, err = db.ExecContext(ctx, "INSERT INTO keys (key, balance, created_at, used_at) VALUES ($1, $2, $3, $4)",
+					k.key, k.balance, k.createdAt, k.usedAt)
+				require.NoError(t, err)
+			}
+
+			repo := NewKeyDBRepository(db, fixedStrategy(tc.strategy))
+			var selected []string
+			for range tc.expectedKeys {
+				key, err := repo.UseBestKey(ctx)
+				require.NoError(t, err)
+				selected = append(selected, *key)
+			}
+			assert.Equal(t, tc.expectedKeys, selected)
+		})
+	}
+}
+
+func TestKeyDBRepository_UseBestKey_MatchesSelector(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	now := time.Now()
+	hourAgo := now.Add(-1 * time.Hour)
+	twoHoursAgo := now.Add(-2 * time.Hour)
+
+	// Ties on every column before the key, and keys whose byte order differs from the usual collations
+	seeds := []struct {
+		key       string
+		balance   int64
+		createdAt time.Time
+		usedAt    *time.Time
+	}{
+		{key: "key-a", balance: 1000, createdAt: twoHoursAgo, usedAt: &hourAgo},
+		{key: "Key-B", balance: 1000, createdAt: twoHoursAgo},
+		{key: "key-c", balance: 5000, createdAt: hourAgo, usedAt: &now},
+		{key: "key-d", balance: 5000, createdAt: hourAgo, usedAt: &hourAgo},
+		{key: "Key-E", balance: 5000, createdAt: hourAgo, usedAt: &hourAgo},
+	}
+
+	for _, strategy := range Strategies {
+		if strategy == StrategyWeightedRandom {
+			continue // Random picks cannot be compared
+		}
+
+		t.Run(strategy, func(t *testing.T) {
+			_, err := db.ExecContext(ctx, "DELETE FROM keys")
+			require.NoError(t, err)
+			for _, seed := range seeds {
+				_, err = db.ExecContext(ctx, "INSERT INTO keys (key, balance, created_at, used_at) VALUES ($1, $2, $3, $4)",
+					seed.key, seed.balance, seed.createdAt, seed.usedAt)
+				require.NoError(t, err)
+			}
+
+			repo := NewKeyDBRepository(db, fixedStrategy(strategy))
+			selector, err := NewSelector(strategy)
+			require.NoError(t, err)
+
+			// The selector picks from the stored keys before the repository picks and updates used_at
+			for i := 0; i < len(seeds)+1; i++ {
+				keys, err := repo.GetAllKeys(ctx)
+				require.NoError(t, err)
+				expected := selector.Select(keys)
+				require.NotNil(t, expected)
+
+				key, err := repo.UseBestKey(ctx)
+				require.NoError(t, err)
+				assert.Equal(t, expected.Key, *key, "Selection %d", i+1)
+			}
+		})
+	}
+}
+
+func TestKeyDBRepository_GetKey(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
+	ctx := context.Background()
+
+	_, err := db.ExecContext(ctx, "INSERT INTO keys (key, balance) VALUES ($1, $2), ($3, $4)", "key-1", 100, "key-2", 200)
+	require.NoError(t, err)
+
+	key, err := repo.GetKey(ctx, "key-2")
+	assert.NoError(t, err)
+	assert.Equal(t, "key-2", key.Key)
+	assert.Equal(t, int64(200), key.Balance)
+	assert.Equal(t, KeyStatusActive, key.Status)
+
+	_, err = repo.GetKey(ctx, "missing-key")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	keys, err := repo.GetAllKeys(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+
+	existing, err := repo.GetExistingKeys(ctx, []string{"key-2", "missing-key"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"key-2"}, existing)
+}
+
+func TestKeyDBRepository_ApplyKeyUsage(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
+	ctx := context.Background()
+	now := time.Now()
+	hourAgo := now.Add(-time.Hour)
+
+	_, err := db.ExecContext(ctx, "INSERT INTO keys (key, balance, used_at) VALUES ($1, $2, $3), ($4, $5, $6)",
+		"key-1", 100, now, "key-2", 100, nil)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, "INSERT INTO keys (key, balance, status, cooldown_until) VALUES ($1, $2, $3, $4), ($5, $6, $7, $8)",
+		"expired-key", 100, "cooling_down", hourAgo, "cooling-key", 100, "cooling_down", now.Add(time.Hour))
+	require.NoError(t, err)
+
+	err = repo.ApplyKeyUsage(ctx, []KeyUsage{
+		{Key: "key-1", Amount: 40, UsedAt: &hourAgo},
+		{Key: "key-2", Amount: 150, UsedAt: &now},
+		{Key: "expired-key", UsedAt: &now, EndCooldown: true},
+		{Key: "cooling-key", UsedAt: &now, EndCooldown: true},
+	})
+	assert.NoError(t, err)
+
+	// Older used_at does not move used_at back
+	key, err := repo.GetKey(ctx, "key-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(60), key.Balance)
+	assert.Equal(t, KeyStatusActive, key.Status)
+	assert.WithinDuration(t, now, *key.UsedAt, time.Millisecond)
+
+	key, err = repo.GetKey(ctx, "key-2")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), key.Balance)
+	assert.Equal(t, KeyStatusExhausted, key.Status)
+	assert.WithinDuration(t, now, *key.UsedAt, time.Millisecond)
+
+	// Expired cooldown ends, a cooldown put back by another process is kept
+	key, err = repo.GetKey(ctx, "expired-key")
+	require.NoError(t, err)
+	assert.Equal(t, KeyStatusActive, key.Status)
+	assert.Nil(t, key.CooldownUntil)
+
+	key, err = repo.GetKey(ctx, "cooling-key")
+	require.NoError(t, err)
+	assert.Equal(t, KeyStatusCoolingDown, key.Status)
+	assert.NotNil(t, key.CooldownUntil)
+}
+
+func TestKeyDBRepository_ListKeys(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
+	ctx := context.Background()
+
+	_, err := db.ExecContext(ctx, "INSERT INTO keys (key, balance, status) VALUES ($1, $2, $3), ($4, $5, $6), ($7, $8, $9)",
+		"key-1", 300, "active", "key-2", 100, "disabled", "key-3", 200, "active")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		params       ListKeysParams
+		expectedKeys []string
+		expectedErr  error
+	}{
+		{name: "Default sort by id", params: ListKeysParams{Limit: 10}, expectedKeys: []string{"key-1", "key-2", "key-3"}},
+		{name: "Filter by status", params: ListKeysParams{Status: KeyStatusActive, Limit: 10}, expectedKeys: []string{"key-1", "key-3"}},
+		{name: "Sort by balance", params: ListKeysParams{Sort: "balance", Limit: 10}, expectedKeys: []string{"key-2", "key-3", "key-1"}},
+		{name: "Sort descending", params: ListKeysParams{Sort: "-balance", Limit: 10}, expectedKeys: []string{"key-1", "key-3", "key-2"}},
+		{name: "Paginate", params: ListKeysParams{Limit: 1, Offset: 1}, expectedKeys: []string{"key-2"}},
+		{name: "Invalid sort", params: ListKeysParams{Sort: "key", Limit: 10}, expectedErr: ErrInvalidKeySort},
+		{name: "Invalid status", params: ListKeysParams{Status: "unknown", Limit: 10}, expectedErr: ErrInvalidKeyStatus},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			list, err := repo.ListKeys(ctx, tc.params)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var keys []string
+			for _, key := range list.Keys {
+				keys = append(keys, key.Key)
+			}
+			assert.Equal(t, tc.expectedKeys, keys)
+
+			expectedTotal := 3
+			if tc.params.Status != "" {
+				expectedTotal = 2
+			}
+			assert.Equal(t, expectedTotal, list.Total)
+		})
+	}
+}
+
+func TestKeyDBRepository_UpdateKey(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
+	ctx := context.Background()
+
+	err := repo.InsertKey(ctx, InsertKeyParams{Key: "test-key"})
+	require.NoError(t, err)
+	inserted, err := repo.GetKey(ctx, "test-key")
+	require.NoError(t, err)
+
+	// Disable the key
+	disabled := KeyStatusDisabled
+	key, err := repo.UpdateKey(ctx, UpdateKeyParams{ID: inserted.ID, Status: &disabled})
+	assert.NoError(t, err)
+	assert.Equal(t, KeyStatusDisabled, key.Status)
+	assert.Equal(t, inserted.Balance, key.Balance)
+
+	// Update the balance only
+	balance := int64(42)
+	key, err = repo.UpdateKey(ctx, UpdateKeyParams{ID: inserted.ID, Balance: &balance})
+	assert.NoError(t, err)
+	assert.Equal(t, KeyStatusDisabled, key.Status)
+	assert.Equal(t, int64(42), key.Balance)
+
+	// Invalid changes
+	invalid := KeyStatusInvalid
+	_, err = repo.UpdateKey(ctx, UpdateKeyParams{ID: inserted.ID, Status: &invalid})
+	assert.ErrorIs(t, err, ErrInvalidStatusTransition)
+
+	coolingDown := KeyStatusCoolingDown
+	_, err = repo.UpdateKey(ctx, UpdateKeyParams{ID: inserted.ID, Status: &coolingDown})
+	assert.ErrorIs(t, err, ErrInvalidKeyStatus)
+
+	negative := int64(-1)
+	_, err = repo.UpdateKey(ctx, UpdateKeyParams{ID: inserted.ID, Balance: &negative})
+	assert.ErrorIs(t, err, ErrInvalidBalance)
+
+	_, err = repo.UpdateKey(ctx, UpdateKeyParams{ID: inserted.ID + 1, Balance: &balance})
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestKeyDBRepository_DeleteKey(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
+	ctx := context.Background()
+
+	err := repo.InsertKey(ctx, InsertKeyParams{Key: "test-key"})
+	require.NoError(t, err)
+	inserted, err := repo.GetKey(ctx, "test-key")
+	require.NoError(t, err)
+
+	key, err := repo.GetKeyByID(ctx, inserted.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "test-key", key.Key)
+
+	err = repo.DeleteKey(ctx, inserted.ID)
+	assert.NoError(t, err)
+
+	_, err = repo.GetKeyByID(ctx, inserted.ID)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	err = repo.DeleteKey(ctx, inserted.ID)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestKeyDBRepository_ImportKeys(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
+	ctx := context.Background()
+
+	err := repo.InsertKey(ctx, InsertKeyParams{Key: "existing-key"})
+	require.NoError(t, err)
+
+	results, err := repo.ImportKeys(ctx, []ImportKeyParams{
+		{Key: "key-1", Balance: 500, Label: "team-a"},
+		{Key: "existing-key", Balance: 500},
+		{Key: "key-1", Balance: 100},
+		{Key: "key-2", Balance: 5_000_000_000},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+	assert.Equal(t, ImportKeyStatusInserted, results[0].Status)
+	assert.NotZero(t, results[0].ID)
+	assert.Equal(t, ImportKeyStatusDuplicate, results[1].Status)
+	assert.Equal(t, ImportKeyStatusDuplicate, results[2].Status)
+	assert.Equal(t, ImportKeyStatusInserted, results[3].Status)
+
+	key, err := repo.GetKey(ctx, "key-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(500), key.Balance)
+	assert.Equal(t, "team-a", key.Label)
+
+	// Balances beyond 32 bits are imported
+	key, err = repo.GetKey(ctx, "key-2")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5_000_000_000), key.Balance)
+}
+
+func TestKeyDBRepository_ReconcileKeyBalance(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
+	ctx := context.Background()
+
+	_, err := db.ExecContext(ctx, "INSERT INTO keys (key, balance) VALUES ($1, $2)", "test-key", 1000)
+	require.NoError(t, err)
+
+	// No drift records nothing
+	drift, err := repo.ReconcileKeyBalance(ctx, "test-key", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, &BalanceDrift{Key: "test-key", LocalBalance: 1000, UpstreamBalance: 1000}, drift)
+
+	// Drift is applied and recorded
+	drift, err = repo.ReconcileKeyBalance(ctx, "test-key", 800)
+	require.NoError(t, err)
+	assert.Equal(t, &BalanceDrift{Key: "test-key", LocalBalance: 1000, UpstreamBalance: 800, Drift: -200}, drift)
+
+	// Key without balance is exhausted
+	drift, err = repo.ReconcileKeyBalance(ctx, "test-key", 0)
+	require.NoError(t, err)
+	require.NotNil(t, drift.Status)
+	assert.Equal(t, KeyStatusExhausted, *drift.Status)
+
+	key, err := repo.GetKey(ctx, "test-key")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), key.Balance)
+	assert.Equal(t, KeyStatusExhausted, key.Status)
+
+	// Exhausted key with balance again is active
+	drift, err = repo.ReconcileKeyBalance(ctx, "test-key", 500)
+	require.NoError(t, err)
+	require.NotNil(t, drift.Status)
+	assert.Equal(t, KeyStatusActive, *drift.Status)
+
+	// Invalid key with balance is active without drift
+	_, err = db.ExecContext(ctx, "UPDATE keys SET status = 'invalid' WHERE id = $1", key.ID)
+	require.NoError(t, err)
+	drift, err = repo.ReconcileKeyBalance(ctx, "test-key", 500)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), drift.Drift)
+	require.NotNil(t, drift.Status)
+	assert.Equal(t, KeyStatusActive, *drift.Status)
+
+	key, err = repo.GetKey(ctx, "test-key")
+	require.NoError(t, err)
+	assert.Equal(t, int64(500), key.Balance)
+	assert.Equal(t, KeyStatusActive, key.Status)
+
+	var drifts []int64
+	rows, err := db.QueryContext(ctx, "SELECT drift FROM key_balance_drifts WHERE key_id = $1 ORDER BY id", key.ID)
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var value int64
+		require.NoError(t, rows.Scan(&value))
+		drifts = append(drifts, value)
+	}
+	assert.Equal(t, []int64{-200, -800, 500}, drifts)
+
+	_, err = repo.ReconcileKeyBalance(ctx, "missing-key", 0)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestKeyDBRepository_ReconcileKeyBalance_LargeBalance(t *testing.T) {
+	db, cleanup := testdb.SetupPostgres(t)
+	defer cleanup()
+
+	repo := NewKeyDBRepository(db, fixedStrategy(StrategyNewestFirst))
+	ctx := context.Background()
+
+	_, err := db.ExecContext(ctx, "INSERT INTO keys (key, balance) VALUES ($1, $2)", "test-key", 1000)
+	require.NoError(t, err)
+
+	// Balances of paid packages do not fit in 32 bits
+	const balance = int64(5_000_000_000)
+	drift, err := repo.ReconcileKeyBalance(ctx, "test-key", balance)
+	require.NoError(t, err)
+	assert.Equal(t, balance-1000, drift.Drift)
+
+	key, err := repo.GetKey(ctx, "test-key")
+	require.NoError(t, err)
+	assert.Equal(t, balance, key.Balance)
+}
@@ -0,0 +1,271 @@
+package key
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StrategyNewestFirst       = "newest-first"
+	StrategyRoundRobin        = "round-robin"
+	StrategyLeastRecentlyUsed = "least-recently-used"
+	StrategyHighestBalance    = "highest-balance"
+	StrategyWeightedRandom    = "weighted-random"
+	StrategyDrainOldestFirst  = "drain-oldest-first"
+)
+
+// Strategies lists the available key selection strategies
+var Strategies = []string{
+	StrategyNewestFirst,
+	StrategyRoundRobin,
+	StrategyLeastRecentlyUsed,
+	StrategyHighestBalance,
+	StrategyWeightedRandom,
+	StrategyDrainOldestFirst,
+}
+
+// Selector picks the key to use among the selectable keys.
+// Select returns nil when there is no candidate.
+type Selector interface {
+	Select(candidates []Key) *Key
+}
+
+// NewSelector returns the selector of the given strategy
+func NewSelector(strategy string) (Selector, error) {
+	switch strategy {
+	case StrategyNewestFirst:
+		return lessSelector(newestFirst), nil
+	case StrategyRoundRobin:
+		return &roundRobinSelector{}, nil
+	case StrategyLeastRecentlyUsed:
+		return lessSelector(leastRecentlyUsed), nil
+	case StrategyHighestBalance:
+		return lessSelector(highestBalance), nil
+	case StrategyWeightedRandom:
+		return &weightedRandomSelector{int64N: rand.Int64N}, nil
+	case StrategyDrainOldestFirst:
+		return lessSelector(drainOldestFirst), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStrategy, strategy)
+	}
+}
+
+// lessSelector selects the first key in the order defined by the less function
+type lessSelector func(a, b *Key) bool
+
+func (less lessSelector) Select(candidates []Key) *Key {
+	var best *Key
+	for i := range candidates {
+		if best == nil || less(&candidates[i], best) {
+			best = &candidates[i]
+		}
+	}
+	return best
+}
+
+// newestFirst orders by latest created_at, then most old used_at, then most balance, then key.
+// Never used keys come after used keys.
+func newestFirst(a, b *Key) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	if c := compareUsedAt(a.UsedAt, b.UsedAt, false); c != 0 {
+		return c < 0
+	}
+	if a.Balance != b.Balance {
+		return a.Balance > b.Balance
+	}
+	return a.Key < b.Key
+}
+
+// leastRecentlyUsed orders by most old used_at, never used keys first, then oldest created_at
+func leastRecentlyUsed(a, b *Key) bool {
+	if c := compareUsedAt(a.UsedAt, b.UsedAt, true); c != 0 {
+		return c < 0
+	}
+	return compareCreated(a, b) < 0
+}
+
+// highestBalance orders by most balance, then least recently used
+func highestBalance(a, b *Key) bool {
+	if a.Balance != b.Balance {
+		return a.Balance > b.Balance
+	}
+	return leastRecentlyUsed(a, b)
+}
+
+// drainOldestFirst orders by oldest created_at so a key is used until it is exhausted
+func drainOldestFirst(a, b *Key) bool {
+	return compareCreated(a, b) < 0
+}
+
+// compareUsedAt compares two used_at times, nilFirst tells whether never used keys come first
+func compareUsedAt(a, b *time.Time, nilFirst bool) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		if nilFirst {
+			return -1
+		}
+		return 1
+	case b == nil:
+		if nilFirst {
+			return 1
+		}
+		return -1
+	default:
+		return a.Compare(*b)
+	}
+}
+
+// compareCreated compares keys by created_at, then by key for a stable order
+func compareCreated(a, b *Key) int {
+	if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+		return c
+	}
+	switch {
+	case a.Key < b.Key:
+		return -1
+	case a.Key > b.Key:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// roundRobinSelector selects the keys in turn in created_at order, starting over after the last key
+type roundRobinSelector struct {
+	cursor roundRobinCursor
+}
+
+func (s *roundRobinSelector) Select(candidates []Key) *Key {
+	last := s.cursor.Last()
+
+	// The next key is the first key after the last selected key, or the first key when there is none
+	var first, next *Key
+	for i := range candidates {
+		candidate := &candidates[i]
+		if first == nil || compareCreated(candidate, first) < 0 {
+			first = candidate
+		}
+		if last != nil && compareCreated(candidate, last) > 0 && (next == nil || compareCreated(candidate, next) < 0) {
+			next = candidate
+		}
+	}
+	if next == nil {
+		next = first
+	}
+
+	if next != nil {
+		s.cursor.Move(*next)
+	}
+	return next
+}
+
+// roundRobinCursor remembers the last key selected in turn
+type roundRobinCursor struct {
+	mu   sync.Mutex
+	last *Key
+}
+
+// Last returns the created_at and key of the last selected key, nil when no key was selected yet
+func (c *roundRobinCursor) Last() *Key {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.last
+}
+
+// Move remembers the key as the last selected key
+func (c *roundRobinCursor) Move(key Key) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.last = &Key{Key: key.Key, CreatedAt: key.CreatedAt}
+}
+
+// weightedRandomSelector picks a random key with a probability proportional to its balance
+type weightedRandomSelector struct {
+	int64N func(n int64) int64
+}
+
+func (s *weightedRandomSelector) Select(candidates []Key) *Key {
+	var total int64
+	for _, candidate := range candidates {
+		total += max(candidate.Balance, 1)
+	}
+	if total == 0 {
+		return nil
+	}
+
+	pick := s.int64N(total)
+	for i := range candidates {
+		pick -= max(candidates[i].Balance, 1)
+		if pick < 0 {
+			return &candidates[i]
+		}
+	}
+	return nil
+}
+
+// StrategyGetter returns the current key selection strategy
+type StrategyGetter interface {
+	Strategy() string
+}
+
+// strategyOrders is the SQL order of the selectable keys for each strategy, the first key is selected.
+// It is the order of the selector of the strategy, keys are compared in byte order like Go strings.
+// Round robin starts after the last selected key of the repository, see UseBestKey.
+// Weighted random orders by an exponential draw divided by the balance, which picks a key
+// with a probability proportional to its balance.
+var strategyOrders = map[string]string{
+	StrategyNewestFirst:       `created_at DESC, used_at ASC NULLS LAST, balance DESC, key COLLATE "C" ASC`,
+	StrategyRoundRobin:        `created_at ASC, key COLLATE "C" ASC`,
+	StrategyLeastRecentlyUsed: `used_at ASC NULLS FIRST, created_at ASC, key COLLATE "C" ASC`,
+	StrategyHighestBalance:    `balance DESC, used_at ASC NULLS FIRST, created_at ASC, key COLLATE "C" ASC`,
+	StrategyWeightedRandom:    "-ln(1 - random()) / GREATEST(balance, 1)",
+	StrategyDrainOldestFirst:  `created_at ASC, key COLLATE "C" ASC`,
+}
+
+// selection is a strategy and its selector
+type selection struct {
+	strategy string
+	selector Selector
+}
+
+// SwappableSelector delegates to the selector of a strategy that can be replaced while keys are being selected
+type SwappableSelector struct {
+	current atomic.Pointer[selection]
+}
+
+func (s *SwappableSelector) Select(candidates []Key) *Key {
+	return s.current.Load().selector.Select(candidates)
+}
+
+// Strategy returns the current strategy
+func (s *SwappableSelector) Strategy() string {
+	return s.current.Load().strategy
+}
+
+// Swap replaces the strategy, the selections in progress finish with the previous strategy
+func (s *SwappableSelector) Swap(strategy string) error {
+	selector, err := NewSelector(strategy)
+	if err != nil {
+		return err
+	}
+	s.current.Store(&selection{strategy: strategy, selector: selector})
+	return nil
+}
+
+func NewSwappableSelector(strategy string) (*SwappableSelector, error) {
+	swappable := &SwappableSelector{}
+	err := swappable.Swap(strategy)
+	if err != nil {
+		return nil, err
+	}
+	return swappable, nil
+}
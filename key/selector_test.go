@@ -0,0 +1,209 @@
+package key
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSelector(t *testing.T) {
+	for _, strategy := range Strategies {
+		t.Run(strategy, func(t *testing.T) {
+			selector, err := NewSelector(strategy)
+			assert.NoError(t, err)
+			assert.NotNil(t, selector)
+			assert.Nil(t, selector.Select(nil), "No candidate selects no key")
+		})
+	}
+
+	_, err := NewSelector("unknown")
+	assert.ErrorIs(t, err, ErrUnknownStrategy)
+}
+
+func TestSelector_Select(t *testing.T) {
+	now := time.Now()
+	hourAgo := now.Add(-time.Hour)
+	twoHoursAgo := now.Add(-2 * time.Hour)
+
+	tests := []struct {
+		name        string
+		strategy    string
+		candidates  []Key
+		expectedKey string
+	}{
+		{
+			name:     "Newest first prefers latest created_at",
+			strategy: StrategyNewestFirst,
+			candidates: []Key{
+				{Key: "old-key", Balance: 1000, CreatedAt: twoHoursAgo},
+				{Key: "new-key", Balance: 1000, CreatedAt: hourAgo},
+			},
+			expectedKey: "new-key",
+		},
+		{
+			name:     "Newest first prefers used keys over never used keys",
+			strategy: StrategyNewestFirst,
+			candidates: []Key{
+				{Key: "never-used", Balance: 1000, CreatedAt: now},
+				{Key: "recently-used", Balance: 1000, CreatedAt: now, UsedAt: &hourAgo},
+			},
+			expectedKey: "recently-used",
+		},
+		{
+			name:     "Newest first prefers higher balance",
+			strategy: StrategyNewestFirst,
+			candidates: []Key{
+				{Key: "low-balance", Balance: 1000, CreatedAt: now, UsedAt: &hourAgo},
+				{Key: "high-balance", Balance: 2000, CreatedAt: now, UsedAt: &hourAgo},
+			},
+			expectedKey: "high-balance",
+		},
+		{
+			name:     "Least recently used prefers never used keys",
+			strategy: StrategyLeastRecentlyUsed,
+			candidates: []Key{
+				{Key: "used", Balance: 1000, CreatedAt: twoHoursAgo, UsedAt: &twoHoursAgo},
+				{Key: "never-used", Balance: 1000, CreatedAt: now},
+			},
+			expectedKey: "never-used",
+		},
+		{
+			name:     "Least recently used prefers oldest used_at",
+			strategy: StrategyLeastRecentlyUsed,
+			candidates: []Key{
+				{Key: "recently-used", Balance: 1000, CreatedAt: twoHoursAgo, UsedAt: &now},
+				{Key: "long-ago-used", Balance: 1000, CreatedAt: now, UsedAt: &twoHoursAgo},
+			},
+			expectedKey: "long-ago-used",
+		},
+		{
+			name:     "Highest balance",
+			strategy: StrategyHighestBalance,
+			candidates: []Key{
+				{Key: "low-balance", Balance: 1000, CreatedAt: now},
+				{Key: "high-balance", Balance: 5000, CreatedAt: twoHoursAgo, UsedAt: &now},
+			},
+			expectedKey: "high-balance",
+		},
+		{
+			name:     "Highest balance tie uses least recently used",
+			strategy: StrategyHighestBalance,
+			candidates: []Key{
+				{Key: "recently-used", Balance: 1000, CreatedAt: now, UsedAt: &now},
+				{Key: "long-ago-used", Balance: 1000, CreatedAt: now, UsedAt: &hourAgo},
+			},
+			expectedKey: "long-ago-used",
+		},
+		{
+			name:     "Drain oldest first",
+			strategy: StrategyDrainOldestFirst,
+			candidates: []Key{
+				{Key: "new-key", Balance: 5000, CreatedAt: now},
+				{Key: "old-key", Balance: 10, CreatedAt: twoHoursAgo, UsedAt: &now},
+			},
+			expectedKey: "old-key",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			selector, err := NewSelector(tc.strategy)
+			require.NoError(t, err)
+
+			selected := selector.Select(tc.candidates)
+			require.NotNil(t, selected)
+			assert.Equal(t, tc.expectedKey, selected.Key)
+		})
+	}
+}
+
+func TestNewSelector_RoundRobin(t *testing.T) {
+	now := time.Now()
+	hourAgo := now.Add(-time.Hour)
+	candidates := []Key{
+		{Key: "key-c", CreatedAt: now},
+		{Key: "key-a", CreatedAt: now.Add(-2 * time.Hour), UsedAt: &now},
+		{Key: "key-b", CreatedAt: hourAgo},
+	}
+
+	selector, err := NewSelector(StrategyRoundRobin)
+	require.NoError(t, err)
+
+	// Keys are used in turn in created_at order, whenever they were last used
+	var selected []string
+	for i := 0; i < 5; i++ {
+		selected = append(selected, selector.Select(candidates).Key)
+	}
+	assert.Equal(t, []string{"key-a", "key-b", "key-c", "key-a", "key-b"}, selected)
+
+	// Removed keys are skipped
+	selected = nil
+	for i := 0; i < 3; i++ {
+		selected = append(selected, selector.Select(candidates[:2]).Key)
+	}
+	assert.Equal(t, []string{"key-c", "key-a", "key-c"}, selected)
+
+	// A key created after the last selected key is used before starting over
+	selected = nil
+	candidates = append(candidates, Key{Key: "key-d", CreatedAt: now.Add(time.Second)})
+	for i := 0; i < 2; i++ {
+		selected = append(selected, selector.Select(candidates).Key)
+	}
+	assert.Equal(t, []string{"key-d", "key-a"}, selected)
+}
+
+func TestWeightedRandomSelector_Select(t *testing.T) {
+	candidates := []Key{
+		{Key: "key-a", Balance: 100},
+		{Key: "key-b", Balance: 300},
+		{Key: "key-c", Balance: 0}, // Still has a minimal weight
+	}
+
+	tests := []struct {
+		pick        int64
+		expectedKey string
+	}{
+		{pick: 0, expectedKey: "key-a"},
+		{pick: 99, expectedKey: "key-a"},
+		{pick: 100, expectedKey: "key-b"},
+		{pick: 399, expectedKey: "key-b"},
+		{pick: 400, expectedKey: "key-c"},
+	}
+
+	for _, tc := range tests {
+		selector := &weightedRandomSelector{int64N: func(n int64) int64 {
+			assert.Equal(t, int64(401), n)
+			return tc.pick
+		}}
+		assert.Equal(t, tc.expectedKey, selector.Select(candidates).Key)
+	}
+}
+
+func TestSwappableSelector(t *testing.T) {
+	now := time.Now()
+	candidates := []Key{
+		{Key: "old-key", Balance: 1000, CreatedAt: now.Add(-time.Hour)},
+		{Key: "new-key", Balance: 1000, CreatedAt: now},
+	}
+
+	selector, err := NewSwappableSelector(StrategyNewestFirst)
+	require.NoError(t, err)
+	assert.Equal(t, StrategyNewestFirst, selector.Strategy())
+	assert.Equal(t, "new-key", selector.Select(candidates).Key)
+
+	require.NoError(t, selector.Swap(StrategyDrainOldestFirst))
+	assert.Equal(t, StrategyDrainOldestFirst, selector.Strategy())
+	assert.Equal(t, "old-key", selector.Select(candidates).Key)
+
+	// An unknown strategy keeps the current strategy
+	assert.ErrorIs(t, selector.Swap("unknown"), ErrUnknownStrategy)
+	assert.Equal(t, StrategyDrainOldestFirst, selector.Strategy())
+}
+
+func TestStrategyOrders(t *testing.T) {
+	for _, strategy := range Strategies {
+		assert.Contains(t, strategyOrders, strategy, "Every strategy can select keys in SQL")
+	}
+}
@@ -1,29 +1,136 @@
 package key
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type KeyRepository interface {
 	InsertKey(ctx context.Context, params InsertKeyParams) error
-	UseBestKey(ctx context.Context) (*string, error)
+	ImportKeys(ctx context.Context, params []ImportKeyParams) ([]ImportKeyResult, error)
+	UseBestKey(ctx context.Context, exclude ...string) (*string, error)
+	DeductKeyBalance(ctx context.Context, params DeductKeyBalanceParams) error
+	UpdateKeyStatus(ctx context.Context, params UpdateKeyStatusParams) error
+	GetAllKeys(ctx context.Context) ([]Key, error)
+	GetKey(ctx context.Context, key string) (*Key, error)
+	GetExistingKeys(ctx context.Context, keys []string) ([]string, error)
+	ListKeys(ctx context.Context, params ListKeysParams) (*KeyList, error)
+	GetKeyByID(ctx context.Context, id int64) (*Key, error)
+	UpdateKey(ctx context.Context, params UpdateKeyParams) (*Key, error)
+	DeleteKey(ctx context.Context, id int64) error
+	ApplyKeyUsage(ctx context.Context, usages []KeyUsage) error
+	ReconcileKeyBalance(ctx context.Context, key string, balance int64) (*BalanceDrift, error)
 	GetKeyStats(ctx context.Context) (*KeyStats, error)
+	GetKeyStatusStats(ctx context.Context) ([]KeyStatusStats, error)
+	NextCooldownEnd(ctx context.Context) (*time.Time, error)
 }
 
 type KeyService struct {
-	repo KeyRepository
+	repo             KeyRepository
+	validator        KeyValidator
+	validateOnInsert bool
 }
 
+// InsertKey inserts a key, validating it against upstream first when validation on insert is enabled.
+// A malformed key returns ErrInvalidKey.
 func (s *KeyService) InsertKey(ctx context.Context, params InsertKeyParams) error {
+	err := ValidateKey(params.Key)
+	if err != nil {
+		return err
+	}
+
+	if s.validateOnInsert {
+		params, err = validatedInsertParams(ctx, s.validator, params)
+		if err != nil {
+			return err
+		}
+	}
+
 	return s.repo.InsertKey(ctx, params)
 }
 
-func (s *KeyService) UseBestKey(ctx context.Context) (*string, error) {
-	return s.repo.UseBestKey(ctx)
+// ValidateKey validates a stored key against upstream and updates its balance and status
+func (s *KeyService) ValidateKey(ctx context.Context, id int64) (*ValidatedKey, error) {
+	key, err := s.repo.GetKeyByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return validateStoredKey(ctx, s.validator, key, s.repo.UpdateKey)
+}
+
+// ImportKeys imports the keys, validating them against upstream first when validation on insert is enabled
+func (s *KeyService) ImportKeys(ctx context.Context, params []ImportKeyParams) ([]ImportKeyResult, error) {
+	if s.validateOnInsert {
+		return importValidatedKeys(ctx, s.validator, s.repo, params)
+	}
+
+	return s.repo.ImportKeys(ctx, params)
+}
+
+// ExportKeys returns every key
+func (s *KeyService) ExportKeys(ctx context.Context) ([]Key, error) {
+	return s.repo.GetAllKeys(ctx)
+}
+
+func (s *KeyService) UseBestKey(ctx context.Context, exclude ...string) (*string, error) {
+	return s.repo.UseBestKey(ctx, exclude...)
+}
+
+func (s *KeyService) DeductKeyBalance(ctx context.Context, key string, amount int64) error {
+	return s.repo.DeductKeyBalance(ctx, DeductKeyBalanceParams{Key: key, Amount: amount})
+}
+
+// InvalidateKey marks a key rejected by upstream as invalid
+func (s *KeyService) InvalidateKey(ctx context.Context, key string) error {
+	return s.repo.UpdateKeyStatus(ctx, UpdateKeyStatusParams{Key: key, Status: KeyStatusInvalid})
+}
+
+// ExhaustKey marks a key without remaining balance as exhausted
+func (s *KeyService) ExhaustKey(ctx context.Context, key string) error {
+	return s.repo.UpdateKeyStatus(ctx, UpdateKeyStatusParams{Key: key, Status: KeyStatusExhausted})
+}
+
+// CooldownKey stops using a rate limited key until the given time
+func (s *KeyService) CooldownKey(ctx context.Context, key string, until time.Time) error {
+	return s.repo.UpdateKeyStatus(ctx, UpdateKeyStatusParams{Key: key, Status: KeyStatusCoolingDown, CooldownUntil: &until})
+}
+
+func (s *KeyService) ListKeys(ctx context.Context, params ListKeysParams) (*KeyList, error) {
+	return s.repo.ListKeys(ctx, params)
+}
+
+func (s *KeyService) GetKey(ctx context.Context, id int64) (*Key, error) {
+	return s.repo.GetKeyByID(ctx, id)
+}
+
+func (s *KeyService) UpdateKey(ctx context.Context, params UpdateKeyParams) (*Key, error) {
+	return s.repo.UpdateKey(ctx, params)
+}
+
+func (s *KeyService) DeleteKey(ctx context.Context, id int64) error {
+	return s.repo.DeleteKey(ctx, id)
+}
+
+// ReconcileKeyBalance replaces the balance of a key with the balance reported by upstream
+func (s *KeyService) ReconcileKeyBalance(ctx context.Context, key string, balance int64) (*BalanceDrift, error) {
+	return s.repo.ReconcileKeyBalance(ctx, key, balance)
+}
+
+// NextKeyAvailableAt returns when the first cooling down key can be used again, nil when no key is cooling down
+func (s *KeyService) NextKeyAvailableAt(ctx context.Context) (*time.Time, error) {
+	return s.repo.NextCooldownEnd(ctx)
 }
 
 func (s *KeyService) GetKeyStats(ctx context.Context) (*KeyStats, error) {
 	return s.repo.GetKeyStats(ctx)
 }
 
-func NewKeyService(repo KeyRepository) *KeyService {
-	return &KeyService{repo: repo}
+// GetKeyStatusStats returns the stats of the keys by status
+func (s *KeyService) GetKeyStatusStats(ctx context.Context) ([]KeyStatusStats, error) {
+	return s.repo.GetKeyStatusStats(ctx)
+}
+
+func NewKeyService(repo KeyRepository, validator KeyValidator, validateOnInsert bool) *KeyService {
+	return &KeyService{repo: repo, validator: validator, validateOnInsert: validateOnInsert}
 }
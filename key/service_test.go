@@ -3,6 +3,7 @@ package key
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -18,8 +19,8 @@ func (m *MockKeyRepository) InsertKey(ctx context.Context, params InsertKeyParam
 	return args.Error(0)
 }
 
-func (m *MockKeyRepository) UseBestKey(ctx context.Context) (*string, error) {
-	args := m.Called(ctx)
+func (m *MockKeyRepository) UseBestKey(ctx context.Context, exclude ...string) (*string, error) {
+	args := m.Called(ctx, exclude)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
@@ -27,14 +28,114 @@ func (m *MockKeyRepository) UseBestKey(ctx context.Context) (*string, error) {
 	return &key, args.Error(1)
 }
 
+func (m *MockKeyRepository) DeductKeyBalance(ctx context.Context, params DeductKeyBalanceParams) error {
+	args := m.Called(ctx, params)
+	return args.Error(0)
+}
+
+func (m *MockKeyRepository) UpdateKeyStatus(ctx context.Context, params UpdateKeyStatusParams) error {
+	args := m.Called(ctx, params)
+	return args.Error(0)
+}
+
+func (m *MockKeyRepository) GetAllKeys(ctx context.Context) ([]Key, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Key), args.Error(1)
+}
+
+func (m *MockKeyRepository) GetExistingKeys(ctx context.Context, keys []string) ([]string, error) {
+	args := m.Called(ctx, keys)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockKeyRepository) GetKey(ctx context.Context, key string) (*Key, error) {
+	args := m.Called(ctx, key)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Key), args.Error(1)
+}
+
+func (m *MockKeyRepository) ImportKeys(ctx context.Context, params []ImportKeyParams) ([]ImportKeyResult, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]ImportKeyResult), args.Error(1)
+}
+
+func (m *MockKeyRepository) ListKeys(ctx context.Context, params ListKeysParams) (*KeyList, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*KeyList), args.Error(1)
+}
+
+func (m *MockKeyRepository) GetKeyByID(ctx context.Context, id int64) (*Key, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Key), args.Error(1)
+}
+
+func (m *MockKeyRepository) UpdateKey(ctx context.Context, params UpdateKeyParams) (*Key, error) {
+	args := m.Called(ctx, params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Key), args.Error(1)
+}
+
+func (m *MockKeyRepository) DeleteKey(ctx context.Context, id int64) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockKeyRepository) ReconcileKeyBalance(ctx context.Context, key string, balance int64) (*BalanceDrift, error) {
+	args := m.Called(ctx, key, balance)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*BalanceDrift), args.Error(1)
+}
+
+func (m *MockKeyRepository) ApplyKeyUsage(ctx context.Context, usages []KeyUsage) error {
+	args := m.Called(ctx, usages)
+	return args.Error(0)
+}
+
 func (m *MockKeyRepository) GetKeyStats(ctx context.Context) (*KeyStats, error) {
 	args := m.Called(ctx)
 	return args.Get(0).(*KeyStats), args.Error(1)
 }
 
+func (m *MockKeyRepository) GetKeyStatusStats(ctx context.Context) ([]KeyStatusStats, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]KeyStatusStats), args.Error(1)
+}
+
+func (m *MockKeyRepository) NextCooldownEnd(ctx context.Context) (*time.Time, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*time.Time), args.Error(1)
+}
+
 func TestKeyService_InsertKey(t *testing.T) {
 	mockRepo := new(MockKeyRepository)
-	service := NewKeyService(mockRepo)
+	service := NewKeyService(mockRepo, nil, false)
 	ctx := context.Background()
 	params := InsertKeyParams{Key: "test-key"}
 
@@ -46,23 +147,32 @@ func TestKeyService_InsertKey(t *testing.T) {
 
 	// Test error handling
 	mockRepo = new(MockKeyRepository)
-	service = NewKeyService(mockRepo)
+	service = NewKeyService(mockRepo, nil, false)
 	expectedErr := assert.AnError
 	mockRepo.On("InsertKey", ctx, params).Return(expectedErr)
 	err = service.InsertKey(ctx, params)
 	assert.Error(t, err)
 	assert.Equal(t, expectedErr, err)
 	mockRepo.AssertExpectations(t)
+
+	// Malformed keys are not stored
+	mockRepo = new(MockKeyRepository)
+	service = NewKeyService(mockRepo, nil, false)
+	for _, key := range []string{"", "  ", "test key"} {
+		err = service.InsertKey(ctx, InsertKeyParams{Key: key})
+		assert.ErrorIs(t, err, ErrInvalidKey)
+	}
+	mockRepo.AssertNotCalled(t, "InsertKey", mock.Anything, mock.Anything)
 }
 
 func TestKeyService_UseBestKey(t *testing.T) {
 	mockRepo := new(MockKeyRepository)
-	service := NewKeyService(mockRepo)
+	service := NewKeyService(mockRepo, nil, false)
 	ctx := context.Background()
 	expectedKey := "best-key"
 
 	// Test successful retrieval
-	mockRepo.On("UseBestKey", ctx).Return(expectedKey, nil)
+	mockRepo.On("UseBestKey", ctx, []string(nil)).Return(expectedKey, nil)
 	key, err := service.UseBestKey(ctx)
 	assert.NoError(t, err)
 	assert.NotNil(t, key)
@@ -71,19 +181,110 @@ func TestKeyService_UseBestKey(t *testing.T) {
 
 	// Test error handling
 	mockRepo = new(MockKeyRepository)
-	service = NewKeyService(mockRepo)
+	service = NewKeyService(mockRepo, nil, false)
 	expectedErr := assert.AnError
-	mockRepo.On("UseBestKey", ctx).Return(nil, expectedErr)
+	mockRepo.On("UseBestKey", ctx, []string(nil)).Return(nil, expectedErr)
 	key, err = service.UseBestKey(ctx)
 	assert.Error(t, err)
 	assert.Nil(t, key)
 	assert.Equal(t, expectedErr, err)
 	mockRepo.AssertExpectations(t)
+
+	// Test excluded keys are passed to the repository
+	mockRepo = new(MockKeyRepository)
+	service = NewKeyService(mockRepo, nil, false)
+	mockRepo.On("UseBestKey", ctx, []string{"bad-key"}).Return(expectedKey, nil)
+	key, err = service.UseBestKey(ctx, "bad-key")
+	assert.NoError(t, err)
+	assert.Equal(t, expectedKey, *key)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyService_DeductKeyBalance(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	service := NewKeyService(mockRepo, nil, false)
+	ctx := context.Background()
+	params := DeductKeyBalanceParams{Key: "test-key", Amount: 42}
+
+	// Test successful deduction
+	mockRepo.On("DeductKeyBalance", ctx, params).Return(nil)
+	err := service.DeductKeyBalance(ctx, "test-key", 42)
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+
+	// Test error handling
+	mockRepo = new(MockKeyRepository)
+	service = NewKeyService(mockRepo, nil, false)
+	expectedErr := assert.AnError
+	mockRepo.On("DeductKeyBalance", ctx, params).Return(expectedErr)
+	err = service.DeductKeyBalance(ctx, "test-key", 42)
+	assert.Error(t, err)
+	assert.Equal(t, expectedErr, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyService_InvalidateKey(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	service := NewKeyService(mockRepo, nil, false)
+	ctx := context.Background()
+	params := UpdateKeyStatusParams{Key: "test-key", Status: KeyStatusInvalid}
+
+	// Test successful invalidation
+	mockRepo.On("UpdateKeyStatus", ctx, params).Return(nil)
+	err := service.InvalidateKey(ctx, "test-key")
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+
+	// Test error handling
+	mockRepo = new(MockKeyRepository)
+	service = NewKeyService(mockRepo, nil, false)
+	mockRepo.On("UpdateKeyStatus", ctx, params).Return(ErrKeyNotFound)
+	err = service.InvalidateKey(ctx, "test-key")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyService_ExhaustKey(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	service := NewKeyService(mockRepo, nil, false)
+	ctx := context.Background()
+	params := UpdateKeyStatusParams{Key: "test-key", Status: KeyStatusExhausted}
+
+	mockRepo.On("UpdateKeyStatus", ctx, params).Return(nil)
+	err := service.ExhaustKey(ctx, "test-key")
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyService_CooldownKey(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	service := NewKeyService(mockRepo, nil, false)
+	ctx := context.Background()
+	until := time.Now().Add(time.Minute)
+	params := UpdateKeyStatusParams{Key: "test-key", Status: KeyStatusCoolingDown, CooldownUntil: &until}
+
+	mockRepo.On("UpdateKeyStatus", ctx, params).Return(nil)
+	err := service.CooldownKey(ctx, "test-key", until)
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyService_NextKeyAvailableAt(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	service := NewKeyService(mockRepo, nil, false)
+	ctx := context.Background()
+	until := time.Now().Add(time.Minute)
+
+	mockRepo.On("NextCooldownEnd", ctx).Return(&until, nil)
+	next, err := service.NextKeyAvailableAt(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &until, next)
+	mockRepo.AssertExpectations(t)
 }
 
 func TestKeyService_GetKeyStats(t *testing.T) {
 	mockRepo := new(MockKeyRepository)
-	service := NewKeyService(mockRepo)
+	service := NewKeyService(mockRepo, nil, false)
 	ctx := context.Background()
 	expectedStats := &KeyStats{Count: 5, Balance: 10000}
 
@@ -96,7 +297,7 @@ func TestKeyService_GetKeyStats(t *testing.T) {
 
 	// Test error handling
 	mockRepo = new(MockKeyRepository)
-	service = NewKeyService(mockRepo)
+	service = NewKeyService(mockRepo, nil, false)
 	expectedErr := assert.AnError
 	mockRepo.On("GetKeyStats", ctx).Return(&KeyStats{}, expectedErr)
 	_, err = service.GetKeyStats(ctx)
@@ -104,3 +305,32 @@ func TestKeyService_GetKeyStats(t *testing.T) {
 	assert.Equal(t, expectedErr, err)
 	mockRepo.AssertExpectations(t)
 }
+
+func TestKeyService_ManageKeys(t *testing.T) {
+	mockRepo := new(MockKeyRepository)
+	service := NewKeyService(mockRepo, nil, false)
+	ctx := context.Background()
+	key := &Key{ID: 1, Key: "test-key", Balance: 100, Status: KeyStatusActive}
+	disabled := KeyStatusDisabled
+
+	listParams := ListKeysParams{Status: KeyStatusActive, Sort: "-balance", Limit: 10}
+	mockRepo.On("ListKeys", ctx, listParams).Return(&KeyList{Keys: []Key{*key}, Total: 1}, nil)
+	list, err := service.ListKeys(ctx, listParams)
+	assert.NoError(t, err)
+	assert.Equal(t, &KeyList{Keys: []Key{*key}, Total: 1}, list)
+
+	mockRepo.On("GetKeyByID", ctx, int64(1)).Return(key, nil)
+	got, err := service.GetKey(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, key, got)
+
+	updateParams := UpdateKeyParams{ID: 1, Status: &disabled}
+	mockRepo.On("UpdateKey", ctx, updateParams).Return(key, nil)
+	_, err = service.UpdateKey(ctx, updateParams)
+	assert.NoError(t, err)
+
+	mockRepo.On("DeleteKey", ctx, int64(1)).Return(ErrKeyNotFound)
+	err = service.DeleteKey(ctx, 1)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	mockRepo.AssertExpectations(t)
+}
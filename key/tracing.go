@@ -0,0 +1,154 @@
+package key
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the instrumentation name of the key spans
+const TracerName = "github.com/trancong12102/jina-http-proxy/key"
+
+// tracedKeyRepository records a span for every call to its repository
+type tracedKeyRepository struct {
+	repo   KeyRepository
+	tracer trace.Tracer
+}
+
+// Check if tracedKeyRepository implements KeyRepository
+var _ KeyRepository = &tracedKeyRepository{}
+
+// start starts the span of a repository call
+func (r *tracedKeyRepository) start(ctx context.Context, operation string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	attributes = append(attributes,
+		attribute.String("db.system.name", "postgresql"),
+		attribute.String("db.operation.name", operation),
+	)
+	return r.tracer.Start(ctx, "KeyRepository."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...),
+	)
+}
+
+// endRepositorySpan records the error of a repository call and ends its span.
+// No selectable key and unknown keys are expected outcomes, they are not marked as errors.
+func endRepositorySpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) && !errors.Is(err, ErrKeyNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (r *tracedKeyRepository) InsertKey(ctx context.Context, params InsertKeyParams) (err error) {
+	ctx, span := r.start(ctx, "InsertKey")
+	defer func() { endRepositorySpan(span, err) }()
+	return r.repo.InsertKey(ctx, params)
+}
+
+func (r *tracedKeyRepository) ImportKeys(ctx context.Context, params []ImportKeyParams) (_ []ImportKeyResult, err error) {
+	ctx, span := r.start(ctx, "ImportKeys", attribute.Int("key.count", len(params)))
+	defer func() { endRepositorySpan(span, err) }()
+	return r.repo.ImportKeys(ctx, params)
+}
+
+func (r *tracedKeyRepository) UseBestKey(ctx context.Context, exclude ...string) (_ *string, err error) {
+	ctx, span := r.start(ctx, "UseBestKey", attribute.Int("key.excluded", len(exclude)))
+	defer func() { endRepositorySpan(span, err) }()
+	return r.repo.UseBestKey(ctx, exclude...)
+}
+
+func (r *tracedKeyRepository) DeductKeyBalance(ctx context.Context, params DeductKeyBalanceParams) (err error) {
+	ctx, span := r.start(ctx, "DeductKeyBalance", attribute.Int64("key.amount", params.Amount))
+	defer func() { endRepositorySpan(span, err) }()
+	return r.repo.DeductKeyBalance(ctx, params)
+}
+
+func (r *tracedKeyRepository) UpdateKeyStatus(ctx context.Context, params UpdateKeyStatusParams) (err error) {
+	ctx, span := r.start(ctx, "UpdateKeyStatus", attribute.String("key.status", string(params.Status)))
+	defer func() { endRepositorySpan(span, err) }()
+	return r.repo.UpdateKeyStatus(ctx, params)
+}
+
+func (r *tracedKeyRepository) GetAllKeys(ctx context.Context) (_ []Key, err error) {
+	ctx, span := r.start(ctx, "GetAllKeys")
+	defer func() { endRepositorySpan(span, err) }()
+	return r.repo.GetAllKeys(ctx)
+}
+
+func (r *tracedKeyRepository) GetKey(ctx context.Context, key string) (_ *Key, err error) {
+	ctx, span := r.start(ctx, "GetKey")
+	defer func() { endRepositorySpan(span, err) }()
+	return r.repo.GetKey(ctx, key)
+}
+
+func (r *tracedKeyRepository) GetExistingKeys(ctx context.Context, keys []string) (_ []string, err error) {
+	ctx, span := r.start(ctx, "GetExistingKeys", attribute.Int("key.count", len(keys)))
+	defer func() { endRepositorySpan(span, err) }()
+	return r.repo.GetExistingKeys(ctx, keys)
+}
+
+func (r *tracedKeyRepository) ListKeys(ctx context.Context, params ListKeysParams) (_ *KeyList, err error) {
+	ctx, span := r.start(ctx, "ListKeys")
+	defer func() { endRepositorySpan(span, err) }()
+	return r.repo.ListKeys(ctx, params)
+}
+
+func (r *tracedKeyRepository) GetKeyByID(ctx context.Context, id int64) (_ *Key, err error) {
+	ctx, span := r.start(ctx, "GetKeyByID", attribute.Int64("key.id", id))
+	defer func() { endRepositorySpan(span, err) }()
+	return r.repo.GetKeyByID(ctx, id)
+}
+
+func (r *tracedKeyRepository) UpdateKey(ctx context.Context, params UpdateKeyParams) (_ *Key, err error) {
+	ctx, span := r.start(ctx, "UpdateKey", attribute.Int64("key.id", params.ID))
+	defer func() { endRepositorySpan(span, err) }()
+	return r.repo.UpdateKey(ctx, params)
+}
+
+func (r *tracedKeyRepository) DeleteKey(ctx context.Context, id int64) (err error) {
+	ctx, span := r.start(ctx, "DeleteKey", attribute.Int64("key.id", id))
+	defer func() { endRepositorySpan(span, err) }()
+	return r.repo.DeleteKey(ctx, id)
+}
+
+func (r *tracedKeyRepository) ApplyKeyUsage(ctx context.Context, usages []KeyUsage) (err error) {
+	ctx, span := r.start(ctx, "ApplyKeyUsage", attribute.Int("key.count", len(usages)))
+	defer func() { endRepositorySpan(span, err) }()
+	return r.repo.ApplyKeyUsage(ctx, usages)
+}
+
+func (r *tracedKeyRepository) ReconcileKeyBalance(ctx context.Context, key string, balance int64) (_ *BalanceDrift, err error) {
+	ctx, span := r.start(ctx, "ReconcileKeyBalance")
+	defer func() { endRepositorySpan(span, err) }()
+	return r.repo.ReconcileKeyBalance(ctx, key, balance)
+}
+
+func (r *tracedKeyRepository) GetKeyStats(ctx context.Context) (_ *KeyStats, err error) {
+	ctx, span := r.start(ctx, "GetKeyStats")
+	defer func() { endRepositorySpan(span, err) }()
+	return r.repo.GetKeyStats(ctx)
+}
+
+func (r *tracedKeyRepository) GetKeyStatusStats(ctx context.Context) (_ []KeyStatusStats, err error) {
+	ctx, span := r.start(ctx, "GetKeyStatusStats")
+	defer func() { endRepositorySpan(span, err) }()
+	return r.repo.GetKeyStatusStats(ctx)
+}
+
+func (r *tracedKeyRepository) NextCooldownEnd(ctx context.Context) (_ *time.Time, err error) {
+	ctx, span := r.start(ctx, "NextCooldownEnd")
+	defer func() { endRepositorySpan(span, err) }()
+	return r.repo.NextCooldownEnd(ctx)
+}
+
+// NewTracedKeyRepository returns a repository recording a span for every call to the repository,
+// with the tracer of the tracer provider
+func NewTracedKeyRepository(repo KeyRepository, tracerProvider trace.TracerProvider) KeyRepository {
+	return &tracedKeyRepository{repo: repo, tracer: tracerProvider.Tracer(TracerName)}
+}
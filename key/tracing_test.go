@@ -0,0 +1,64 @@
+package key
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracedKeyRepository(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	mockRepo := new(MockKeyRepository)
+	repo := NewTracedKeyRepository(mockRepo, tracerProvider)
+
+	// The repository receives the context of the span
+	mockRepo.On("UseBestKey", mock.MatchedBy(func(ctx context.Context) bool {
+		return trace.SpanFromContext(ctx).SpanContext().IsValid()
+	}), []string{"key-1"}).Return("key-2", nil).Once()
+	mockRepo.On("UseBestKey", mock.Anything, []string(nil)).Return(nil, sql.ErrNoRows).Once()
+	mockRepo.On("GetKeyByID", mock.Anything, int64(1)).Return(nil, assert.AnError).Once()
+
+	key, err := repo.UseBestKey(context.Background(), "key-1")
+	require.NoError(t, err)
+	assert.Equal(t, "key-2", *key)
+
+	_, err = repo.UseBestKey(context.Background())
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = repo.GetKeyByID(context.Background(), 1)
+	assert.ErrorIs(t, err, assert.AnError)
+	mockRepo.AssertExpectations(t)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+
+	tests := []struct {
+		name       string
+		attribute  attribute.KeyValue
+		statusCode codes.Code
+	}{
+		{name: "KeyRepository.UseBestKey", attribute: attribute.Int("key.excluded", 1), statusCode: codes.Unset},
+		// No selectable key is not an error
+		{name: "KeyRepository.UseBestKey", attribute: attribute.Int("key.excluded", 0), statusCode: codes.Unset},
+		{name: "KeyRepository.GetKeyByID", attribute: attribute.Int64("key.id", 1), statusCode: codes.Error},
+	}
+	for i, tt := range tests {
+		span := spans[i]
+		assert.Equal(t, tt.name, span.Name())
+		assert.Equal(t, trace.SpanKindClient, span.SpanKind())
+		assert.Contains(t, span.Attributes(), tt.attribute)
+		assert.Contains(t, span.Attributes(), attribute.String("db.system.name", "postgresql"))
+		assert.Equal(t, tt.statusCode, span.Status().Code)
+	}
+}
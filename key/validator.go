@@ -0,0 +1,268 @@
+package key
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+type KeyValidationResult string
+
+const (
+	KeyValidationResultValid   KeyValidationResult = "valid"
+	KeyValidationResultInvalid KeyValidationResult = "invalid"
+	// KeyValidationResultUnknown means upstream could not tell, for example when it is unreachable
+	KeyValidationResultUnknown KeyValidationResult = "unknown"
+)
+
+// KeyValidation is the outcome of checking a key against upstream.
+// Balance is set when upstream reports the remaining balance of a valid key.
+type KeyValidation struct {
+	Result  KeyValidationResult
+	Balance *int64
+}
+
+// KeyValidator checks whether upstream accepts a key
+type KeyValidator interface {
+	Validate(ctx context.Context, key string) (*KeyValidation, error)
+}
+
+// UpstreamKeyValidator validates keys by calling a cheap upstream endpoint with the key.
+// The key is sent as a bearer token, and replaces the {key} placeholder of the URL if any.
+type UpstreamKeyValidator struct {
+	url    string
+	client *http.Client
+}
+
+// Check if UpstreamKeyValidator implements KeyValidator
+var _ KeyValidator = &UpstreamKeyValidator{}
+
+// Validate classifies the key from the upstream response status.
+// Errors reaching upstream are not returned, the key is classified as unknown instead.
+func (v *UpstreamKeyValidator) Validate(ctx context.Context, key string) (*KeyValidation, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.ReplaceAll(v.url, "{key}", url.QueryEscape(key)), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		slog.Warn("validate key", slog.String("key", MaskKey(key)), slog.Any("error", withoutURL(err)))
+		return &KeyValidation{Result: KeyValidationResultUnknown}, nil
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return &KeyValidation{Result: KeyValidationResultValid, Balance: parseValidationBalance(resp.Body)}, nil
+	case http.StatusPaymentRequired:
+		// Upstream knows the key but it has no balance left
+		balance := int64(0)
+		return &KeyValidation{Result: KeyValidationResultValid, Balance: &balance}, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return &KeyValidation{Result: KeyValidationResultInvalid}, nil
+	default:
+		slog.Warn("validate key", slog.String("key", MaskKey(key)), slog.Int("status", resp.StatusCode))
+		return &KeyValidation{Result: KeyValidationResultUnknown}, nil
+	}
+}
+
+// withoutURL strips the request URL from the errors of the HTTP client,
+// as the validation URL can carry the key in its query
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
+
+// parseValidationBalance reads the balance from wallet.total_balance, total_balance or balance
+func parseValidationBalance(body io.Reader) *int64 {
+	var payload struct {
+		Wallet *struct {
+			TotalBalance *float64 `json:"total_balance"`
+		} `json:"wallet"`
+		TotalBalance *float64 `json:"total_balance"`
+		Balance      *float64 `json:"balance"`
+	}
+	if err := json.NewDecoder(body).Decode(&payload); err != nil {
+		return nil
+	}
+
+	var balance *float64
+	switch {
+	case payload.Wallet != nil && payload.Wallet.TotalBalance != nil:
+		balance = payload.Wallet.TotalBalance
+	case payload.TotalBalance != nil:
+		balance = payload.TotalBalance
+	case payload.Balance != nil:
+		balance = payload.Balance
+	default:
+		return nil
+	}
+
+	value := max(int64(*balance), 0)
+	return &value
+}
+
+func NewUpstreamKeyValidator(url string, client *http.Client) *UpstreamKeyValidator {
+	return &UpstreamKeyValidator{url: url, client: client}
+}
+
+// validatedInsertParams validates the key to insert and sets its balance to the one reported by upstream.
+// A key rejected by upstream returns ErrKeyRejected, a key upstream cannot classify is inserted as is.
+func validatedInsertParams(ctx context.Context, validator KeyValidator, params InsertKeyParams) (InsertKeyParams, error) {
+	validation, err := validator.Validate(ctx, params.Key)
+	if err != nil {
+		return params, err
+	}
+
+	switch validation.Result {
+	case KeyValidationResultInvalid:
+		return params, fmt.Errorf("%w: %s", ErrKeyRejected, MaskKey(params.Key))
+	case KeyValidationResultValid:
+		if validation.Balance != nil {
+			params.Balance = validation.Balance
+		}
+	}
+
+	return params, nil
+}
+
+// importValidationConcurrency is the number of keys validated against upstream at a time during an import
+const importValidationConcurrency = 8
+
+// importValidatedKeys validates the keys to import and imports the keys not rejected by upstream,
+// with the balance reported by upstream. Keys already stored, or repeated in the import, are reported
+// as duplicates without querying upstream. Keys rejected by upstream are reported as invalid,
+// the results are in the order of the params.
+func importValidatedKeys(ctx context.Context, validator KeyValidator, repo KeyRepository, params []ImportKeyParams) ([]ImportKeyResult, error) {
+	keys := make([]string, 0, len(params))
+	for _, param := range params {
+		keys = append(keys, param.Key)
+	}
+	existing, err := repo.GetExistingKeys(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(params))
+	for _, key := range existing {
+		seen[key] = true
+	}
+
+	results := make([]ImportKeyResult, len(params))
+	validated := make([]*InsertKeyParams, len(params))
+	errGroup, groupCtx := errgroup.WithContext(ctx)
+	errGroup.SetLimit(importValidationConcurrency)
+	for i, param := range params {
+		if seen[param.Key] {
+			results[i] = ImportKeyResult{Key: param.Key, Status: ImportKeyStatusDuplicate}
+			continue
+		}
+		seen[param.Key] = true
+
+		errGroup.Go(func() error {
+			insertParams, err := validatedInsertParams(groupCtx, validator, InsertKeyParams{Key: param.Key, Balance: &param.Balance})
+			if errors.Is(err, ErrKeyRejected) {
+				results[i] = ImportKeyResult{Key: param.Key, Status: ImportKeyStatusInvalid, Err: err}
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			validated[i] = &insertParams
+			return nil
+		})
+	}
+	err = errGroup.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	accepted := make([]ImportKeyParams, 0, len(params))
+	indexes := make([]int, 0, len(params))
+	for i, insertParams := range validated {
+		if insertParams == nil {
+			continue
+		}
+
+		param := params[i]
+		param.Balance = *insertParams.Balance
+		accepted = append(accepted, param)
+		indexes = append(indexes, i)
+	}
+
+	if len(accepted) == 0 {
+		return results, nil
+	}
+
+	imported, err := repo.ImportKeys(ctx, accepted)
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range imported {
+		results[indexes[i]] = result
+	}
+
+	return results, nil
+}
+
+// validatedStatus returns the status of a key in the given status after a validation, or nil if it does not change.
+// A key without balance is exhausted, a valid key with balance again is active, and a rejected key is invalid.
+// A disabled key keeps its status, operators enable it explicitly.
+func validatedStatus(current KeyStatus, validation *KeyValidation) *KeyStatus {
+	status := current
+	switch validation.Result {
+	case KeyValidationResultValid:
+		switch {
+		case validation.Balance != nil && *validation.Balance == 0:
+			status = KeyStatusExhausted
+		case current == KeyStatusInvalid, current == KeyStatusExhausted && validation.Balance != nil:
+			status = KeyStatusActive
+		}
+	case KeyValidationResultInvalid:
+		status = KeyStatusInvalid
+	}
+	if status == current || current == KeyStatusDisabled || !current.CanTransitionTo(status) {
+		return nil
+	}
+	return &status
+}
+
+// validationUpdate returns the update applying a validation to a key, or nil if nothing changes
+func validationUpdate(key *Key, validation *KeyValidation) *UpdateKeyParams {
+	update := UpdateKeyParams{ID: key.ID, Balance: validation.Balance, Status: validatedStatus(key.Status, validation)}
+	if update.Balance == nil && update.Status == nil {
+		return nil
+	}
+	return &update
+}
+
+// validateStoredKey validates a stored key and applies the outcome with the update function
+func validateStoredKey(ctx context.Context, validator KeyValidator, key *Key, update func(context.Context, UpdateKeyParams) (*Key, error)) (*ValidatedKey, error) {
+	validation, err := validator.Validate(ctx, key.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	params := validationUpdate(key, validation)
+	if params != nil {
+		key, err = update(ctx, *params)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &ValidatedKey{Key: *key, Validation: *validation}, nil
+}
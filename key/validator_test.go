@@ -0,0 +1,281 @@
+package key
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeJinaServer starts a server answering like the Jina dashboard for the configured keys
+func newFakeJinaServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("api_key")
+		if r.Header.Get("Authorization") != "Bearer "+key {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch key {
+		case "valid-key":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"wallet":{"total_balance":12345.0}}`))
+		case "valid-key-without-balance":
+			w.WriteHeader(http.StatusOK)
+		case "empty-key":
+			w.WriteHeader(http.StatusPaymentRequired)
+		case "slow-key":
+			time.Sleep(200 * time.Millisecond)
+		case "broken-key":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestUpstreamKeyValidator_Validate(t *testing.T) {
+	server := newFakeJinaServer(t)
+	validator := NewUpstreamKeyValidator(server.URL+"/api/v1/api_key/fe/user?api_key={key}", &http.Client{Timeout: 50 * time.Millisecond})
+
+	balance := int64(12345)
+	zero := int64(0)
+	tests := []struct {
+		key      string
+		expected KeyValidation
+	}{
+		{key: "valid-key", expected: KeyValidation{Result: KeyValidationResultValid, Balance: &balance}},
+		{key: "valid-key-without-balance", expected: KeyValidation{Result: KeyValidationResultValid}},
+		{key: "empty-key", expected: KeyValidation{Result: KeyValidationResultValid, Balance: &zero}},
+		{key: "revoked-key", expected: KeyValidation{Result: KeyValidationResultInvalid}},
+		{key: "broken-key", expected: KeyValidation{Result: KeyValidationResultUnknown}},
+		{key: "slow-key", expected: KeyValidation{Result: KeyValidationResultUnknown}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.key, func(t *testing.T) {
+			validation, err := validator.Validate(context.Background(), tc.key)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, *validation)
+		})
+	}
+}
+
+func TestUpstreamKeyValidator_Validate_LogsNoKey(t *testing.T) {
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	// Nothing listens on the port, the request fails before any response
+	validator := NewUpstreamKeyValidator("http://127.0.0.1:1/api/v1/api_key/fe/user?api_key={key}", &http.Client{Timeout: time.Second})
+
+	validation, err := validator.Validate(context.Background(), "secret-key-1234567890")
+	require.NoError(t, err)
+	assert.Equal(t, KeyValidationResultUnknown, validation.Result)
+	assert.Contains(t, logs.String(), "validate key")
+	assert.NotContains(t, logs.String(), "secret-key-1234567890")
+}
+
+func TestKeyService_InsertKey_Validation(t *testing.T) {
+	server := newFakeJinaServer(t)
+	validator := NewUpstreamKeyValidator(server.URL+"?api_key={key}", http.DefaultClient)
+	ctx := context.Background()
+	balance := int64(12345)
+
+	mockRepo := new(MockKeyRepository)
+	service := NewKeyService(mockRepo, validator, true)
+
+	// Valid key is inserted with the balance reported by upstream
+	mockRepo.On("InsertKey", ctx, InsertKeyParams{Key: "valid-key", Balance: &balance}).Return(nil)
+	assert.NoError(t, service.InsertKey(ctx, InsertKeyParams{Key: "valid-key"}))
+
+	// Unknown key is inserted as is
+	mockRepo.On("InsertKey", ctx, InsertKeyParams{Key: "broken-key"}).Return(nil)
+	assert.NoError(t, service.InsertKey(ctx, InsertKeyParams{Key: "broken-key"}))
+
+	// Invalid key is rejected
+	assert.ErrorIs(t, service.InsertKey(ctx, InsertKeyParams{Key: "revoked-key"}), ErrKeyRejected)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyService_ValidateKey(t *testing.T) {
+	server := newFakeJinaServer(t)
+	validator := NewUpstreamKeyValidator(server.URL+"?api_key={key}", http.DefaultClient)
+	ctx := context.Background()
+	balance := int64(12345)
+	zero := int64(0)
+	active := KeyStatusActive
+	invalid := KeyStatusInvalid
+	exhausted := KeyStatusExhausted
+
+	tests := []struct {
+		name           string
+		key            Key
+		expectedUpdate *UpdateKeyParams
+	}{
+		{
+			name:           "Valid key gets the reported balance",
+			key:            Key{ID: 1, Key: "valid-key", Balance: 100, Status: KeyStatusActive},
+			expectedUpdate: &UpdateKeyParams{ID: 1, Balance: &balance},
+		},
+		{
+			name:           "Valid exhausted key is active again",
+			key:            Key{ID: 1, Key: "valid-key", Balance: 0, Status: KeyStatusExhausted},
+			expectedUpdate: &UpdateKeyParams{ID: 1, Balance: &balance, Status: &active},
+		},
+		{
+			name:           "Valid key without balance is exhausted",
+			key:            Key{ID: 1, Key: "empty-key", Balance: 100, Status: KeyStatusActive},
+			expectedUpdate: &UpdateKeyParams{ID: 1, Balance: &zero, Status: &exhausted},
+		},
+		{
+			name:           "Revoked key is invalidated",
+			key:            Key{ID: 1, Key: "revoked-key", Balance: 100, Status: KeyStatusActive},
+			expectedUpdate: &UpdateKeyParams{ID: 1, Status: &invalid},
+		},
+		{
+			name: "Disabled key keeps its status",
+			key:  Key{ID: 1, Key: "revoked-key", Balance: 100, Status: KeyStatusDisabled},
+		},
+		{
+			name: "Unknown result changes nothing",
+			key:  Key{ID: 1, Key: "broken-key", Balance: 100, Status: KeyStatusActive},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockKeyRepository)
+			service := NewKeyService(mockRepo, validator, false)
+
+			mockRepo.On("GetKeyByID", ctx, int64(1)).Return(&tc.key, nil)
+			if tc.expectedUpdate != nil {
+				mockRepo.On("UpdateKey", ctx, *tc.expectedUpdate).Return(&tc.key, nil)
+			}
+
+			validated, err := service.ValidateKey(ctx, 1)
+			require.NoError(t, err)
+			assert.Equal(t, tc.key.ID, validated.Key.ID)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestKeyPool_ValidateKey_FlushesUsage(t *testing.T) {
+	server := newFakeJinaServer(t)
+	validator := NewUpstreamKeyValidator(server.URL+"?api_key={key}", http.DefaultClient)
+	ctx := context.Background()
+	balance := int64(12345)
+
+	mockRepo := new(MockKeyRepository)
+	mockRepo.On("GetAllKeys", mock.Anything).Return([]Key{
+		{ID: 1, Key: "valid-key", Balance: 100, Status: KeyStatusActive},
+	}, nil).Once()
+	pool := NewKeyPool(mockRepo, lessSelector(newestFirst), validator, false, time.Second, time.Minute)
+	require.NoError(t, pool.Load(ctx))
+	require.NoError(t, pool.DeductKeyBalance(ctx, "valid-key", 30))
+
+	// Usage is flushed before the balance is replaced, so it is not deducted from the upstream balance
+	flush := mockRepo.On("ApplyKeyUsage", ctx, []KeyUsage{{Key: "valid-key", Amount: 30}}).Return(nil).Once()
+	mockRepo.On("GetKeyByID", ctx, int64(1)).
+		Return(&Key{ID: 1, Key: "valid-key", Balance: 70, Status: KeyStatusActive}, nil).Once().
+		NotBefore(flush)
+	mockRepo.On("UpdateKey", ctx, UpdateKeyParams{ID: 1, Balance: &balance}).
+		Return(&Key{ID: 1, Key: "valid-key", Balance: balance, Status: KeyStatusActive}, nil).Once()
+	mockRepo.On("GetKey", ctx, "valid-key").
+		Return(&Key{ID: 1, Key: "valid-key", Balance: balance, Status: KeyStatusActive}, nil).Once()
+
+	validated, err := pool.ValidateKey(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, balance, validated.Key.Balance)
+	assert.Equal(t, balance, pool.keys["valid-key"].Balance)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyService_ImportKeys_Validation(t *testing.T) {
+	server := newFakeJinaServer(t)
+	validator := NewUpstreamKeyValidator(server.URL+"?api_key={key}", http.DefaultClient)
+	ctx := context.Background()
+
+	mockRepo := new(MockKeyRepository)
+	service := NewKeyService(mockRepo, validator, true)
+
+	// Valid key gets the balance reported by upstream, unknown key is imported as is,
+	// and the rejected key is not imported. The stored key and the repeated key are duplicates
+	// without validation, upstream would reject the stored key.
+	mockRepo.On("GetExistingKeys", ctx, []string{"valid-key", "revoked-key", "stored-key", "broken-key", "valid-key"}).
+		Return([]string{"stored-key"}, nil).Once()
+	mockRepo.On("ImportKeys", ctx, []ImportKeyParams{
+		{Key: "valid-key", Balance: 12345, Label: "team-a"},
+		{Key: "broken-key", Balance: 100},
+	}).Return([]ImportKeyResult{
+		{ID: 1, Key: "valid-key", Status: ImportKeyStatusInserted},
+		{Key: "broken-key", Status: ImportKeyStatusDuplicate},
+	}, nil).Once()
+
+	results, err := service.ImportKeys(ctx, []ImportKeyParams{
+		{Key: "valid-key", Balance: 100, Label: "team-a"},
+		{Key: "revoked-key", Balance: 100},
+		{Key: "stored-key", Balance: 100},
+		{Key: "broken-key", Balance: 100},
+		{Key: "valid-key", Balance: 100},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 5)
+	assert.Equal(t, ImportKeyResult{ID: 1, Key: "valid-key", Status: ImportKeyStatusInserted}, results[0])
+	assert.Equal(t, ImportKeyStatusInvalid, results[1].Status)
+	assert.ErrorIs(t, results[1].Err, ErrKeyRejected)
+	assert.Equal(t, ImportKeyResult{Key: "stored-key", Status: ImportKeyStatusDuplicate}, results[2])
+	assert.Equal(t, ImportKeyResult{Key: "broken-key", Status: ImportKeyStatusDuplicate}, results[3])
+	assert.Equal(t, ImportKeyResult{Key: "valid-key", Status: ImportKeyStatusDuplicate}, results[4])
+
+	// Nothing is imported when every key is rejected
+	mockRepo.On("GetExistingKeys", ctx, []string{"revoked-key"}).Return([]string{}, nil).Once()
+	results, err = service.ImportKeys(ctx, []ImportKeyParams{{Key: "revoked-key", Balance: 100}})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, ImportKeyStatusInvalid, results[0].Status)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestKeyPool_ImportKeys_Validation(t *testing.T) {
+	server := newFakeJinaServer(t)
+	validator := NewUpstreamKeyValidator(server.URL+"?api_key={key}", http.DefaultClient)
+	ctx := context.Background()
+
+	mockRepo := new(MockKeyRepository)
+	mockRepo.On("GetAllKeys", mock.Anything).Return([]Key{}, nil).Once()
+	pool := NewKeyPool(mockRepo, lessSelector(newestFirst), validator, true, time.Second, time.Minute)
+	require.NoError(t, pool.Load(ctx))
+
+	mockRepo.On("GetExistingKeys", ctx, []string{"revoked-key", "valid-key"}).Return([]string{}, nil).Once()
+	mockRepo.On("ImportKeys", ctx, []ImportKeyParams{{Key: "valid-key", Balance: 12345}}).
+		Return([]ImportKeyResult{{ID: 1, Key: "valid-key", Status: ImportKeyStatusInserted}}, nil).Once()
+	mockRepo.On("GetAllKeys", mock.Anything).
+		Return([]Key{{ID: 1, Key: "valid-key", Balance: 12345, Status: KeyStatusActive}}, nil).Once()
+
+	results, err := pool.ImportKeys(ctx, []ImportKeyParams{
+		{Key: "revoked-key", Balance: 100},
+		{Key: "valid-key", Balance: 100},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, ImportKeyStatusInvalid, results[0].Status)
+	assert.Equal(t, ImportKeyStatusInserted, results[1].Status)
+	assert.Contains(t, pool.keys, "valid-key")
+	assert.NotContains(t, pool.keys, "revoked-key")
+	mockRepo.AssertExpectations(t)
+}
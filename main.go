@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -17,28 +18,95 @@ import (
 	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/pressly/goose/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/trancong12102/jina-http-proxy/apitoken"
+	"github.com/trancong12102/jina-http-proxy/client"
 	"github.com/trancong12102/jina-http-proxy/config"
 	"github.com/trancong12102/jina-http-proxy/key"
 	"github.com/trancong12102/jina-http-proxy/proxy"
 )
 
-const (
-	ReadHeaderTimeout = 5 * time.Second
-	ProxyListenAddr   = ":5555"
-	ApiListenAddr     = ":5556"
-)
+// keyProvider is implemented by key.KeyService and key.KeyPool
+type keyProvider interface {
+	proxy.KeyGetter
+	key.KeyBiz
+	key.BalanceStore
+	key.KeyStatusStatsGetter
+}
+
+// clientAuthenticator authenticates proxy clients with the client service
+type clientAuthenticator struct {
+	service *client.ClientService
+}
 
-func runSrv() error {
+func (a clientAuthenticator) AuthenticateClient(ctx context.Context, token string) (*proxy.Client, error) {
+	authenticated, err := a.service.AuthenticateClient(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return &proxy.Client{ID: authenticated.ID, Name: authenticated.Name}, nil
+}
+
+// clientBudgets enforces the budgets of proxy clients with the client service
+type clientBudgets struct {
+	service *client.ClientService
+}
+
+func (b clientBudgets) CheckClientBudget(ctx context.Context, clientID int64) (*proxy.BudgetExceeded, error) {
+	exceeded, err := b.service.CheckClientBudget(ctx, clientID)
+	if errors.Is(err, client.ErrClientNotFound) || errors.Is(err, client.ErrClientDisabled) {
+		return nil, fmt.Errorf("%w: %w", proxy.ErrClientRevoked, err)
+	}
+	if err != nil || exceeded == nil {
+		return nil, err
+	}
+	return &proxy.BudgetExceeded{
+		Period:   string(exceeded.Period),
+		Limit:    exceeded.Limit,
+		Used:     exceeded.Used,
+		ResetsAt: exceeded.ResetsAt,
+	}, nil
+}
+
+func (b clientBudgets) DebitClientUsage(ctx context.Context, clientID int64, tokens int64) error {
+	return b.service.DebitClientUsage(ctx, clientID, tokens)
+}
+
+// KeyListenerReconnectInterval is the delay before listening for key changes again after a failure
+const KeyListenerReconnectInterval = 5 * time.Second
+
+func runSrv(serverConfig *config.Config, args []string) error {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	// Catch SIGHUP first, it would terminate the process until the config reloader runs
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
 	errGroup, ctx := errgroup.WithContext(ctx)
 
-	// Load config
-	serverConfig, err := config.LoadConfig()
+	// Create logger
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(serverConfig.LogLevel)
+	slog.SetDefault(newLogger(serverConfig.LogFormat, logLevel))
+
+	// Create tracer provider
+	tracerProvider, shutdownTracing, err := newTracerProvider(ctx, serverConfig)
 	if err != nil {
-		return fmt.Errorf("load config: %w", err)
+		return err
 	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverConfig.ShutdownTimeout)
+		defer cancel()
+
+		shutdownErr := shutdownTracing(shutdownCtx)
+		if shutdownErr != nil {
+			slog.Warn("tracing shutdown", slog.Any("error", shutdownErr))
+		}
+	}()
 
 	// Create database
 	db, err := sql.Open("pgx", serverConfig.DatabaseURL)
@@ -46,6 +114,10 @@ func runSrv() error {
 		return fmt.Errorf("open database: %w", err)
 	}
 	defer db.Close()
+	db.SetMaxOpenConns(serverConfig.DatabaseMaxOpenConns)
+	db.SetMaxIdleConns(serverConfig.DatabaseMaxIdleConns)
+	db.SetConnMaxLifetime(serverConfig.DatabaseConnMaxLifetime)
+	db.SetConnMaxIdleTime(serverConfig.DatabaseConnMaxIdleTime)
 
 	// Run migrations
 	err = goose.Up(db, serverConfig.MigrationDir)
@@ -53,38 +125,170 @@ func runSrv() error {
 		return fmt.Errorf("run migrations: %w", err)
 	}
 
+	// Create metrics registry
+	metricsRegistry := prometheus.NewRegistry()
+	metricsRegistry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	// Create key metrics
+	keyMetrics := key.NewMetrics(metricsRegistry)
+
+	// Create key selector
+	keySelector, err := key.NewSwappableSelector(serverConfig.KeySelectionStrategy)
+	if err != nil {
+		return fmt.Errorf("create key selector: %w", err)
+	}
+
 	// Create key repository
-	keyRepository := key.NewKeyDBRepository(db)
+	keyRepository := key.NewTracedKeyRepository(key.NewKeyDBRepository(db, keySelector, key.WithMetrics(keyMetrics)), tracerProvider)
+
+	// Create key validator
+	keyValidator := key.NewUpstreamKeyValidator(serverConfig.KeyValidationURL, &http.Client{Timeout: serverConfig.KeyValidationTimeout})
 
 	// Create key service
-	keyService := key.NewKeyService(keyRepository)
+	var keyService keyProvider = key.NewKeyService(keyRepository, keyValidator, serverConfig.KeyValidateOnInsert)
+	if serverConfig.KeyPoolEnabled {
+		keyPool := key.NewKeyPool(keyRepository, keyMetrics.InstrumentSelector(keySelector), keyValidator, serverConfig.KeyValidateOnInsert, serverConfig.KeyPoolFlushInterval, serverConfig.KeyPoolReloadInterval)
+		err = keyPool.Load(ctx)
+		if err != nil {
+			return fmt.Errorf("load key pool: %w", err)
+		}
+
+		// Run key pool
+		errGroup.Go(func() error {
+			slog.Info("key pool started", slog.Duration("flush_interval", serverConfig.KeyPoolFlushInterval))
+			return keyPool.Run(ctx)
+		})
+
+		// Listen for key changes made by other instances
+		keyListener := key.NewKeyListener(serverConfig.DatabaseURL, keyPool, KeyListenerReconnectInterval)
+		errGroup.Go(func() error {
+			slog.Info("key listener started", slog.String("channel", key.KeyChangesChannel))
+			return keyListener.Run(ctx)
+		})
+
+		keyService = keyPool
+	}
+
+	// Run balance refresher
+	if serverConfig.BalanceRefreshEnabled {
+		balanceRefresher := key.NewBalanceRefresher(keyService, keyValidator, serverConfig.BalanceRefreshInterval, serverConfig.BalanceRefreshJitter, serverConfig.BalanceRefreshConcurrency)
+		errGroup.Go(func() error {
+			slog.Info("balance refresher started", slog.Duration("interval", serverConfig.BalanceRefreshInterval))
+			return balanceRefresher.Run(ctx)
+		})
+	}
+
+	// Register key stats metrics
+	metricsRegistry.MustRegister(key.NewStatsCollector(keyService))
 
 	// Create key handler
 	keyHandler := key.NewKeyHandler(keyService)
 
+	// Create client repository
+	clientRepository := client.NewClientDBRepository(db)
+
+	// Create client service
+	clientService := client.NewClientService(clientRepository)
+
+	// Create client handler
+	clientHandler := client.NewClientHandler(clientService)
+
+	// Create api token repository
+	apiTokenRepository := apitoken.NewAPITokenDBRepository(db)
+
+	// Create api token service
+	apiTokenService := apitoken.NewAPITokenService(apiTokenRepository)
+
+	// Bootstrap admin api token
+	bootstrapped, err := apiTokenService.Bootstrap(ctx, serverConfig.AdminToken)
+	if err != nil {
+		return fmt.Errorf("bootstrap admin api token: %w", err)
+	}
+	switch {
+	case bootstrapped != nil && serverConfig.AdminToken == "":
+		// The secret is printed once to stderr, outside of the logs that may be collected and kept
+		slog.Warn("admin api token created, its secret is printed once to stderr", slog.String("name", bootstrapped.APIToken.Name))
+		fmt.Fprintf(os.Stderr, "Admin API token %q created, store its secret now as it is not shown again:\n%s\n",
+			bootstrapped.APIToken.Name, bootstrapped.Secret)
+	case bootstrapped != nil:
+		slog.Info("admin api token created from ADMIN_TOKEN", slog.String("name", bootstrapped.APIToken.Name))
+	case serverConfig.AdminToken != "":
+		slog.Warn("ADMIN_TOKEN is ignored, an admin api token already exists")
+	}
+
+	// Create api token handler
+	apiTokenHandler := apitoken.NewAPITokenHandler(apiTokenService)
+
+	// Create proxy stats
+	proxyStats := proxy.NewStats()
+
+	// Create proxy stats handler
+	proxyStatsHandler := proxy.NewStatsHandler(proxyStats)
+
+	// Load proxy CA
+	proxyCA, err := proxy.LoadCA(serverConfig.ProxyCACert, serverConfig.ProxyCAKey, time.Now())
+	if err != nil {
+		return fmt.Errorf("load proxy CA: %w", err)
+	}
+
+	// Create proxy config
+	proxyConfig, err := newProxyConfig(serverConfig)
+	if err != nil {
+		return err
+	}
+	proxyConfig.CA = proxyCA
+	proxyConfig.CertCacheSize = serverConfig.ProxyCertCacheSize
+	proxyConfig.Metrics = proxy.NewMetrics(metricsRegistry)
+	proxyConfig.TracerProvider = tracerProvider
+	if serverConfig.ProxyAuthEnabled {
+		proxyConfig.Authenticator = clientAuthenticator{service: clientService}
+		proxyConfig.Budgets = clientBudgets{service: clientService}
+	}
+	proxyConfigs := proxy.NewConfigStore(proxyConfig)
+
 	// Create proxy handler
-	proxyHandler := proxy.CreateProxyHandler(ctx, keyService)
+	proxyHandler := proxy.CreateProxyHandler(ctx, keyService, proxyConfigs, proxyStats)
+
+	// Create reverse proxy handler
+	reverseProxyHandler := proxy.CreateReverseProxyHandler(keyService, serverConfig.ReverseProxyUpstreamURL, proxyConfigs, proxyStats)
+
+	// Reload config on SIGHUP
+	configReloader := &configReloader{
+		args:         args,
+		started:      serverConfig,
+		current:      serverConfig,
+		logLevel:     logLevel,
+		keySelector:  keySelector,
+		proxyConfigs: proxyConfigs,
+	}
+	errGroup.Go(func() error {
+		slog.Info("config reloader started", slog.String("signal", syscall.SIGHUP.String()))
+		return configReloader.Run(ctx, hangup)
+	})
+
+	// Create metrics handler
+	metricsHandler := promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
 
 	// Create apiRouter
-	apiRouter := createApiRouter(keyHandler)
+	apiRouter := createApiRouter(keyHandler, clientHandler, apiTokenHandler, proxyStatsHandler, metricsHandler, apiTokenService)
 
 	// Create apiHttpServer
 	apiHttpServer := &http.Server{
-		Addr:              ApiListenAddr,
-		ReadHeaderTimeout: ReadHeaderTimeout,
+		Addr:              serverConfig.ApiListenAddr,
+		ReadHeaderTimeout: serverConfig.ReadHeaderTimeout,
 		Handler:           apiRouter,
 	}
 
 	// Create proxyHttpServer
 	proxyHttpServer := &http.Server{
-		Addr:              ProxyListenAddr,
-		ReadHeaderTimeout: ReadHeaderTimeout,
+		Addr:              serverConfig.ProxyListenAddr,
+		ReadHeaderTimeout: serverConfig.ReadHeaderTimeout,
 		Handler:           proxyHandler,
 	}
 
 	// Start apiHttpServer
 	errGroup.Go(func() error {
-		slog.Info("api server started", slog.String("listen_addr", ApiListenAddr))
+		slog.Info("api server started", slog.String("listen_addr", serverConfig.ApiListenAddr))
 
 		listenErr := apiHttpServer.ListenAndServe()
 		if listenErr != nil {
@@ -98,7 +302,10 @@ func runSrv() error {
 	errGroup.Go(func() error {
 		<-ctx.Done()
 
-		shutdownErr := apiHttpServer.Shutdown(ctx)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverConfig.ShutdownTimeout)
+		defer cancel()
+
+		shutdownErr := apiHttpServer.Shutdown(shutdownCtx)
 		if shutdownErr != nil {
 			return fmt.Errorf("http server shutdown: %w", shutdownErr)
 		}
@@ -108,7 +315,7 @@ func runSrv() error {
 
 	// Start proxyHttpServer
 	errGroup.Go(func() error {
-		slog.Info("proxy server started", slog.String("listen_addr", ProxyListenAddr))
+		slog.Info("proxy server started", slog.String("listen_addr", serverConfig.ProxyListenAddr))
 
 		listenErr := proxyHttpServer.ListenAndServe()
 		if listenErr != nil {
@@ -122,7 +329,10 @@ func runSrv() error {
 	errGroup.Go(func() error {
 		<-ctx.Done()
 
-		shutdownErr := proxyHttpServer.Shutdown(ctx)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverConfig.ShutdownTimeout)
+		defer cancel()
+
+		shutdownErr := proxyHttpServer.Shutdown(shutdownCtx)
 		if shutdownErr != nil {
 			return fmt.Errorf("http server shutdown: %w", shutdownErr)
 		}
@@ -130,6 +340,45 @@ func runSrv() error {
 		return nil
 	})
 
+	if serverConfig.ReverseProxyEnabled {
+		// Create reverseHttpServer
+		reverseHttpServer := &http.Server{
+			Addr:              serverConfig.ReverseProxyListenAddr,
+			ReadHeaderTimeout: serverConfig.ReadHeaderTimeout,
+			Handler:           reverseProxyHandler,
+		}
+
+		// Start reverseHttpServer
+		errGroup.Go(func() error {
+			slog.Info("reverse proxy server started",
+				slog.String("listen_addr", serverConfig.ReverseProxyListenAddr),
+				slog.String("upstream_url", serverConfig.ReverseProxyUpstreamURL.String()),
+			)
+
+			listenErr := reverseHttpServer.ListenAndServe()
+			if listenErr != nil {
+				return fmt.Errorf("http server listen: %w", listenErr)
+			}
+
+			return nil
+		})
+
+		// Shutdown reverseHttpServer
+		errGroup.Go(func() error {
+			<-ctx.Done()
+
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), serverConfig.ShutdownTimeout)
+			defer cancel()
+
+			shutdownErr := reverseHttpServer.Shutdown(shutdownCtx)
+			if shutdownErr != nil {
+				return fmt.Errorf("http server shutdown: %w", shutdownErr)
+			}
+
+			return nil
+		})
+	}
+
 	err = errGroup.Wait()
 
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -139,8 +388,49 @@ func runSrv() error {
 	return nil
 }
 
+// configRules checks the settings whose accepted values are defined by the key and proxy packages
+var configRules = config.Rules{
+	KeySelectionStrategies: key.Strategies,
+	ClientKeyPolicies: func(defaultPolicy string, clientPolicies []string) error {
+		_, err := proxy.ParseClientKeyPolicies(defaultPolicy, clientPolicies)
+		return err
+	},
+	AllowedTargets: func(targets []string) error {
+		_, err := proxy.ParseAllowlist(targets)
+		return err
+	},
+}
+
+// newLogger returns a logger writing records of the level and above to stderr in the format
+func newLogger(format string, level slog.Leveler) *slog.Logger {
+	options := &slog.HandlerOptions{Level: level}
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, options))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, options))
+}
+
 func main() {
-	err := runSrv()
+	if len(os.Args) > 1 && os.Args[1] == GenerateCACommand {
+		err := runGenerateCA(os.Args[2:])
+		if err != nil {
+			slog.Error("generate CA error", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	args := os.Args[1:]
+	serverConfig, err := config.LoadConfig(args, configRules)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		slog.Error("load config error", "error", err)
+		os.Exit(2)
+	}
+
+	err = runSrv(serverConfig, args)
 	if err != nil {
 		slog.Error("server error", "error", err)
 		os.Exit(1)
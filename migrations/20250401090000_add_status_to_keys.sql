@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "keys" ADD COLUMN "status" varchar NOT NULL DEFAULT 'active'
+	CHECK ("status" IN ('active', 'exhausted', 'invalid', 'cooling_down', 'disabled'));
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+UPDATE "keys" SET "status" = 'exhausted' WHERE "balance" <= 0;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX "keys_status_idx" ON "keys" ("status");
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX "keys_status_idx";
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE "keys" DROP COLUMN "status";
+-- +goose StatementEnd
@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "keys" ADD COLUMN "cooldown_until" timestamp with time zone;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "keys" DROP COLUMN "cooldown_until";
+-- +goose StatementEnd
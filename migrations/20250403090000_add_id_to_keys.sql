@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "keys" ADD COLUMN "id" bigint GENERATED ALWAYS AS IDENTITY UNIQUE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "keys" DROP COLUMN "id";
+-- +goose StatementEnd
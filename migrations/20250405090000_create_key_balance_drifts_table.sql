@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE "key_balance_drifts" (
+	"id" bigint GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	"key_id" bigint NOT NULL REFERENCES "keys" ("id") ON DELETE CASCADE,
+	"local_balance" bigint NOT NULL,
+	"upstream_balance" bigint NOT NULL,
+	"drift" bigint NOT NULL,
+	"recorded_at" timestamp with time zone NOT NULL DEFAULT now()
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX "key_balance_drifts_key_id_idx" ON "key_balance_drifts" ("key_id");
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE "key_balance_drifts";
+-- +goose StatementEnd
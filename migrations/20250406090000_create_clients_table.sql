@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE "clients" (
+	"id" bigint GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	"name" varchar(64) NOT NULL UNIQUE,
+	"token_hash" varchar(64) NOT NULL UNIQUE,
+	"disabled" boolean NOT NULL DEFAULT false,
+	"created_at" timestamp with time zone NOT NULL DEFAULT now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE "clients";
+-- +goose StatementEnd
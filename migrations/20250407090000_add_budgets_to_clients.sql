@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "clients"
+	ADD COLUMN "daily_token_budget" bigint NOT NULL DEFAULT 0,
+	ADD COLUMN "monthly_token_budget" bigint NOT NULL DEFAULT 0,
+	ADD COLUMN "requests_per_minute" bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE "client_usage" (
+	"client_id" bigint NOT NULL REFERENCES "clients" ("id") ON DELETE CASCADE,
+	"day" date NOT NULL,
+	"tokens" bigint NOT NULL DEFAULT 0,
+	PRIMARY KEY ("client_id", "day")
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE "client_usage";
+
+ALTER TABLE "clients"
+	DROP COLUMN "daily_token_budget",
+	DROP COLUMN "monthly_token_budget",
+	DROP COLUMN "requests_per_minute";
+-- +goose StatementEnd
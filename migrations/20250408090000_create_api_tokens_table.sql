@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE "api_tokens" (
+	"id" bigint GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	"name" varchar(64) NOT NULL UNIQUE,
+	"token_hash" varchar(64) NOT NULL UNIQUE,
+	"role" varchar(16) NOT NULL,
+	"created_at" timestamp with time zone NOT NULL DEFAULT now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE "api_tokens";
+-- +goose StatementEnd
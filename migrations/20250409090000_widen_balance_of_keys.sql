@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "keys" ALTER COLUMN "balance" TYPE bigint;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "keys" ALTER COLUMN "balance" TYPE integer;
+-- +goose StatementEnd
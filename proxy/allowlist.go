@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// UnmatchedPolicy tells what to do with requests to targets outside of the allowlist
+type UnmatchedPolicy string
+
+const (
+	// UnmatchedPolicyForward forwards the request untouched, without a key,
+	// and tunnels HTTPS connections without interception
+	UnmatchedPolicyForward UnmatchedPolicy = "forward"
+	// UnmatchedPolicyRefuse answers the request with 403 Forbidden and rejects HTTPS connections
+	UnmatchedPolicyRefuse UnmatchedPolicy = "refuse"
+)
+
+var ErrInvalidAllowlistRule = errors.New("invalid allowlist rule")
+
+// allowlistRule matches a host, or any subdomain of a host when it starts with "*.",
+// and optionally a path prefix
+type allowlistRule struct {
+	host       string
+	wildcard   bool
+	pathPrefix string
+}
+
+// Allowlist is the list of upstream targets that receive keys
+type Allowlist struct {
+	rules []allowlistRule
+}
+
+// Allows reports whether a request to the host and path can receive a key.
+// The host may contain a port, which is ignored.
+func (a *Allowlist) Allows(host string, path string) bool {
+	if a == nil {
+		return false
+	}
+
+	host = normalizeHost(host)
+	if path == "" {
+		path = "/"
+	}
+
+	for _, rule := range a.rules {
+		if rule.matchesHost(host) && strings.HasPrefix(path, rule.pathPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsHost reports whether some requests to the host can receive a key, whatever their path.
+// The host may contain a port, which is ignored.
+func (a *Allowlist) AllowsHost(host string) bool {
+	if a == nil {
+		return false
+	}
+
+	host = normalizeHost(host)
+	for _, rule := range a.rules {
+		if rule.matchesHost(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeHost removes the port and the trailing dot of the host and lowercases it
+func normalizeHost(host string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+func (r allowlistRule) matchesHost(host string) bool {
+	if r.wildcard {
+		return strings.HasSuffix(host, "."+r.host)
+	}
+	return host == r.host
+}
+
+// ParseAllowlist parses rules such as "*.jina.ai", "api.jina.ai" or "api.jina.ai/v1/"
+func ParseAllowlist(rules []string) (*Allowlist, error) {
+	allowlist := &Allowlist{}
+	for _, value := range rules {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		host, path, hasPath := strings.Cut(value, "/")
+		rule := allowlistRule{host: strings.ToLower(host), pathPrefix: "/"}
+		if hasPath {
+			rule.pathPrefix = "/" + path
+		}
+		if strings.HasPrefix(rule.host, "*.") {
+			rule.wildcard = true
+			rule.host = rule.host[2:]
+		}
+		if rule.host == "" || strings.ContainsAny(rule.host, "*:") {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidAllowlistRule, value)
+		}
+
+		allowlist.rules = append(allowlist.rules, rule)
+	}
+
+	return allowlist, nil
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowlist_Allows(t *testing.T) {
+	allowlist, err := ParseAllowlist([]string{"*.jina.ai", "example.com/v1/"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		host     string
+		path     string
+		expected bool
+	}{
+		{name: "Subdomain", host: "api.jina.ai", path: "/v1/embeddings", expected: true},
+		{name: "Subdomain with port", host: "api.jina.ai:443", path: "/v1/embeddings", expected: true},
+		{name: "Uppercase subdomain", host: "API.Jina.AI", path: "/", expected: true},
+		{name: "Wildcard does not match the apex domain", host: "jina.ai", path: "/", expected: false},
+		{name: "Suffix of another domain", host: "evil-jina.ai", path: "/", expected: false},
+		{name: "Other host", host: "example.org", path: "/v1/embeddings", expected: false},
+		{name: "Path prefix", host: "example.com", path: "/v1/embeddings", expected: true},
+		{name: "Outside of path prefix", host: "example.com", path: "/v2/embeddings", expected: false},
+		{name: "Empty path", host: "example.com", path: "", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, allowlist.Allows(tt.host, tt.path))
+		})
+	}
+}
+
+func TestAllowlist_AllowsHost(t *testing.T) {
+	allowlist, err := ParseAllowlist([]string{"*.jina.ai", "example.com/v1/"})
+	require.NoError(t, err)
+
+	assert.True(t, allowlist.AllowsHost("api.jina.ai:443"))
+	assert.True(t, allowlist.AllowsHost("example.com:443"))
+	assert.False(t, allowlist.AllowsHost("jina.ai:443"))
+	assert.False(t, allowlist.AllowsHost("example.org:443"))
+}
+
+func TestAllowlist_AllowsNil(t *testing.T) {
+	var allowlist *Allowlist
+	assert.False(t, allowlist.Allows("api.jina.ai", "/"))
+	assert.False(t, allowlist.AllowsHost("api.jina.ai"))
+}
+
+func TestParseAllowlist_Invalid(t *testing.T) {
+	for _, rule := range []string{"*", "*.", "api.*.ai", "api.jina.ai:443", "/v1/"} {
+		t.Run(rule, func(t *testing.T) {
+			_, err := ParseAllowlist([]string{rule})
+			assert.ErrorIs(t, err, ErrInvalidAllowlistRule)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/elazarl/goproxy"
+)
+
+// ClientAuthenticator returns the client owning a token
+type ClientAuthenticator interface {
+	AuthenticateClient(ctx context.Context, token string) (*Client, error)
+}
+
+// ErrorCodeProxyAuthRequired is the error code of the requests without valid proxy credentials
+const ErrorCodeProxyAuthRequired = "proxy_authentication_required"
+
+var (
+	ErrMissingProxyCredentials = errors.New("missing proxy credentials")
+	ErrInvalidCredentials      = errors.New("invalid proxy credentials")
+)
+
+// proxyAuthenticateChallenges are sent with 407 responses to tell the supported schemes
+var proxyAuthenticateChallenges = []string{`Basic realm="jina-http-proxy"`, `Bearer realm="jina-http-proxy"`}
+
+// authenticateProxyClient authenticates the client of the request from its Proxy-Authorization header.
+// Basic credentials use the client name as user name and the client token as password,
+// Bearer credentials only carry the client token.
+func authenticateProxyClient(r *http.Request, authenticator ClientAuthenticator) (*Client, error) {
+	name, token, ok := parseProxyAuthorization(r.Header.Get("Proxy-Authorization"))
+	if !ok {
+		return nil, ErrMissingProxyCredentials
+	}
+
+	client, err := authenticator.AuthenticateClient(r.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	if name != "" && name != client.Name {
+		return nil, fmt.Errorf("%w: token of another client", ErrInvalidCredentials)
+	}
+
+	return client, nil
+}
+
+// parseProxyAuthorization returns the user name, empty for Bearer credentials, and the token of the header
+func parseProxyAuthorization(header string) (string, string, bool) {
+	scheme, credentials, ok := strings.Cut(header, " ")
+	if !ok {
+		return "", "", false
+	}
+	credentials = strings.TrimSpace(credentials)
+
+	switch strings.ToLower(scheme) {
+	case "bearer":
+		return "", credentials, credentials != ""
+	case "basic":
+		decoded, err := base64.StdEncoding.DecodeString(credentials)
+		if err != nil {
+			return "", "", false
+		}
+		name, token, ok := strings.Cut(string(decoded), ":")
+		return name, token, ok && token != ""
+	default:
+		return "", "", false
+	}
+}
+
+// logProxyAuthFailure logs why a client could not be authenticated
+func logProxyAuthFailure(r *http.Request, err error) {
+	slog.Warn("proxy authentication failed",
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("host", r.Host),
+		slog.Any("error", err),
+	)
+}
+
+// proxyAuthRequiredResponse answers 407 to a request without valid proxy credentials
+func proxyAuthRequiredResponse(req *http.Request) *http.Response {
+	return newErrorResponse(req, http.StatusProxyAuthRequired, ErrorDetail{
+		Code:    ErrorCodeProxyAuthRequired,
+		Message: "Valid proxy credentials are required",
+	}, http.Header{"Proxy-Authenticate": proxyAuthenticateChallenges})
+}
+
+// rejectConnectWithAuthRequired answers 407 to a CONNECT request and closes the connection
+var rejectConnectWithAuthRequired = &goproxy.ConnectAction{
+	Action: goproxy.ConnectHijack,
+	Hijack: func(req *http.Request, conn net.Conn, ctx *goproxy.ProxyCtx) {
+		defer conn.Close()
+
+		resp := proxyAuthRequiredResponse(req)
+		resp.Close = true
+		_ = resp.Write(conn) // Intentionally ignore error as the connection is closed anyway
+	},
+}
+
+// withProxyAuth authenticates the clients of the handler, or identifies them by their IP address
+// when there is no authenticator
+func withProxyAuth(authenticator ClientAuthenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := remoteClient(r)
+		if authenticator != nil {
+			authenticated, err := authenticateProxyClient(r, authenticator)
+			if err != nil {
+				logProxyAuthFailure(r, err)
+				resp := proxyAuthRequiredResponse(r)
+				for name, values := range resp.Header {
+					w.Header()[name] = values
+				}
+				w.WriteHeader(resp.StatusCode)
+				_, _ = io.Copy(w, resp.Body)
+				return
+			}
+			client = *authenticated
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithClient(r.Context(), client)))
+	})
+}
@@ -0,0 +1,272 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAuthenticator authenticates the clients of a token map
+type fakeAuthenticator map[string]Client
+
+func (a fakeAuthenticator) AuthenticateClient(ctx context.Context, token string) (*Client, error) {
+	client, ok := a[token]
+	if !ok {
+		return nil, errors.New("invalid client credentials")
+	}
+	return &client, nil
+}
+
+func TestParseProxyAuthorization(t *testing.T) {
+	basic := func(value string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(value))
+	}
+
+	tests := []struct {
+		name          string
+		header        string
+		expectedName  string
+		expectedToken string
+		expectedOk    bool
+	}{
+		{name: "Bearer", header: "Bearer token-a", expectedToken: "token-a", expectedOk: true},
+		{name: "Basic", header: basic("team-a:token-a"), expectedName: "team-a", expectedToken: "token-a", expectedOk: true},
+		{name: "Lowercase scheme", header: "bearer token-a", expectedToken: "token-a", expectedOk: true},
+		{name: "Empty", header: ""},
+		{name: "Empty bearer", header: "Bearer "},
+		{name: "Basic without password", header: basic("team-a")},
+		{name: "Basic with empty password", header: basic("team-a:")},
+		{name: "Basic not base64", header: "Basic !!!"},
+		{name: "Unknown scheme", header: "Digest token-a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, token, ok := parseProxyAuthorization(tt.header)
+			assert.Equal(t, tt.expectedOk, ok)
+			if tt.expectedOk {
+				assert.Equal(t, tt.expectedName, name)
+				assert.Equal(t, tt.expectedToken, token)
+			}
+		})
+	}
+}
+
+func TestAuthenticateProxyClient(t *testing.T) {
+	authenticator := fakeAuthenticator{"token-a": {ID: 1, Name: "team-a"}}
+
+	tests := []struct {
+		name               string
+		proxyAuthorization string
+		expectedClient     string
+		expectedErr        error
+	}{
+		{
+			name:               "Bearer credentials",
+			proxyAuthorization: "Bearer token-a",
+			expectedClient:     "team-a",
+		},
+		{
+			name:               "Basic credentials",
+			proxyAuthorization: "Basic " + base64.StdEncoding.EncodeToString([]byte("team-a:token-a")),
+			expectedClient:     "team-a",
+		},
+		{
+			name:               "Basic credentials with the name of another client",
+			proxyAuthorization: "Basic " + base64.StdEncoding.EncodeToString([]byte("team-b:token-a")),
+			expectedErr:        ErrInvalidCredentials,
+		},
+		{
+			name:        "Missing credentials",
+			expectedErr: ErrMissingProxyCredentials,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://127.0.0.1/v1/embeddings", nil)
+			if tt.proxyAuthorization != "" {
+				req.Header.Set("Proxy-Authorization", tt.proxyAuthorization)
+			}
+
+			client, err := authenticateProxyClient(req, authenticator)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				assert.Nil(t, client)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedClient, client.Name)
+		})
+	}
+}
+
+func TestCreateProxyHandler_Auth(t *testing.T) {
+	authenticator := fakeAuthenticator{"token-a": {ID: 1, Name: "team-a"}}
+	policies, err := ParseClientKeyPolicies("override", []string{"team-a=keep"})
+	require.NoError(t, err)
+
+	certPEM, keyPEM := newTestCA(t)
+	ca, err := LoadCA(certPEM, keyPEM, time.Now())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name               string
+		tls                bool
+		proxyAuthorization string
+		expectedErr        bool
+		expectedStatus     int
+		expectedCalls      []string
+	}{
+		{
+			name:               "HTTP request of an authenticated client",
+			proxyAuthorization: "Bearer token-a",
+			expectedStatus:     http.StatusOK,
+			expectedCalls:      []string{"Bearer client-key"},
+		},
+		{
+			name:               "HTTP request with Basic credentials",
+			proxyAuthorization: "Basic " + base64.StdEncoding.EncodeToString([]byte("team-a:token-a")),
+			expectedStatus:     http.StatusOK,
+			expectedCalls:      []string{"Bearer client-key"},
+		},
+		{
+			name:               "HTTP request with Basic credentials of another client",
+			proxyAuthorization: "Basic " + base64.StdEncoding.EncodeToString([]byte("team-b:token-a")),
+			expectedStatus:     http.StatusProxyAuthRequired,
+		},
+		{
+			name:           "HTTP request without credentials",
+			expectedStatus: http.StatusProxyAuthRequired,
+		},
+		{
+			name:               "HTTP request with invalid credentials",
+			proxyAuthorization: "Bearer token-b",
+			expectedStatus:     http.StatusProxyAuthRequired,
+		},
+		{
+			name:               "HTTPS request of an authenticated client",
+			tls:                true,
+			proxyAuthorization: "Bearer token-a",
+			expectedStatus:     http.StatusOK,
+			expectedCalls:      []string{"Bearer client-key"},
+		},
+		{
+			name:        "HTTPS request without credentials",
+			tls:         true,
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var authorizations []string
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authorizations = append(authorizations, r.Header.Get("Authorization"))
+				assert.Empty(t, r.Header.Get("Proxy-Authorization"))
+			})
+			upstream := httptest.NewServer(handler)
+			if tt.tls {
+				upstream = httptest.NewTLSServer(handler)
+			}
+			t.Cleanup(upstream.Close)
+
+			allowlist, err := ParseAllowlist([]string{"127.0.0.1"})
+			require.NoError(t, err)
+
+			keyGetter := new(MockKeyGetter)
+			proxyServer := httptest.NewServer(CreateProxyHandler(context.Background(), keyGetter, NewConfigStore(Config{
+				MaxBufferedBodyBytes: 1024,
+				CA:                   ca,
+				Authenticator:        authenticator,
+				ClientKeyPolicies:    policies,
+				Allowlist:            allowlist,
+			}), NewStats()))
+			t.Cleanup(proxyServer.Close)
+
+			proxyURL, err := url.Parse(proxyServer.URL)
+			require.NoError(t, err)
+			proxyHeader := http.Header{}
+			if tt.proxyAuthorization != "" {
+				proxyHeader.Set("Proxy-Authorization", tt.proxyAuthorization)
+			}
+			client := &http.Client{Transport: &http.Transport{
+				Proxy:              http.ProxyURL(proxyURL),
+				ProxyConnectHeader: proxyHeader,
+				TLSClientConfig:    &tls.Config{InsecureSkipVerify: true},
+			}}
+
+			// Allowed targets are only reached over HTTPS, plain HTTP requests are sent to another target
+			target := upstream.URL
+			if !tt.tls {
+				target = strings.Replace(target, "127.0.0.1", "localhost", 1)
+			}
+			req, err := http.NewRequest(http.MethodGet, target+"/v1/embeddings", nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer client-key")
+			if !tt.tls && tt.proxyAuthorization != "" {
+				req.Header.Set("Proxy-Authorization", tt.proxyAuthorization)
+			}
+
+			resp, err := client.Do(req)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+			if tt.expectedStatus == http.StatusProxyAuthRequired {
+				assert.Equal(t, proxyAuthenticateChallenges, resp.Header.Values("Proxy-Authenticate"))
+			}
+			assert.Equal(t, tt.expectedCalls, authorizations)
+			keyGetter.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateReverseProxyHandler_Auth(t *testing.T) {
+	upstream, calls := newFakeUpstream(t, map[string]int{"key-1": http.StatusOK})
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	keyGetter := new(MockKeyGetter)
+	keyGetter.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil).Once()
+	keyGetter.On("DeductKeyBalance", mock.Anything, "key-1", int64(10)).Return(nil).Once()
+
+	reverseProxy := httptest.NewServer(CreateReverseProxyHandler(keyGetter, upstreamURL, NewConfigStore(Config{
+		MaxBufferedBodyBytes: 1024,
+		Authenticator:        fakeAuthenticator{"token-a": {ID: 1, Name: "team-a"}},
+	}), NewStats()))
+	t.Cleanup(reverseProxy.Close)
+
+	// Unauthenticated request
+	resp, err := http.Get(reverseProxy.URL + "/v1/embeddings")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusProxyAuthRequired, resp.StatusCode)
+
+	// Authenticated request
+	req, err := http.NewRequest(http.MethodGet, reverseProxy.URL+"/v1/embeddings", nil)
+	require.NoError(t, err)
+	req.Header.Set("Proxy-Authorization", "Bearer token-a")
+
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []upstreamCall{{Authorization: "Bearer key-1"}}, calls())
+	keyGetter.AssertExpectations(t)
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ClientBudgets enforces the budgets of authenticated clients
+type ClientBudgets interface {
+	// CheckClientBudget counts a request of the client and returns the budget it has spent,
+	// or nil when the request can be sent. ErrClientRevoked is returned when the client was
+	// deleted or disabled since it authenticated.
+	CheckClientBudget(ctx context.Context, clientID int64) (*BudgetExceeded, error)
+	// DebitClientUsage adds the tokens used by a request to the usage of the client
+	DebitClientUsage(ctx context.Context, clientID int64, tokens int64) error
+}
+
+// BudgetExceeded describes the budget a client has spent
+type BudgetExceeded struct {
+	// Period is "minute" for the request rate limit, "day" or "month" for token budgets
+	Period   string    `json:"period"`
+	Limit    int64     `json:"limit"`
+	Used     int64     `json:"used"`
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+// ErrClientRevoked is returned by ClientBudgets when the client can no longer send requests
+var ErrClientRevoked = errors.New("client revoked")
+
+// Error codes of the responses sent to clients over their budget
+const (
+	ErrorCodeClientBudgetExceeded = "client_budget_exceeded"
+	ErrorCodeClientRateLimited    = "client_rate_limited"
+)
+
+// checkClientBudget answers 429 when the client of the request has spent one of its budgets.
+// Requests of unauthenticated clients are not limited. Requests of revoked clients are answered 407,
+// as the client of a tunnel is only authenticated on CONNECT, and requests are let through when
+// the budget cannot be checked.
+func (t *keyTransport) checkClientBudget(req *http.Request) *http.Response {
+	client := ClientFromContext(req.Context())
+	budgets := t.config.Load().Budgets
+	if budgets == nil || client.ID == 0 {
+		return nil
+	}
+
+	exceeded, err := budgets.CheckClientBudget(req.Context(), client.ID)
+	if errors.Is(err, ErrClientRevoked) {
+		logProxyAuthFailure(req, err)
+		return proxyAuthRequiredResponse(req)
+	}
+	if err != nil {
+		slog.Warn("check client budget", slog.String("client", client.Name), slog.Any("error", err))
+		return nil
+	}
+	if exceeded == nil {
+		return nil
+	}
+
+	t.stats.BudgetExceeded.Add(1)
+	slog.Warn("client budget exceeded",
+		slog.String("client", client.Name),
+		slog.String("period", exceeded.Period),
+		slog.Int64("limit", exceeded.Limit),
+	)
+
+	resetsAt := exceeded.ResetsAt.UTC().Format(time.RFC3339)
+	detail := ErrorDetail{Code: ErrorCodeClientBudgetExceeded, Budget: exceeded}
+	switch exceeded.Period {
+	case "minute":
+		detail.Code = ErrorCodeClientRateLimited
+		detail.Message = fmt.Sprintf("The limit of %d requests per minute is reached, it resets at %s", exceeded.Limit, resetsAt)
+	case "day":
+		detail.Message = fmt.Sprintf("The daily budget of %d tokens is spent, it resets at %s", exceeded.Limit, resetsAt)
+	default:
+		detail.Message = fmt.Sprintf("The monthly budget of %d tokens is spent, it resets at %s", exceeded.Limit, resetsAt)
+	}
+
+	seconds := max(int64(math.Ceil(time.Until(exceeded.ResetsAt).Seconds())), 1)
+	header := http.Header{"Retry-After": []string{strconv.FormatInt(seconds, 10)}}
+	return newErrorResponse(req, http.StatusTooManyRequests, detail, header)
+}
+
+// debitClientUsage adds the tokens used by the request to the usage of its client
+func (t *keyTransport) debitClientUsage(ctx context.Context, tokens int64) {
+	client := ClientFromContext(ctx)
+	budgets := t.config.Load().Budgets
+	if budgets == nil || client.ID == 0 {
+		return
+	}
+
+	err := budgets.DebitClientUsage(ctx, client.ID, tokens)
+	if err != nil {
+		slog.Warn("debit client usage", slog.String("client", client.Name), slog.Any("error", err))
+	}
+}
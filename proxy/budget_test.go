@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockClientBudgets is a mock implementation of ClientBudgets
+type MockClientBudgets struct {
+	mock.Mock
+}
+
+func (m *MockClientBudgets) CheckClientBudget(ctx context.Context, clientID int64) (*BudgetExceeded, error) {
+	args := m.Called(ctx, clientID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*BudgetExceeded), args.Error(1)
+}
+
+func (m *MockClientBudgets) DebitClientUsage(ctx context.Context, clientID int64, tokens int64) error {
+	args := m.Called(ctx, clientID, tokens)
+	return args.Error(0)
+}
+
+func TestKeyTransport_RoundTrip_Budget(t *testing.T) {
+	resetsAt := time.Now().Add(30 * time.Second)
+	dailyExceeded := &BudgetExceeded{Period: "day", Limit: 1000, Used: 1010, ResetsAt: resetsAt}
+	minuteExceeded := &BudgetExceeded{Period: "minute", Limit: 60, Used: 60, ResetsAt: resetsAt}
+
+	tests := []struct {
+		name           string
+		client         Client
+		setupMock      func(*MockKeyGetter, *MockClientBudgets)
+		expectedStatus int
+		expectedCalls  []string
+		expectedCode   string
+		expectedBudget *BudgetExceeded
+	}{
+		{
+			name:   "Within budget",
+			client: Client{ID: 1, Name: "team-a"},
+			setupMock: func(k *MockKeyGetter, b *MockClientBudgets) {
+				b.On("CheckClientBudget", mock.Anything, int64(1)).Return(nil, nil)
+				k.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+				k.On("DeductKeyBalance", mock.Anything, "key-1", int64(10)).Return(nil)
+				b.On("DebitClientUsage", mock.Anything, int64(1), int64(10)).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedCalls:  []string{"Bearer key-1"},
+		},
+		{
+			name:   "Daily budget spent",
+			client: Client{ID: 1, Name: "team-a"},
+			setupMock: func(k *MockKeyGetter, b *MockClientBudgets) {
+				b.On("CheckClientBudget", mock.Anything, int64(1)).Return(dailyExceeded, nil)
+			},
+			expectedStatus: http.StatusTooManyRequests,
+			expectedCode:   ErrorCodeClientBudgetExceeded,
+			expectedBudget: dailyExceeded,
+		},
+		{
+			name:   "Rate limited",
+			client: Client{ID: 1, Name: "team-a"},
+			setupMock: func(k *MockKeyGetter, b *MockClientBudgets) {
+				b.On("CheckClientBudget", mock.Anything, int64(1)).Return(minuteExceeded, nil)
+			},
+			expectedStatus: http.StatusTooManyRequests,
+			expectedCode:   ErrorCodeClientRateLimited,
+			expectedBudget: minuteExceeded,
+		},
+		{
+			name:   "Budget check failure lets the request through",
+			client: Client{ID: 1, Name: "team-a"},
+			setupMock: func(k *MockKeyGetter, b *MockClientBudgets) {
+				b.On("CheckClientBudget", mock.Anything, int64(1)).Return(nil, assert.AnError)
+				k.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+				k.On("DeductKeyBalance", mock.Anything, "key-1", int64(10)).Return(nil)
+				b.On("DebitClientUsage", mock.Anything, int64(1), int64(10)).Return(assert.AnError)
+			},
+			expectedStatus: http.StatusOK,
+			expectedCalls:  []string{"Bearer key-1"},
+		},
+		{
+			name:   "Revoked client is refused",
+			client: Client{ID: 1, Name: "team-a"},
+			setupMock: func(k *MockKeyGetter, b *MockClientBudgets) {
+				b.On("CheckClientBudget", mock.Anything, int64(1)).Return(nil, ErrClientRevoked)
+			},
+			expectedStatus: http.StatusProxyAuthRequired,
+		},
+		{
+			name:   "Unauthenticated client is not limited",
+			client: Client{Name: "10.0.0.5"},
+			setupMock: func(k *MockKeyGetter, b *MockClientBudgets) {
+				k.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+				k.On("DeductKeyBalance", mock.Anything, "key-1", int64(10)).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedCalls:  []string{"Bearer key-1"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			upstream, getCalls := newFakeUpstream(t, map[string]int{"key-1": http.StatusOK})
+			mockKeyGetter := new(MockKeyGetter)
+			mockBudgets := new(MockClientBudgets)
+			tc.setupMock(mockKeyGetter, mockBudgets)
+			stats := NewStats()
+
+			transport := &keyTransport{
+				keyGetter: mockKeyGetter,
+				transport: http.DefaultTransport,
+				config:    NewConfigStore(Config{MaxBufferedBodyBytes: 1024, Budgets: mockBudgets}),
+				stats:     stats,
+			}
+
+			req, err := http.NewRequestWithContext(WithClient(context.Background(), tc.client),
+				http.MethodPost, upstream.URL+"/v1/embeddings", strings.NewReader(`{"input":["hello"]}`))
+			require.NoError(t, err)
+
+			resp, err := transport.RoundTrip(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			var authorizations []string
+			for _, call := range getCalls() {
+				authorizations = append(authorizations, call.Authorization)
+			}
+			assert.Equal(t, tc.expectedCalls, authorizations)
+
+			if tc.expectedCode != "" {
+				var body ErrorResponse
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+				assert.Equal(t, tc.expectedCode, body.Error.Code)
+				assert.NotEmpty(t, body.Error.Message)
+				require.NotNil(t, body.Error.Budget)
+				assert.Equal(t, tc.expectedBudget.Period, body.Error.Budget.Period)
+				assert.Equal(t, tc.expectedBudget.Limit, body.Error.Budget.Limit)
+				assert.Equal(t, tc.expectedBudget.Used, body.Error.Budget.Used)
+				assert.True(t, tc.expectedBudget.ResetsAt.Equal(body.Error.Budget.ResetsAt))
+				assert.Equal(t, "30", resp.Header.Get("Retry-After"))
+				assert.Equal(t, int64(1), stats.BudgetExceeded.Load())
+			}
+			mockKeyGetter.AssertExpectations(t)
+			mockBudgets.AssertExpectations(t)
+		})
+	}
+}
+
+func TestKeyTransport_RoundTrip_BudgetLargeResponse(t *testing.T) {
+	upstream, _ := newFakeUpstream(t, map[string]int{"key-1": http.StatusOK})
+
+	mockKeyGetter := new(MockKeyGetter)
+	mockKeyGetter.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+	mockKeyGetter.On("DeductKeyBalance", mock.Anything, "key-1", int64(10)).Return(nil)
+	mockBudgets := new(MockClientBudgets)
+	mockBudgets.On("CheckClientBudget", mock.Anything, int64(1)).Return(nil, nil)
+
+	transport := &keyTransport{
+		keyGetter: mockKeyGetter,
+		transport: http.DefaultTransport,
+		config:    NewConfigStore(Config{MaxBufferedBodyBytes: 4, Budgets: mockBudgets}),
+		stats:     NewStats(),
+	}
+
+	req, err := http.NewRequestWithContext(WithClient(context.Background(), Client{ID: 1, Name: "team-a"}),
+		http.MethodPost, upstream.URL+"/v1/embeddings", strings.NewReader(`{"input":["hello"]}`))
+	require.NoError(t, err)
+
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// A response larger than the buffer is debited from the client once it is read
+	mockBudgets.On("DebitClientUsage", mock.Anything, int64(1), int64(10)).Return(nil).Once()
+	_, err = io.Copy(io.Discard, resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	mockKeyGetter.AssertExpectations(t)
+	mockBudgets.AssertExpectations(t)
+}
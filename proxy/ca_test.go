@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCA generates a CA for tests
+func newTestCA(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	certPEM, keyPEM, err := GenerateCA("Test CA", time.Now(), time.Hour)
+	require.NoError(t, err)
+	return certPEM, keyPEM
+}
+
+func TestLoadCA(t *testing.T) {
+	now := time.Now()
+	certPEM, keyPEM := newTestCA(t)
+	_, otherKeyPEM := newTestCA(t)
+
+	// Leaf certificate that cannot sign certificates
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "api.jina.ai"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, leafTemplate, leafKey.Public(), leafKey)
+	require.NoError(t, err)
+	leafKeyDER, err := x509.MarshalPKCS8PrivateKey(leafKey)
+	require.NoError(t, err)
+	leafPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})
+	leafKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: leafKeyDER})
+
+	tests := []struct {
+		name        string
+		certPEM     []byte
+		keyPEM      []byte
+		now         time.Time
+		expectedErr bool
+	}{
+		{name: "Valid CA", certPEM: certPEM, keyPEM: keyPEM, now: now},
+		{name: "Mismatched key", certPEM: certPEM, keyPEM: otherKeyPEM, now: now, expectedErr: true},
+		{name: "Not a CA", certPEM: leafPEM, keyPEM: leafKeyPEM, now: now, expectedErr: true},
+		{name: "Expired", certPEM: certPEM, keyPEM: keyPEM, now: now.Add(2 * time.Hour), expectedErr: true},
+		{name: "Not yet valid", certPEM: certPEM, keyPEM: keyPEM, now: now.Add(-2 * time.Hour), expectedErr: true},
+		{name: "Invalid PEM", certPEM: []byte("not a certificate"), keyPEM: keyPEM, now: now, expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca, err := LoadCA(tt.certPEM, tt.keyPEM, tt.now)
+			if tt.expectedErr {
+				assert.ErrorIs(t, err, ErrInvalidCA)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "Test CA", ca.Leaf.Subject.CommonName)
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"container/list"
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+	"time"
+
+	"github.com/elazarl/goproxy"
+)
+
+// CertCacheExpiryMargin is how long before the end of its validity a cached certificate is generated again
+const CertCacheExpiryMargin = 24 * time.Hour
+
+// certCacheEntry is a certificate cached for a hostname
+type certCacheEntry struct {
+	hostname  string
+	cert      *tls.Certificate
+	expiresAt time.Time
+}
+
+// CertCache keeps the most recently used certificates generated for intercepted hosts,
+// so a certificate is not signed again for every connection
+type CertCache struct {
+	size   int
+	margin time.Duration
+	stats  *Stats
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// Check if CertCache implements goproxy.CertStorage
+var _ goproxy.CertStorage = &CertCache{}
+
+func NewCertCache(size int, margin time.Duration, stats *Stats) *CertCache {
+	return &CertCache{
+		size:    size,
+		margin:  margin,
+		stats:   stats,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Fetch returns the cached certificate of the hostname, or generates and caches a new one
+// when it is missing or about to expire
+func (c *CertCache) Fetch(hostname string, gen func() (*tls.Certificate, error)) (*tls.Certificate, error) {
+	if cert := c.get(hostname); cert != nil {
+		c.stats.CertCacheHits.Add(1)
+		return cert, nil
+	}
+	c.stats.CertCacheMisses.Add(1)
+
+	cert, err := gen()
+	if err != nil {
+		return nil, err
+	}
+
+	leaf := cert.Leaf
+	if leaf == nil {
+		leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.put(hostname, cert, leaf.NotAfter.Add(-c.margin))
+	return cert, nil
+}
+
+// get returns the cached certificate of the hostname unless it expired
+func (c *CertCache) get(hostname string) *tls.Certificate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[hostname]
+	if !ok {
+		return nil
+	}
+
+	entry := elem.Value.(*certCacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.entries, hostname)
+		return nil
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.cert
+}
+
+// put caches the certificate until expiresAt and evicts the least recently used certificates
+func (c *CertCache) put(hostname string, cert *tls.Certificate, expiresAt time.Time) {
+	if !c.now().Before(expiresAt) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[hostname]; ok {
+		elem.Value = &certCacheEntry{hostname: hostname, cert: cert, expiresAt: expiresAt}
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[hostname] = c.order.PushFront(&certCacheEntry{hostname: hostname, cert: cert, expiresAt: expiresAt})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*certCacheEntry).hostname)
+	}
+}
+
+// Len returns the number of cached certificates
+func (c *CertCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newCertGen returns a generator of certificates valid until notAfter and counts its calls
+func newCertGen(notAfter time.Time, calls *int) func() (*tls.Certificate, error) {
+	return func() (*tls.Certificate, error) {
+		*calls++
+		return &tls.Certificate{Leaf: &x509.Certificate{NotAfter: notAfter}}, nil
+	}
+}
+
+func TestCertCache_Fetch(t *testing.T) {
+	now := time.Date(2025, 4, 6, 9, 0, 0, 0, time.UTC)
+	stats := NewStats()
+	cache := NewCertCache(2, time.Hour, stats)
+	cache.now = func() time.Time { return now }
+
+	var calls int
+	gen := newCertGen(now.Add(3*time.Hour), &calls)
+
+	first, err := cache.Fetch("api.jina.ai", gen)
+	require.NoError(t, err)
+	second, err := cache.Fetch("api.jina.ai", gen)
+	require.NoError(t, err)
+
+	assert.Same(t, first, second)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, int64(1), stats.CertCacheHits.Load())
+	assert.Equal(t, int64(1), stats.CertCacheMisses.Load())
+
+	// Certificates are generated again ahead of the end of their validity
+	now = now.Add(2 * time.Hour)
+	third, err := cache.Fetch("api.jina.ai", gen)
+	require.NoError(t, err)
+
+	assert.NotSame(t, first, third)
+	assert.Equal(t, 2, calls)
+}
+
+func TestCertCache_Eviction(t *testing.T) {
+	now := time.Date(2025, 4, 6, 9, 0, 0, 0, time.UTC)
+	cache := NewCertCache(2, time.Hour, NewStats())
+	cache.now = func() time.Time { return now }
+
+	var calls int
+	gen := newCertGen(now.Add(3*time.Hour), &calls)
+
+	for _, hostname := range []string{"a.jina.ai", "b.jina.ai", "a.jina.ai", "c.jina.ai"} {
+		_, err := cache.Fetch(hostname, gen)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, 2, cache.Len())
+
+	// b.jina.ai was the least recently used certificate
+	_, err := cache.Fetch("a.jina.ai", gen)
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	_, err = cache.Fetch("b.jina.ai", gen)
+	require.NoError(t, err)
+	assert.Equal(t, 4, calls)
+}
+
+func TestCertCache_NotCached(t *testing.T) {
+	now := time.Date(2025, 4, 6, 9, 0, 0, 0, time.UTC)
+	cache := NewCertCache(2, time.Hour, NewStats())
+	cache.now = func() time.Time { return now }
+
+	// Certificate expiring within the margin
+	var calls int
+	_, err := cache.Fetch("api.jina.ai", newCertGen(now.Add(30*time.Minute), &calls))
+	require.NoError(t, err)
+	assert.Equal(t, 0, cache.Len())
+
+	// Generation error
+	genErr := errors.New("sign failed")
+	_, err = cache.Fetch("api.jina.ai", func() (*tls.Certificate, error) { return nil, genErr })
+	assert.ErrorIs(t, err, genErr)
+	assert.Equal(t, 0, cache.Len())
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Client identifies the client sending a request.
+// ID is 0 when the client is not authenticated and identified by its IP address.
+type Client struct {
+	ID   int64
+	Name string
+}
+
+// clientKey is the context key of the client
+type clientKey struct{}
+
+// WithClient returns a context carrying the client sending the request
+func WithClient(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// ClientFromContext returns the client sending the request, the zero Client when unknown
+func ClientFromContext(ctx context.Context) Client {
+	client, _ := ctx.Value(clientKey{}).(Client)
+	return client
+}
+
+// remoteClient identifies a client by its IP address
+func remoteClient(r *http.Request) Client {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return Client{Name: r.RemoteAddr}
+	}
+	return Client{Name: host}
+}
+
+// ClientKeyPolicy tells what to do with the Authorization header sent by a client
+type ClientKeyPolicy string
+
+const (
+	// ClientKeyPolicyOverride replaces the client key with a key of the pool
+	ClientKeyPolicyOverride ClientKeyPolicy = "override"
+	// ClientKeyPolicyKeep forwards the request with the client key, bypassing the pool
+	ClientKeyPolicyKeep ClientKeyPolicy = "keep"
+	// ClientKeyPolicyReject answers 403 Forbidden to requests carrying a client key
+	ClientKeyPolicyReject ClientKeyPolicy = "reject"
+)
+
+// IsValid reports whether p is a known policy
+func (p ClientKeyPolicy) IsValid() bool {
+	return p == ClientKeyPolicyOverride || p == ClientKeyPolicyKeep || p == ClientKeyPolicyReject
+}
+
+// ErrorCodeClientKeyRejected is the error code of the requests rejected for carrying a client key
+const ErrorCodeClientKeyRejected = "client_key_rejected"
+
+var ErrInvalidClientKeyPolicy = errors.New("invalid client key policy")
+
+// ClientKeyPolicies is the client key policy of every client
+type ClientKeyPolicies struct {
+	// Default applies to the clients without their own policy
+	Default ClientKeyPolicy
+	// ByClient is the policy of each client identity
+	ByClient map[string]ClientKeyPolicy
+}
+
+// For returns the policy of the client identity
+func (p ClientKeyPolicies) For(identity string) ClientKeyPolicy {
+	if policy, ok := p.ByClient[identity]; ok {
+		return policy
+	}
+	if p.Default == "" {
+		return ClientKeyPolicyOverride
+	}
+	return p.Default
+}
+
+// ParseClientKeyPolicies parses the default policy and the client policies written as "identity=policy"
+func ParseClientKeyPolicies(defaultPolicy string, clientPolicies []string) (ClientKeyPolicies, error) {
+	policies := ClientKeyPolicies{
+		Default:  ClientKeyPolicy(defaultPolicy),
+		ByClient: make(map[string]ClientKeyPolicy, len(clientPolicies)),
+	}
+	if !policies.Default.IsValid() {
+		return ClientKeyPolicies{}, fmt.Errorf("%w: %s", ErrInvalidClientKeyPolicy, defaultPolicy)
+	}
+
+	for _, value := range clientPolicies {
+		identity, policy, ok := strings.Cut(value, "=")
+		identity = strings.TrimSpace(identity)
+		if !ok || identity == "" || !ClientKeyPolicy(strings.TrimSpace(policy)).IsValid() {
+			return ClientKeyPolicies{}, fmt.Errorf("%w: %s", ErrInvalidClientKeyPolicy, value)
+		}
+		policies.ByClient[identity] = ClientKeyPolicy(strings.TrimSpace(policy))
+	}
+
+	return policies, nil
+}
@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClientKeyPolicies(t *testing.T) {
+	policies, err := ParseClientKeyPolicies("override", []string{"10.0.0.5=keep", " team-a = reject "})
+	require.NoError(t, err)
+
+	assert.Equal(t, ClientKeyPolicyKeep, policies.For("10.0.0.5"))
+	assert.Equal(t, ClientKeyPolicyReject, policies.For("team-a"))
+	assert.Equal(t, ClientKeyPolicyOverride, policies.For("10.0.0.6"))
+	assert.Equal(t, ClientKeyPolicyOverride, ClientKeyPolicies{}.For("10.0.0.6"))
+}
+
+func TestParseClientKeyPolicies_Invalid(t *testing.T) {
+	tests := []struct {
+		name           string
+		defaultPolicy  string
+		clientPolicies []string
+	}{
+		{name: "Unknown default policy", defaultPolicy: "ignore"},
+		{name: "Unknown client policy", defaultPolicy: "override", clientPolicies: []string{"team-a=ignore"}},
+		{name: "Missing policy", defaultPolicy: "override", clientPolicies: []string{"team-a"}},
+		{name: "Missing client", defaultPolicy: "override", clientPolicies: []string{"=keep"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseClientKeyPolicies(tt.defaultPolicy, tt.clientPolicies)
+			assert.ErrorIs(t, err, ErrInvalidClientKeyPolicy)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unixTimestampThreshold separates reset headers sent as a unix timestamp from those sent as seconds
+const unixTimestampThreshold = 1_000_000_000
+
+// rateLimitResetHeaders are checked in order when Retry-After is missing
+var rateLimitResetHeaders = []string{"RateLimit-Reset", "X-RateLimit-Reset", "X-RateLimit-Reset-Requests", "X-RateLimit-Reset-Tokens"}
+
+// parseCooldown returns how long a rate limited key should cool down according to the response headers.
+// Cooldowns in seconds are capped to maxCooldown, when positive, before they are converted to a duration,
+// so huge or infinite values do not overflow.
+func parseCooldown(header http.Header, now time.Time, maxCooldown time.Duration) (time.Duration, bool) {
+	if maxCooldown <= 0 {
+		maxCooldown = math.MaxInt64
+	}
+
+	if retryAfter := strings.TrimSpace(header.Get("Retry-After")); retryAfter != "" {
+		if seconds, err := strconv.ParseFloat(retryAfter, 64); err == nil && seconds >= 0 {
+			return secondsDuration(seconds, maxCooldown), true
+		}
+		if date, err := http.ParseTime(retryAfter); err == nil {
+			return max(date.Sub(now), 0), true
+		}
+	}
+
+	for _, name := range rateLimitResetHeaders {
+		value := strings.TrimSpace(header.Get(name))
+		if value == "" {
+			continue
+		}
+		if duration, err := time.ParseDuration(value); err == nil && duration >= 0 {
+			return duration, true
+		}
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil || seconds < 0 {
+			continue
+		}
+		if seconds >= unixTimestampThreshold {
+			until := min(seconds, float64(now.Add(maxCooldown).Unix()))
+			return max(time.Unix(int64(until), 0).Sub(now), 0), true
+		}
+		return secondsDuration(seconds, maxCooldown), true
+	}
+
+	return 0, false
+}
+
+// secondsDuration converts seconds to a duration, capped to limit
+func secondsDuration(seconds float64, limit time.Duration) time.Duration {
+	if seconds*float64(time.Second) >= float64(limit) {
+		return limit
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// cooldownUntil returns the end of the cooldown for a rate limited response.
+// Missing headers fall back to the default cooldown, and the cooldown is capped to the max cooldown.
+func cooldownUntil(header http.Header, now time.Time, config Config) time.Time {
+	cooldown, ok := parseCooldown(header, now, config.MaxCooldown)
+	if !ok {
+		cooldown = config.DefaultCooldown
+	}
+	if config.MaxCooldown > 0 && cooldown > config.MaxCooldown {
+		cooldown = config.MaxCooldown
+	}
+	return now.Add(cooldown)
+}
@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCooldown(t *testing.T) {
+	now := time.Date(2025, 4, 2, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name             string
+		header           http.Header
+		maxCooldown      time.Duration
+		expectedCooldown time.Duration
+		expectedOk       bool
+	}{
+		{
+			name:             "Retry-After seconds",
+			header:           http.Header{"Retry-After": {"30"}},
+			expectedCooldown: 30 * time.Second,
+			expectedOk:       true,
+		},
+		{
+			name:             "Retry-After HTTP date",
+			header:           http.Header{"Retry-After": {now.Add(2 * time.Minute).Format(http.TimeFormat)}},
+			expectedCooldown: 2 * time.Minute,
+			expectedOk:       true,
+		},
+		{
+			name:             "Retry-After date in the past",
+			header:           http.Header{"Retry-After": {now.Add(-time.Minute).Format(http.TimeFormat)}},
+			expectedCooldown: 0,
+			expectedOk:       true,
+		},
+		{
+			name:             "RateLimit-Reset seconds",
+			header:           http.Header{"Ratelimit-Reset": {"12"}},
+			expectedCooldown: 12 * time.Second,
+			expectedOk:       true,
+		},
+		{
+			name:             "X-RateLimit-Reset unix timestamp",
+			header:           http.Header{"X-Ratelimit-Reset": {"1743584445"}},
+			expectedCooldown: 45 * time.Second,
+			expectedOk:       true,
+		},
+		{
+			name:             "X-RateLimit-Reset-Requests duration",
+			header:           http.Header{"X-Ratelimit-Reset-Requests": {"1m30s"}},
+			expectedCooldown: 90 * time.Second,
+			expectedOk:       true,
+		},
+		{
+			name:             "Retry-After beyond the max cooldown",
+			header:           http.Header{"Retry-After": {"1e300"}},
+			maxCooldown:      10 * time.Minute,
+			expectedCooldown: 10 * time.Minute,
+			expectedOk:       true,
+		},
+		{
+			name:             "Retry-After Inf",
+			header:           http.Header{"Retry-After": {"Inf"}},
+			maxCooldown:      10 * time.Minute,
+			expectedCooldown: 10 * time.Minute,
+			expectedOk:       true,
+		},
+		{
+			name:             "Retry-After Inf without max cooldown",
+			header:           http.Header{"Retry-After": {"Inf"}},
+			expectedCooldown: math.MaxInt64,
+			expectedOk:       true,
+		},
+		{
+			name:             "X-RateLimit-Reset Inf",
+			header:           http.Header{"X-Ratelimit-Reset": {"Inf"}},
+			maxCooldown:      10 * time.Minute,
+			expectedCooldown: 10 * time.Minute,
+			expectedOk:       true,
+		},
+		{
+			name:       "Invalid header",
+			header:     http.Header{"Retry-After": {"soon"}},
+			expectedOk: false,
+		},
+		{
+			name:       "No header",
+			header:     http.Header{},
+			expectedOk: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cooldown, ok := parseCooldown(tc.header, now, tc.maxCooldown)
+			assert.Equal(t, tc.expectedOk, ok)
+			assert.Equal(t, tc.expectedCooldown, cooldown)
+		})
+	}
+}
+
+func TestCooldownUntil(t *testing.T) {
+	now := time.Date(2025, 4, 2, 9, 0, 0, 0, time.UTC)
+	config := Config{DefaultCooldown: time.Minute, MaxCooldown: 10 * time.Minute}
+
+	// Missing headers use the default cooldown
+	assert.Equal(t, now.Add(time.Minute), cooldownUntil(http.Header{}, now, config))
+
+	// Upstream cooldown is used
+	assert.Equal(t, now.Add(5*time.Second), cooldownUntil(http.Header{"Retry-After": {"5"}}, now, config))
+
+	// Upstream cooldown is capped
+	assert.Equal(t, now.Add(10*time.Minute), cooldownUntil(http.Header{"Retry-After": {"86400"}}, now, config))
+}
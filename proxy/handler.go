@@ -3,29 +3,152 @@ package proxy
 import (
 	"context"
 	"net/http"
-	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/elazarl/goproxy"
 )
 
+// ErrorCodeHTTPSRequired is the error code of the 403 answered to plain HTTP requests to allowed targets
+const ErrorCodeHTTPSRequired = "https_required"
+
+// ErrorCodeTargetNotAllowed is the error code of the 403 answered to requests to other targets with the refuse policy
+const ErrorCodeTargetNotAllowed = "target_not_allowed"
+
 type KeyGetter interface {
-	UseBestKey(ctx context.Context) (*string, error)
+	UseBestKey(ctx context.Context, exclude ...string) (*string, error)
+	DeductKeyBalance(ctx context.Context, key string, amount int64) error
+	InvalidateKey(ctx context.Context, key string) error
+	ExhaustKey(ctx context.Context, key string) error
+	CooldownKey(ctx context.Context, key string, until time.Time) error
+	NextKeyAvailableAt(ctx context.Context) (*time.Time, error)
 }
 
-func CreateProxyHandler(ctx context.Context, keyGetter KeyGetter) http.Handler {
+// CreateProxyHandler creates the forward proxy. The allowlist, the unmatched policy and
+// the transport settings are read from the config store on every request so they can be reloaded.
+func CreateProxyHandler(ctx context.Context, keyGetter KeyGetter, configs *ConfigStore, stats *Stats) http.Handler {
+	config := configs.Load()
 	proxy := goproxy.NewProxyHttpServer()
 	proxy.Verbose = true
-	proxy.OnRequest().HandleConnect(goproxy.AlwaysMitm)
-	proxy.OnRequest(
-		goproxy.ReqHostMatches(regexp.MustCompile(".*")),
-	).DoFunc(
-		func(r *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
-			key, err := keyGetter.UseBestKey(r.Context())
-			if err == nil && key != nil {
-				r.Header.Set("Authorization", "Bearer "+*key)
+	if config.CertCacheSize > 0 {
+		proxy.CertStore = NewCertCache(config.CertCacheSize, CertCacheExpiryMargin, stats)
+	}
+
+	transport := &keyTransport{
+		keyGetter: keyGetter,
+		transport: proxy.Tr,
+		config:    configs,
+		stats:     stats,
+	}
+
+	// Only inject keys into HTTPS requests to allowed targets, which are intercepted,
+	// so keys never travel in cleartext
+	allowedTarget := goproxy.ReqConditionFunc(func(r *http.Request, ctx *goproxy.ProxyCtx) bool {
+		return configs.Load().Allowlist.Allows(r.URL.Host, r.URL.Path)
+	})
+	https := goproxy.ReqConditionFunc(func(r *http.Request, ctx *goproxy.ProxyCtx) bool {
+		return r.URL.Scheme == "https"
+	})
+
+	// Sign the certificates of intercepted hosts with our CA instead of the public goproxy CA
+	mitmConnect := &goproxy.ConnectAction{
+		Action:    goproxy.ConnectMitm,
+		TLSConfig: tracedTLSConfig(config, goproxy.TLSConfigFromCA(config.CA)),
+	}
+
+	// Only intercept HTTPS connections to hosts that can receive keys,
+	// other connections are tunneled untouched unless refused by the policy.
+	// The client authenticated on CONNECT is kept for the requests sent through the connection.
+	proxy.OnRequest().HandleConnectFunc(
+		func(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
+			if config.Authenticator != nil {
+				client, err := authenticateProxyClient(ctx.Req, config.Authenticator)
+				if err != nil {
+					logProxyAuthFailure(ctx.Req, err)
+					return rejectConnectWithAuthRequired, host
+				}
+				ctx.UserData = *client
 			}
+
+			current := configs.Load()
+			if current.Allowlist.AllowsHost(host) {
+				return mitmConnect, host
+			}
+			if current.UnmatchedPolicy == UnmatchedPolicyRefuse {
+				return goproxy.RejectConnect, host
+			}
+			return goproxy.OkConnect, host
+		})
+
+	// Authenticate the clients of plain HTTP requests
+	if config.Authenticator != nil {
+		proxy.OnRequest().DoFunc(
+			func(r *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+				if _, ok := ctx.UserData.(Client); ok {
+					return r, nil
+				}
+
+				client, err := authenticateProxyClient(r, config.Authenticator)
+				if err != nil {
+					logProxyAuthFailure(r, err)
+					return r, proxyAuthRequiredResponse(r)
+				}
+				ctx.UserData = *client
+				return r, nil
+			})
+	}
+
+	// Plain HTTP requests to allowed targets are refused, whatever the unmatched policy
+	proxy.OnRequest(allowedTarget, goproxy.Not(https)).DoFunc(
+		func(r *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+			return r, newErrorResponse(r, http.StatusForbidden, ErrorDetail{
+				Code:    ErrorCodeHTTPSRequired,
+				Message: "Requests to this target must use HTTPS",
+			}, nil)
+		})
+
+	proxy.OnRequest(allowedTarget, https).DoFunc(
+		func(r *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+			r = r.WithContext(WithClient(r.Context(), proxyCtxClient(r, ctx)))
+			ctx.RoundTripper = goproxy.RoundTripperFunc(
+				func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Response, error) {
+					return transport.RoundTrip(req)
+				})
 			return r, nil
 		})
 
+	// Other requests are forwarded untouched unless refused by the policy
+	proxy.OnRequest(goproxy.Not(allowedTarget)).DoFunc(
+		func(r *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+			if configs.Load().UnmatchedPolicy != UnmatchedPolicyRefuse {
+				return r, nil
+			}
+			return r, newErrorResponse(r, http.StatusForbidden, ErrorDetail{
+				Code:    ErrorCodeTargetNotAllowed,
+				Message: "Target not allowed by the proxy",
+			}, nil)
+		})
+
+	// Count every request answered, including the requests refused by the proxy
+	proxy.OnResponse().DoFunc(
+		func(resp *http.Response, ctx *goproxy.ProxyCtx) *http.Response {
+			status := RequestStatusError
+			if resp != nil {
+				status = strconv.Itoa(resp.StatusCode)
+			}
+			host := metricsHost(configs.Load().Allowlist, ctx.Req.URL.Host)
+			config.Metrics.observeRequest(host, status, proxyCtxClient(ctx.Req, ctx))
+			return resp
+		})
+
 	return proxy
 }
+
+// proxyCtxClient returns the client authenticated for the proxy context,
+// or identifies the client by its IP address when clients are not authenticated
+func proxyCtxClient(r *http.Request, ctx *goproxy.ProxyCtx) Client {
+	if client, ok := ctx.UserData.(Client); ok {
+		return client
+	}
+	return remoteClient(r)
+}
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newProxyClient starts the proxy handler and returns a client sending requests through it
+func newProxyClient(t *testing.T, handler http.Handler) *http.Client {
+	t.Helper()
+
+	proxyServer := httptest.NewServer(handler)
+	t.Cleanup(proxyServer.Close)
+
+	proxyURL, err := url.Parse(proxyServer.URL)
+	require.NoError(t, err)
+
+	return &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+}
+
+func TestCreateProxyHandler_Allowlist(t *testing.T) {
+	certPEM, keyPEM := newTestCA(t)
+	ca, err := LoadCA(certPEM, keyPEM, time.Now())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		tls             bool
+		allowedTargets  []string
+		unmatchedPolicy UnmatchedPolicy
+		setupMock       func(*MockKeyGetter)
+		expectedStatus  int
+		expectedCode    string
+		expectedCalls   []string
+	}{
+		{
+			name:            "Allowed target receives a key",
+			tls:             true,
+			allowedTargets:  []string{"127.0.0.1"},
+			unmatchedPolicy: UnmatchedPolicyRefuse,
+			setupMock: func(m *MockKeyGetter) {
+				m.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+				m.On("DeductKeyBalance", mock.Anything, "key-1", int64(10)).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedCalls:  []string{"Bearer key-1"},
+		},
+		{
+			name:            "Plain HTTP to an allowed target is refused",
+			allowedTargets:  []string{"127.0.0.1"},
+			unmatchedPolicy: UnmatchedPolicyForward,
+			expectedStatus:  http.StatusForbidden,
+			expectedCode:    ErrorCodeHTTPSRequired,
+		},
+		{
+			name:            "Unmatched target is forwarded without key",
+			allowedTargets:  []string{"*.jina.ai"},
+			unmatchedPolicy: UnmatchedPolicyForward,
+			expectedStatus:  http.StatusUnauthorized,
+			expectedCalls:   []string{""},
+		},
+		{
+			name:            "Unmatched target is refused",
+			allowedTargets:  []string{"*.jina.ai"},
+			unmatchedPolicy: UnmatchedPolicyRefuse,
+			expectedStatus:  http.StatusForbidden,
+			expectedCode:    ErrorCodeTargetNotAllowed,
+		},
+		{
+			name:            "Unmatched path is refused",
+			allowedTargets:  []string{"127.0.0.1/v1/"},
+			unmatchedPolicy: UnmatchedPolicyRefuse,
+			expectedStatus:  http.StatusForbidden,
+			expectedCode:    ErrorCodeTargetNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newUpstream := newFakeUpstream
+			if tt.tls {
+				newUpstream = newFakeTLSUpstream
+			}
+			upstream, calls := newUpstream(t, map[string]int{"key-1": http.StatusOK})
+
+			keyGetter := new(MockKeyGetter)
+			if tt.setupMock != nil {
+				tt.setupMock(keyGetter)
+			}
+
+			allowlist, err := ParseAllowlist(tt.allowedTargets)
+			require.NoError(t, err)
+
+			handler := CreateProxyHandler(context.Background(), keyGetter, NewConfigStore(Config{
+				MaxBufferedBodyBytes: 1024,
+				CA:                   ca,
+				Allowlist:            allowlist,
+				UnmatchedPolicy:      tt.unmatchedPolicy,
+			}), NewStats())
+			client := newProxyClient(t, handler)
+
+			resp, err := client.Get(upstream.URL + "/embeddings")
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+			if tt.expectedCode != "" {
+				var body ErrorResponse
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+				assert.Equal(t, tt.expectedCode, body.Error.Code)
+			}
+
+			var authorizations []string
+			for _, call := range calls() {
+				authorizations = append(authorizations, call.Authorization)
+			}
+			assert.Equal(t, tt.expectedCalls, authorizations)
+			keyGetter.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateProxyHandler_Connect(t *testing.T) {
+	certPEM, keyPEM := newTestCA(t)
+
+	tests := []struct {
+		name            string
+		allowedTargets  []string
+		unmatchedPolicy UnmatchedPolicy
+		setupMock       func(*MockKeyGetter)
+		expectedErr     bool
+		expectedIssuer  string
+		expectedCalls   []string
+	}{
+		{
+			name:            "Allowed host is intercepted",
+			allowedTargets:  []string{"127.0.0.1/v1/"},
+			unmatchedPolicy: UnmatchedPolicyRefuse,
+			setupMock: func(m *MockKeyGetter) {
+				m.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+			},
+			expectedIssuer: "Test CA",
+			expectedCalls:  []string{"Bearer key-1"},
+		},
+		{
+			name:            "Unmatched host is tunneled",
+			allowedTargets:  []string{"*.jina.ai"},
+			unmatchedPolicy: UnmatchedPolicyForward,
+			expectedCalls:   []string{""},
+		},
+		{
+			name:            "Unmatched host is rejected",
+			allowedTargets:  []string{"*.jina.ai"},
+			unmatchedPolicy: UnmatchedPolicyRefuse,
+			expectedErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var authorizations []string
+			upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authorizations = append(authorizations, r.Header.Get("Authorization"))
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			t.Cleanup(upstream.Close)
+
+			keyGetter := new(MockKeyGetter)
+			if tt.setupMock != nil {
+				tt.setupMock(keyGetter)
+			}
+
+			allowlist, err := ParseAllowlist(tt.allowedTargets)
+			require.NoError(t, err)
+
+			ca, err := LoadCA(certPEM, keyPEM, time.Now())
+			require.NoError(t, err)
+
+			handler := CreateProxyHandler(context.Background(), keyGetter, NewConfigStore(Config{
+				MaxBufferedBodyBytes: 1024,
+				CA:                   ca,
+				Allowlist:            allowlist,
+				UnmatchedPolicy:      tt.unmatchedPolicy,
+			}), NewStats())
+			client := newProxyClient(t, handler)
+
+			resp, err := client.Get(upstream.URL + "/v1/embeddings")
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+			if tt.expectedIssuer != "" {
+				assert.Equal(t, tt.expectedIssuer, resp.TLS.PeerCertificates[0].Issuer.CommonName)
+			} else {
+				assert.True(t, resp.TLS.PeerCertificates[0].Equal(upstream.Certificate()))
+			}
+			assert.Equal(t, tt.expectedCalls, authorizations)
+			keyGetter.AssertExpectations(t)
+		})
+	}
+}
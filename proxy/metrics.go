@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsNamespace prefixes the names of the Prometheus metrics
+const MetricsNamespace = "jina"
+
+// RequestStatusError is the status label of the requests that got no upstream response
+const RequestStatusError = "error"
+
+// MetricsClientAnonymous is the client label of the requests from unauthenticated clients
+const MetricsClientAnonymous = "anonymous"
+
+// MetricsHostOther is the host label of the requests to targets outside of the allowlist
+const MetricsHostOther = "other"
+
+// Metrics holds the Prometheus metrics of the requests, upstream calls and retries of the proxy
+type Metrics struct {
+	requests         *prometheus.CounterVec
+	upstreamDuration *prometheus.HistogramVec
+	retries          *prometheus.CounterVec
+	unaccounted      *prometheus.CounterVec
+}
+
+// observeRequest counts a request answered to a client, status is RequestStatusError
+// when no response was received. Unauthenticated clients are counted as MetricsClientAnonymous,
+// so the IP addresses of the clients do not create label values.
+func (m *Metrics) observeRequest(host string, status string, client Client) {
+	if m == nil {
+		return
+	}
+	m.requests.WithLabelValues(host, status, metricsClient(client)).Inc()
+}
+
+// metricsClient returns the client label of a request from the client
+func metricsClient(client Client) string {
+	if client.ID == 0 {
+		return MetricsClientAnonymous
+	}
+	return client.Name
+}
+
+// metricsHost returns the host label of a request to the host, targets outside of the allowlist
+// are counted as MetricsHostOther so arbitrary hosts do not create label values
+func metricsHost(allowlist *Allowlist, host string) string {
+	if !allowlist.AllowsHost(host) {
+		return MetricsHostOther
+	}
+	return host
+}
+
+// observeUpstream records how long upstream took to send the response headers
+func (m *Metrics) observeUpstream(host string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.upstreamDuration.WithLabelValues(host).Observe(duration.Seconds())
+}
+
+// observeRetry counts a request sent again with another key
+func (m *Metrics) observeRetry(host string) {
+	if m == nil {
+		return
+	}
+	m.retries.WithLabelValues(host).Inc()
+}
+
+// observeUnaccounted counts a successful response whose usage could not be read, so nothing was
+// deducted from the key nor debited from the client
+func (m *Metrics) observeUnaccounted(host string, client Client) {
+	if m == nil {
+		return
+	}
+	m.unaccounted.WithLabelValues(host, metricsClient(client)).Inc()
+}
+
+// NewMetrics creates the proxy metrics and registers them
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "requests_total",
+			Help:      "Requests answered by the proxy by upstream host, status and client.",
+		}, []string{"host", "status", "client"}),
+		upstreamDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "upstream_duration_seconds",
+			Help:      "Time until upstream sends the response headers, for every attempt with a key.",
+			Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+		}, []string{"host"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "retries_total",
+			Help:      "Requests sent again with another key by upstream host.",
+		}, []string{"host"}),
+		unaccounted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "unaccounted_responses_total",
+			Help:      "Successful JSON responses whose usage could not be read, so no tokens were deducted, by upstream host and client.",
+		}, []string{"host", "client"}),
+	}
+	registerer.MustRegister(m.requests, m.upstreamDuration, m.retries, m.unaccounted)
+	return m
+}
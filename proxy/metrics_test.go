@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_Nil(t *testing.T) {
+	var metrics *Metrics
+	assert.NotPanics(t, func() {
+		metrics.observeRequest("api.jina.ai", "200", Client{Name: "client"})
+		metrics.observeUpstream("api.jina.ai", 0)
+		metrics.observeRetry("api.jina.ai")
+		metrics.observeUnaccounted("api.jina.ai", Client{Name: "client"})
+	})
+}
+
+func TestMetrics_ObserveRequest_Client(t *testing.T) {
+	metrics := NewMetrics(prometheus.NewRegistry())
+	metrics.observeRequest("api.jina.ai", "200", Client{ID: 1, Name: "client"})
+	metrics.observeRequest("api.jina.ai", "200", Client{Name: "192.0.2.1"})
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.requests.WithLabelValues("api.jina.ai", "200", "client")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.requests.WithLabelValues("api.jina.ai", "200", MetricsClientAnonymous)))
+	assert.Equal(t, 2, testutil.CollectAndCount(metrics.requests))
+}
+
+func TestCreateProxyHandler_Metrics(t *testing.T) {
+	upstream, _ := newFakeTLSUpstream(t, map[string]int{"key-1": http.StatusUnauthorized, "key-2": http.StatusOK})
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+	plainUpstream, _ := newFakeUpstream(t, nil)
+	plainUpstreamURL, err := url.Parse(plainUpstream.URL)
+	require.NoError(t, err)
+
+	keyGetter := new(MockKeyGetter)
+	keyGetter.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+	keyGetter.On("InvalidateKey", mock.Anything, "key-1").Return(nil)
+	keyGetter.On("UseBestKey", mock.Anything, []string{"key-1"}).Return("key-2", nil)
+	keyGetter.On("DeductKeyBalance", mock.Anything, "key-2", int64(10)).Return(nil)
+
+	allowlist, err := ParseAllowlist([]string{"127.0.0.1"})
+	require.NoError(t, err)
+
+	certPEM, keyPEM := newTestCA(t)
+	ca, err := LoadCA(certPEM, keyPEM, time.Now())
+	require.NoError(t, err)
+
+	registry := prometheus.NewRegistry()
+	metrics := NewMetrics(registry)
+	client := newProxyClient(t, CreateProxyHandler(context.Background(), keyGetter, NewConfigStore(Config{
+		MaxRetries:           3,
+		MaxBufferedBodyBytes: 1024,
+		CA:                   ca,
+		Allowlist:            allowlist,
+		Metrics:              metrics,
+	}), NewStats()))
+
+	resp, err := client.Post(upstream.URL+"/v1/embeddings", "application/json", strings.NewReader(`{"input":["hello"]}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Requests to targets outside of the allowlist share one host label
+	resp, err = client.Get("http://localhost:" + plainUpstreamURL.Port() + "/v1/models")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.requests.WithLabelValues(upstreamURL.Host, "200", MetricsClientAnonymous)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.requests.WithLabelValues(MetricsHostOther, strconv.Itoa(resp.StatusCode), MetricsClientAnonymous)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.retries.WithLabelValues(upstreamURL.Host)))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.upstreamDuration))
+
+	count, err := testutil.GatherAndCount(registry, "jina_proxy_upstream_duration_seconds")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	keyGetter.AssertExpectations(t)
+}
+
+func TestCreateReverseProxyHandler_Metrics(t *testing.T) {
+	upstream, _ := newFakeUpstream(t, map[string]int{"key-1": http.StatusOK})
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	keyGetter := new(MockKeyGetter)
+	keyGetter.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+	keyGetter.On("DeductKeyBalance", mock.Anything, "key-1", int64(10)).Return(nil)
+
+	metrics := NewMetrics(prometheus.NewRegistry())
+	configs := NewConfigStore(Config{MaxBufferedBodyBytes: 1024, Metrics: metrics})
+	reverseProxy := httptest.NewServer(CreateReverseProxyHandler(keyGetter, upstreamURL, configs, NewStats()))
+	t.Cleanup(reverseProxy.Close)
+
+	resp, err := http.Post(reverseProxy.URL+"/v1/embeddings", "application/json", strings.NewReader(`{"input":["hello"]}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.requests.WithLabelValues(upstreamURL.Host, "200", MetricsClientAnonymous)))
+
+	// Requests without upstream response are counted as errors
+	unreachable, err := url.Parse("http://127.0.0.1:1")
+	require.NoError(t, err)
+	keyGetter.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+	reverseProxy = httptest.NewServer(CreateReverseProxyHandler(keyGetter, unreachable, configs, NewStats()))
+	t.Cleanup(reverseProxy.Close)
+
+	resp, err = http.Post(reverseProxy.URL+"/v1/embeddings", "application/json", strings.NewReader(`{"input":["hello"]}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.requests.WithLabelValues(unreachable.Host, RequestStatusError, MetricsClientAnonymous)))
+}
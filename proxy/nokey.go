@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// KeyWaitPollInterval is the longest delay between two attempts to get a key while waiting for one
+const KeyWaitPollInterval = time.Second
+
+// Error codes of the responses sent by the proxy when no key can be used
+const (
+	ErrorCodeNoKeyAvailable    = "no_key_available"
+	ErrorCodeKeySelectionError = "key_selection_failed"
+)
+
+// isNoKeyAvailable reports whether the key getter found no selectable key
+func isNoKeyAvailable(key *string, err error) bool {
+	return key == nil && (err == nil || errors.Is(err, sql.ErrNoRows))
+}
+
+// waitForKey returns the best key, waiting up to the key wait timeout for a key to be available
+func (t *keyTransport) waitForKey(ctx context.Context) (*string, error) {
+	deadline := time.Now().Add(t.config.Load().KeyWaitTimeout)
+	for {
+		key, err := t.keyGetter.UseBestKey(ctx)
+		if !isNoKeyAvailable(key, err) {
+			return key, err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return key, err
+		}
+
+		delay := min(KeyWaitPollInterval, remaining)
+		if next, nextErr := t.keyGetter.NextKeyAvailableAt(ctx); nextErr == nil && next != nil {
+			delay = min(delay, max(time.Until(*next), 0))
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// noKeyResponse answers 503 to a request that cannot get a key.
+// Retry-After tells when the first cooling down key can be used again.
+func (t *keyTransport) noKeyResponse(req *http.Request, err error) *http.Response {
+	t.stats.NoKeyAvailable.Add(1)
+
+	detail := ErrorDetail{Code: ErrorCodeNoKeyAvailable, Message: "No key is available to authenticate the request"}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.Warn("use best key", slog.Any("error", err))
+		detail = ErrorDetail{Code: ErrorCodeKeySelectionError, Message: "The key to authenticate the request could not be selected"}
+	}
+
+	header := http.Header{}
+	next, nextErr := t.keyGetter.NextKeyAvailableAt(context.WithoutCancel(req.Context()))
+	if nextErr != nil {
+		slog.Warn("next key available at", slog.Any("error", nextErr))
+	} else if next != nil {
+		seconds := max(int64(math.Ceil(time.Until(*next).Seconds())), 1)
+		header.Set("Retry-After", strconv.FormatInt(seconds, 10))
+	}
+
+	slog.Warn("no key available", slog.String("host", req.URL.Host), slog.String("code", detail.Code))
+	return newErrorResponse(req, http.StatusServiceUnavailable, detail, header)
+}
@@ -0,0 +1,34 @@
+package proxy
+
+import "sync/atomic"
+
+// ConfigStore holds the config of the proxy handlers. The reloadable settings can be swapped
+// while requests are served, a request keeps the settings it started with.
+type ConfigStore struct {
+	current atomic.Pointer[Config]
+}
+
+// Load returns the current config, it must not be modified
+func (s *ConfigStore) Load() *Config {
+	return s.current.Load()
+}
+
+// Reload swaps the reloadable settings with the settings of the config.
+// The CA, the certificate cache size, the authenticator, the budgets, the metrics and the
+// tracer provider are kept as the handlers are built with them.
+func (s *ConfigStore) Reload(config Config) {
+	current := s.current.Load()
+	config.CA = current.CA
+	config.CertCacheSize = current.CertCacheSize
+	config.Authenticator = current.Authenticator
+	config.Budgets = current.Budgets
+	config.Metrics = current.Metrics
+	config.TracerProvider = current.TracerProvider
+	s.current.Store(&config)
+}
+
+func NewConfigStore(config Config) *ConfigStore {
+	store := &ConfigStore{}
+	store.current.Store(&config)
+	return store
+}
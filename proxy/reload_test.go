@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigStore_Reload(t *testing.T) {
+	ca := &tls.Certificate{}
+	authenticator := fakeAuthenticator{"token": {ID: 1, Name: "client"}}
+	tracerProvider, _ := newSpanRecorder()
+	store := NewConfigStore(Config{
+		MaxRetries:     3,
+		CA:             ca,
+		CertCacheSize:  100,
+		Authenticator:  authenticator,
+		TracerProvider: tracerProvider,
+	})
+
+	store.Reload(Config{MaxRetries: 1, UnmatchedPolicy: UnmatchedPolicyRefuse})
+
+	config := store.Load()
+	assert.Equal(t, 1, config.MaxRetries)
+	assert.Equal(t, UnmatchedPolicyRefuse, config.UnmatchedPolicy)
+	assert.Same(t, ca, config.CA, "The CA is kept")
+	assert.Equal(t, 100, config.CertCacheSize, "The cert cache size is kept")
+	assert.Equal(t, authenticator, config.Authenticator, "The authenticator is kept")
+	assert.Same(t, tracerProvider, config.TracerProvider, "The tracer provider is kept")
+}
+
+func TestCreateProxyHandler_ReloadAllowlist(t *testing.T) {
+	upstream, calls := newFakeTLSUpstream(t, map[string]int{"key-1": http.StatusOK})
+
+	keyGetter := new(MockKeyGetter)
+	keyGetter.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+	keyGetter.On("DeductKeyBalance", mock.Anything, "key-1", int64(10)).Return(nil)
+
+	refused, err := ParseAllowlist([]string{"*.jina.ai"})
+	require.NoError(t, err)
+	allowed, err := ParseAllowlist([]string{"127.0.0.1"})
+	require.NoError(t, err)
+
+	certPEM, keyPEM := newTestCA(t)
+	ca, err := LoadCA(certPEM, keyPEM, time.Now())
+	require.NoError(t, err)
+
+	store := NewConfigStore(Config{MaxBufferedBodyBytes: 1024, CA: ca, Allowlist: refused, UnmatchedPolicy: UnmatchedPolicyRefuse})
+	client := newProxyClient(t, CreateProxyHandler(context.Background(), keyGetter, store, NewStats()))
+
+	// The connection to a refused host is rejected
+	_, err = client.Get(upstream.URL + "/embeddings")
+	require.Error(t, err)
+
+	store.Reload(Config{MaxBufferedBodyBytes: 1024, CA: ca, Allowlist: allowed, UnmatchedPolicy: UnmatchedPolicyRefuse})
+
+	resp, err := client.Get(upstream.URL + "/embeddings")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "The reloaded allowlist applies to the next request")
+	require.Len(t, calls(), 1)
+	assert.Equal(t, "Bearer key-1", calls()[0].Authorization)
+	keyGetter.AssertExpectations(t)
+}
@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// ErrorResponse is the JSON body of the errors sent by the proxy itself
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Budget is the budget spent by the client, only set for budget errors
+	Budget *BudgetExceeded `json:"budget,omitempty"`
+}
+
+// newErrorResponse builds the response to an error of the proxy itself, without calling upstream
+func newErrorResponse(req *http.Request, status int, detail ErrorDetail, header http.Header) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Type", "application/json")
+
+	body, _ := json.Marshal(ErrorResponse{Error: detail}) // Marshaling strings cannot fail
+
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+)
+
+// ErrorCodeUpstreamUnreachable is the error code of the reverse proxy requests that got no upstream response
+const ErrorCodeUpstreamUnreachable = "upstream_unreachable"
+
+// CreateReverseProxyHandler forwards every request to the upstream URL with the best key,
+// for clients that cannot use a forward proxy or trust the proxy CA
+func CreateReverseProxyHandler(keyGetter KeyGetter, upstream *url.URL, configs *ConfigStore, stats *Stats) http.Handler {
+	config := configs.Load()
+	return withProxyAuth(config.Authenticator, &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(upstream)
+		},
+		Transport: &keyTransport{
+			keyGetter: keyGetter,
+			transport: http.DefaultTransport,
+			config:    configs,
+			stats:     stats,
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			config.Metrics.observeRequest(upstream.Host, strconv.Itoa(resp.StatusCode), ClientFromContext(resp.Request.Context()))
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if errors.Is(err, context.Canceled) {
+				// The client went away, nobody reads the answer
+				config.Metrics.observeRequest(upstream.Host, RequestStatusError, ClientFromContext(r.Context()))
+				return
+			}
+			slog.Warn("reverse proxy error", slog.String("host", upstream.Host), slog.Any("error", err))
+			config.Metrics.observeRequest(upstream.Host, RequestStatusError, ClientFromContext(r.Context()))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadGateway)
+			_ = json.NewEncoder(w).Encode(ErrorResponse{Error: ErrorDetail{
+				Code:    ErrorCodeUpstreamUnreachable,
+				Message: "The upstream could not be reached",
+			}})
+		},
+	})
+}
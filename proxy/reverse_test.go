@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateReverseProxyHandler(t *testing.T) {
+	upstream, calls := newFakeUpstream(t, map[string]int{"key-1": http.StatusUnauthorized, "key-2": http.StatusOK})
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	keyGetter := new(MockKeyGetter)
+	keyGetter.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+	keyGetter.On("InvalidateKey", mock.Anything, "key-1").Return(nil)
+	keyGetter.On("UseBestKey", mock.Anything, []string{"key-1"}).Return("key-2", nil)
+	keyGetter.On("DeductKeyBalance", mock.Anything, "key-2", int64(10)).Return(nil)
+
+	stats := NewStats()
+	reverseProxy := httptest.NewServer(CreateReverseProxyHandler(keyGetter, upstreamURL, NewConfigStore(Config{
+		MaxRetries:           3,
+		MaxBufferedBodyBytes: 1024,
+	}), stats))
+	t.Cleanup(reverseProxy.Close)
+
+	req, err := http.NewRequest(http.MethodPost, reverseProxy.URL+"/v1/embeddings", strings.NewReader(`{"input":["hello"]}`))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer client-key")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []upstreamCall{
+		{Authorization: "Bearer key-1", Body: `{"input":["hello"]}`},
+		{Authorization: "Bearer key-2", Body: `{"input":["hello"]}`},
+	}, calls())
+	assert.Equal(t, int64(1), stats.Retries.Load())
+	keyGetter.AssertExpectations(t)
+}
+
+func TestCreateReverseProxyHandler_UpstreamUnreachable(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+	upstream.Close()
+
+	keyGetter := new(MockKeyGetter)
+	keyGetter.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+
+	reverseProxy := httptest.NewServer(CreateReverseProxyHandler(keyGetter, upstreamURL, NewConfigStore(Config{
+		MaxBufferedBodyBytes: 1024,
+	}), NewStats()))
+	t.Cleanup(reverseProxy.Close)
+
+	resp, err := http.Post(reverseProxy.URL+"/v1/embeddings", "application/json", strings.NewReader(`{"input":["hello"]}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `"code":"upstream_unreachable"`)
+}
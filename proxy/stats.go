@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// Stats holds the proxy counters
+type Stats struct {
+	Requests         atomic.Int64
+	Retries          atomic.Int64
+	RetriesExhausted atomic.Int64
+	NoKeyAvailable   atomic.Int64
+	BudgetExceeded   atomic.Int64
+	CertCacheHits    atomic.Int64
+	CertCacheMisses  atomic.Int64
+}
+
+type StatsSnapshot struct {
+	Requests         int64
+	Retries          int64
+	RetriesExhausted int64
+	NoKeyAvailable   int64
+	BudgetExceeded   int64
+	CertCacheHits    int64
+	CertCacheMisses  int64
+}
+
+// Snapshot returns the current value of the counters
+func (s *Stats) Snapshot() StatsSnapshot {
+	return StatsSnapshot{
+		Requests:         s.Requests.Load(),
+		Retries:          s.Retries.Load(),
+		RetriesExhausted: s.RetriesExhausted.Load(),
+		NoKeyAvailable:   s.NoKeyAvailable.Load(),
+		BudgetExceeded:   s.BudgetExceeded.Load(),
+		CertCacheHits:    s.CertCacheHits.Load(),
+		CertCacheMisses:  s.CertCacheMisses.Load(),
+	}
+}
+
+func NewStats() *Stats {
+	return &Stats{}
+}
+
+type StatsHandler struct {
+	stats *Stats
+}
+
+func (h *StatsHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	if err := json.NewEncoder(w).Encode(h.stats.Snapshot()); err != nil {
+		http.Error(w, "Failed to encode response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func NewStatsHandler(stats *Stats) *StatsHandler {
+	return &StatsHandler{stats: stats}
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+
+	"github.com/elazarl/goproxy"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// TracerName is the instrumentation name of the proxy spans
+const TracerName = "github.com/trancong12102/jina-http-proxy/proxy"
+
+// propagator reads the trace context of incoming requests and writes it into upstream requests.
+// Only the W3C trace context is propagated, the proxy does not add baggage.
+var propagator = propagation.TraceContext{}
+
+// tracer returns the tracer of the proxy spans, spans are not recorded when no tracer provider is set
+func (c *Config) tracer() trace.Tracer {
+	if c.TracerProvider == nil {
+		return noop.NewTracerProvider().Tracer(TracerName)
+	}
+	return c.TracerProvider.Tracer(TracerName)
+}
+
+// startRequestSpan starts the span of a proxied request, child of the trace context sent by the client
+func startRequestSpan(config *Config, req *http.Request) (context.Context, trace.Span) {
+	ctx := propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	return config.tracer().Start(ctx, "proxy.request",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", req.URL.Host),
+			attribute.String("url.path", req.URL.Path),
+			attribute.String("proxy.client", ClientFromContext(req.Context()).Name),
+		),
+	)
+}
+
+// startUpstreamSpan starts the span of an attempt sent upstream and writes its trace context
+// into the request headers
+func startUpstreamSpan(config *Config, req *http.Request, attempt int) (*http.Request, trace.Span) {
+	ctx, span := config.tracer().Start(req.Context(), "proxy.upstream",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", req.URL.Host),
+			attribute.Int("proxy.attempt", attempt),
+		),
+	)
+	req = req.WithContext(ctx)
+	propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return req, span
+}
+
+// endHTTPSpan records the outcome of a round trip and ends its span.
+// Server errors and round trips without response are marked as errors.
+func endHTTPSpan(span trace.Span, resp *http.Response, err error) {
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case resp != nil:
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		if resp.StatusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+		}
+	}
+	span.End()
+}
+
+// acquireKey gets a key for a request under a key acquisition span, waiting for a key on the
+// first attempt and excluding the keys already used on retries
+func (t *keyTransport) acquireKey(ctx context.Context, config *Config, usedKeys []string) (*string, error) {
+	ctx, span := config.tracer().Start(ctx, "key.acquire", trace.WithAttributes(
+		attribute.Int("key.excluded", len(usedKeys)),
+	))
+	defer span.End()
+
+	var key *string
+	var err error
+	if len(usedKeys) == 0 {
+		key, err = t.waitForKey(ctx)
+	} else {
+		key, err = t.keyGetter.UseBestKey(ctx, usedKeys...)
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.SetAttributes(attribute.Bool("key.found", key != nil))
+	return key, err
+}
+
+// tracedTLSConfig wraps the TLS config of intercepted hosts with a span covering only the fetching
+// of the host certificate from the cache or its signing, not the TLS handshake with the client.
+// The span is a child of the trace context of the CONNECT request, so it starts its own trace
+// when the client sends trace headers only on the requests inside the tunnel.
+func tracedTLSConfig(config *Config, tlsConfig func(host string, ctx *goproxy.ProxyCtx) (*tls.Config, error)) func(host string, ctx *goproxy.ProxyCtx) (*tls.Config, error) {
+	return func(host string, ctx *goproxy.ProxyCtx) (*tls.Config, error) {
+		spanCtx := propagator.Extract(ctx.Req.Context(), propagation.HeaderCarrier(ctx.Req.Header))
+		_, span := config.tracer().Start(spanCtx, "proxy.host_certificate", trace.WithAttributes(
+			attribute.String("server.address", host),
+		))
+		defer span.End()
+
+		result, err := tlsConfig(host, ctx)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return result, err
+	}
+}
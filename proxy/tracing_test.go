@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// clientTraceparent is the trace context sent by the client in the tests
+const clientTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// newSpanRecorder returns a tracer provider recording the ended spans in the returned recorder
+func newSpanRecorder() (*sdktrace.TracerProvider, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	return sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)), recorder
+}
+
+// spansByName returns the ended spans with the name, in the order they ended
+func spansByName(recorder *tracetest.SpanRecorder, name string) []sdktrace.ReadOnlySpan {
+	var spans []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func TestCreateReverseProxyHandler_Tracing(t *testing.T) {
+	var mu sync.Mutex
+	var traceparents []string
+	var baggages []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceparents = append(traceparents, r.Header.Get("Traceparent"))
+		baggages = append(baggages, r.Header.Get("Baggage"))
+		mu.Unlock()
+
+		if r.Header.Get("Authorization") == "Bearer key-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(upstream.Close)
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	keyGetter := new(MockKeyGetter)
+	keyGetter.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+	keyGetter.On("InvalidateKey", mock.Anything, "key-1").Return(nil)
+	keyGetter.On("UseBestKey", mock.Anything, []string{"key-1"}).Return("key-2", nil)
+
+	tracerProvider, recorder := newSpanRecorder()
+	reverseProxy := httptest.NewServer(CreateReverseProxyHandler(keyGetter, upstreamURL, NewConfigStore(Config{
+		MaxRetries:           3,
+		MaxBufferedBodyBytes: 1024,
+		TracerProvider:       tracerProvider,
+	}), NewStats()))
+	t.Cleanup(reverseProxy.Close)
+
+	req, err := http.NewRequest(http.MethodPost, reverseProxy.URL+"/v1/embeddings", strings.NewReader(`{"input":["hello"]}`))
+	require.NoError(t, err)
+	req.Header.Set("Traceparent", clientTraceparent)
+	req.Header.Set("Baggage", "user=alice")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	keyGetter.AssertExpectations(t)
+
+	// The request span continues the trace of the client
+	requestSpans := spansByName(recorder, "proxy.request")
+	require.Len(t, requestSpans, 1)
+	requestSpan := requestSpans[0]
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", requestSpan.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", requestSpan.Parent().SpanID().String())
+	assert.True(t, requestSpan.Parent().IsRemote())
+	assert.Equal(t, trace.SpanKindServer, requestSpan.SpanKind())
+	assert.Contains(t, requestSpan.Attributes(), attribute.Int("http.response.status_code", http.StatusNoContent))
+
+	// A key is acquired for the request and for the retry
+	keySpans := spansByName(recorder, "key.acquire")
+	require.Len(t, keySpans, 2)
+	for i, span := range keySpans {
+		assert.Equal(t, requestSpan.SpanContext().SpanID(), span.Parent().SpanID())
+		assert.Contains(t, span.Attributes(), attribute.Int("key.excluded", i))
+		assert.Contains(t, span.Attributes(), attribute.Bool("key.found", true))
+	}
+
+	// Every attempt has its own span, propagated upstream, the baggage of the client is left untouched
+	upstreamSpans := spansByName(recorder, "proxy.upstream")
+	require.Len(t, upstreamSpans, 2)
+	for i, span := range upstreamSpans {
+		assert.Equal(t, requestSpan.SpanContext().SpanID(), span.Parent().SpanID())
+		assert.Equal(t, trace.SpanKindClient, span.SpanKind())
+		assert.Contains(t, span.Attributes(), attribute.Int("proxy.attempt", i))
+		assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.SpanContext().SpanID().String()+"-01", traceparents[i])
+		assert.Equal(t, "user=alice", baggages[i])
+	}
+	assert.Contains(t, upstreamSpans[0].Attributes(), attribute.Int("http.response.status_code", http.StatusUnauthorized))
+}
+
+func TestCreateProxyHandler_TracingMitm(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(upstream.Close)
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	keyGetter := new(MockKeyGetter)
+	keyGetter.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+
+	allowlist, err := ParseAllowlist([]string{"127.0.0.1"})
+	require.NoError(t, err)
+
+	certPEM, keyPEM := newTestCA(t)
+	ca, err := LoadCA(certPEM, keyPEM, time.Now())
+	require.NoError(t, err)
+
+	tracerProvider, recorder := newSpanRecorder()
+	client := newProxyClient(t, CreateProxyHandler(context.Background(), keyGetter, NewConfigStore(Config{
+		MaxBufferedBodyBytes: 1024,
+		CA:                   ca,
+		Allowlist:            allowlist,
+		TracerProvider:       tracerProvider,
+	}), NewStats()))
+
+	resp, err := client.Get(upstream.URL + "/v1/embeddings")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	certificateSpans := spansByName(recorder, "proxy.host_certificate")
+	require.Len(t, certificateSpans, 1)
+	assert.Contains(t, certificateSpans[0].Attributes(), attribute.String("server.address", upstreamURL.Host))
+
+	requestSpans := spansByName(recorder, "proxy.request")
+	require.Len(t, requestSpans, 1)
+	assert.Contains(t, requestSpans[0].Attributes(), attribute.String("server.address", upstreamURL.Host))
+	assert.Len(t, spansByName(recorder, "key.acquire"), 1)
+	assert.Len(t, spansByName(recorder, "proxy.upstream"), 1)
+}
@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrorCodeUpstreamResponseUnreadable is the error code of the 502 answered when the body of a successful
+// upstream response cannot be read, the partial body is not sent as if it were complete
+const ErrorCodeUpstreamResponseUnreadable = "upstream_response_unreadable"
+
+type Config struct {
+	// MaxRetries is the number of times a request is retried with another key
+	MaxRetries int
+	// MaxBufferedBodyBytes is the largest request body buffered to be replayed on retry,
+	// and the largest response body buffered to read its usage before it is sent
+	MaxBufferedBodyBytes int64
+	// DefaultCooldown is used for rate limited keys when upstream does not tell when to retry
+	DefaultCooldown time.Duration
+	// MaxCooldown caps the cooldown requested by upstream
+	MaxCooldown time.Duration
+	// KeyWaitTimeout is how long a request waits for a key when none is available, 0 answers 503 at once
+	KeyWaitTimeout time.Duration
+	// CA signs the certificates of intercepted hosts
+	CA *tls.Certificate
+	// CertCacheSize is the number of certificates of intercepted hosts kept in memory, 0 disables the cache
+	CertCacheSize int
+	// Authenticator authenticates the clients from their Proxy-Authorization header,
+	// clients are not authenticated when nil
+	Authenticator ClientAuthenticator
+	// Budgets enforces the budgets of authenticated clients, budgets are not enforced when nil
+	Budgets ClientBudgets
+	// Metrics records the Prometheus metrics of the proxy, nothing is recorded when nil
+	Metrics *Metrics
+	// TracerProvider creates the tracer of the proxy spans, nothing is traced when nil
+	TracerProvider trace.TracerProvider
+	// ClientKeyPolicies tells what to do with the Authorization header sent by each client
+	ClientKeyPolicies ClientKeyPolicies
+	// Allowlist is the list of upstream targets that receive keys
+	Allowlist *Allowlist
+	// UnmatchedPolicy tells what to do with requests to targets outside of the allowlist
+	UnmatchedPolicy UnmatchedPolicy
+}
+
+// keyTransport injects the best key into requests and retries with another key
+// when upstream rejects the key
+type keyTransport struct {
+	keyGetter KeyGetter
+	transport http.RoundTripper
+	config    *ConfigStore
+	stats     *Stats
+}
+
+// Check if keyTransport implements http.RoundTripper
+var _ http.RoundTripper = &keyTransport{}
+
+// RoundTrip sends the request under a span continuing the trace context of the client
+func (t *keyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	config := t.config.Load()
+	ctx, span := startRequestSpan(config, req)
+	resp, err := t.roundTrip(req.WithContext(ctx), config)
+	endHTTPSpan(span, resp, err)
+	return resp, err
+}
+
+func (t *keyTransport) roundTrip(req *http.Request, config *Config) (*http.Response, error) {
+	t.stats.Requests.Add(1)
+	ctx := context.WithoutCancel(req.Context())
+
+	if req.Header.Get("Authorization") != "" {
+		client := ClientFromContext(req.Context())
+		policy := config.ClientKeyPolicies.For(client.Name)
+		slog.Info("client sent its own key",
+			slog.String("client", client.Name),
+			slog.String("host", req.URL.Host),
+			slog.String("policy", string(policy)),
+		)
+
+		switch policy {
+		case ClientKeyPolicyKeep:
+			return t.transport.RoundTrip(req)
+		case ClientKeyPolicyReject:
+			return newErrorResponse(req, http.StatusForbidden, ErrorDetail{
+				Code:    ErrorCodeClientKeyRejected,
+				Message: "Requests must not carry their own Authorization header",
+			}, nil), nil
+		}
+	}
+
+	if resp := t.checkClientBudget(req); resp != nil {
+		return resp, nil
+	}
+
+	body, replayable, err := bufferBody(req, config.MaxBufferedBodyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := t.acquireKey(req.Context(), config, nil)
+	if errors.Is(err, context.Canceled) {
+		// The client went away while waiting for a key, nobody reads the answer
+		return nil, err
+	}
+	if err != nil || key == nil {
+		return t.noKeyResponse(req, err), nil
+	}
+
+	var usedKeys []string
+	for attempt := 0; ; attempt++ {
+		usedKeys = append(usedKeys, *key)
+
+		outReq := req
+		if replayable {
+			outReq = req.Clone(req.Context())
+			if body != nil {
+				outReq.Body = io.NopCloser(bytes.NewReader(body))
+				outReq.ContentLength = int64(len(body))
+			}
+		}
+		outReq.Header.Set("Authorization", "Bearer "+*key)
+
+		outReq, span := startUpstreamSpan(config, outReq, attempt)
+		start := time.Now()
+		resp, err := t.transport.RoundTrip(outReq)
+		config.Metrics.observeUpstream(req.URL.Host, time.Since(start))
+		endHTTPSpan(span, resp, err)
+		if err != nil {
+			return nil, err
+		}
+
+		t.reportKeyStatus(ctx, *key, resp)
+		if resp.StatusCode == http.StatusOK {
+			err = t.deductUsage(ctx, *key, req.URL.Host, resp, config)
+			if err != nil {
+				slog.Error("read upstream response", slog.String("host", req.URL.Host), slog.Any("error", err))
+				return newErrorResponse(req, http.StatusBadGateway, ErrorDetail{
+					Code:    ErrorCodeUpstreamResponseUnreadable,
+					Message: "The upstream response could not be read",
+				}, nil), nil
+			}
+			return resp, nil
+		}
+		if !isRetryableStatus(resp.StatusCode) || !replayable {
+			return resp, nil
+		}
+		if attempt >= config.MaxRetries {
+			t.stats.RetriesExhausted.Add(1)
+			slog.Warn("retries exhausted", slog.String("host", req.URL.Host), slog.Int("attempts", attempt+1))
+			return resp, nil
+		}
+
+		nextKey, err := t.acquireKey(req.Context(), config, usedKeys)
+		if err != nil || nextKey == nil {
+			// No other key left, the client gets the last upstream response
+			return resp, nil
+		}
+
+		t.stats.Retries.Add(1)
+		config.Metrics.observeRetry(req.URL.Host)
+		slog.Info("retrying request with another key",
+			slog.String("host", req.URL.Host),
+			slog.Int("status", resp.StatusCode),
+			slog.Int("attempt", attempt+1),
+		)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		key = nextKey
+	}
+}
+
+// reportKeyStatus updates the key status from the upstream response
+func (t *keyTransport) reportKeyStatus(ctx context.Context, key string, resp *http.Response) {
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		slog.Warn("key rejected by upstream, marking as invalid", slog.Int("status", resp.StatusCode))
+		if err := t.keyGetter.InvalidateKey(ctx, key); err != nil {
+			slog.Warn("invalidate key", slog.Any("error", err))
+		}
+	case http.StatusPaymentRequired:
+		slog.Warn("key has insufficient balance, marking as exhausted")
+		if err := t.keyGetter.ExhaustKey(ctx, key); err != nil {
+			slog.Warn("exhaust key", slog.Any("error", err))
+		}
+	case http.StatusTooManyRequests:
+		until := cooldownUntil(resp.Header, time.Now(), *t.config.Load())
+		slog.Warn("key is rate limited, cooling down", slog.Time("until", until))
+		if err := t.keyGetter.CooldownKey(ctx, key, until); err != nil {
+			slog.Warn("cooldown key", slog.Any("error", err))
+		}
+	}
+}
+
+// deductUsage deducts the tokens reported in the response from the key balance and the client budget.
+// The usage of bodies larger than the buffer limit is read while they are sent to the client, and deducted
+// once they are sent. Responses whose usage cannot be read are counted as unaccounted with their client,
+// as the client budget is not debited either.
+// The error reading the body is returned and the response counted as unaccounted, it must not be sent to the client.
+func (t *keyTransport) deductUsage(ctx context.Context, key string, host string, resp *http.Response, config *Config) error {
+	if !isJSONResponse(resp) {
+		return nil
+	}
+
+	err := readUsage(resp, config.MaxBufferedBodyBytes, func(tokens int64, ok bool) {
+		if !ok {
+			client := ClientFromContext(ctx)
+			config.Metrics.observeUnaccounted(host, client)
+			slog.Warn("usage of the response cannot be read, no tokens deducted",
+				slog.String("host", host),
+				slog.String("client", client.Name),
+			)
+			return
+		}
+		if tokens <= 0 {
+			return
+		}
+
+		err := t.keyGetter.DeductKeyBalance(ctx, key, tokens)
+		if err != nil {
+			slog.Warn("deduct key balance", slog.Any("error", err))
+		}
+
+		t.debitClientUsage(ctx, tokens)
+	})
+	if err != nil {
+		config.Metrics.observeUnaccounted(host, ClientFromContext(ctx))
+		return fmt.Errorf("read response body: %w", err)
+	}
+	return nil
+}
+
+// isRetryableStatus reports whether the upstream rejected the key rather than the request
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusPaymentRequired, http.StatusForbidden, http.StatusTooManyRequests:
+		return true
+	default:
+		return false
+	}
+}
+
+// bufferBody reads the request body so it can be replayed.
+// Bodies larger than limit are not buffered, the request body is restored to be sent once.
+func bufferBody(req *http.Request, limit int64) ([]byte, bool, error) {
+	return bufferReadCloser(&req.Body, req.ContentLength, limit)
+}
@@ -0,0 +1,635 @@
+package proxy
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockKeyGetter is a mock implementation of KeyGetter
+type MockKeyGetter struct {
+	mock.Mock
+}
+
+func (m *MockKeyGetter) UseBestKey(ctx context.Context, exclude ...string) (*string, error) {
+	args := m.Called(ctx, exclude)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	key := args.String(0)
+	return &key, args.Error(1)
+}
+
+func (m *MockKeyGetter) DeductKeyBalance(ctx context.Context, key string, amount int64) error {
+	args := m.Called(ctx, key, amount)
+	return args.Error(0)
+}
+
+func (m *MockKeyGetter) InvalidateKey(ctx context.Context, key string) error {
+	args := m.Called(ctx, key)
+	return args.Error(0)
+}
+
+func (m *MockKeyGetter) ExhaustKey(ctx context.Context, key string) error {
+	args := m.Called(ctx, key)
+	return args.Error(0)
+}
+
+func (m *MockKeyGetter) CooldownKey(ctx context.Context, key string, until time.Time) error {
+	args := m.Called(ctx, key, until)
+	return args.Error(0)
+}
+
+func (m *MockKeyGetter) NextKeyAvailableAt(ctx context.Context) (*time.Time, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*time.Time), args.Error(1)
+}
+
+// upstreamCall is a request received by the fake upstream
+type upstreamCall struct {
+	Authorization string
+	Body          string
+}
+
+// newFakeUpstream starts a server answering with the status configured for each key
+func newFakeUpstream(t *testing.T, statusByKey map[string]int) (*httptest.Server, func() []upstreamCall) {
+	t.Helper()
+	return startFakeUpstream(t, statusByKey, httptest.NewServer)
+}
+
+// newFakeTLSUpstream starts the fake upstream over HTTPS, so the proxy intercepts the requests sent to it
+func newFakeTLSUpstream(t *testing.T, statusByKey map[string]int) (*httptest.Server, func() []upstreamCall) {
+	t.Helper()
+	return startFakeUpstream(t, statusByKey, httptest.NewTLSServer)
+}
+
+func startFakeUpstream(t *testing.T, statusByKey map[string]int, newServer func(http.Handler) *httptest.Server) (*httptest.Server, func() []upstreamCall) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var calls []upstreamCall
+	server := newServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		authorization := r.Header.Get("Authorization")
+
+		mu.Lock()
+		calls = append(calls, upstreamCall{Authorization: authorization, Body: string(body)})
+		mu.Unlock()
+
+		status, ok := statusByKey[strings.TrimPrefix(authorization, "Bearer ")]
+		if !ok {
+			status = http.StatusUnauthorized
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			_, _ = w.Write([]byte(`{"usage":{"total_tokens":10}}`))
+		} else {
+			_, _ = w.Write([]byte(`{"detail":"error"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []upstreamCall {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]upstreamCall(nil), calls...)
+	}
+}
+
+func TestKeyTransport_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name               string
+		config             Config
+		statusByKey        map[string]int
+		body               string
+		setupMock          func(*MockKeyGetter)
+		expectedStatus     int
+		expectedCalls      []string
+		expectedRetries    int64
+		expectedExhausted  int64
+		expectedClientBody string
+	}{
+		{
+			name:        "Success with first key",
+			config:      Config{MaxRetries: 3, MaxBufferedBodyBytes: 1024},
+			statusByKey: map[string]int{"key-1": http.StatusOK},
+			body:        `{"input":["hello"]}`,
+			setupMock: func(m *MockKeyGetter) {
+				m.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+				m.On("DeductKeyBalance", mock.Anything, "key-1", int64(10)).Return(nil)
+			},
+			expectedStatus:     http.StatusOK,
+			expectedCalls:      []string{"Bearer key-1"},
+			expectedClientBody: `{"usage":{"total_tokens":10}}`,
+		},
+		{
+			name:        "Retry after invalid key",
+			config:      Config{MaxRetries: 3, MaxBufferedBodyBytes: 1024},
+			statusByKey: map[string]int{"key-1": http.StatusUnauthorized, "key-2": http.StatusOK},
+			body:        `{"input":["hello"]}`,
+			setupMock: func(m *MockKeyGetter) {
+				m.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+				m.On("InvalidateKey", mock.Anything, "key-1").Return(nil)
+				m.On("UseBestKey", mock.Anything, []string{"key-1"}).Return("key-2", nil)
+				m.On("DeductKeyBalance", mock.Anything, "key-2", int64(10)).Return(nil)
+			},
+			expectedStatus:     http.StatusOK,
+			expectedCalls:      []string{"Bearer key-1", "Bearer key-2"},
+			expectedRetries:    1,
+			expectedClientBody: `{"usage":{"total_tokens":10}}`,
+		},
+		{
+			name:        "Retry after forbidden key",
+			config:      Config{MaxRetries: 3, MaxBufferedBodyBytes: 1024},
+			statusByKey: map[string]int{"key-1": http.StatusForbidden, "key-2": http.StatusOK},
+			body:        `{"input":["hello"]}`,
+			setupMock: func(m *MockKeyGetter) {
+				m.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+				m.On("InvalidateKey", mock.Anything, "key-1").Return(nil)
+				m.On("UseBestKey", mock.Anything, []string{"key-1"}).Return("key-2", nil)
+				m.On("DeductKeyBalance", mock.Anything, "key-2", int64(10)).Return(nil)
+			},
+			expectedStatus:     http.StatusOK,
+			expectedCalls:      []string{"Bearer key-1", "Bearer key-2"},
+			expectedRetries:    1,
+			expectedClientBody: `{"usage":{"total_tokens":10}}`,
+		},
+		{
+			name:        "Retry after exhausted key",
+			config:      Config{MaxRetries: 3, MaxBufferedBodyBytes: 1024},
+			statusByKey: map[string]int{"key-1": http.StatusPaymentRequired, "key-2": http.StatusOK},
+			body:        `{"input":["hello"]}`,
+			setupMock: func(m *MockKeyGetter) {
+				m.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+				m.On("ExhaustKey", mock.Anything, "key-1").Return(nil)
+				m.On("UseBestKey", mock.Anything, []string{"key-1"}).Return("key-2", nil)
+				m.On("DeductKeyBalance", mock.Anything, "key-2", int64(10)).Return(nil)
+			},
+			expectedStatus:     http.StatusOK,
+			expectedCalls:      []string{"Bearer key-1", "Bearer key-2"},
+			expectedRetries:    1,
+			expectedClientBody: `{"usage":{"total_tokens":10}}`,
+		},
+		{
+			name:        "Retries are bounded",
+			config:      Config{MaxRetries: 1, MaxBufferedBodyBytes: 1024},
+			statusByKey: map[string]int{"key-1": http.StatusTooManyRequests, "key-2": http.StatusTooManyRequests},
+			body:        `{"input":["hello"]}`,
+			setupMock: func(m *MockKeyGetter) {
+				m.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+				m.On("CooldownKey", mock.Anything, "key-1", mock.Anything).Return(nil)
+				m.On("UseBestKey", mock.Anything, []string{"key-1"}).Return("key-2", nil)
+				m.On("CooldownKey", mock.Anything, "key-2", mock.Anything).Return(nil)
+			},
+			expectedStatus:     http.StatusTooManyRequests,
+			expectedCalls:      []string{"Bearer key-1", "Bearer key-2"},
+			expectedRetries:    1,
+			expectedExhausted:  1,
+			expectedClientBody: `{"detail":"error"}`,
+		},
+		{
+			name:        "No other key left",
+			config:      Config{MaxRetries: 3, MaxBufferedBodyBytes: 1024},
+			statusByKey: map[string]int{"key-1": http.StatusTooManyRequests},
+			body:        `{"input":["hello"]}`,
+			setupMock: func(m *MockKeyGetter) {
+				m.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+				m.On("CooldownKey", mock.Anything, "key-1", mock.Anything).Return(nil)
+				m.On("UseBestKey", mock.Anything, []string{"key-1"}).Return(nil, sql.ErrNoRows)
+			},
+			expectedStatus:     http.StatusTooManyRequests,
+			expectedCalls:      []string{"Bearer key-1"},
+			expectedClientBody: `{"detail":"error"}`,
+		},
+		{
+			name:        "Body too large to replay",
+			config:      Config{MaxRetries: 3, MaxBufferedBodyBytes: 4},
+			statusByKey: map[string]int{"key-1": http.StatusTooManyRequests, "key-2": http.StatusOK},
+			body:        `{"input":["hello"]}`,
+			setupMock: func(m *MockKeyGetter) {
+				m.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+				m.On("CooldownKey", mock.Anything, "key-1", mock.Anything).Return(nil)
+			},
+			expectedStatus:     http.StatusTooManyRequests,
+			expectedCalls:      []string{"Bearer key-1"},
+			expectedClientBody: `{"detail":"error"}`,
+		},
+		{
+			name:        "Client error is not retried",
+			config:      Config{MaxRetries: 3, MaxBufferedBodyBytes: 1024},
+			statusByKey: map[string]int{"key-1": http.StatusBadRequest},
+			body:        `{"input":["hello"]}`,
+			setupMock: func(m *MockKeyGetter) {
+				m.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+			},
+			expectedStatus:     http.StatusBadRequest,
+			expectedCalls:      []string{"Bearer key-1"},
+			expectedClientBody: `{"detail":"error"}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			upstream, getCalls := newFakeUpstream(t, tc.statusByKey)
+			mockKeyGetter := new(MockKeyGetter)
+			tc.setupMock(mockKeyGetter)
+			stats := NewStats()
+
+			transport := &keyTransport{
+				keyGetter: mockKeyGetter,
+				transport: http.DefaultTransport,
+				config:    NewConfigStore(tc.config),
+				stats:     stats,
+			}
+
+			req, err := http.NewRequest(http.MethodPost, upstream.URL+"/v1/embeddings", strings.NewReader(tc.body))
+			require.NoError(t, err)
+
+			resp, err := transport.RoundTrip(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			clientBody, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.Equal(t, tc.expectedClientBody, string(clientBody))
+
+			calls := getCalls()
+			var authorizations []string
+			for _, call := range calls {
+				authorizations = append(authorizations, call.Authorization)
+				// Every attempt must carry the full body
+				assert.Equal(t, tc.body, call.Body)
+			}
+			assert.Equal(t, tc.expectedCalls, authorizations)
+
+			snapshot := stats.Snapshot()
+			assert.Equal(t, int64(1), snapshot.Requests)
+			assert.Equal(t, tc.expectedRetries, snapshot.Retries)
+			assert.Equal(t, tc.expectedExhausted, snapshot.RetriesExhausted)
+			mockKeyGetter.AssertExpectations(t)
+		})
+	}
+}
+
+func TestKeyTransport_RoundTrip_Cooldown(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer upstream.Close()
+
+	mockKeyGetter := new(MockKeyGetter)
+	mockKeyGetter.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+	mockKeyGetter.On("UseBestKey", mock.Anything, []string{"key-1"}).Return(nil, sql.ErrNoRows)
+	mockKeyGetter.On("CooldownKey", mock.Anything, "key-1", mock.MatchedBy(func(until time.Time) bool {
+		cooldown := time.Until(until)
+		return cooldown > 25*time.Second && cooldown <= 30*time.Second
+	})).Return(nil)
+
+	transport := &keyTransport{
+		keyGetter: mockKeyGetter,
+		transport: http.DefaultTransport,
+		config:    NewConfigStore(Config{MaxRetries: 3, MaxBufferedBodyBytes: 1024, DefaultCooldown: time.Minute, MaxCooldown: time.Hour}),
+		stats:     NewStats(),
+	}
+
+	req, err := http.NewRequest(http.MethodGet, upstream.URL, nil)
+	require.NoError(t, err)
+
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	mockKeyGetter.AssertExpectations(t)
+}
+
+func TestKeyTransport_RoundTrip_LargeResponse(t *testing.T) {
+	largeBody := `{"data":[{"embedding":[` + strings.Repeat("0.1,", 1000) + `0.1]}],"usage":{"total_tokens":10}}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/v1/models" {
+			_, _ = w.Write([]byte(`{"data":[]}`))
+			return
+		}
+		_, _ = w.Write([]byte(largeBody))
+	}))
+	t.Cleanup(upstream.Close)
+
+	mockKeyGetter := new(MockKeyGetter)
+	mockKeyGetter.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+
+	metrics := NewMetrics(prometheus.NewRegistry())
+	transport := &keyTransport{
+		keyGetter: mockKeyGetter,
+		transport: http.DefaultTransport,
+		config:    NewConfigStore(Config{MaxBufferedBodyBytes: 1024, Metrics: metrics}),
+		stats:     NewStats(),
+	}
+
+	req, err := http.NewRequest(http.MethodPost, upstream.URL+"/v1/embeddings", strings.NewReader(`{"input":["hello"]}`))
+	require.NoError(t, err)
+
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// The usage of a body larger than the buffer is deducted once the body is sent
+	mockKeyGetter.AssertNotCalled(t, "DeductKeyBalance", mock.Anything, mock.Anything, mock.Anything)
+	mockKeyGetter.On("DeductKeyBalance", mock.Anything, "key-1", int64(10)).Return(nil).Once()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, largeBody, string(body))
+	require.NoError(t, resp.Body.Close())
+	mockKeyGetter.AssertExpectations(t)
+
+	// A response without usage is counted as unaccounted
+	req, err = http.NewRequest(http.MethodGet, upstream.URL+"/v1/models", nil)
+	require.NoError(t, err)
+	resp, err = transport.RoundTrip(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.unaccounted.WithLabelValues(host, MetricsClientAnonymous)))
+	mockKeyGetter.AssertExpectations(t)
+}
+
+func TestKeyTransport_RoundTrip_TruncatedBody(t *testing.T) {
+	// Upstream announces a longer body than it sends, the read fails when the connection closes
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "100")
+		_, _ = w.Write([]byte(`{"usage":`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	mockKeyGetter := new(MockKeyGetter)
+	mockKeyGetter.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+
+	transport := &keyTransport{
+		keyGetter: mockKeyGetter,
+		transport: http.DefaultTransport,
+		config:    NewConfigStore(Config{MaxBufferedBodyBytes: 1024}),
+		stats:     NewStats(),
+	}
+
+	req, err := http.NewRequest(http.MethodPost, upstream.URL+"/v1/embeddings", strings.NewReader(`{"input":["hello"]}`))
+	require.NoError(t, err)
+
+	// The truncated body is not sent as if it were complete
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+
+	var body ErrorResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, ErrorCodeUpstreamResponseUnreadable, body.Error.Code)
+	mockKeyGetter.AssertExpectations(t)
+}
+
+func TestKeyTransport_RoundTrip_NoKey(t *testing.T) {
+	nextAvailable := time.Now().Add(90 * time.Second)
+
+	tests := []struct {
+		name               string
+		config             Config
+		setupMock          func(*MockKeyGetter)
+		expectedStatus     int
+		expectedCalls      []string
+		expectedRetryAfter string
+		expectedCode       string
+	}{
+		{
+			name:   "No key available",
+			config: Config{MaxBufferedBodyBytes: 1024},
+			setupMock: func(m *MockKeyGetter) {
+				m.On("UseBestKey", mock.Anything, []string(nil)).Return(nil, sql.ErrNoRows)
+				m.On("NextKeyAvailableAt", mock.Anything).Return(nil, nil)
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   ErrorCodeNoKeyAvailable,
+		},
+		{
+			name:   "Every key is cooling down",
+			config: Config{MaxBufferedBodyBytes: 1024},
+			setupMock: func(m *MockKeyGetter) {
+				m.On("UseBestKey", mock.Anything, []string(nil)).Return(nil, sql.ErrNoRows)
+				m.On("NextKeyAvailableAt", mock.Anything).Return(&nextAvailable, nil)
+			},
+			expectedStatus:     http.StatusServiceUnavailable,
+			expectedRetryAfter: "90",
+			expectedCode:       ErrorCodeNoKeyAvailable,
+		},
+		{
+			name:   "Key selection failure",
+			config: Config{MaxBufferedBodyBytes: 1024},
+			setupMock: func(m *MockKeyGetter) {
+				m.On("UseBestKey", mock.Anything, []string(nil)).Return(nil, assert.AnError)
+				m.On("NextKeyAvailableAt", mock.Anything).Return(nil, assert.AnError)
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   ErrorCodeKeySelectionError,
+		},
+		{
+			name:   "Key available after waiting",
+			config: Config{MaxBufferedBodyBytes: 1024, KeyWaitTimeout: time.Second},
+			setupMock: func(m *MockKeyGetter) {
+				m.On("UseBestKey", mock.Anything, []string(nil)).Return(nil, sql.ErrNoRows).Once()
+				m.On("NextKeyAvailableAt", mock.Anything).Return(ptr(time.Now().Add(10*time.Millisecond)), nil).Once()
+				m.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil).Once()
+				m.On("DeductKeyBalance", mock.Anything, "key-1", int64(10)).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedCalls:  []string{"Bearer key-1"},
+		},
+		{
+			name:   "No key available after waiting",
+			config: Config{MaxBufferedBodyBytes: 1024, KeyWaitTimeout: 50 * time.Millisecond},
+			setupMock: func(m *MockKeyGetter) {
+				m.On("UseBestKey", mock.Anything, []string(nil)).Return(nil, sql.ErrNoRows)
+				m.On("NextKeyAvailableAt", mock.Anything).Return(nil, nil)
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   ErrorCodeNoKeyAvailable,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			upstream, getCalls := newFakeUpstream(t, map[string]int{"key-1": http.StatusOK})
+			mockKeyGetter := new(MockKeyGetter)
+			tc.setupMock(mockKeyGetter)
+			stats := NewStats()
+
+			transport := &keyTransport{
+				keyGetter: mockKeyGetter,
+				transport: http.DefaultTransport,
+				config:    NewConfigStore(tc.config),
+				stats:     stats,
+			}
+
+			req, err := http.NewRequest(http.MethodPost, upstream.URL+"/v1/embeddings", strings.NewReader(`{"input":["hello"]}`))
+			require.NoError(t, err)
+
+			resp, err := transport.RoundTrip(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.Equal(t, tc.expectedRetryAfter, resp.Header.Get("Retry-After"))
+
+			var authorizations []string
+			for _, call := range getCalls() {
+				authorizations = append(authorizations, call.Authorization)
+			}
+			assert.Equal(t, tc.expectedCalls, authorizations)
+
+			if tc.expectedCode != "" {
+				var body ErrorResponse
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+				assert.Equal(t, tc.expectedCode, body.Error.Code)
+				assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+				assert.Equal(t, int64(1), stats.NoKeyAvailable.Load())
+			}
+			mockKeyGetter.AssertExpectations(t)
+		})
+	}
+}
+
+func TestKeyTransport_RoundTrip_ClientCanceledWhileWaiting(t *testing.T) {
+	upstream, getCalls := newFakeUpstream(t, map[string]int{"key-1": http.StatusOK})
+	mockKeyGetter := new(MockKeyGetter)
+	mockKeyGetter.On("UseBestKey", mock.Anything, []string(nil)).Return(nil, sql.ErrNoRows)
+	mockKeyGetter.On("NextKeyAvailableAt", mock.Anything).Return(nil, nil)
+	stats := NewStats()
+
+	transport := &keyTransport{
+		keyGetter: mockKeyGetter,
+		transport: http.DefaultTransport,
+		config:    NewConfigStore(Config{MaxBufferedBodyBytes: 1024, KeyWaitTimeout: time.Minute}),
+		stats:     stats,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, upstream.URL+"/v1/embeddings", strings.NewReader(`{"input":["hello"]}`))
+	require.NoError(t, err)
+
+	resp, err := transport.RoundTrip(req)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, resp)
+	assert.Empty(t, getCalls())
+	assert.Equal(t, int64(0), stats.NoKeyAvailable.Load())
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
+
+func TestKeyTransport_RoundTrip_ClientKey(t *testing.T) {
+	policies, err := ParseClientKeyPolicies("override", []string{"team-a=keep", "team-b=reject"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		client         string
+		authorization  string
+		setupMock      func(*MockKeyGetter)
+		expectedStatus int
+		expectedCalls  []string
+	}{
+		{
+			name:          "Client key is overridden",
+			client:        "team-c",
+			authorization: "Bearer client-key",
+			setupMock: func(m *MockKeyGetter) {
+				m.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+				m.On("DeductKeyBalance", mock.Anything, "key-1", int64(10)).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedCalls:  []string{"Bearer key-1"},
+		},
+		{
+			name:           "Client key is kept",
+			client:         "team-a",
+			authorization:  "Bearer client-key",
+			expectedStatus: http.StatusOK,
+			expectedCalls:  []string{"Bearer client-key"},
+		},
+		{
+			name:          "Pool key is used without client key",
+			client:        "team-a",
+			authorization: "",
+			setupMock: func(m *MockKeyGetter) {
+				m.On("UseBestKey", mock.Anything, []string(nil)).Return("key-1", nil)
+				m.On("DeductKeyBalance", mock.Anything, "key-1", int64(10)).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedCalls:  []string{"Bearer key-1"},
+		},
+		{
+			name:           "Client key is rejected",
+			client:         "team-b",
+			authorization:  "Bearer client-key",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			upstream, getCalls := newFakeUpstream(t, map[string]int{"key-1": http.StatusOK, "client-key": http.StatusOK})
+			mockKeyGetter := new(MockKeyGetter)
+			if tc.setupMock != nil {
+				tc.setupMock(mockKeyGetter)
+			}
+
+			transport := &keyTransport{
+				keyGetter: mockKeyGetter,
+				transport: http.DefaultTransport,
+				config:    NewConfigStore(Config{MaxBufferedBodyBytes: 1024, ClientKeyPolicies: policies}),
+				stats:     NewStats(),
+			}
+
+			req, err := http.NewRequestWithContext(WithClient(context.Background(), Client{Name: tc.client}),
+				http.MethodPost, upstream.URL+"/v1/embeddings", strings.NewReader(`{"input":["hello"]}`))
+			require.NoError(t, err)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+
+			resp, err := transport.RoundTrip(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			var authorizations []string
+			for _, call := range getCalls() {
+				authorizations = append(authorizations, call.Authorization)
+			}
+			assert.Equal(t, tc.expectedCalls, authorizations)
+			mockKeyGetter.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// usage is the token usage reported by Jina APIs.
+// Embeddings and rerank report total_tokens, reader and search report tokens.
+type usage struct {
+	TotalTokens  *int64 `json:"total_tokens"`
+	PromptTokens *int64 `json:"prompt_tokens"`
+	Tokens       *int64 `json:"tokens"`
+}
+
+func (u *usage) tokens() (int64, bool) {
+	switch {
+	case u == nil:
+		return 0, false
+	case u.TotalTokens != nil:
+		return *u.TotalTokens, true
+	case u.Tokens != nil:
+		return *u.Tokens, true
+	case u.PromptTokens != nil:
+		return *u.PromptTokens, true
+	default:
+		return 0, false
+	}
+}
+
+// decodeUsageTokens reads a Jina JSON response body token by token and returns the number of tokens
+// reported, so the body is never held in memory whatever its size. Usage read before the body turns
+// out to be invalid or truncated is still returned.
+func decodeUsageTokens(body io.Reader) (int64, bool) {
+	dec := json.NewDecoder(body)
+
+	// Embeddings and rerank report usage at the top level, search in meta,
+	// and reader inside data, which is an array for other APIs
+	var topUsage, metaUsage, dataUsage *usage
+	_ = walkObject(dec, func(name string) error {
+		switch name {
+		case "usage":
+			return dec.Decode(&topUsage)
+		case "meta":
+			return walkObject(dec, usageField(dec, &metaUsage))
+		case "data":
+			return walkObject(dec, usageField(dec, &dataUsage))
+		default:
+			return skipValue(dec)
+		}
+	})
+
+	for _, u := range []*usage{topUsage, metaUsage, dataUsage} {
+		if tokens, ok := u.tokens(); ok {
+			return tokens, true
+		}
+	}
+	return 0, false
+}
+
+// usageField returns the walkObject callback decoding the usage member of an object into u
+func usageField(dec *json.Decoder, u **usage) func(name string) error {
+	return func(name string) error {
+		if name == "usage" {
+			return dec.Decode(u)
+		}
+		return skipValue(dec)
+	}
+}
+
+// walkObject calls field with the name of every member of the next value when it is an object,
+// field must read the value of the member. Values other than objects are skipped.
+func walkObject(dec *json.Decoder, field func(name string) error) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != json.Delim('{') {
+		return skipRest(dec, token)
+	}
+
+	for dec.More() {
+		token, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		name, _ := token.(string) // Object keys are always strings
+		err = field(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Read the end of the object
+	_, err = dec.Token()
+	return err
+}
+
+// skipValue skips the next value
+func skipValue(dec *json.Decoder) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	return skipRest(dec, token)
+}
+
+// skipRest skips the rest of the value starting with the token
+func skipRest(dec *json.Decoder, token json.Token) error {
+	depth := 0
+	for {
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+
+		var err error
+		token, err = dec.Token()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// isJSONResponse reports whether the response carries a JSON body
+func isJSONResponse(resp *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// decodingReader returns a reader decoding the body according to the Content-Encoding header
+func decodingReader(body io.Reader, contentEncoding string) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		gzipReader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		return gzipReader, nil
+	case "br":
+		return brotli.NewReader(body), nil
+	case "deflate":
+		zlibReader, err := zlib.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		return zlibReader, nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding: %s", contentEncoding)
+	}
+}
+
+// readUsage reads the usage reported in the response body and passes it to account,
+// ok is false when the usage cannot be read.
+// Bodies up to limit are buffered and accounted before returning. The usage of larger bodies is read
+// while they are sent to the client, and accounted once they are read to the end or closed.
+// The error reading the body is returned, the body is then closed and must not be sent.
+func readUsage(resp *http.Response, limit int64, account func(tokens int64, ok bool)) error {
+	contentEncoding := resp.Header.Get("Content-Encoding")
+	body, buffered, err := bufferReadCloser(&resp.Body, resp.ContentLength, limit)
+	if err != nil {
+		_ = resp.Body.Close()
+		return err
+	}
+	if !buffered {
+		resp.Body = newUsageReader(resp.Body, contentEncoding, account)
+		return nil
+	}
+
+	decoded, err := decodingReader(bytes.NewReader(body), contentEncoding)
+	if err != nil {
+		slog.Warn("decode usage", slog.Any("error", err))
+		account(0, false)
+		return nil
+	}
+
+	account(decodeUsageTokens(decoded))
+	return nil
+}
+
+// bufferReadCloser reads the body of a request or a response and replaces it with the buffered bytes.
+// Bodies larger than limit are not buffered, the body is restored to be read once.
+func bufferReadCloser(body *io.ReadCloser, contentLength int64, limit int64) ([]byte, bool, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, true, nil
+	}
+	if contentLength > limit {
+		return nil, false, nil
+	}
+
+	buffered, err := io.ReadAll(io.LimitReader(*body, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(buffered)) > limit {
+		*body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buffered), *body), *body}
+		return nil, false, nil
+	}
+
+	_ = (*body).Close()
+	*body = io.NopCloser(bytes.NewReader(buffered))
+	return buffered, true, nil
+}
+
+// usageReader sends a response body to the client and reads its usage on the way.
+// The usage is decoded from a pipe in its own goroutine, which drains the pipe to the end
+// so the body is never blocked. The body must be read to the end or closed.
+type usageReader struct {
+	body    io.ReadCloser
+	pipe    *io.PipeWriter
+	tokens  chan usageTokens
+	account func(tokens int64, ok bool)
+	once    sync.Once
+}
+
+// usageTokens is the usage decoded by a usageReader
+type usageTokens struct {
+	tokens int64
+	ok     bool
+}
+
+// errBodyClosed stops the decoding of the usage of a body closed before its end
+var errBodyClosed = errors.New("body closed before its end")
+
+func (r *usageReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if n > 0 {
+		_, _ = r.pipe.Write(p[:n]) // Only fails once the pipe is closed
+	}
+	if err != nil {
+		r.finish(err)
+	}
+	return n, err
+}
+
+func (r *usageReader) Close() error {
+	err := r.body.Close()
+	r.finish(errBodyClosed)
+	return err
+}
+
+// finish closes the pipe and accounts the decoded usage once, err is io.EOF when the body was read to the end
+func (r *usageReader) finish(err error) {
+	r.once.Do(func() {
+		if err == io.EOF {
+			_ = r.pipe.Close()
+		} else {
+			_ = r.pipe.CloseWithError(err)
+		}
+		usage := <-r.tokens
+		r.account(usage.tokens, usage.ok)
+	})
+}
+
+func newUsageReader(body io.ReadCloser, contentEncoding string, account func(tokens int64, ok bool)) *usageReader {
+	pipeReader, pipeWriter := io.Pipe()
+	r := &usageReader{
+		body:    body,
+		pipe:    pipeWriter,
+		tokens:  make(chan usageTokens, 1),
+		account: account,
+	}
+
+	go func() {
+		var usage usageTokens
+		decoded, err := decodingReader(pipeReader, contentEncoding)
+		if err != nil {
+			slog.Warn("decode usage", slog.Any("error", err))
+		} else {
+			usage.tokens, usage.ok = decodeUsageTokens(decoded)
+		}
+		_, _ = io.Copy(io.Discard, pipeReader)
+		r.tokens <- usage
+	}()
+
+	return r
+}
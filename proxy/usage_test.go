@@ -0,0 +1,259 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeUsageTokens(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		expectedTokens int64
+		expectedOk     bool
+	}{
+		{
+			name:           "Embeddings total_tokens",
+			body:           `{"model":"jina-clip-v2","data":[],"usage":{"total_tokens":42,"prompt_tokens":42}}`,
+			expectedTokens: 42,
+			expectedOk:     true,
+		},
+		{
+			name:           "Prompt tokens only",
+			body:           `{"usage":{"prompt_tokens":7}}`,
+			expectedTokens: 7,
+			expectedOk:     true,
+		},
+		{
+			name:           "Search meta usage",
+			body:           `{"code":200,"data":[{"title":"a"}],"meta":{"usage":{"tokens":1000}}}`,
+			expectedTokens: 1000,
+			expectedOk:     true,
+		},
+		{
+			name:           "Reader data usage",
+			body:           `{"code":200,"data":{"content":"hello","usage":{"tokens":15}}}`,
+			expectedTokens: 15,
+			expectedOk:     true,
+		},
+		{
+			name:           "Usage after the data",
+			body:           `{"data":[{"embedding":[0.1,0.2],"usage":{"total_tokens":1}},{"embedding":[0.3]}],"usage":{"total_tokens":42}}`,
+			expectedTokens: 42,
+			expectedOk:     true,
+		},
+		{
+			name:           "Truncated after the usage",
+			body:           `{"usage":{"total_tokens":42},"data":[{"embedding":[0.1,`,
+			expectedTokens: 42,
+			expectedOk:     true,
+		},
+		{
+			name:       "No usage",
+			body:       `{"data":[]}`,
+			expectedOk: false,
+		},
+		{
+			name:       "Usage in an array",
+			body:       `[{"usage":{"total_tokens":42}}]`,
+			expectedOk: false,
+		},
+		{
+			name:       "Invalid JSON",
+			body:       `not-json`,
+			expectedOk: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens, ok := decodeUsageTokens(strings.NewReader(tc.body))
+			assert.Equal(t, tc.expectedOk, ok)
+			assert.Equal(t, tc.expectedTokens, tokens)
+		})
+	}
+}
+
+func TestReadUsageTokens(t *testing.T) {
+	body := []byte(`{"usage":{"total_tokens":128}}`)
+
+	var gzipBody bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipBody)
+	_, err := gzipWriter.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, gzipWriter.Close())
+
+	var brotliBody bytes.Buffer
+	brotliWriter := brotli.NewWriter(&brotliBody)
+	_, err = brotliWriter.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, brotliWriter.Close())
+
+	// A small gzip body decoding to much more than the limit
+	var bombBody bytes.Buffer
+	bombWriter := gzip.NewWriter(&bombBody)
+	_, err = bombWriter.Write(bytes.Repeat([]byte(" "), 1<<20))
+	require.NoError(t, err)
+	_, err = bombWriter.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, bombWriter.Close())
+
+	// A gzip body larger than the limit
+	var largeBody bytes.Buffer
+	largeWriter := gzip.NewWriter(&largeBody)
+	_, err = largeWriter.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, largeWriter.Close())
+
+	tests := []struct {
+		name            string
+		contentEncoding string
+		body            []byte
+		limit           int64
+		expectedOk      bool
+	}{
+		{
+			name:       "Identity",
+			body:       body,
+			limit:      1024,
+			expectedOk: true,
+		},
+		{
+			name:            "Gzip",
+			contentEncoding: "gzip",
+			body:            gzipBody.Bytes(),
+			limit:           1024,
+			expectedOk:      true,
+		},
+		{
+			name:            "Brotli",
+			contentEncoding: "br",
+			body:            brotliBody.Bytes(),
+			limit:           1024,
+			expectedOk:      true,
+		},
+		{
+			name:            "Unsupported encoding",
+			contentEncoding: "zstd",
+			body:            body,
+			limit:           1024,
+		},
+		{
+			name:       "Body larger than the limit",
+			body:       body,
+			limit:      10,
+			expectedOk: true,
+		},
+		{
+			name:            "Gzip body larger than the limit",
+			contentEncoding: "gzip",
+			body:            largeBody.Bytes(),
+			limit:           10,
+			expectedOk:      true,
+		},
+		{
+			name:            "Unsupported encoding larger than the limit",
+			contentEncoding: "zstd",
+			body:            body,
+			limit:           10,
+		},
+		{
+			name:            "Decoded body larger than the limit",
+			contentEncoding: "gzip",
+			body:            bombBody.Bytes(),
+			limit:           int64(bombBody.Len()) + 1024,
+			expectedOk:      true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{
+				Header:        http.Header{},
+				Body:          io.NopCloser(bytes.NewReader(tc.body)),
+				ContentLength: -1,
+			}
+			resp.Header.Set("Content-Type", "application/json")
+			if tc.contentEncoding != "" {
+				resp.Header.Set("Content-Encoding", tc.contentEncoding)
+			}
+
+			var accounted []usageTokens
+			err := readUsage(resp, tc.limit, func(tokens int64, ok bool) {
+				accounted = append(accounted, usageTokens{tokens: tokens, ok: ok})
+			})
+			assert.NoError(t, err)
+
+			// Body must be restored untouched for the client
+			restored, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.body, restored)
+			assert.NoError(t, resp.Body.Close())
+
+			// Usage is accounted once, when the body is read to the end
+			require.Len(t, accounted, 1)
+			assert.Equal(t, tc.expectedOk, accounted[0].ok)
+			if tc.expectedOk {
+				assert.Equal(t, int64(128), accounted[0].tokens)
+			}
+		})
+	}
+}
+
+// failingReader returns its data then fails
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestReadUsageTokens_ReadError(t *testing.T) {
+	resp := &http.Response{
+		Header:        http.Header{},
+		Body:          io.NopCloser(&failingReader{data: []byte(`{"usage":`)}),
+		ContentLength: -1,
+	}
+
+	err := readUsage(resp, 1024, func(tokens int64, ok bool) {
+		t.Error("usage of a body that cannot be read must not be accounted")
+	})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestReadUsage_ClosedEarly(t *testing.T) {
+	resp := &http.Response{
+		Header:        http.Header{},
+		Body:          io.NopCloser(strings.NewReader(`{"data":[{"embedding":[0.1]}],"usage":{"total_tokens":128}}`)),
+		ContentLength: -1,
+	}
+
+	var accounted []usageTokens
+	err := readUsage(resp, 10, func(tokens int64, ok bool) {
+		accounted = append(accounted, usageTokens{tokens: tokens, ok: ok})
+	})
+	require.NoError(t, err)
+
+	// The client stops reading before the usage
+	_, err = io.ReadFull(resp.Body, make([]byte, 20))
+	require.NoError(t, err)
+	assert.Empty(t, accounted)
+	assert.NoError(t, resp.Body.Close())
+
+	require.Len(t, accounted, 1)
+	assert.False(t, accounted[0].ok)
+}
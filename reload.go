@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+
+	"github.com/trancong12102/jina-http-proxy/config"
+	"github.com/trancong12102/jina-http-proxy/key"
+	"github.com/trancong12102/jina-http-proxy/proxy"
+)
+
+// reloadableSettings lists the settings applied on reload, the other settings need a restart
+var reloadableSettings = []string{
+	"log.level",
+	"key.selection_strategy",
+	"proxy.client_key_policy",
+	"proxy.client_key_policies",
+	"proxy.allowed_targets",
+	"proxy.unmatched_policy",
+	"proxy.max_retries",
+	"proxy.max_buffered_body_bytes",
+	"proxy.default_cooldown",
+	"proxy.max_cooldown",
+	"proxy.key_wait_timeout",
+}
+
+// newProxyConfig creates the reloadable part of the proxy config
+func newProxyConfig(serverConfig *config.Config) (proxy.Config, error) {
+	allowlist, err := proxy.ParseAllowlist(serverConfig.ProxyAllowedTargets)
+	if err != nil {
+		return proxy.Config{}, fmt.Errorf("parse proxy allowlist: %w", err)
+	}
+
+	clientKeyPolicies, err := proxy.ParseClientKeyPolicies(serverConfig.ProxyClientKeyPolicy, serverConfig.ProxyClientKeyPolicies)
+	if err != nil {
+		return proxy.Config{}, fmt.Errorf("parse client key policies: %w", err)
+	}
+
+	return proxy.Config{
+		MaxRetries:           serverConfig.ProxyMaxRetries,
+		MaxBufferedBodyBytes: serverConfig.ProxyMaxBufferedBodyBytes,
+		DefaultCooldown:      serverConfig.ProxyDefaultCooldown,
+		MaxCooldown:          serverConfig.ProxyMaxCooldown,
+		KeyWaitTimeout:       serverConfig.ProxyKeyWaitTimeout,
+		ClientKeyPolicies:    clientKeyPolicies,
+		Allowlist:            allowlist,
+		UnmatchedPolicy:      proxy.UnmatchedPolicy(serverConfig.ProxyUnmatchedPolicy),
+	}, nil
+}
+
+// configReloader reloads the config and swaps the reloadable settings of the running server
+type configReloader struct {
+	args         []string
+	started      *config.Config
+	current      *config.Config
+	logLevel     *slog.LevelVar
+	keySelector  *key.SwappableSelector
+	proxyConfigs *proxy.ConfigStore
+}
+
+// Reload loads the config again and applies the reloadable settings.
+// Nothing is applied when the config is invalid, the server keeps the current config.
+func (r *configReloader) Reload() error {
+	next, err := config.LoadConfig(r.args, configRules)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	proxyConfig, err := newProxyConfig(next)
+	if err != nil {
+		return err
+	}
+
+	// Settings that changed since startup, not only since the last reload, are reported until a restart
+	var pending []string
+	for _, setting := range config.ChangedSettings(r.started, next) {
+		if !slices.Contains(reloadableSettings, setting) {
+			pending = append(pending, setting)
+		}
+	}
+	if len(pending) > 0 {
+		slog.Warn("settings changed but need a restart to apply", slog.Any("settings", pending))
+	}
+
+	changed := config.ChangedSettings(r.current, next)
+	if slices.Contains(changed, "key.selection_strategy") {
+		// A new selector starts over, so it is only swapped when the strategy changes.
+		// The strategy is swapped first so nothing is applied when it is unknown.
+		err = r.keySelector.Swap(next.KeySelectionStrategy)
+		if err != nil {
+			return fmt.Errorf("swap key selection strategy: %w", err)
+		}
+	}
+	r.proxyConfigs.Reload(proxyConfig)
+	r.logLevel.Set(next.LogLevel)
+	r.current = next
+
+	var applied []string
+	for _, setting := range changed {
+		if slices.Contains(reloadableSettings, setting) {
+			applied = append(applied, setting)
+		}
+	}
+	slog.Info("config reloaded", slog.Any("settings", applied))
+
+	return nil
+}
+
+// Run reloads the config on every signal received on hangup until the context is done.
+// The caller registers hangup for SIGHUP before starting the servers, since SIGHUP terminates the process by default.
+func (r *configReloader) Run(ctx context.Context, hangup <-chan os.Signal) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hangup:
+			err := r.Reload()
+			if err != nil {
+				slog.Error("reload config error, keeping the current config", slog.Any("error", err))
+			}
+		}
+	}
+}
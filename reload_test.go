@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trancong12102/jina-http-proxy/config"
+	"github.com/trancong12102/jina-http-proxy/key"
+	"github.com/trancong12102/jina-http-proxy/proxy"
+)
+
+// startedConfig is the config file the reloader starts with
+const startedConfig = `
+database:
+  url: postgres://localhost/jina
+  migration_dir: migrations
+server:
+  api_listen_addr: ":5000"
+log:
+  level: info
+key:
+  selection_strategy: newest-first
+proxy:
+  ca_cert: cert
+  ca_key: key
+  allowed_targets: [api.jina.ai]
+  max_retries: 1
+`
+
+// newTestReloader returns a reloader started with the config file, and the path of the file
+func newTestReloader(t *testing.T) (*configReloader, string) {
+	t.Setenv(config.ConfigFileEnv, "")
+	for _, env := range []string{
+		"GOOSE_DBSTRING", "GOOSE_MIGRATION_DIR", "API_LISTEN_ADDR", "LOG_LEVEL",
+		"KEY_SELECTION_STRATEGY", "PROXY_CA_CERT", "PROXY_CA_KEY", "PROXY_ALLOWED_TARGETS", "PROXY_MAX_RETRIES",
+	} {
+		t.Setenv(env, "")
+		require.NoError(t, os.Unsetenv(env))
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(startedConfig), 0o600))
+	args := []string{"-config", path}
+
+	started, err := config.LoadConfig(args, configRules)
+	require.NoError(t, err)
+	proxyConfig, err := newProxyConfig(started)
+	require.NoError(t, err)
+	keySelector, err := key.NewSwappableSelector(started.KeySelectionStrategy)
+	require.NoError(t, err)
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(started.LogLevel)
+
+	return &configReloader{
+		args:         args,
+		started:      started,
+		current:      started,
+		logLevel:     logLevel,
+		keySelector:  keySelector,
+		proxyConfigs: proxy.NewConfigStore(proxyConfig),
+	}, path
+}
+
+func TestConfigReloader_Reload(t *testing.T) {
+	reloader, path := newTestReloader(t)
+	started := reloader.current
+
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+database:
+  url: postgres://localhost/jina
+  migration_dir: migrations
+server:
+  api_listen_addr: ":6000"
+log:
+  level: debug
+key:
+  selection_strategy: round-robin
+proxy:
+  ca_cert: cert
+  ca_key: key
+  allowed_targets: [api.jina.ai, "*.example.com"]
+  max_retries: 5
+`), 0o600))
+
+	err := reloader.Reload()
+	require.NoError(t, err)
+
+	// The reloadable settings are applied
+	assert.Equal(t, slog.LevelDebug, reloader.logLevel.Level())
+	assert.Equal(t, key.StrategyRoundRobin, reloader.keySelector.Strategy())
+	proxyConfig := reloader.proxyConfigs.Load()
+	assert.Equal(t, 5, proxyConfig.MaxRetries)
+	assert.True(t, proxyConfig.Allowlist.AllowsHost("api.example.com"))
+
+	// The other settings need a restart, they are reported against the config the server started with
+	assert.Same(t, started, reloader.started)
+	assert.Equal(t, ":5000", reloader.started.ApiListenAddr)
+	assert.Contains(t, logs.String(), `msg="settings changed but need a restart to apply" settings=[server.api_listen_addr]`)
+	assert.Contains(t, logs.String(), `msg="config reloaded" settings="[log.level key.selection_strategy proxy.allowed_targets proxy.max_retries]"`)
+}
+
+func TestConfigReloader_Reload_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			name:    "malformed file",
+			content: "proxy: [",
+		},
+		{
+			name: "invalid setting",
+			content: `
+database:
+  url: postgres://localhost/jina
+  migration_dir: migrations
+log:
+  level: debug
+key:
+  selection_strategy: unknown
+proxy:
+  ca_cert: cert
+  ca_key: key
+  max_retries: 5
+`,
+		},
+		{
+			name: "invalid proxy config",
+			content: `
+database:
+  url: postgres://localhost/jina
+  migration_dir: migrations
+log:
+  level: debug
+key:
+  selection_strategy: round-robin
+proxy:
+  ca_cert: cert
+  ca_key: key
+  allowed_targets: ["*"]
+  max_retries: 5
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reloader, path := newTestReloader(t)
+			current := reloader.current
+			proxyConfig := reloader.proxyConfigs.Load()
+
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+
+			err := reloader.Reload()
+			require.Error(t, err)
+
+			// Nothing is applied, the server keeps the current config
+			assert.Same(t, current, reloader.current)
+			assert.Same(t, proxyConfig, reloader.proxyConfigs.Load())
+			assert.Equal(t, slog.LevelInfo, reloader.logLevel.Level())
+			assert.Equal(t, key.StrategyNewestFirst, reloader.keySelector.Strategy())
+		})
+	}
+}
+
+func TestConfigReloader_Run(t *testing.T) {
+	reloader, path := newTestReloader(t)
+	require.NoError(t, os.WriteFile(path, []byte(strings.Replace(startedConfig, "level: info", "level: debug", 1)), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	hangup := make(chan os.Signal, 1)
+	done := make(chan error)
+	go func() {
+		done <- reloader.Run(ctx, hangup)
+	}()
+
+	// A signal received on hangup reloads the config
+	hangup <- syscall.SIGHUP
+	require.Eventually(t, func() bool {
+		return reloader.logLevel.Level() == slog.LevelDebug
+	}, time.Second, 5*time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("reloader did not stop")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/trancong12102/jina-http-proxy/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// TracingServiceName is the service name of the exported spans, OTEL_SERVICE_NAME overrides it
+const TracingServiceName = "jina-http-proxy"
+
+// newTracerProvider creates the tracer provider of the configured exporter, and the function
+// flushing the spans left on shutdown. Nothing is traced when the exporter is "none".
+func newTracerProvider(ctx context.Context, serverConfig *config.Config) (trace.TracerProvider, func(context.Context) error, error) {
+	if serverConfig.TracingExporter != "otlp" {
+		return noop.NewTracerProvider(), func(context.Context) error { return nil }, nil
+	}
+
+	var options []otlptracehttp.Option
+	if serverConfig.TracingOTLPEndpoint != nil {
+		options = append(options, otlptracehttp.WithEndpointURL(serverConfig.TracingOTLPEndpoint.String()))
+	}
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create otlp exporter: %w", err)
+	}
+
+	tracingResource, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", TracingServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create tracing resource: %w", err)
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(tracingResource),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(serverConfig.TracingSampleRatio))),
+	)
+	return tracerProvider, tracerProvider.Shutdown, nil
+}